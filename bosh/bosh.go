@@ -4,37 +4,72 @@
 package bosh
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 )
 
 // diegoDeploymentPrefixes contains all the known TAS bosh deployment string prefixes
 var diegoDeploymentPrefixes = []string{"cf-", "p-isolation-segment-", "pas-windows-"}
 
-// Runner is a bosh command runner
+// Option configures optional Runner and Director behavior
+type Option func(o *options)
+
+type options struct {
+	output io.Writer
+}
+
+// WithOutput writes the output of deploys to w instead of stdout, errors are
+// still written to stderr.
+func WithOutput(w io.Writer) Option {
+	return func(o *options) {
+		o.output = w
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{output: os.Stdout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Runner is a bosh command runner, it's safe to run concurrent deploys of
+// different deployments. Commands are killed when the context they're run
+// with is done.
 type Runner struct {
-	env []string
+	env    []string
+	output io.Writer
 }
 
 // NewRunner creates a new bosh runner instance, env should contain the
 // environment variables necessary to connect to the bosh instance.
-func NewRunner(env []string) *Runner {
+func NewRunner(env []string, opts ...Option) *Runner {
+	o := newOptions(opts)
 	return &Runner{
-		env: env,
+		env:    env,
+		output: o.output,
 	}
 }
 
 // Deploy executes the specified bosh deployment with the specified
 // manifest on disk.
-func (r Runner) Deploy(deploymentName string, manifestFilename string) error {
-	return r.DeployWithFlags(deploymentName, manifestFilename)
+func (r Runner) Deploy(ctx context.Context, deploymentName string, manifestFilename string) error {
+	return r.DeployWithFlags(ctx, deploymentName, manifestFilename)
 }
 
-func (r Runner) DeployWithFlags(deploymentName string, manifestFilename string, flags ...string) error {
+// DeployWithFlags executes the specified bosh deployment with the specified
+// manifest on disk and additional bosh deploy flags. Each line of output is
+// prefixed with the deployment name. A deploy that's abandoned when ctx is
+// done reports the bosh task that's still running.
+func (r Runner) DeployWithFlags(ctx context.Context, deploymentName string, manifestFilename string, flags ...string) error {
 	args := []string{
 		"deploy",
 		manifestFilename,
@@ -45,17 +80,40 @@ func (r Runner) DeployWithFlags(deploymentName string, manifestFilename string,
 	args = append(args, flags...)
 
 	log.Println("running command: bosh", strings.Join(args, " "))
-	cmd := exec.Command("bosh", args...)
+	stdout := newPrefixWriter(r.output, "["+deploymentName+"] ")
+	stderr := newPrefixWriter(os.Stderr, "["+deploymentName+"] ")
+	defer stdout.Flush()
+	defer stderr.Flush()
+	task := &taskRecorder{}
+
+	cmd := r.command(ctx, args...)
+	cmd.Stdout = io.MultiWriter(stdout, task)
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil && ctx.Err() != nil {
+		if id := task.ID(); id != "" {
+			return fmt.Errorf("stopped following bosh task %s deploying %s, it's still running on the director, see 'bosh task %s': %w",
+				id, deploymentName, id, ctx.Err())
+		}
+		return fmt.Errorf("stopped deploying %s: %w", deploymentName, ctx.Err())
+	}
+	return err
+}
+
+// command creates a bosh command that's killed when the context is done.
+// It's run in its own process group so an interrupt only reaches riic,
+// which decides whether to wait for it.
+func (r Runner) command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "bosh", args...)
 	cmd.Env = r.env
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	detach(cmd)
+	return cmd
 }
 
 // GetDeploymentManifest returns the bosh deployment manifest yaml for
 // the specified deployment.
-func (r Runner) GetDeploymentManifest(deploymentName string) ([]byte, error) {
-	output, err := r.boshExec("-d", deploymentName, "manifest")
+func (r Runner) GetDeploymentManifest(ctx context.Context, deploymentName string) ([]byte, error) {
+	output, err := r.boshExec(ctx, "-d", deploymentName, "manifest")
 	if err != nil {
 		return nil, fmt.Errorf("retrieving bosh manifest failed: %w", err)
 	}
@@ -64,8 +122,8 @@ func (r Runner) GetDeploymentManifest(deploymentName string) ([]byte, error) {
 
 // GetDiegoDeployments returns only the bosh deployment names of deployments
 // with diego cells: TAS, TASW, ISO segments.
-func (r Runner) GetDiegoDeployments() ([]string, error) {
-	allDeployments, err := r.GetDeployments()
+func (r Runner) GetDiegoDeployments(ctx context.Context) ([]string, error) {
+	allDeployments, err := r.GetDeployments(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -73,8 +131,8 @@ func (r Runner) GetDiegoDeployments() ([]string, error) {
 }
 
 // GetDeployments gets a bosh deployment names.
-func (r Runner) GetDeployments() (deployments []string, err error) {
-	output, err := r.boshExec("deployments", "--json")
+func (r Runner) GetDeployments(ctx context.Context) (deployments []string, err error) {
+	output, err := r.boshExec(ctx, "deployments", "--json")
 	if err != nil {
 		return nil, fmt.Errorf("retrieving bosh deployments failed: %w", err)
 	}
@@ -82,17 +140,33 @@ func (r Runner) GetDeployments() (deployments []string, err error) {
 }
 
 // GetDeploymentVMs gets all the VMs from the specified deployment.
-func (r Runner) GetDeploymentVMs(deploymentName string) (vms []VM, err error) {
-	output, err := r.boshExec("vms", "-d", deploymentName, "--json")
+func (r Runner) GetDeploymentVMs(ctx context.Context, deploymentName string) (vms []VM, err error) {
+	output, err := r.boshExec(ctx, "vms", "-d", deploymentName, "--json")
 	if err != nil {
 		return nil, fmt.Errorf("retrieving bosh vms failed: %w", err)
 	}
 	return loadVMs(deploymentName, output)
 }
 
+// GetDeploymentVMStates gets all the VMs from the specified deployment with
+// their process state.
+func (r Runner) GetDeploymentVMStates(ctx context.Context, deploymentName string) (vms []VM, err error) {
+	return r.GetDeploymentVMs(ctx, deploymentName)
+}
+
+// GetRunningTasks gets the tasks that are queued or processing on the
+// director.
+func (r Runner) GetRunningTasks(ctx context.Context) (tasks []Task, err error) {
+	output, err := r.boshExec(ctx, "tasks", "--json")
+	if err != nil {
+		return nil, fmt.Errorf("retrieving bosh tasks failed: %w", err)
+	}
+	return loadTasks(output)
+}
+
 // ScpFile copies a file using bosh scp
-func (r Runner) ScpFile(deploymentName, source, target string) error {
-	output, err := r.boshExec("-d", deploymentName, "scp", source, target)
+func (r Runner) ScpFile(ctx context.Context, deploymentName, source, target string) error {
+	output, err := r.boshExec(ctx, "-d", deploymentName, "scp", source, target)
 	if err != nil {
 		return fmt.Errorf("failed to SCP file from %s to %s (%s): %w", source, target, output, err)
 	}
@@ -111,10 +185,8 @@ func filterDiegoDeployments(deployments []string) (diegoDeployments []string) {
 	return diegoDeployments
 }
 
-func (r *Runner) boshExec(args ...string) ([]byte, error) {
-	cmd := exec.Command("bosh", args...)
-	cmd.Env = r.env
-	output, err := cmd.CombinedOutput()
+func (r *Runner) boshExec(ctx context.Context, args ...string) ([]byte, error) {
+	output, err := r.command(ctx, args...).CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("could not execute bosh command: bosh %s: %w\n%s",
 			strings.Join(args, " "), err, string(output))
@@ -142,3 +214,41 @@ func loadDeployments(output []byte) (deployments []string, err error) {
 
 	return deployments, nil
 }
+
+func loadTasks(output []byte) (tasks []Task, err error) {
+	type boshTasks struct {
+		Tables []struct {
+			Rows []struct {
+				ID          string `json:"id,omitempty"`
+				State       string `json:"state,omitempty"`
+				Deployment  string `json:"deployment,omitempty"`
+				Description string `json:"description,omitempty"`
+				Result      string `json:"result,omitempty"`
+			} `json:"Rows,omitempty"`
+		} `json:"Tables,omitempty"`
+	}
+
+	var t boshTasks
+	err = json.Unmarshal(output, &t)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json from bosh tasks: %w", err)
+	}
+	if len(t.Tables) == 0 {
+		return nil, nil
+	}
+	for _, row := range t.Tables[0].Rows {
+		id, err := strconv.Atoi(row.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid task id %q from bosh tasks: %w", row.ID, err)
+		}
+		tasks = append(tasks, Task{
+			ID:          id,
+			State:       row.State,
+			Description: row.Description,
+			Result:      row.Result,
+			Deployment:  row.Deployment,
+		})
+	}
+
+	return tasks, nil
+}
@@ -55,6 +55,28 @@ func TestLoadDeployments(t *testing.T) {
 	}
 }
 
+func TestLoadTasks(t *testing.T) {
+	f, err := ioutil.ReadFile("testdata/tasks.json")
+	if err != nil {
+		t.Fatalf("Failed to read test data tasks.json: %s", err)
+	}
+
+	tasks, err := loadTasks(f)
+	if err != nil {
+		t.Fatalf("Failed to parse tasks from tasks.json: %s", err)
+	}
+
+	if len(tasks) != 2 {
+		t.Fatalf("Expected 2 tasks but got %d", len(tasks))
+	}
+	if tasks[0].ID != 1423 || tasks[0].State != "processing" || tasks[0].Deployment != "cf-3e6b71ab5a6736db362b" {
+		t.Fatalf("Unexpected first task %+v", tasks[0])
+	}
+	if tasks[1].ID != 1424 || tasks[1].Description != "scheduled CleanupArtifacts" {
+		t.Fatalf("Unexpected second task %+v", tasks[1])
+	}
+}
+
 func containsDeployment(s []string, e string) bool {
 	for _, a := range s {
 		if a == e {
@@ -0,0 +1,18 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+// +build !windows
+
+package bosh
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach runs the command in its own process group so it doesn't receive
+// the terminal's interrupts
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
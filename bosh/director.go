@@ -0,0 +1,571 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package bosh
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// defaultDirectorPort is the port of the BOSH Director API when
+// BOSH_ENVIRONMENT doesn't specify one
+const defaultDirectorPort = "25555"
+
+// The states of a finished BOSH task
+const (
+	TaskDone      = "done"
+	TaskError     = "error"
+	TaskCancelled = "cancelled"
+	TaskTimeout   = "timeout"
+)
+
+// ErrBadStatusCode is returned when the Director API responds with an
+// unexpected status code
+var ErrBadStatusCode = errors.New("unexpected status code from the bosh director")
+
+// Director is a BOSH Director API client, it's safe to run concurrent
+// deploys of different deployments. It authenticates with UAA client
+// credentials taken from the same BOSH_* environment variables as the bosh
+// CLI. The Director API can't copy files from instances, so ScpFile uses the
+// bosh CLI.
+type Director struct {
+	url          string
+	client       *http.Client
+	cli          *Runner
+	output       io.Writer
+	pollInterval time.Duration
+}
+
+// Task is a BOSH Director task
+type Task struct {
+	ID          int    `json:"id"`
+	State       string `json:"state"`
+	Description string `json:"description"`
+	Result      string `json:"result"`
+	Deployment  string `json:"deployment"`
+}
+
+// Finished returns true if the task is no longer queued or processing
+func (t Task) Finished() bool {
+	switch t.State {
+	case TaskDone, TaskError, TaskCancelled, TaskTimeout:
+		return true
+	}
+	return false
+}
+
+// NewDirector creates a BOSH Director API client, env should contain the
+// BOSH_ENVIRONMENT, BOSH_CLIENT, BOSH_CLIENT_SECRET, and BOSH_CA_CERT
+// environment variables. BOSH_CA_CERT may be a PEM certificate or a path to
+// one.
+func NewDirector(env []string, opts ...Option) (*Director, error) {
+	vars := map[string]string{}
+	for _, e := range env {
+		i := strings.Index(e, "=")
+		if i < 0 {
+			continue
+		}
+		if _, ok := vars[e[:i]]; !ok {
+			vars[e[:i]] = e[i+1:]
+		}
+	}
+	for _, name := range []string{"BOSH_ENVIRONMENT", "BOSH_CLIENT", "BOSH_CLIENT_SECRET", "BOSH_CA_CERT"} {
+		if vars[name] == "" {
+			return nil, fmt.Errorf("could not connect to the bosh director, %s is not set", name)
+		}
+	}
+
+	directorURL, err := directorURL(vars["BOSH_ENVIRONMENT"])
+	if err != nil {
+		return nil, err
+	}
+
+	caCert := []byte(vars["BOSH_CA_CERT"])
+	if !bytes.Contains(caCert, []byte("-----BEGIN")) {
+		caCert, err = ioutil.ReadFile(vars["BOSH_CA_CERT"])
+		if err != nil {
+			return nil, fmt.Errorf("could not read the bosh director CA certificate: %w", err)
+		}
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("could not parse the bosh director CA certificate")
+	}
+
+	base := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	o := newOptions(opts)
+	d := &Director{
+		url:          directorURL,
+		client:       base,
+		cli:          NewRunner(env, opts...),
+		output:       o.output,
+		pollInterval: 5 * time.Second,
+	}
+
+	uaaURL, err := d.uaaURL(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	config := &clientcredentials.Config{
+		ClientID:     vars["BOSH_CLIENT"],
+		ClientSecret: vars["BOSH_CLIENT_SECRET"],
+		TokenURL:     uaaURL + "/oauth/token",
+	}
+	d.client = config.Client(context.WithValue(context.Background(), oauth2.HTTPClient, base))
+	d.client.Timeout = base.Timeout
+	// deploys redirect to the task, which is polled rather than followed
+	d.client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return d, nil
+}
+
+// directorURL returns the Director API URL for BOSH_ENVIRONMENT, which may
+// be a host, a host and port, or a URL.
+func directorURL(environment string) (string, error) {
+	if !strings.Contains(environment, "://") {
+		environment = "https://" + environment
+	}
+	u, err := url.Parse(environment)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid BOSH_ENVIRONMENT %q", environment)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), defaultDirectorPort)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// uaaURL gets the UAA URL that issues director tokens from the
+// unauthenticated info endpoint
+func (d *Director) uaaURL(ctx context.Context) (string, error) {
+	var info struct {
+		UserAuthentication struct {
+			Type    string `json:"type"`
+			Options struct {
+				URL string `json:"url"`
+			} `json:"options"`
+		} `json:"user_authentication"`
+	}
+	if err := d.getJSON(ctx, "/info", &info); err != nil {
+		return "", fmt.Errorf("could not get the bosh director info: %w", err)
+	}
+	if info.UserAuthentication.Type != "uaa" || info.UserAuthentication.Options.URL == "" {
+		return "", fmt.Errorf("the bosh director at %s doesn't use UAA authentication", d.url)
+	}
+	return strings.TrimSuffix(info.UserAuthentication.Options.URL, "/"), nil
+}
+
+// Deploy executes the specified bosh deployment with the specified
+// manifest on disk.
+func (d *Director) Deploy(ctx context.Context, deploymentName string, manifestFilename string) error {
+	return d.DeployWithFlags(ctx, deploymentName, manifestFilename)
+}
+
+// DeployWithFlags deploys the manifest on disk to the specified deployment
+// and waits for the deploy task to finish. The flags are the bosh deploy CLI
+// flags --recreate, --fix, --skip-drain, and --dry-run. The task events are
+// written to the output prefixed with the deployment name.
+func (d *Director) DeployWithFlags(ctx context.Context, deploymentName string, manifestFilename string, flags ...string) error {
+	query, err := deployQuery(flags)
+	if err != nil {
+		return err
+	}
+	manifest, err := ioutil.ReadFile(manifestFilename)
+	if err != nil {
+		return fmt.Errorf("could not read the manifest for deployment %s: %w", deploymentName, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url+"/deployments?"+query.Encode(), bytes.NewReader(manifest))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/yaml")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not deploy %s: %w", deploymentName, err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return fmt.Errorf("could not deploy %s: %w, got %d with body %s",
+			deploymentName, ErrBadStatusCode, resp.StatusCode, string(body))
+	}
+
+	taskID, err := redirectTaskID(resp)
+	if err != nil {
+		return fmt.Errorf("could not deploy %s: %w", deploymentName, err)
+	}
+
+	log.Printf("deploying %s, bosh task %d\n", deploymentName, taskID)
+	stdout := newPrefixWriter(d.output, "["+deploymentName+"] ")
+	defer stdout.Flush()
+	task, err := d.WaitForTask(ctx, taskID, stdout)
+	if err != nil {
+		return fmt.Errorf("deploying %s: %w", deploymentName, err)
+	}
+	if task.State != TaskDone {
+		return fmt.Errorf("bosh task %d deploying %s %s: %s", taskID, deploymentName, task.State, task.Result)
+	}
+	return nil
+}
+
+// deployQuery converts bosh deploy CLI flags to Director API parameters
+func deployQuery(flags []string) (url.Values, error) {
+	query := url.Values{}
+	for _, f := range flags {
+		switch f {
+		case "--recreate":
+			query.Set("recreate", "true")
+		case "--fix":
+			query.Set("fix", "true")
+		case "--skip-drain":
+			query.Set("skip_drain", "*")
+		case "--dry-run":
+			query.Set("dry_run", "true")
+		default:
+			return nil, fmt.Errorf("the bosh deploy flag %s is not supported by the director API client", f)
+		}
+	}
+	return query, nil
+}
+
+// redirectTaskID returns the ID of the task the director redirected to
+func redirectTaskID(resp *http.Response) (int, error) {
+	taskID, err := strconv.Atoi(path.Base(resp.Header.Get("Location")))
+	if err != nil {
+		return 0, fmt.Errorf("the director didn't return a task: %q", resp.Header.Get("Location"))
+	}
+	return taskID, nil
+}
+
+// GetRunningTasks gets the tasks that are queued or processing on the
+// director.
+func (d *Director) GetRunningTasks(ctx context.Context) (tasks []Task, err error) {
+	query := url.Values{"state": {"queued,processing,cancelling"}, "verbose": {"1"}}
+	if err = d.getJSON(ctx, "/tasks?"+query.Encode(), &tasks); err != nil {
+		return nil, fmt.Errorf("retrieving bosh tasks failed: %w", err)
+	}
+	return tasks, nil
+}
+
+// GetTask gets the current state of a task
+func (d *Director) GetTask(ctx context.Context, taskID int) (Task, error) {
+	var task Task
+	if err := d.getJSON(ctx, fmt.Sprintf("/tasks/%d", taskID), &task); err != nil {
+		return Task{}, fmt.Errorf("could not get bosh task %d: %w", taskID, err)
+	}
+	return task, nil
+}
+
+// GetTaskOutput gets the output of a task, outputType is event, result, or
+// debug.
+func (d *Director) GetTaskOutput(ctx context.Context, taskID int, outputType string) ([]byte, error) {
+	output, _, err := d.taskOutput(ctx, taskID, outputType, 0)
+	return output, err
+}
+
+// WaitForTask polls the task until it finishes, writing each of its events
+// to w as they happen. It returns the finished task, a task that failed
+// isn't an error. If ctx is done it stops polling, the task keeps running on
+// the director.
+func (d *Director) WaitForTask(ctx context.Context, taskID int, w io.Writer) (task Task, err error) {
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = fmt.Errorf("stopped following bosh task %d, it's still running on the director, see 'bosh task %d': %w",
+				taskID, taskID, ctx.Err())
+		}
+	}()
+
+	var offset int64
+	var partial []byte
+	for {
+		// get the state before the output so no events are missed after
+		// the task finishes
+		task, err = d.GetTask(ctx, taskID)
+		if err != nil {
+			return Task{}, err
+		}
+
+		output, next, err := d.taskOutput(ctx, taskID, "event", offset)
+		if err != nil {
+			return Task{}, err
+		}
+		offset = next
+		partial = append(partial, output...)
+		for {
+			i := bytes.IndexByte(partial, '\n')
+			if i < 0 {
+				break
+			}
+			writeTaskEvent(w, taskID, partial[:i])
+			partial = partial[i+1:]
+		}
+
+		if task.Finished() {
+			if len(partial) > 0 {
+				writeTaskEvent(w, taskID, partial)
+			}
+			return task, nil
+		}
+		select {
+		case <-time.After(d.pollInterval):
+		case <-ctx.Done():
+			return Task{}, ctx.Err()
+		}
+	}
+}
+
+// taskOutput gets the task output from offset, returning the output and
+// the offset of the end of the output.
+func (d *Director) taskOutput(ctx context.Context, taskID int, outputType string, offset int64) ([]byte, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/tasks/%d/output?type=%s", d.url, taskID, url.QueryEscape(outputType)), nil)
+	if err != nil {
+		return nil, offset, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, offset, fmt.Errorf("could not get bosh task %d output: %w", taskID, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, offset, fmt.Errorf("could not read bosh task %d output: %w", taskID, err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusRequestedRangeNotSatisfiable:
+		// no output since the offset
+		return nil, offset, nil
+	case http.StatusPartialContent:
+		return body, offset + int64(len(body)), nil
+	case http.StatusOK:
+		// the range was ignored and the whole output returned
+		if int64(len(body)) <= offset {
+			return nil, offset, nil
+		}
+		return body[offset:], int64(len(body)), nil
+	}
+	return nil, offset, fmt.Errorf("could not get bosh task %d output: %w, got %d with body %s",
+		taskID, ErrBadStatusCode, resp.StatusCode, string(body))
+}
+
+// writeTaskEvent writes a line of event output in a similar format to the
+// bosh CLI, lines that aren't events are written as is.
+func writeTaskEvent(w io.Writer, taskID int, line []byte) {
+	var e struct {
+		Time  int64    `json:"time"`
+		Type  string   `json:"type"`
+		Stage string   `json:"stage"`
+		Tags  []string `json:"tags"`
+		Task  string   `json:"task"`
+		State string   `json:"state"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(line, &e); err != nil {
+		fmt.Fprintf(w, "%s\n", line)
+		return
+	}
+
+	t := time.Unix(e.Time, 0).UTC().Format("15:04:05")
+	switch {
+	case e.Error != nil:
+		fmt.Fprintf(w, "Task %d | %s | Error: %s\n", taskID, t, e.Error.Message)
+	case e.Stage == "":
+		fmt.Fprintf(w, "Task %d | %s | %s\n", taskID, t, e.Message)
+	default:
+		stage := e.Stage
+		if len(e.Tags) > 0 {
+			stage += " " + strings.Join(e.Tags, ", ")
+		}
+		fmt.Fprintf(w, "Task %d | %s | %s: %s (%s)\n", taskID, t, stage, e.Task, e.State)
+	}
+}
+
+// GetDeploymentManifest returns the bosh deployment manifest yaml for
+// the specified deployment.
+func (d *Director) GetDeploymentManifest(ctx context.Context, deploymentName string) ([]byte, error) {
+	var deployment struct {
+		Manifest string `json:"manifest"`
+	}
+	if err := d.getJSON(ctx, "/deployments/"+url.PathEscape(deploymentName), &deployment); err != nil {
+		return nil, fmt.Errorf("retrieving bosh manifest failed: %w", err)
+	}
+	return []byte(deployment.Manifest), nil
+}
+
+// GetDiegoDeployments returns only the bosh deployment names of deployments
+// with diego cells: TAS, TASW, ISO segments.
+func (d *Director) GetDiegoDeployments(ctx context.Context) ([]string, error) {
+	allDeployments, err := d.GetDeployments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterDiegoDeployments(allDeployments), nil
+}
+
+// GetDeployments gets a bosh deployment names.
+func (d *Director) GetDeployments(ctx context.Context) (deployments []string, err error) {
+	var resp []struct {
+		Name string `json:"name"`
+	}
+	if err = d.getJSON(ctx, "/deployments", &resp); err != nil {
+		return nil, fmt.Errorf("retrieving bosh deployments failed: %w", err)
+	}
+	for _, r := range resp {
+		deployments = append(deployments, r.Name)
+	}
+	return deployments, nil
+}
+
+// GetDeploymentVMs gets all the VMs from the specified deployment.
+func (d *Director) GetDeploymentVMs(ctx context.Context, deploymentName string) (vms []VM, err error) {
+	vms, err = d.getInstances(ctx, deploymentName, "vms")
+	if err != nil {
+		return nil, fmt.Errorf("retrieving bosh vms failed: %w", err)
+	}
+	return vms, nil
+}
+
+// GetDeploymentVMStates gets all the VMs from the specified deployment with
+// their process state. The director collects the states in a task.
+func (d *Director) GetDeploymentVMStates(ctx context.Context, deploymentName string) (vms []VM, err error) {
+	output, err := d.runTask(ctx, fmt.Sprintf("/deployments/%s/vms?format=full", url.PathEscape(deploymentName)))
+	if err != nil {
+		return nil, fmt.Errorf("retrieving bosh vm states failed: %w", err)
+	}
+
+	// the task result is a JSON object per line
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var state struct {
+			JobName  string `json:"job_name"`
+			ID       string `json:"id"`
+			JobState string `json:"job_state"`
+		}
+		if err = json.Unmarshal(line, &state); err != nil {
+			return nil, fmt.Errorf("invalid vm state from the bosh director: %w", err)
+		}
+		vm := newVM(deploymentName, state.JobName+"/"+state.ID)
+		vm.ProcessState = state.JobState
+		vms = append(vms, vm)
+	}
+	return vms, nil
+}
+
+// runTask starts a task with a GET that the director redirects to the task,
+// then waits for it and returns its result output.
+func (d *Director) runTask(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("%w, got %d from %s with body %s", ErrBadStatusCode, resp.StatusCode, path, string(body))
+	}
+
+	taskID, err := redirectTaskID(resp)
+	if err != nil {
+		return nil, err
+	}
+	task, err := d.WaitForTask(ctx, taskID, ioutil.Discard)
+	if err != nil {
+		return nil, err
+	}
+	if task.State != TaskDone {
+		return nil, fmt.Errorf("bosh task %d %s: %s", taskID, task.State, task.Result)
+	}
+	return d.GetTaskOutput(ctx, taskID, "result")
+}
+
+// GetDeploymentInstances gets all the instances from the specified
+// deployment, including instances that don't have a VM.
+func (d *Director) GetDeploymentInstances(ctx context.Context, deploymentName string) (instances []VM, err error) {
+	instances, err = d.getInstances(ctx, deploymentName, "instances")
+	if err != nil {
+		return nil, fmt.Errorf("retrieving bosh instances failed: %w", err)
+	}
+	return instances, nil
+}
+
+func (d *Director) getInstances(ctx context.Context, deploymentName, resource string) (vms []VM, err error) {
+	var resp []struct {
+		Job string `json:"job"`
+		ID  string `json:"id"`
+	}
+	if err = d.getJSON(ctx, fmt.Sprintf("/deployments/%s/%s", url.PathEscape(deploymentName), resource), &resp); err != nil {
+		return nil, err
+	}
+	for _, r := range resp {
+		vms = append(vms, newVM(deploymentName, r.Job+"/"+r.ID))
+	}
+	return vms, nil
+}
+
+// ScpFile copies a file using bosh scp
+func (d *Director) ScpFile(ctx context.Context, deploymentName, source, target string) error {
+	return d.cli.ScpFile(ctx, deploymentName, source, target)
+}
+
+func (d *Director) getJSON(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w, got %d from %s with body %s", ErrBadStatusCode, resp.StatusCode, path, string(body))
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid json from %s: %w", path, err)
+	}
+	return nil
+}
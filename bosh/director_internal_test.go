@@ -0,0 +1,350 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package bosh
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDirector is a minimal BOSH Director API with a UAA token endpoint
+type fakeDirector struct {
+	mu           sync.Mutex
+	server       *httptest.Server
+	deployed     []byte
+	deployQuery  string
+	taskPolls    int
+	taskState    string
+	taskEvents   string
+	rangeHeaders []string
+}
+
+func newFakeDirector(t *testing.T) *fakeDirector {
+	f := &fakeDirector{taskState: TaskDone}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"name":"p-bosh","user_authentication":{"type":"uaa","options":{"url":"%s/uaa"}}}`, f.server.URL)
+	})
+	mux.HandleFunc("/uaa/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"director-token","token_type":"bearer","expires_in":3600}`)
+	})
+	authorized := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer director-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			h(w, r)
+		}
+	}
+	mux.HandleFunc("/deployments", authorized(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if r.Header.Get("Content-Type") != "text/yaml" {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			f.deployed, _ = ioutil.ReadAll(r.Body)
+			f.deployQuery = r.URL.RawQuery
+			w.Header().Set("Location", "/tasks/42")
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, `[{"name":"cf-3e6b71ab5a6736db362b"},{"name":"mysql-guid"},{"name":"p-isolation-segment-56025cc76d5913ca1a69"}]`)
+	}))
+	mux.HandleFunc("/deployments/cf-3e6b71ab5a6736db362b", authorized(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"manifest":"name: cf-3e6b71ab5a6736db362b\n"}`)
+	}))
+	mux.HandleFunc("/deployments/cf-3e6b71ab5a6736db362b/vms", authorized(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") == "full" {
+			w.Header().Set("Location", "/tasks/43")
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, `[{"agent_id":"a1","cid":"vm-1","job":"diego_cell","index":0,"id":"0d1d3f6e"},{"agent_id":"a2","cid":"vm-2","job":"router","index":0,"id":"7a5b9c1d"}]`)
+	}))
+	mux.HandleFunc("/deployments/cf-3e6b71ab5a6736db362b/instances", authorized(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"job":"diego_cell","index":0,"id":"0d1d3f6e","expects_vm":true},{"job":"smoke_tests","index":0,"id":"3c2e","expects_vm":false}]`)
+	}))
+	mux.HandleFunc("/tasks", authorized(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("state") != "queued,processing,cancelling" {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		fmt.Fprint(w, `[{"id":41,"state":"processing","description":"create deployment","deployment":"p-isolation-segment-56025cc76d5913ca1a69"}]`)
+	}))
+	mux.HandleFunc("/tasks/43", authorized(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":43,"state":"done","description":"retrieve vm-stats","deployment":"cf-3e6b71ab5a6736db362b"}`)
+	}))
+	mux.HandleFunc("/tasks/43/output", authorized(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("type") == "result" {
+			fmt.Fprint(w, `{"job_name":"diego_cell","id":"0d1d3f6e","job_state":"running"}`+"\n"+
+				`{"job_name":"router","id":"7a5b9c1d","job_state":"failing"}`+"\n")
+		}
+	}))
+	mux.HandleFunc("/tasks/42", authorized(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.taskPolls++
+		state := "processing"
+		if f.taskPolls > 1 {
+			state = f.taskState
+		}
+		fmt.Fprintf(w, `{"id":42,"state":%q,"description":"create deployment","result":"boom","deployment":"cf-3e6b71ab5a6736db362b"}`, state)
+	}))
+	mux.HandleFunc("/tasks/42/output", authorized(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if r.URL.Query().Get("type") != "event" {
+			fmt.Fprint(w, "result output")
+			return
+		}
+		// the first poll sees half the events
+		events := f.taskEvents
+		if f.taskPolls == 1 {
+			events = events[:len(events)/2]
+		}
+		rng := r.Header.Get("Range")
+		f.rangeHeaders = append(f.rangeHeaders, rng)
+		if rng == "" {
+			fmt.Fprint(w, events)
+			return
+		}
+		var offset int
+		fmt.Sscanf(rng, "bytes=%d-", &offset)
+		if offset >= len(events) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		fmt.Fprint(w, events[offset:])
+	}))
+
+	f.server = httptest.NewTLSServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeDirector) env(t *testing.T) []string {
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.server.Certificate().Raw})
+	caFile := filepath.Join(t.TempDir(), "root_ca_certificate")
+	if err := ioutil.WriteFile(caFile, caCert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return []string{
+		"BOSH_CLIENT=ops_manager",
+		"BOSH_CLIENT_SECRET=secret",
+		"BOSH_CA_CERT=" + caFile,
+		"BOSH_ENVIRONMENT=" + f.server.URL,
+	}
+}
+
+func TestDirectorURL(t *testing.T) {
+	tests := map[string]string{
+		"172.31.0.11":               "https://172.31.0.11:25555",
+		"172.31.0.11:443":           "https://172.31.0.11:443",
+		"https://director.example/": "https://director.example:25555",
+	}
+	for env, expected := range tests {
+		u, err := directorURL(env)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", env, err)
+		}
+		if u != expected {
+			t.Errorf("expected %s for %s, but got %s", expected, env, u)
+		}
+	}
+}
+
+func TestNewDirectorRequiresEnv(t *testing.T) {
+	_, err := NewDirector([]string{"BOSH_CLIENT=ops_manager"})
+	if err == nil || !strings.Contains(err.Error(), "BOSH_ENVIRONMENT") {
+		t.Fatalf("expected a missing BOSH_ENVIRONMENT error, got %v", err)
+	}
+}
+
+func TestDirectorQueries(t *testing.T) {
+	f := newFakeDirector(t)
+	d, err := NewDirector(f.env(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deployments, err := d.GetDiegoDeployments(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(deployments, ",") != "cf-3e6b71ab5a6736db362b,p-isolation-segment-56025cc76d5913ca1a69" {
+		t.Errorf("unexpected diego deployments %v", deployments)
+	}
+
+	manifest, err := d.GetDeploymentManifest(context.Background(), "cf-3e6b71ab5a6736db362b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(manifest) != "name: cf-3e6b71ab5a6736db362b\n" {
+		t.Errorf("unexpected manifest %q", manifest)
+	}
+
+	vms, err := d.GetDeploymentVMs(context.Background(), "cf-3e6b71ab5a6736db362b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vms) != 2 || vms[0] != newVM("cf-3e6b71ab5a6736db362b", "diego_cell/0d1d3f6e") || vms[1].Name != "router/7a5b9c1d" {
+		t.Errorf("unexpected vms %v", vms)
+	}
+
+	instances, err := d.GetDeploymentInstances(context.Background(), "cf-3e6b71ab5a6736db362b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(instances) != 2 || instances[1].Name != "smoke_tests/3c2e" {
+		t.Errorf("unexpected instances %v", instances)
+	}
+
+	_, err = d.GetDeploymentManifest(context.Background(), "missing")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	output, err := d.GetTaskOutput(context.Background(), 42, "result")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(output) != "result output" {
+		t.Errorf("unexpected task output %q", output)
+	}
+}
+
+func TestDirectorVMStatesAndTasks(t *testing.T) {
+	f := newFakeDirector(t)
+	d, err := NewDirector(f.env(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d.pollInterval = 0
+
+	vms, err := d.GetDeploymentVMStates(context.Background(), "cf-3e6b71ab5a6736db362b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vms) != 2 || vms[0].Name != "diego_cell/0d1d3f6e" || vms[0].ProcessState != "running" ||
+		vms[1].ProcessState != "failing" {
+		t.Errorf("unexpected vm states %v", vms)
+	}
+
+	tasks, err := d.GetRunningTasks(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != 41 || tasks[0].Deployment != "p-isolation-segment-56025cc76d5913ca1a69" {
+		t.Errorf("unexpected running tasks %v", tasks)
+	}
+}
+
+func TestDirectorDeploy(t *testing.T) {
+	f := newFakeDirector(t)
+	f.taskEvents = `{"time":1611075960,"stage":"Updating instance","tags":["diego_cell"],"total":1,"task":"diego_cell/0d1d3f6e (0)","index":1,"state":"started","progress":0}
+{"time":1611075990,"stage":"Updating instance","tags":["diego_cell"],"total":1,"task":"diego_cell/0d1d3f6e (0)","index":1,"state":"finished","progress":100}
+`
+	var deployOut bytes.Buffer
+	d, err := NewDirector(f.env(t), WithOutput(&deployOut))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d.pollInterval = 0
+
+	manifestFile := filepath.Join(t.TempDir(), "cf.yml")
+	if err = ioutil.WriteFile(manifestFile, []byte("name: cf-3e6b71ab5a6736db362b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	task, err := d.WaitForTask(context.Background(), 42, &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if task.State != TaskDone {
+		t.Errorf("expected the task to be done, got %s", task.State)
+	}
+	expected := `Task 42 | 17:06:00 | Updating instance diego_cell: diego_cell/0d1d3f6e (0) (started)
+Task 42 | 17:06:30 | Updating instance diego_cell: diego_cell/0d1d3f6e (0) (finished)
+`
+	if out.String() != expected {
+		t.Errorf("expected task events:\n%s\ngot:\n%s", expected, out.String())
+	}
+	if len(f.rangeHeaders) != 2 || f.rangeHeaders[0] != "" || !strings.HasPrefix(f.rangeHeaders[1], "bytes=") {
+		t.Errorf("expected the second poll to request the remaining output, got ranges %q", f.rangeHeaders)
+	}
+
+	f.taskPolls = 0
+	if err = d.DeployWithFlags(context.Background(), "cf-3e6b71ab5a6736db362b", manifestFile, "--recreate"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(f.deployed) != "name: cf-3e6b71ab5a6736db362b\n" {
+		t.Errorf("unexpected deployed manifest %q", f.deployed)
+	}
+	if f.deployQuery != "recreate=true" {
+		t.Errorf("expected the recreate parameter, got %q", f.deployQuery)
+	}
+	if !strings.HasPrefix(deployOut.String(), "[cf-3e6b71ab5a6736db362b] Task 42 | 17:06:00 |") {
+		t.Errorf("expected the prefixed task events in the deploy output, got:\n%s", deployOut.String())
+	}
+
+	f.taskPolls = 0
+	f.taskState = TaskError
+	err = d.Deploy(context.Background(), "cf-3e6b71ab5a6736db362b", manifestFile)
+	if err == nil || err.Error() != "bosh task 42 deploying cf-3e6b71ab5a6736db362b error: boom" {
+		t.Errorf("expected the task error, got %v", err)
+	}
+
+	err = d.DeployWithFlags(context.Background(), "cf-3e6b71ab5a6736db362b", manifestFile, "--no-redact")
+	if err == nil || !strings.Contains(err.Error(), "--no-redact is not supported") {
+		t.Errorf("expected an unsupported flag error, got %v", err)
+	}
+}
+
+func TestDirectorDeployAbandoned(t *testing.T) {
+	f := newFakeDirector(t)
+	// the task never finishes
+	f.taskState = "processing"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d, err := NewDirector(f.env(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d.pollInterval = time.Hour
+
+	manifestFile := filepath.Join(t.TempDir(), "cf.yml")
+	if err = ioutil.WriteFile(manifestFile, []byte("name: cf-3e6b71ab5a6736db362b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err = d.Deploy(ctx, "cf-3e6b71ab5a6736db362b", manifestFile)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the deploy to be abandoned, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "bosh task 42, it's still running") {
+		t.Errorf("expected the error to report the running task, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package bosh
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sync"
+)
+
+// outputMu serializes writes from concurrent bosh commands so lines from
+// different deployments aren't interleaved.
+var outputMu sync.Mutex
+
+// prefixWriter prefixes each complete line written to it before writing it
+// to the underlying writer.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	buf    bytes.Buffer
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{
+		w:      w,
+		prefix: []byte(prefix),
+	}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf.Write(b)
+
+	var out bytes.Buffer
+	for {
+		i := bytes.IndexByte(p.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		out.Write(p.prefix)
+		out.Write(p.buf.Next(i + 1))
+	}
+
+	if out.Len() > 0 {
+		outputMu.Lock()
+		defer outputMu.Unlock()
+		if _, err := p.w.Write(out.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush writes any incomplete last line
+func (p *prefixWriter) Flush() error {
+	if p.buf.Len() == 0 {
+		return nil
+	}
+	_, err := p.Write([]byte("\n"))
+	return err
+}
+
+// taskLine matches the line where the bosh CLI reports the task it started
+var taskLine = regexp.MustCompile(`^Task (\d+)\s*$`)
+
+// taskRecorder records the id of the first task in bosh CLI output
+type taskRecorder struct {
+	mu   sync.Mutex
+	line bytes.Buffer
+	id   string
+}
+
+func (t *taskRecorder) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range b {
+		if t.id != "" {
+			break
+		}
+		if c != '\n' {
+			t.line.WriteByte(c)
+			continue
+		}
+		if m := taskLine.FindSubmatch(t.line.Bytes()); m != nil {
+			t.id = string(m[1])
+		}
+		t.line.Reset()
+	}
+	return len(b), nil
+}
+
+// ID returns the task id or an empty string if a task wasn't started
+func (t *taskRecorder) ID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.id
+}
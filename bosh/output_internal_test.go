@@ -0,0 +1,48 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package bosh
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newPrefixWriter(&out, "[cf-guid] ")
+
+	if _, err := w.Write([]byte("Using deployment 'cf-guid'\nTask 42")); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "[cf-guid] Using deployment 'cf-guid'\n" {
+		t.Fatalf("expected only complete lines to be written, but got %q", out.String())
+	}
+
+	if _, err := w.Write([]byte(" | Preparing deployment\n\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("Succeeded")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[cf-guid] Using deployment 'cf-guid'\n[cf-guid] Task 42 | Preparing deployment\n[cf-guid] \n[cf-guid] Succeeded\n"
+	if out.String() != expected {
+		t.Fatalf("expected:\n%s\nbut got:\n%s", expected, out.String())
+	}
+}
+
+func TestTaskRecorder(t *testing.T) {
+	r := &taskRecorder{}
+	for _, s := range []string{"Using deployment 'cf-guid'\n\nTa", "sk 1234\n\nTask 1234 | 17:06:00 | Preparing deployment\n", "Task 1235\n"} {
+		if _, err := r.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if id := r.ID(); id != "1234" {
+		t.Fatalf("expected task 1234, but got %q", id)
+	}
+}
@@ -12,6 +12,9 @@ import (
 type VM struct {
 	DeploymentName string
 	Name           string
+	// ProcessState is the state of the VM's processes, for example running
+	// or failing, it's empty when the state wasn't retrieved
+	ProcessState string
 }
 
 // newVM creats a new bosh VM instance
@@ -26,7 +29,8 @@ func loadVMs(deploymentName string, output []byte) (vms []VM, err error) {
 	type boshVms struct {
 		Tables []struct {
 			Rows []struct {
-				Instance string `json:"instance,omitempty"`
+				Instance     string `json:"instance,omitempty"`
+				ProcessState string `json:"process_state,omitempty"`
 			} `json:"Rows,omitempty"`
 		} `json:"Tables,omitempty"`
 	}
@@ -39,6 +43,7 @@ func loadVMs(deploymentName string, output []byte) (vms []VM, err error) {
 
 	for _, row := range v.Tables[0].Rows {
 		vm := newVM(deploymentName, row.Instance)
+		vm.ProcessState = row.ProcessState
 		vms = append(vms, vm)
 	}
 
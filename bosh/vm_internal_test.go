@@ -35,6 +35,9 @@ func TestLoadVMs(t *testing.T) {
 		if vm.DeploymentName != "p-isolation-segment-guid" {
 			t.Fatalf("Expected VM to have deployment name p-isolation-segment-guid but got %s", vm.DeploymentName)
 		}
+		if vm.ProcessState != "running" {
+			t.Fatalf("Expected VM %s to be running but got %q", vm.Name, vm.ProcessState)
+		}
 		foundVMName := false
 		for _, expectedVMName := range expectedVMNames {
 			if expectedVMName == vm.Name {
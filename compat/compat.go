@@ -0,0 +1,197 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package compat matches the deployed Operations Manager and product
+// versions against the compatibility matrix of the versions riic supports.
+package compat
+
+import (
+	_ "embed" // the default matrix is embedded
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+	"gopkg.in/yaml.v2"
+)
+
+// OpsManager is the product name of Operations Manager and its BOSH Director
+const OpsManager = "p-bosh"
+
+// The features riic relies on the Operations Manager version for
+const (
+	FeatureSelectiveApplyChanges = "selective_apply_changes"
+	FeaturePendingChanges        = "pending_changes"
+	FeatureRunningInstallations  = "running_installations"
+)
+
+// ErrUnsupported is returned when a product version isn't in the matrix
+var ErrUnsupported = errors.New("unsupported version")
+
+//go:embed matrix.yml
+var defaultMatrix []byte
+
+// Matrix maps ranges of product versions to the features and manifest
+// profile riic uses for them
+type Matrix struct {
+	Products map[string][]Entry `yaml:"products"`
+}
+
+// Entry is a range of supported versions of a product
+type Entry struct {
+	// Versions is the range of versions, like ">=2.7.0 <2.14.0"
+	Versions string `yaml:"versions"`
+	// Profile is the name of the manifest profile used to update the
+	// product's deployments
+	Profile string `yaml:"profile"`
+	// Features are the riic features that rely on the product version
+	Features []string `yaml:"features"`
+
+	versions versionRange
+	profile  *manifest.Profile
+}
+
+// ManifestProfile returns the manifest profile of the entry, it's nil if the
+// product doesn't have diego cells
+func (e *Entry) ManifestProfile() *manifest.Profile {
+	return e.profile
+}
+
+// Supports returns true if the entry has the feature
+func (e *Entry) Supports(feature string) bool {
+	for _, f := range e.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Default returns the matrix embedded in riic
+func Default() (*Matrix, error) {
+	return Parse(defaultMatrix)
+}
+
+// Load reads the matrix from the file, the embedded matrix is returned when
+// path is empty.
+func Load(path string) (*Matrix, error) {
+	if path == "" {
+		return Default()
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the compatibility matrix: %w", err)
+	}
+	m, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Parse deserializes the matrix and checks every range and profile is valid
+func Parse(b []byte) (*Matrix, error) {
+	m := &Matrix{}
+	if err := yaml.UnmarshalStrict(b, m); err != nil {
+		return nil, fmt.Errorf("could not deserialize the compatibility matrix: %w", err)
+	}
+
+	for product, entries := range m.Products {
+		for i := range entries {
+			e := &entries[i]
+			r, err := parseRange(e.Versions)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", product, err)
+			}
+			e.versions = r
+
+			if e.Profile == "" {
+				continue
+			}
+			if e.profile, err = manifest.ProfileNamed(e.Profile); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", product, e.Versions, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Lookup returns the entry supporting the product version. Replicated tiles,
+// like p-isolation-segment-is1, use the entries of the product they were
+// replicated from.
+func (m *Matrix) Lookup(product, version string) (*Entry, error) {
+	name, ok := m.productName(product)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s isn't in the compatibility matrix", ErrUnsupported, product)
+	}
+
+	v, err := parseVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	entries := m.Products[name]
+	for i := range entries {
+		if entries[i].versions.matches(v) {
+			return &entries[i], nil
+		}
+	}
+
+	var ranges []string
+	for _, e := range entries {
+		ranges = append(ranges, e.Versions)
+	}
+	return nil, fmt.Errorf("%w: %s %s, supported versions are %s", ErrUnsupported, product, version,
+		strings.Join(ranges, " or "))
+}
+
+// productName returns the name of the product in the matrix
+func (m *Matrix) productName(product string) (string, bool) {
+	if _, ok := m.Products[product]; ok {
+		return product, true
+	}
+
+	// prefer the longest name when replicated tiles share a prefix
+	var names []string
+	for name := range m.Products {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for _, name := range names {
+		if strings.HasPrefix(product, name+"-") {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Product is a deployed product
+type Product struct {
+	Name    string
+	GUID    string
+	Version string
+}
+
+// Support is whether a deployed product's version is supported
+type Support struct {
+	Product
+	// Entry is the matching entry, it's nil when the version is unsupported
+	Entry *Entry
+	// Err is why the version is unsupported
+	Err error
+}
+
+// Check looks up each product in the matrix, products riic doesn't act on
+// are skipped.
+func (m *Matrix) Check(products []Product) []Support {
+	var supports []Support
+	for _, p := range products {
+		if _, ok := m.productName(p.Name); !ok {
+			continue
+		}
+		e, err := m.Lookup(p.Name, p.Version)
+		supports = append(supports, Support{Product: p, Entry: e, Err: err})
+	}
+	return supports
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package compat
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		versions string
+		version  string
+		matches  bool
+	}{
+		{">=2.4.0 <2.7.0", "2.4.0", true},
+		{">=2.4.0 <2.7.0", "2.6.21-build.2", true},
+		{">=2.4.0 <2.7.0", "2.7.0", false},
+		{">=2.4.0 <2.7.0", "2.3.9", false},
+		{">2.4 <=2.6", "2.4.0", false},
+		{">2.4 <=2.6", "2.6", true},
+		{"2.10.5", "2.10.5+LTS-T", true},
+		{"=2.10.5", "2.10.6", false},
+		{">=2.4.0 <2.7.0 || >=3.0.0", "3.0.1", true},
+		{">=2.4.0 <2.7.0 || >=3.0.0", "2.13.0", false},
+	}
+
+	for _, tc := range tests {
+		r, err := parseRange(tc.versions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := parseVersion(tc.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.matches(v) != tc.matches {
+			t.Errorf("expected %q matching %s to be %v", tc.versions, tc.version, tc.matches)
+		}
+	}
+
+	for _, invalid := range []string{"", ">=two", ">=2.4.0 ||", "2.4.0.1"} {
+		if _, err := parseRange(invalid); err == nil {
+			t.Errorf("expected %q to be an invalid range", invalid)
+		}
+	}
+}
+
+func TestDefault(t *testing.T) {
+	m, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		product string
+		version string
+		profile string
+	}{
+		{"cf", "2.4.9", "TAS 2.4-2.6"},
+		{"cf", "2.13.5-build.3", "TAS 2.13"},
+		{"cf", "6.0.4+LTS-T", "TAS 6.0"},
+		{"p-isolation-segment", "2.13.40", "TAS 2.13"},
+		{"p-isolation-segment-is1", "2.6.1", "TAS 2.4-2.6"},
+		{"pas-windows", "6.0.12", "TAS 6.0"},
+		{"cf", "2.3.1", ""},
+		{"cf", "2.10.5", ""},
+		{"cf", "2.14.0", ""},
+		{"pas-windows", "4.0.12", ""},
+		{"p-redis", "2.4.0", ""},
+	}
+	for _, tc := range tests {
+		e, err := m.Lookup(tc.product, tc.version)
+		if tc.profile == "" {
+			if !errors.Is(err, ErrUnsupported) {
+				t.Errorf("expected %s %s to be unsupported, but got %v", tc.product, tc.version, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected %s %s to be supported, but got %v", tc.product, tc.version, err)
+			continue
+		}
+		if p := e.ManifestProfile(); p == nil || p.Name != tc.profile {
+			t.Errorf("expected %s %s to use the %s profile, but got %v", tc.product, tc.version, tc.profile, p)
+		}
+	}
+
+	e, err := m.Lookup(OpsManager, "2.10.38-build.268")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{FeatureSelectiveApplyChanges, FeaturePendingChanges, FeatureRunningInstallations} {
+		if !e.Supports(f) {
+			t.Errorf("expected Operations Manager to support %s", f)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	write := func(content string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "matrix.yml")
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("overrides the default", func(t *testing.T) {
+		m, err := Load(write(`
+products:
+  cf:
+  - versions: ">=2.14.0 <3.0.0"
+    profile: TAS 2.13
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = m.Lookup("cf", "2.14.1"); err != nil {
+			t.Error(err)
+		}
+		if _, err = m.Lookup("cf", "2.4.0"); err == nil {
+			t.Error("expected the default ranges to be replaced")
+		}
+	})
+
+	t.Run("rejects invalid ranges", func(t *testing.T) {
+		if _, err := Load(write("products:\n  cf:\n  - versions: \">=two\"\n")); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("rejects unknown profiles", func(t *testing.T) {
+		if _, err := Load(write("products:\n  cf:\n  - versions: \">=2.4.0\"\n    profile: TAS 1.12\n")); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("rejects unknown fields", func(t *testing.T) {
+		if _, err := Load(write("products:\n  cf:\n  - version: \">=2.4.0\"\n")); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestCheck(t *testing.T) {
+	m, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	supports := m.Check([]Product{
+		{Name: "cf", GUID: "cf-1234", Version: "2.13.1"},
+		{Name: "p-redis", GUID: "p-redis-1234", Version: "2.4.0"},
+		{Name: "pas-windows", GUID: "pas-windows-1234", Version: "2.3.0"},
+	})
+	if len(supports) != 2 {
+		t.Fatalf("expected products riic doesn't act on to be skipped, but got %v", supports)
+	}
+	if supports[0].Err != nil || supports[0].Entry == nil {
+		t.Errorf("expected cf to be supported, but got %v", supports[0].Err)
+	}
+	if !errors.Is(supports[1].Err, ErrUnsupported) || supports[1].Entry != nil {
+		t.Errorf("expected pas-windows to be unsupported, but got %v", supports[1].Err)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package compat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// version is the major, minor and patch of a product version
+type version [3]int
+
+// parseVersion parses a product version like 2.10.5-build.3, a missing
+// minor or patch is 0 and build suffixes are ignored.
+func parseVersion(s string) (version, error) {
+	var v version
+	core := s
+	if i := strings.IndexAny(core, "-+"); i > -1 {
+		core = core[:i]
+	}
+	parts := strings.Split(core, ".")
+	if core == "" || len(parts) > len(v) {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func (v version) compare(o version) int {
+	for i := range v {
+		if v[i] != o[i] {
+			return v[i] - o[i]
+		}
+	}
+	return 0
+}
+
+// comparison is a single operator and version, like >=2.7.0
+type comparison struct {
+	op string
+	v  version
+}
+
+func (c comparison) matches(v version) bool {
+	n := v.compare(c.v)
+	switch c.op {
+	case ">=":
+		return n >= 0
+	case ">":
+		return n > 0
+	case "<=":
+		return n <= 0
+	case "<":
+		return n < 0
+	default:
+		return n == 0
+	}
+}
+
+// versionRange matches a version if all the comparisons of any of its sets
+// match
+type versionRange [][]comparison
+
+// parseRange parses a range like ">=2.4.0 <2.7.0 || >=3.0.0"
+func parseRange(s string) (versionRange, error) {
+	var r versionRange
+	for _, set := range strings.Split(s, "||") {
+		var comparisons []comparison
+		for _, f := range strings.Fields(set) {
+			c := comparison{op: "="}
+			for _, op := range []string{">=", "<=", ">", "<", "="} {
+				if strings.HasPrefix(f, op) {
+					c.op, f = op, strings.TrimPrefix(f, op)
+					break
+				}
+			}
+			v, err := parseVersion(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q: %w", s, err)
+			}
+			c.v = v
+			comparisons = append(comparisons, c)
+		}
+		if len(comparisons) == 0 {
+			return nil, fmt.Errorf("invalid range %q: empty comparison", s)
+		}
+		r = append(r, comparisons)
+	}
+	return r, nil
+}
+
+func (r versionRange) matches(v version) bool {
+	for _, set := range r {
+		matched := true
+		for _, c := range set {
+			matched = matched && c.matches(v)
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
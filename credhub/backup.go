@@ -0,0 +1,131 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package credhub
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// backupMagic identifies an encrypted riic credential backup and its format
+// version.
+const backupMagic = "RIICBAK1"
+
+// scrypt parameters recommended for interactive logins as of 2017
+const (
+	scryptN      = 32768
+	scryptR      = 8
+	scryptP      = 1
+	backupKeyLen = 32
+	backupSalt   = 16
+)
+
+// ErrBackupPassphrase is returned when a backup can't be decrypted, either
+// because the passphrase is wrong or the backup was modified.
+var ErrBackupPassphrase = errors.New("wrong passphrase or corrupt backup")
+
+// WriteBackup encrypts the certificates with a key derived from the
+// passphrase and writes them to the specified file. Decrypted, the backup is
+// a credhub bulk import file.
+//
+// The file layout is the magic header, the scrypt salt, the AES-GCM nonce,
+// then the sealed import file. The header is authenticated with the content.
+func WriteBackup(path, passphrase string, certs []Certificate) error {
+	if passphrase == "" {
+		return errors.New("a passphrase is required to encrypt the backup")
+	}
+
+	plaintext, err := yaml.Marshal(certificateImport{Credentials: certs})
+	if err != nil {
+		return fmt.Errorf("could not serialize backup: %w", err)
+	}
+
+	salt := make([]byte, backupSalt)
+	if _, err = rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	b.WriteString(backupMagic)
+	b.Write(salt)
+	b.Write(nonce)
+	b.Write(aead.Seal(nil, nonce, plaintext, []byte(backupMagic)))
+
+	if err = ioutil.WriteFile(path, b.Bytes(), 0600); err != nil {
+		return fmt.Errorf("could not write backup %s: %w", path, err)
+	}
+
+	// the backup is useless if it can't be read back, so check it now
+	if _, err = ReadBackup(path, passphrase); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("could not verify backup %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadBackup decrypts a backup created by WriteBackup
+func ReadBackup(path, passphrase string) ([]Certificate, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read backup %s: %w", path, err)
+	}
+
+	if !bytes.HasPrefix(b, []byte(backupMagic)) {
+		return nil, fmt.Errorf("%s is not a riic backup", path)
+	}
+	b = b[len(backupMagic):]
+	if len(b) < backupSalt {
+		return nil, fmt.Errorf("backup %s is truncated", path)
+	}
+	salt, b := b[:backupSalt], b[backupSalt:]
+
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, fmt.Errorf("backup %s is truncated", path)
+	}
+	nonce, sealed := b[:aead.NonceSize()], b[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, sealed, []byte(backupMagic))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt backup %s: %w", path, ErrBackupPassphrase)
+	}
+
+	var certImport certificateImport
+	if err = yaml.Unmarshal(plaintext, &certImport); err != nil {
+		return nil, fmt.Errorf("could not parse backup %s: %w", path, err)
+	}
+	return certImport.Credentials, nil
+}
+
+func backupCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, backupKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("could not derive backup key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package credhub
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	certs := []Certificate{
+		*NewCertificate("/cf/diego-instance-identity-root-ca", "certificate", "ca-cert", "ca-key", "ca-cert"),
+		*NewCertificate("/p-bosh/cf/diego-instance-identity-intermediate-ca-2018", "certificate", "intermediate-cert", "intermediate-key", "ca-cert"),
+	}
+	path := filepath.Join(t.TempDir(), "credhub.riic-backup")
+
+	if err := WriteBackup(path, "correct horse", certs); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte("ca-key")) {
+		t.Fatal("expected the backup to be encrypted, but found a private key")
+	}
+
+	restored, err := ReadBackup(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 2 || restored[1].Name != certs[1].Name || restored[1].Value.PrivateKey != "intermediate-key" {
+		t.Fatalf("expected the backup to contain the original certs, but got %v", restored)
+	}
+
+	if _, err = ReadBackup(path, "battery staple"); !errors.Is(err, ErrBackupPassphrase) {
+		t.Fatalf("expected a passphrase error, but got %v", err)
+	}
+
+	content[len(content)-1] ^= 0xff
+	if err = ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadBackup(path, "correct horse"); !errors.Is(err, ErrBackupPassphrase) {
+		t.Fatalf("expected a modified backup to be rejected, but got %v", err)
+	}
+
+	if err = WriteBackup(path, "", certs); err == nil {
+		t.Fatal("expected an error without a passphrase")
+	}
+}
@@ -5,13 +5,13 @@ package credhub
 
 // Certificate is a credhub certificate
 type Certificate struct {
-	Name  string `yaml:"name"`
-	Type  string `yaml:"type"`
+	Name  string `yaml:"name" json:"name"`
+	Type  string `yaml:"type" json:"type"`
 	Value struct {
-		Certificate string `yaml:"certificate,omitempty"`
-		PrivateKey  string `yaml:"private_key,omitempty"`
-		CA          string `yaml:"ca,omitempty"`
-	} `yaml:"value"`
+		Certificate string `yaml:"certificate,omitempty" json:"certificate,omitempty"`
+		PrivateKey  string `yaml:"private_key,omitempty" json:"private_key,omitempty"`
+		CA          string `yaml:"ca,omitempty" json:"ca,omitempty"`
+	} `yaml:"value" json:"value"`
 }
 
 func NewCertificate(name, certType, certificate, privateKey, CA string) *Certificate {
@@ -0,0 +1,288 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package credhub
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrBadStatusCode is returned when the CredHub API responds with an
+// unexpected status code
+var ErrBadStatusCode = errors.New("unexpected status code from credhub")
+
+// Client is a CredHub API client. It authenticates with UAA client
+// credentials taken from the same CREDHUB_* environment variables as the
+// credhub CLI, using the UAA advertised by the CredHub server.
+type Client struct {
+	url    string
+	client *http.Client
+}
+
+// Permission grants an actor operations on credentials matching a path
+type Permission struct {
+	UUID       string   `json:"uuid,omitempty"`
+	Path       string   `json:"path"`
+	Actor      string   `json:"actor"`
+	Operations []string `json:"operations"`
+}
+
+// NewClient creates a CredHub API client, env should contain the
+// CREDHUB_SERVER, CREDHUB_CLIENT, CREDHUB_SECRET, and CREDHUB_CA_CERT
+// environment variables. CREDHUB_CA_CERT may be a PEM certificate or a path
+// to one.
+func NewClient(env []string) (*Client, error) {
+	vars := map[string]string{}
+	for _, e := range env {
+		i := strings.Index(e, "=")
+		if i < 0 {
+			continue
+		}
+		if _, ok := vars[e[:i]]; !ok {
+			vars[e[:i]] = e[i+1:]
+		}
+	}
+	for _, name := range []string{"CREDHUB_SERVER", "CREDHUB_CLIENT", "CREDHUB_SECRET", "CREDHUB_CA_CERT"} {
+		if vars[name] == "" {
+			return nil, fmt.Errorf("could not connect to credhub, %s is not set", name)
+		}
+	}
+
+	serverURL := strings.TrimSuffix(vars["CREDHUB_SERVER"], "/")
+	if !strings.Contains(serverURL, "://") {
+		serverURL = "https://" + serverURL
+	}
+	if _, err := url.Parse(serverURL); err != nil {
+		return nil, fmt.Errorf("invalid CREDHUB_SERVER %q: %w", vars["CREDHUB_SERVER"], err)
+	}
+
+	var err error
+	caCert := []byte(vars["CREDHUB_CA_CERT"])
+	if !bytes.Contains(caCert, []byte("-----BEGIN")) {
+		caCert, err = ioutil.ReadFile(vars["CREDHUB_CA_CERT"])
+		if err != nil {
+			return nil, fmt.Errorf("could not read the credhub CA certificate: %w", err)
+		}
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("could not parse the credhub CA certificate")
+	}
+
+	base := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	c := &Client{
+		url:    serverURL,
+		client: base,
+	}
+
+	var info struct {
+		AuthServer struct {
+			URL string `json:"url"`
+		} `json:"auth-server"`
+	}
+	if err = c.do(context.Background(), http.MethodGet, "/info", nil, nil, &info); err != nil {
+		return nil, fmt.Errorf("could not get the credhub info: %w", err)
+	}
+	if info.AuthServer.URL == "" {
+		return nil, fmt.Errorf("credhub at %s didn't advertise a UAA server", serverURL)
+	}
+
+	config := &clientcredentials.Config{
+		ClientID:     vars["CREDHUB_CLIENT"],
+		ClientSecret: vars["CREDHUB_SECRET"],
+		TokenURL:     strings.TrimSuffix(info.AuthServer.URL, "/") + "/oauth/token",
+	}
+	c.client = config.Client(context.WithValue(context.Background(), oauth2.HTTPClient, base))
+	c.client.Timeout = base.Timeout
+	return c, nil
+}
+
+// GetCertificate gets the current version of a certificate
+func (c *Client) GetCertificate(ctx context.Context, certPath string) (*Certificate, error) {
+	certs, err := c.getData(ctx, certPath, url.Values{"current": {"true"}})
+	if err != nil {
+		return nil, fmt.Errorf("credhub get failed for %q: %w", certPath, err)
+	}
+	return &certs[0], nil
+}
+
+// GetCertificateVersions gets up to the specified number of versions of a
+// certificate, newest first.
+func (c *Client) GetCertificateVersions(ctx context.Context, certPath string, versions int) ([]Certificate, error) {
+	certs, err := c.getData(ctx, certPath, url.Values{"versions": {strconv.Itoa(versions)}})
+	if err != nil {
+		return nil, fmt.Errorf("credhub get failed for %q: %w", certPath, err)
+	}
+	return certs, nil
+}
+
+func (c *Client) getData(ctx context.Context, name string, query url.Values) ([]Certificate, error) {
+	query.Set("name", name)
+	var resp struct {
+		Data []Certificate `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v1/data", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, ErrCredentialNotFound
+	}
+	return resp.Data, nil
+}
+
+// SetCertificate sets a new version of a certificate
+func (c *Client) SetCertificate(ctx context.Context, cert Certificate) error {
+	if err := c.do(ctx, http.MethodPut, "/api/v1/data", nil, cert, nil); err != nil {
+		return fmt.Errorf("credhub set failed for %q: %w", cert.Name, err)
+	}
+	return nil
+}
+
+// ImportCertificates sets a new version of each of the certificates, like
+// credhub import does.
+func (c *Client) ImportCertificates(ctx context.Context, certs []Certificate) error {
+	for _, cert := range certs {
+		if err := c.SetCertificate(ctx, cert); err != nil {
+			return fmt.Errorf("could not overwrite values in credhub: %w", err)
+		}
+	}
+	return nil
+}
+
+// Import imports the certificates in a credhub import file
+func (c *Client) Import(ctx context.Context, credentialJsonPath string) error {
+	b, err := ioutil.ReadFile(credentialJsonPath)
+	if err != nil {
+		return fmt.Errorf("could not read credhub import file: %w", err)
+	}
+	var certImport certificateImport
+	if err = yaml.Unmarshal(b, &certImport); err != nil {
+		return fmt.Errorf("could not parse credhub import file %s: %w", credentialJsonPath, err)
+	}
+	return c.ImportCertificates(ctx, certImport.Credentials)
+}
+
+// Delete deletes every version of a credential
+func (c *Client) Delete(ctx context.Context, path string) error {
+	if err := c.do(ctx, http.MethodDelete, "/api/v1/data", url.Values{"name": {path}}, nil, nil); err != nil {
+		return fmt.Errorf("credhub delete failed %q: %w", path, err)
+	}
+	return nil
+}
+
+// Find returns the names of the credentials whose name contains nameLike
+func (c *Client) Find(ctx context.Context, nameLike string) ([]string, error) {
+	return c.find(ctx, url.Values{"name-like": {nameLike}})
+}
+
+// FindByPath returns the names of the credentials under the path
+func (c *Client) FindByPath(ctx context.Context, path string) ([]string, error) {
+	return c.find(ctx, url.Values{"path": {path}})
+}
+
+func (c *Client) find(ctx context.Context, query url.Values) (names []string, err error) {
+	var resp struct {
+		Credentials []struct {
+			Name string `json:"name"`
+		} `json:"credentials"`
+	}
+	if err = c.do(ctx, http.MethodGet, "/api/v1/data", query, nil, &resp); err != nil {
+		return nil, fmt.Errorf("credhub find failed: %w", err)
+	}
+	for _, cred := range resp.Credentials {
+		names = append(names, cred.Name)
+	}
+	return names, nil
+}
+
+// GetPermission gets the permission an actor has on a path
+func (c *Client) GetPermission(ctx context.Context, path, actor string) (*Permission, error) {
+	var p Permission
+	if err := c.do(ctx, http.MethodGet, "/api/v2/permissions", url.Values{"path": {path}, "actor": {actor}}, nil, &p); err != nil {
+		return nil, fmt.Errorf("credhub get permission failed for %s on %q: %w", actor, path, err)
+	}
+	return &p, nil
+}
+
+// AddPermission grants an actor operations (read, write, delete,
+// read_acl, write_acl) on a path
+func (c *Client) AddPermission(ctx context.Context, path, actor string, operations []string) (*Permission, error) {
+	p := Permission{Path: path, Actor: actor, Operations: operations}
+	if err := c.do(ctx, http.MethodPost, "/api/v2/permissions", nil, p, &p); err != nil {
+		return nil, fmt.Errorf("credhub add permission failed for %s on %q: %w", actor, path, err)
+	}
+	return &p, nil
+}
+
+// do sends a JSON request and decodes the JSON response into out if it
+// isn't nil. A 404 is returned as ErrCredentialNotFound.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	u := c.url + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrCredentialNotFound
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("%w, got %d with body %s", ErrBadStatusCode, resp.StatusCode, string(b))
+	}
+	if out == nil {
+		return nil
+	}
+	if err = json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("invalid json from credhub %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package credhub
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCredhub is a minimal in memory CredHub API with a UAA token endpoint
+type fakeCredhub struct {
+	mu          sync.Mutex
+	server      *httptest.Server
+	credentials map[string][]Certificate
+	permissions []Permission
+}
+
+func newFakeCredhub(t *testing.T) *fakeCredhub {
+	f := &fakeCredhub{credentials: map[string][]Certificate{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"auth-server":{"url":"%s/uaa"},"app":{"name":"CredHub"}}`, f.server.URL)
+	})
+	mux.HandleFunc("/uaa/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"credhub-token","token_type":"bearer","expires_in":3600}`)
+	})
+	mux.HandleFunc("/api/v1/data", f.authorized(f.data))
+	mux.HandleFunc("/api/v2/permissions", f.authorized(f.permission))
+	f.server = httptest.NewTLSServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeCredhub) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer credhub-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		h(w, r)
+	}
+}
+
+func (f *fakeCredhub) data(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	switch r.Method {
+	case http.MethodPut:
+		var c Certificate
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.credentials[c.Name] = append([]Certificate{c}, f.credentials[c.Name]...)
+		json.NewEncoder(w).Encode(c)
+	case http.MethodDelete:
+		if _, ok := f.credentials[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.credentials, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		if like := q.Get("name-like"); like != "" {
+			var found []map[string]string
+			for n := range f.credentials {
+				if strings.Contains(n, like) {
+					found = append(found, map[string]string{"name": n})
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"credentials": found})
+			return
+		}
+		versions, ok := f.credentials[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"The request could not be completed because the credential does not exist or you do not have sufficient authorization."}`)
+			return
+		}
+		if q.Get("current") == "true" {
+			versions = versions[:1]
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": versions})
+	}
+}
+
+func (f *fakeCredhub) permission(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var p Permission
+		json.NewDecoder(r.Body).Decode(&p)
+		p.UUID = fmt.Sprintf("uuid-%d", len(f.permissions))
+		f.permissions = append(f.permissions, p)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(p)
+		return
+	}
+	for _, p := range f.permissions {
+		if p.Path == r.URL.Query().Get("path") && p.Actor == r.URL.Query().Get("actor") {
+			json.NewEncoder(w).Encode(p)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func (f *fakeCredhub) env(t *testing.T) []string {
+	return []string{
+		"CREDHUB_CLIENT=ops_manager",
+		"CREDHUB_SECRET=secret",
+		"CREDHUB_CA_CERT=" + string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.server.Certificate().Raw})),
+		"CREDHUB_SERVER=" + f.server.URL,
+	}
+}
+
+func TestClient(t *testing.T) {
+	f := newFakeCredhub(t)
+	c, err := NewClient(f.env(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = c.GetCertificate(context.Background(), "/p-bosh/cf/diego-instance-identity-root-ca")
+	if !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	certs := []Certificate{
+		*NewCertificate("/p-bosh/cf/diego-instance-identity-root-ca", "certificate", "ca-cert", "ca-key", "ca-cert"),
+		*NewCertificate("/p-bosh/cf/diego-instance-identity-intermediate-ca", "certificate", "intermediate-cert", "intermediate-key", "ca-cert"),
+	}
+	if err = c.ImportCertificates(context.Background(), certs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rotated := *NewCertificate("/p-bosh/cf/diego-instance-identity-root-ca", "certificate", "new-ca-cert", "new-ca-key", "new-ca-cert")
+	if err = c.SetCertificate(context.Background(), rotated); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cert, err := c.GetCertificate(context.Background(), "/p-bosh/cf/diego-instance-identity-root-ca")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(*cert, rotated) {
+		t.Errorf("expected the current version %+v, got %+v", rotated, *cert)
+	}
+
+	versions, err := c.GetCertificateVersions(context.Background(), "/p-bosh/cf/diego-instance-identity-root-ca", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(versions) != 2 || versions[1].Value.Certificate != "ca-cert" {
+		t.Errorf("expected both versions newest first, got %+v", versions)
+	}
+
+	names, err := c.Find(context.Background(), "intermediate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(names) != 1 || names[0] != "/p-bosh/cf/diego-instance-identity-intermediate-ca" {
+		t.Errorf("unexpected find result %v", names)
+	}
+
+	if err = c.Delete(context.Background(), "/p-bosh/cf/diego-instance-identity-intermediate-ca"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = c.Delete(context.Background(), "/p-bosh/cf/diego-instance-identity-intermediate-ca")
+	if !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("expected a not found error deleting twice, got %v", err)
+	}
+
+	p, err := c.AddPermission(context.Background(), "/p-bosh/cf/*", "uaa-client:riic", []string{"read"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := c.GetPermission(context.Background(), "/p-bosh/cf/*", "uaa-client:riic")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(p, got) || got.UUID == "" {
+		t.Errorf("expected the added permission %+v, got %+v", p, got)
+	}
+}
+
+func TestClientImportFile(t *testing.T) {
+	f := newFakeCredhub(t)
+	c, err := NewClient(f.env(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	importFile := filepath.Join(t.TempDir(), "import.yml")
+	if err = ioutil.WriteFile(importFile, []byte(expectedImportContent), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Import(context.Background(), importFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cert, err := c.GetCertificate(context.Background(), "diego-intermediate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cert.Value.PrivateKey != "intermediate-key" || cert.Value.CA != "ca-cert" {
+		t.Errorf("unexpected imported certificate %+v", cert)
+	}
+}
+
+func TestNewClientRequiresEnv(t *testing.T) {
+	_, err := NewClient([]string{"CREDHUB_CLIENT=ops_manager"})
+	if err == nil || !strings.Contains(err.Error(), "CREDHUB_SERVER") {
+		t.Fatalf("expected a missing CREDHUB_SERVER error, got %v", err)
+	}
+}
@@ -4,6 +4,9 @@
 package credhub
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -13,9 +16,15 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// ErrCredentialNotFound is returned when the requested credential doesn't
+// exist in credhub.
+var ErrCredentialNotFound = errors.New("credential does not exist")
+
+// Runner is a credhub command runner. Commands are killed when the context
+// they're run with is done.
 type Runner struct {
 	env         []string
-	credhubExec func(args ...string) ([]byte, error)
+	credhubExec func(ctx context.Context, args ...string) ([]byte, error)
 }
 
 // NewRunner creates a new credhub runner instance, env should contain the
@@ -28,9 +37,12 @@ func NewRunner(env []string) *Runner {
 	return r
 }
 
-func (r *Runner) GetCertificate(certPath string) (*Certificate, error) {
-	output, err := r.credhubExec("get", "-n", certPath)
+func (r *Runner) GetCertificate(ctx context.Context, certPath string) (*Certificate, error) {
+	output, err := r.credhubExec(ctx, "get", "-n", certPath)
 	if err != nil {
+		if strings.Contains(err.Error(), "does not exist") {
+			return nil, fmt.Errorf("credhub get failed for %q: %w", certPath, ErrCredentialNotFound)
+		}
 		return nil, fmt.Errorf("credhub get failed for %q: %w", certPath, err)
 	}
 
@@ -43,19 +55,47 @@ func (r *Runner) GetCertificate(certPath string) (*Certificate, error) {
 	return &cred, nil
 }
 
-func (r *Runner) Delete(path string) error {
-	_, err := r.credhubExec("delete", "-n", path)
+func (r *Runner) Delete(ctx context.Context, path string) error {
+	_, err := r.credhubExec(ctx, "delete", "-n", path)
 	if err != nil {
 		return fmt.Errorf("credhub delete failed %q: %w", path, err)
 	}
 	return nil
 }
 
-// ImportCertificates will bulk import multiple certs using a credentials file
-func (r *Runner) ImportCertificates(certs []Certificate) error {
-	type certificateImport struct {
-		Credentials []Certificate `yaml:"credentials"`
+// Find returns the names of the credentials whose name contains nameLike
+func (r *Runner) Find(ctx context.Context, nameLike string) ([]string, error) {
+	output, err := r.credhubExec(ctx, "find", "-n", nameLike, "-j")
+	if err != nil {
+		if strings.Contains(err.Error(), "No credentials exist") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("credhub find failed: %w", err)
 	}
+
+	var found struct {
+		Credentials []struct {
+			Name string `json:"name"`
+		} `json:"credentials"`
+	}
+	if err = json.Unmarshal(output, &found); err != nil {
+		return nil, fmt.Errorf("invalid json from credhub find: %w", err)
+	}
+
+	var names []string
+	for _, c := range found.Credentials {
+		names = append(names, c.Name)
+	}
+	return names, nil
+}
+
+// certificateImport is the credhub bulk import file format
+type certificateImport struct {
+	Credentials []Certificate `yaml:"credentials"`
+}
+
+// ImportCertificates will bulk import multiple certs using a credentials file
+func (r *Runner) ImportCertificates(ctx context.Context, certs []Certificate) error {
 	certImport := certificateImport{
 		Credentials: certs,
 	}
@@ -73,20 +113,23 @@ func (r *Runner) ImportCertificates(certs []Certificate) error {
 
 	f.Close()
 	defer os.RemoveAll(f.Name())
-	return r.Import(f.Name())
+	return r.Import(ctx, f.Name())
 }
 
-func (r *Runner) Import(credentialJsonPath string) error {
-	_, err := r.credhubExec("import", "-f", credentialJsonPath)
+func (r *Runner) Import(ctx context.Context, credentialJsonPath string) error {
+	_, err := r.credhubExec(ctx, "import", "-f", credentialJsonPath)
 	if err != nil {
 		return fmt.Errorf("could not overwrite values in credhub: %w", err)
 	}
 	return nil
 }
 
-func (r *Runner) credhubCLI(args ...string) ([]byte, error) {
-	cmd := exec.Command("credhub", args...)
+func (r *Runner) credhubCLI(ctx context.Context, args ...string) ([]byte, error) {
+	// run in its own process group so an interrupt only reaches riic, which
+	// decides whether to wait for it
+	cmd := exec.CommandContext(ctx, "credhub", args...)
 	cmd.Env = r.env
+	detach(cmd)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("could not execute credhub command: credhub %s: %w\n%s",
@@ -4,6 +4,8 @@
 package credhub
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"strings"
 	"testing"
@@ -16,7 +18,7 @@ func TestImportCerts(t *testing.T) {
 	}
 
 	r := NewRunner([]string{})
-	r.credhubExec = func(args ...string) ([]byte, error) {
+	r.credhubExec = func(ctx context.Context, args ...string) ([]byte, error) {
 		if len(args) != 3 {
 			t.Fatalf("Expected 3 credhub CLI flags, but got %d", len(args))
 		}
@@ -40,7 +42,7 @@ func TestImportCerts(t *testing.T) {
 		return nil, nil
 	}
 
-	err := r.ImportCertificates(certs)
+	err := r.ImportCertificates(context.Background(), certs)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -48,7 +50,7 @@ func TestImportCerts(t *testing.T) {
 
 func TestGetCert(t *testing.T) {
 	r := NewRunner([]string{})
-	r.credhubExec = func(args ...string) ([]byte, error) {
+	r.credhubExec = func(ctx context.Context, args ...string) ([]byte, error) {
 		if len(args) != 3 {
 			t.Fatalf("Expected 3 credhub CLI flags, but got %d", len(args))
 		}
@@ -62,7 +64,7 @@ func TestGetCert(t *testing.T) {
 		return []byte(credhubGetOutput), nil
 	}
 
-	cert, err := r.GetCertificate("/cf/diego-instance-identity-root-ca")
+	cert, err := r.GetCertificate(context.Background(), "/cf/diego-instance-identity-root-ca")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -84,6 +86,19 @@ func TestGetCert(t *testing.T) {
 	}
 }
 
+func TestGetMissingCert(t *testing.T) {
+	r := NewRunner([]string{})
+	r.credhubExec = func(ctx context.Context, args ...string) ([]byte, error) {
+		return nil, errors.New("could not execute credhub command: credhub get -n /missing: exit status 1\n" +
+			"The request could not be completed because the credential does not exist or you do not have sufficient authorization.")
+	}
+
+	_, err := r.GetCertificate(context.Background(), "/missing")
+	if !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("Expected a credential not found error, but got %v", err)
+	}
+}
+
 const expectedImportContent = `
 credentials:
 - name: diego-ca
@@ -177,3 +192,29 @@ value:
     -----END RSA PRIVATE KEY-----
 version_created_at: "2020-10-26T17:30:42Z"
 `
+
+func TestFind(t *testing.T) {
+	r := NewRunner([]string{})
+	r.credhubExec = func(ctx context.Context, args ...string) ([]byte, error) {
+		if strings.Join(args, " ") != "find -n -riic-regen -j" {
+			t.Fatalf("Unexpected credhub CLI args %v", args)
+		}
+		return []byte(`{"credentials":[{"version_created_at":"2021-03-15T18:02:10Z","name":"/services/tls_ca-riic-regen"}]}`), nil
+	}
+
+	names, err := r.Find(context.Background(), "-riic-regen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "/services/tls_ca-riic-regen" {
+		t.Fatalf("Unexpected credentials %v", names)
+	}
+
+	r.credhubExec = func(ctx context.Context, args ...string) ([]byte, error) {
+		return nil, errors.New("could not execute credhub command: No credentials exist which match the provided parameters.")
+	}
+	names, err = r.Find(context.Background(), "-riic-regen")
+	if err != nil || len(names) != 0 {
+		t.Fatalf("Expected no credentials and no error, got %v and %v", names, err)
+	}
+}
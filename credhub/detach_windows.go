@@ -0,0 +1,9 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package credhub
+
+import "os/exec"
+
+// detach is a no-op, riic only runs on the Operations Manager VM
+func detach(*exec.Cmd) {}
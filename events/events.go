@@ -0,0 +1,173 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package events publishes the progress of a rotation or validation so it
+// can be logged for operators and consumed by automation.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// The types of events
+const (
+	Info    = "info"
+	Warning = "warning"
+	Error   = "error"
+
+	PhaseStarted  = "phase_started"
+	PhaseFinished = "phase_finished"
+
+	DeployStarted  = "deploy_started"
+	DeployFinished = "deploy_finished"
+
+	CredhubOperation = "credhub_operation"
+
+	ApplyChangesStarted  = "apply_changes_started"
+	ApplyChangesProgress = "apply_changes_progress"
+	ApplyChangesFinished = "apply_changes_finished"
+
+	Validation = "validation"
+	HealthGate = "health_gate"
+)
+
+// The status of a finished event
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Event is a single thing that happened, only the fields relevant to the
+// type of event are set.
+type Event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+
+	Phase      string   `json:"phase,omitempty"`
+	Deployment string   `json:"deployment,omitempty"`
+	Instance   string   `json:"instance,omitempty"`
+	Products   []string `json:"products,omitempty"`
+	// Operation is the credhub operation (get, import, delete) or the
+	// validation performed
+	Operation string   `json:"operation,omitempty"`
+	Names     []string `json:"names,omitempty"`
+
+	Status          string  `json:"status,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	Message         string  `json:"message,omitempty"`
+	// Output is a line of output from a long running operation
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Finished sets the status, duration and error of an event that started at
+// the specified time.
+func (e Event) Finished(started time.Time, err error) Event {
+	e.DurationSeconds = time.Since(started).Seconds()
+	e.Status = StatusSucceeded
+	if err != nil {
+		e.Status = StatusFailed
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// Sink receives published events, sinks must be safe for concurrent use.
+type Sink interface {
+	Publish(e Event)
+}
+
+// Infof publishes an informational message
+func Infof(s Sink, format string, args ...interface{}) {
+	s.Publish(Event{Type: Info, Message: fmt.Sprintf(format, args...)})
+}
+
+// Warnf publishes a warning message
+func Warnf(s Sink, format string, args ...interface{}) {
+	s.Publish(Event{Type: Warning, Message: fmt.Sprintf(format, args...)})
+}
+
+// Errorf publishes an error message
+func Errorf(s Sink, format string, args ...interface{}) {
+	s.Publish(Event{Type: Error, Message: fmt.Sprintf(format, args...)})
+}
+
+// LogSink writes the message of each event to the standard logger, events
+// without a message aren't logged.
+type LogSink struct{}
+
+// NewLogSink creates a sink that writes to the standard logger
+func NewLogSink() *LogSink {
+	return &LogSink{}
+}
+
+// Publish logs the event message
+func (s *LogSink) Publish(e Event) {
+	if e.Message == "" {
+		return
+	}
+	switch e.Type {
+	case Warning:
+		log.Println("[WARNING]:", e.Message)
+	case Error:
+		log.Println("[ERROR]:", e.Message)
+	default:
+		log.Println(e.Message)
+	}
+}
+
+// JSONSink writes each event as a single line JSON object
+type JSONSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONSink creates a sink that writes JSON lines to w
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// Publish writes the event
+func (s *JSONSink) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(e); err != nil {
+		log.Println("[WARNING]: could not write event:", err)
+	}
+}
+
+// MultiSink publishes each event to every sink
+type MultiSink []Sink
+
+// NewMultiSink creates a sink that publishes to each of the sinks
+func NewMultiSink(sinks ...Sink) MultiSink {
+	return MultiSink(sinks)
+}
+
+// Publish publishes the event to every sink
+func (m MultiSink) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	for _, s := range m {
+		s.Publish(e)
+	}
+}
+
+// Discard is a sink that drops every event
+var Discard Sink = discard{}
+
+type discard struct{}
+
+func (discard) Publish(Event) {}
@@ -0,0 +1,60 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package events_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+)
+
+type recorder struct {
+	events []events.Event
+}
+
+func (r *recorder) Publish(e events.Event) {
+	r.events = append(r.events, e)
+}
+
+func TestJSONSink(t *testing.T) {
+	var out bytes.Buffer
+	rec := &recorder{}
+	sink := events.NewMultiSink(events.NewJSONSink(&out), rec)
+
+	events.Infof(sink, "Rotating identity certs in %s", "Credhub")
+	started := time.Now().Add(-2 * time.Second)
+	sink.Publish(events.Event{Type: events.DeployFinished, Deployment: "cf-guid"}.Finished(started, errors.New("deploy failed")))
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("expected a JSON object per line, but got %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 events, but got %d", len(lines))
+	}
+
+	if lines[0]["type"] != events.Info || lines[0]["message"] != "Rotating identity certs in Credhub" {
+		t.Errorf("unexpected info event %v", lines[0])
+	}
+	if lines[1]["status"] != events.StatusFailed || lines[1]["error"] != "deploy failed" {
+		t.Errorf("unexpected deploy event %v", lines[1])
+	}
+	if d, ok := lines[1]["duration_seconds"].(float64); !ok || d < 2 {
+		t.Errorf("expected a duration of at least 2 seconds, but got %v", lines[1]["duration_seconds"])
+	}
+
+	if len(rec.events) != 2 || rec.events[0].Time.IsZero() {
+		t.Errorf("expected every sink to receive timestamped events, but got %v", rec.events)
+	}
+}
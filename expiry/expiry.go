@@ -0,0 +1,257 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package expiry reports how close the instance identity certs are to
+// expiring, in formats that can be read by people and by monitoring.
+package expiry
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/validate"
+	"gopkg.in/yaml.v2"
+)
+
+// The report formats
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatYAML  = "yaml"
+	FormatCSV   = "csv"
+)
+
+// The cert names
+const (
+	CertRoot         = "root"
+	CertIntermediate = "intermediate"
+)
+
+// Status is how close a cert is to expiring
+type Status string
+
+// The statuses, from best to worst
+const (
+	StatusOK       Status = "ok"
+	StatusWarning  Status = "warning"
+	StatusCritical Status = "critical"
+	StatusExpired  Status = "expired"
+)
+
+var severity = map[Status]int{
+	StatusOK:       0,
+	StatusWarning:  1,
+	StatusCritical: 2,
+	StatusExpired:  3,
+}
+
+// ExitCode is the process exit code for the status, 1 is left for failures
+// to check the certs.
+func (s Status) ExitCode() int {
+	switch s {
+	case StatusWarning:
+		return 2
+	case StatusCritical:
+		return 3
+	case StatusExpired:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// Thresholds are the days before a cert expires that it's a warning or
+// critical
+type Thresholds struct {
+	WarnDays     int
+	CriticalDays int
+}
+
+// Validate checks the thresholds are usable
+func (t Thresholds) Validate() error {
+	if t.CriticalDays < 0 || t.WarnDays < 0 {
+		return fmt.Errorf("the warning and critical days can't be negative")
+	}
+	if t.CriticalDays > t.WarnDays {
+		return fmt.Errorf("the critical days (%d) can't be more than the warning days (%d)",
+			t.CriticalDays, t.WarnDays)
+	}
+	return nil
+}
+
+// Cert is the expiry status of a single cert
+type Cert struct {
+	Cert       string `json:"cert" yaml:"cert"`
+	Deployment string `json:"deployment,omitempty" yaml:"deployment,omitempty"`
+	// Instance is the VM the cert is deployed on, it's empty for the certs
+	// in credhub
+	Instance          string `json:"instance,omitempty" yaml:"instance,omitempty"`
+	validate.CertInfo `yaml:",inline"`
+	DaysLeft          int    `json:"days_left" yaml:"days_left"`
+	Status            Status `json:"status" yaml:"status"`
+	// Stale is true when the deployed cert expires sooner than credhub
+	// suggests
+	Stale bool `json:"stale,omitempty" yaml:"stale,omitempty"`
+}
+
+// Name is how the cert is shown to people
+func (c Cert) Name() string {
+	name := c.Cert
+	if c.Instance != "" {
+		name = c.Instance + " " + name
+	}
+	if c.Deployment != "" {
+		name = c.Deployment + " " + name
+	}
+	return name
+}
+
+// Report is the expiry status of the root and intermediate certs
+type Report struct {
+	// Status is the worst status of the certs
+	Status Status `json:"status" yaml:"status"`
+	Certs  []Cert `json:"certs" yaml:"certs"`
+
+	thresholds Thresholds
+	now        time.Time
+}
+
+// NewReport creates an empty report, the certs are compared to now.
+func NewReport(thresholds Thresholds, now time.Time) *Report {
+	return &Report{
+		Status:     StatusOK,
+		Certs:      []Cert{},
+		thresholds: thresholds,
+		now:        now,
+	}
+}
+
+// Add adds the cert to the report, the deployment is empty for the root.
+func (r *Report) Add(cert, deployment string, info validate.CertInfo) {
+	r.add(Cert{
+		Cert:       cert,
+		Deployment: deployment,
+		CertInfo:   info,
+	})
+}
+
+// AddDeployed adds the certs deployed on an instance of the deployment. A
+// diego cell's chain expires with its first cert, and it's compared to the
+// intermediate in credhub. A router trusts the app instance identity certs
+// until the last of its roots with the subject of the credhub root expires,
+// and it's compared to the root in credhub. A stale instance is at least a
+// warning, and a router without the root is critical.
+func (r *Report) AddDeployed(deployment string, d validate.DeployedCerts, intermediate, root validate.CertInfo) {
+	c := Cert{
+		Cert:       d.Kind,
+		Deployment: deployment,
+		Instance:   d.VM.Name,
+	}
+
+	var credhub validate.CertInfo
+	var found bool
+	if d.Kind == validate.RouterCACerts {
+		credhub = root
+		for _, info := range d.Certs {
+			if info.Subject == root.Subject && (!found || info.NotAfter.After(c.NotAfter)) {
+				c.CertInfo, found = info, true
+			}
+		}
+	} else {
+		credhub = intermediate
+		for _, info := range d.Certs {
+			if !found || info.NotAfter.Before(c.NotAfter) {
+				c.CertInfo, found = info, true
+			}
+		}
+	}
+
+	if !found {
+		c.Stale = true
+		c.Status = StatusCritical
+		r.append(c)
+		return
+	}
+	c.Stale = c.NotAfter.Before(credhub.NotAfter)
+	r.add(c)
+}
+
+// add computes the cert's status and adds it to the report
+func (r *Report) add(c Cert) {
+	c.DaysLeft = int(c.NotAfter.Sub(r.now).Hours() / 24)
+
+	switch {
+	case !r.now.Before(c.NotAfter):
+		c.Status = StatusExpired
+	case c.DaysLeft <= r.thresholds.CriticalDays:
+		c.Status = StatusCritical
+	case c.DaysLeft <= r.thresholds.WarnDays:
+		c.Status = StatusWarning
+	default:
+		c.Status = StatusOK
+	}
+	if c.Stale && c.Status == StatusOK {
+		c.Status = StatusWarning
+	}
+	r.append(c)
+}
+
+func (r *Report) append(c Cert) {
+	if severity[c.Status] > severity[r.Status] {
+		r.Status = c.Status
+	}
+	r.Certs = append(r.Certs, c)
+}
+
+// Write writes the report in the format
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatTable:
+		return r.writeTable(w)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case FormatYAML:
+		return yaml.NewEncoder(w).Encode(r)
+	case FormatCSV:
+		return r.writeCSV(w)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func (r *Report) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CERT\tSTATUS\tDAYS LEFT\tEXPIRES")
+	for _, c := range r.Certs {
+		status := string(c.Status)
+		if c.Stale {
+			status += " (stale)"
+		}
+		expires := "-"
+		if !c.NotAfter.IsZero() {
+			expires = c.NotAfter.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", c.Name(), status, c.DaysLeft, expires)
+	}
+	return tw.Flush()
+}
+
+func (r *Report) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"cert", "deployment", "instance", "status", "stale", "days_left", "subject", "issuer", "serial",
+		"sha256_fingerprint", "not_before", "not_after"})
+	for _, c := range r.Certs {
+		cw.Write([]string{c.Cert, c.Deployment, c.Instance, string(c.Status), strconv.FormatBool(c.Stale),
+			strconv.Itoa(c.DaysLeft), c.Subject, c.Issuer, c.Serial, c.SHA256Fingerprint,
+			c.NotBefore.UTC().Format(time.RFC3339), c.NotAfter.UTC().Format(time.RFC3339)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
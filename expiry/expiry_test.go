@@ -0,0 +1,217 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package expiry
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/validate"
+	"gopkg.in/yaml.v2"
+)
+
+var now = time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+
+func expiringIn(days int) validate.CertInfo {
+	return validate.CertInfo{
+		Subject:           "CN=Diego Instance Identity Root CA",
+		Issuer:            "CN=Diego Instance Identity Root CA",
+		Serial:            "01:02",
+		SHA256Fingerprint: "AB:CD",
+		NotBefore:         now.AddDate(-1, 0, 0),
+		NotAfter:          now.Add(time.Duration(days)*24*time.Hour + time.Hour),
+	}
+}
+
+func TestReportStatus(t *testing.T) {
+	thresholds := Thresholds{WarnDays: 90, CriticalDays: 30}
+	tests := []struct {
+		days     []int
+		status   Status
+		exitCode int
+	}{
+		{[]int{365, 91}, StatusOK, 0},
+		{[]int{365, 90}, StatusWarning, 2},
+		{[]int{60, 30}, StatusCritical, 3},
+		{[]int{365, -1, 10}, StatusExpired, 4},
+	}
+
+	for _, tc := range tests {
+		r := NewReport(thresholds, now)
+		for i, d := range tc.days {
+			r.Add(CertIntermediate, "cf-"+string(rune('a'+i)), expiringIn(d))
+		}
+		if r.Status != tc.status {
+			t.Errorf("expected %v to be %s, but got %s", tc.days, tc.status, r.Status)
+		}
+		if r.Status.ExitCode() != tc.exitCode {
+			t.Errorf("expected %s to exit with %d, but got %d", r.Status, tc.exitCode, r.Status.ExitCode())
+		}
+	}
+}
+
+func TestAddDeployed(t *testing.T) {
+	root := expiringIn(1000)
+	intermediate := expiringIn(700)
+	intermediate.Subject = "CN=Diego Instance Identity Intermediate CA"
+	oldIntermediate := expiringIn(200)
+	oldIntermediate.Subject = intermediate.Subject
+	oldRoot := expiringIn(20)
+	servicesCA := expiringIn(5)
+	servicesCA.Subject = "CN=Services CA"
+
+	tests := []struct {
+		name     string
+		deployed validate.DeployedCerts
+		status   Status
+		stale    bool
+		daysLeft int
+	}{
+		{
+			name:     "cell serving the credhub intermediate",
+			deployed: validate.DeployedCerts{Kind: validate.InstanceIdentityCerts, Certs: []validate.CertInfo{intermediate}},
+			status:   StatusOK,
+			daysLeft: 700,
+		},
+		{
+			name:     "cell serving an older intermediate",
+			deployed: validate.DeployedCerts{Kind: validate.InstanceIdentityCerts, Certs: []validate.CertInfo{oldIntermediate}},
+			status:   StatusWarning,
+			stale:    true,
+			daysLeft: 200,
+		},
+		{
+			name:     "cell chain expiring with its root",
+			deployed: validate.DeployedCerts{Kind: validate.InstanceIdentityCerts, Certs: []validate.CertInfo{intermediate, oldRoot}},
+			status:   StatusCritical,
+			stale:    true,
+			daysLeft: 20,
+		},
+		{
+			name:     "router trusting both roots",
+			deployed: validate.DeployedCerts{Kind: validate.RouterCACerts, Certs: []validate.CertInfo{servicesCA, oldRoot, root}},
+			status:   StatusOK,
+			daysLeft: 1000,
+		},
+		{
+			name:     "router trusting only the old root",
+			deployed: validate.DeployedCerts{Kind: validate.RouterCACerts, Certs: []validate.CertInfo{servicesCA, oldRoot}},
+			status:   StatusCritical,
+			stale:    true,
+			daysLeft: 20,
+		},
+		{
+			name:     "router without the root",
+			deployed: validate.DeployedCerts{Kind: validate.RouterCACerts, Certs: []validate.CertInfo{servicesCA}},
+			status:   StatusCritical,
+			stale:    true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewReport(Thresholds{WarnDays: 90, CriticalDays: 30}, now)
+			tc.deployed.VM.Name = "diego_cell/guid1"
+			r.AddDeployed("cf-1234", tc.deployed, intermediate, root)
+
+			c := r.Certs[0]
+			if c.Status != tc.status || c.Stale != tc.stale || c.DaysLeft != tc.daysLeft {
+				t.Errorf("expected %s, stale %v, %d days left, but got %s, stale %v, %d days left",
+					tc.status, tc.stale, tc.daysLeft, c.Status, c.Stale, c.DaysLeft)
+			}
+			if r.Status != tc.status {
+				t.Errorf("expected the report to be %s, but got %s", tc.status, r.Status)
+			}
+			if c.Name() != "cf-1234 diego_cell/guid1 "+tc.deployed.Kind {
+				t.Errorf("unexpected name %s", c.Name())
+			}
+		})
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	if err := (Thresholds{WarnDays: 90, CriticalDays: 30}).Validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (Thresholds{WarnDays: 30, CriticalDays: 90}).Validate(); err == nil {
+		t.Error("expected critical days beyond the warning days to be invalid")
+	}
+	if err := (Thresholds{WarnDays: -1}).Validate(); err == nil {
+		t.Error("expected negative days to be invalid")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r := NewReport(Thresholds{WarnDays: 90, CriticalDays: 30}, now)
+	r.Add(CertRoot, "", expiringIn(365))
+	r.Add(CertIntermediate, "cf-1234", expiringIn(45))
+
+	write := func(format string) []byte {
+		t.Helper()
+		var b bytes.Buffer
+		if err := r.Write(&b, format); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+
+	t.Run("table", func(t *testing.T) {
+		out := string(write(FormatTable))
+		if !strings.Contains(out, "cf-1234 intermediate") || !strings.Contains(out, "warning") {
+			t.Errorf("unexpected table:\n%s", out)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var got struct {
+			Status string
+			Certs  []map[string]interface{}
+		}
+		if err := json.Unmarshal(write(FormatJSON), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != "warning" || len(got.Certs) != 2 {
+			t.Fatalf("unexpected report %+v", got)
+		}
+		for _, key := range []string{"subject", "issuer", "serial", "sha256_fingerprint", "not_before", "not_after"} {
+			if _, ok := got.Certs[0][key]; !ok {
+				t.Errorf("expected the json to include %s", key)
+			}
+		}
+		if got.Certs[1]["deployment"] != "cf-1234" {
+			t.Errorf("expected the intermediate's deployment, but got %v", got.Certs[1]["deployment"])
+		}
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		var got struct {
+			Status string
+			Certs  []map[string]interface{}
+		}
+		if err := yaml.Unmarshal(write(FormatYAML), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != "warning" || got.Certs[0]["sha256_fingerprint"] != "AB:CD" {
+			t.Errorf("unexpected report %+v", got)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		rows, err := csv.NewReader(bytes.NewReader(write(FormatCSV))).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 3 || rows[2][1] != "cf-1234" || rows[2][3] != "warning" || rows[2][5] != "45" {
+			t.Errorf("unexpected csv %v", rows)
+		}
+	})
+
+	if err := r.Write(&bytes.Buffer{}, "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
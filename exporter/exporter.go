@@ -0,0 +1,255 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package exporter serves the certificate expiration dates as Prometheus
+// metrics so they can be alerted on.
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+)
+
+// The cert label values
+const (
+	CertRoot         = "root"
+	CertIntermediate = "intermediate"
+)
+
+// CertExpiration gets the expiration dates of the certs in credhub
+type CertExpiration interface {
+	CheckRootCertExpiration(ctx context.Context) (time.Time, error)
+	CheckIntermediateCertExpiration(ctx context.Context, m *manifest.Manifest) (time.Time, error)
+}
+
+// ManifestLoader loads bosh manifests from existing deployments
+type ManifestLoader interface {
+	GetManifestsWithDiegoCells(ctx context.Context, selector manifest.Selector) ([]manifest.Manifest, error)
+}
+
+// Option configures optional Exporter behavior
+type Option func(e *Exporter)
+
+// WithEvents publishes refresh failures to the specified sink instead of the
+// standard logger.
+func WithEvents(s events.Sink) Option {
+	return func(e *Exporter) {
+		e.events = s
+	}
+}
+
+// Exporter periodically refreshes the certificate expiration dates and
+// serves the last values in the Prometheus text format.
+type Exporter struct {
+	certs    CertExpiration
+	loader   ManifestLoader
+	selector manifest.Selector
+	events   events.Sink
+	now      func() time.Time
+
+	mu            sync.Mutex
+	expirations   []expiration
+	refreshErrors int
+	lastSucceeded bool
+	lastSuccess   time.Time
+	lastDuration  time.Duration
+}
+
+// expiration is the expiration date of a single cert
+type expiration struct {
+	cert       string
+	deployment string
+	notAfter   time.Time
+}
+
+// New creates an exporter for the root cert and the intermediate cert of each
+// deployment chosen by the selector.
+func New(certs CertExpiration, loader ManifestLoader, selector manifest.Selector, opts ...Option) *Exporter {
+	e := &Exporter{
+		certs:    certs,
+		loader:   loader,
+		selector: selector,
+		events:   events.NewLogSink(),
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Run refreshes the expiration dates immediately and then at every interval
+// until ctx is done. Refresh failures are published and counted, they don't
+// stop the exporter.
+func (e *Exporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := e.Refresh(ctx); err != nil {
+			events.Warnf(e.events, "could not refresh certificate expiration dates: %s", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Refresh gets the expiration date of every cert. A cert that can't be
+// checked keeps its previous value and the refresh is counted as failed.
+func (e *Exporter) Refresh(ctx context.Context) error {
+	started := e.now()
+
+	e.mu.Lock()
+	previous := e.expirations
+	e.mu.Unlock()
+
+	var expirations []expiration
+	var errs []string
+
+	root, err := e.certs.CheckRootCertExpiration(ctx)
+	if err != nil {
+		errs = append(errs, err.Error())
+		expirations = append(expirations, findExpirations(previous, CertRoot, nil)...)
+	} else {
+		expirations = append(expirations, expiration{cert: CertRoot, notAfter: root})
+	}
+
+	manifests, err := e.loader.GetManifestsWithDiegoCells(ctx, e.selector)
+	if err != nil {
+		errs = append(errs, err.Error())
+		expirations = append(expirations, findExpirations(previous, CertIntermediate, nil)...)
+	}
+	for i := range manifests {
+		m := &manifests[i]
+		intermediate, err := e.certs.CheckIntermediateCertExpiration(ctx, m)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", m.DeploymentName, err))
+			expirations = append(expirations, findExpirations(previous, CertIntermediate, &m.DeploymentName)...)
+		} else {
+			expirations = append(expirations, expiration{
+				cert:       CertIntermediate,
+				deployment: m.DeploymentName,
+				notAfter:   intermediate,
+			})
+		}
+		// the loader writes each manifest to a temp file, don't let them pile
+		// up in a long running process
+		os.Remove(m.Path)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expirations = expirations
+	e.lastDuration = e.now().Sub(started)
+	e.lastSucceeded = len(errs) == 0
+	if !e.lastSucceeded {
+		e.refreshErrors++
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	e.lastSuccess = e.now()
+	return nil
+}
+
+// findExpirations returns the expirations of the cert, limited to the
+// deployment if it isn't nil.
+func findExpirations(expirations []expiration, cert string, deployment *string) (found []expiration) {
+	for _, exp := range expirations {
+		if exp.cert == cert && (deployment == nil || exp.deployment == *deployment) {
+			found = append(found, exp)
+		}
+	}
+	return found
+}
+
+// ServeHTTP writes the metrics from the last refresh
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.WriteMetrics(w)
+}
+
+// WriteMetrics writes the metrics from the last refresh in the Prometheus
+// text exposition format.
+func (e *Exporter) WriteMetrics(w io.Writer) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	expirations := append([]expiration(nil), e.expirations...)
+	sort.SliceStable(expirations, func(i, j int) bool {
+		if expirations[i].cert != expirations[j].cert {
+			return expirations[i].cert == CertRoot
+		}
+		return expirations[i].deployment < expirations[j].deployment
+	})
+
+	var b strings.Builder
+	writeHeader(&b, "riic_cert_expiry_timestamp_seconds", "gauge",
+		"The time the certificate expires in seconds since the epoch.")
+	for _, exp := range expirations {
+		labels := [][2]string{{"cert", exp.cert}}
+		if exp.deployment != "" {
+			labels = append(labels, [2]string{"deployment", exp.deployment})
+		}
+		writeSample(&b, "riic_cert_expiry_timestamp_seconds", labels, float64(exp.notAfter.Unix()))
+	}
+
+	writeHeader(&b, "riic_scrape_errors_total", "counter",
+		"The number of times the certificate expiration dates could not be refreshed.")
+	writeSample(&b, "riic_scrape_errors_total", nil, float64(e.refreshErrors))
+
+	writeHeader(&b, "riic_last_scrape_success", "gauge",
+		"Whether the last refresh of the certificate expiration dates succeeded.")
+	success := 0.0
+	if e.lastSucceeded {
+		success = 1
+	}
+	writeSample(&b, "riic_last_scrape_success", nil, success)
+
+	writeHeader(&b, "riic_last_scrape_success_timestamp_seconds", "gauge",
+		"The time of the last successful refresh in seconds since the epoch.")
+	lastSuccess := 0.0
+	if !e.lastSuccess.IsZero() {
+		lastSuccess = float64(e.lastSuccess.Unix())
+	}
+	writeSample(&b, "riic_last_scrape_success_timestamp_seconds", nil, lastSuccess)
+
+	writeHeader(&b, "riic_last_scrape_duration_seconds", "gauge",
+		"How long the last refresh took.")
+	writeSample(&b, "riic_last_scrape_duration_seconds", nil, e.lastDuration.Seconds())
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHeader(b *strings.Builder, name, metricType, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func writeSample(b *strings.Builder, name string, labels [][2]string, value float64) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "%s=\"%s\"", l[0], labelEscaper.Replace(l[1]))
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(b, " %s\n", strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,150 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+)
+
+type fakeCerts struct {
+	root            time.Time
+	rootErr         error
+	intermediates   map[string]time.Time
+	intermediateErr map[string]error
+}
+
+func (f *fakeCerts) CheckRootCertExpiration(ctx context.Context) (time.Time, error) {
+	return f.root, f.rootErr
+}
+
+func (f *fakeCerts) CheckIntermediateCertExpiration(ctx context.Context, m *manifest.Manifest) (time.Time, error) {
+	if err := f.intermediateErr[m.DeploymentName]; err != nil {
+		return time.Time{}, err
+	}
+	return f.intermediates[m.DeploymentName], nil
+}
+
+type fakeLoader struct {
+	deployments []string
+	err         error
+	selector    manifest.Selector
+}
+
+func (f *fakeLoader) GetManifestsWithDiegoCells(ctx context.Context, selector manifest.Selector) ([]manifest.Manifest, error) {
+	f.selector = selector
+	var manifests []manifest.Manifest
+	for _, d := range f.deployments {
+		manifests = append(manifests, manifest.Manifest{DeploymentName: d})
+	}
+	return manifests, f.err
+}
+
+func TestExporter(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	certs := &fakeCerts{
+		root: time.Unix(1700000000, 0),
+		intermediates: map[string]time.Time{
+			"cf-abc":                     time.Unix(1650000000, 0),
+			"p-isolation-segment-is1-de": time.Unix(1660000000, 0),
+		},
+	}
+	loader := &fakeLoader{deployments: []string{"p-isolation-segment-is1-de", "cf-abc"}}
+	selector, err := manifest.NewSelector([]string{"cf", "p-isolation-segment"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(certs, loader, selector, WithEvents(events.Discard))
+	e.now = func() time.Time { return now }
+
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loader.selector.String() != selector.String() {
+		t.Errorf("expected the loader to use the selector %s, but it used %s", selector, loader.selector)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %s", ct)
+	}
+	expected := `# HELP riic_cert_expiry_timestamp_seconds The time the certificate expires in seconds since the epoch.
+# TYPE riic_cert_expiry_timestamp_seconds gauge
+riic_cert_expiry_timestamp_seconds{cert="root"} 1700000000
+riic_cert_expiry_timestamp_seconds{cert="intermediate",deployment="cf-abc"} 1650000000
+riic_cert_expiry_timestamp_seconds{cert="intermediate",deployment="p-isolation-segment-is1-de"} 1660000000
+# HELP riic_scrape_errors_total The number of times the certificate expiration dates could not be refreshed.
+# TYPE riic_scrape_errors_total counter
+riic_scrape_errors_total 0
+# HELP riic_last_scrape_success Whether the last refresh of the certificate expiration dates succeeded.
+# TYPE riic_last_scrape_success gauge
+riic_last_scrape_success 1
+# HELP riic_last_scrape_success_timestamp_seconds The time of the last successful refresh in seconds since the epoch.
+# TYPE riic_last_scrape_success_timestamp_seconds gauge
+riic_last_scrape_success_timestamp_seconds 1600000000
+# HELP riic_last_scrape_duration_seconds How long the last refresh took.
+# TYPE riic_last_scrape_duration_seconds gauge
+riic_last_scrape_duration_seconds 0
+`
+	if rec.Body.String() != expected {
+		t.Errorf("unexpected metrics, expected:\n%s\ngot:\n%s", expected, rec.Body.String())
+	}
+
+	// a failed check keeps the previous value and counts the error
+	certs.root = time.Unix(1800000000, 0)
+	certs.intermediateErr = map[string]error{"cf-abc": errors.New("credhub is down")}
+	e.now = func() time.Time { return now.Add(time.Hour) }
+	if err := e.Refresh(context.Background()); err == nil || !strings.Contains(err.Error(), "cf-abc: credhub is down") {
+		t.Fatalf("expected the intermediate error, got %v", err)
+	}
+
+	var b strings.Builder
+	if err := e.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`riic_cert_expiry_timestamp_seconds{cert="root"} 1800000000`,
+		`riic_cert_expiry_timestamp_seconds{cert="intermediate",deployment="cf-abc"} 1650000000`,
+		"riic_scrape_errors_total 1",
+		"riic_last_scrape_success 0",
+		"riic_last_scrape_success_timestamp_seconds 1600000000",
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("expected metrics to contain %q, got:\n%s", line, b.String())
+		}
+	}
+
+	// deployments that no longer exist are dropped
+	certs.intermediateErr = nil
+	loader.deployments = []string{"cf-abc"}
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b.Reset()
+	if err := e.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "p-isolation-segment-is1-de") {
+		t.Errorf("expected the removed deployment to be dropped, got:\n%s", b.String())
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	var b strings.Builder
+	writeSample(&b, "metric", [][2]string{{"deployment", "a\"b\\c\nd"}}, 1.5)
+	expected := fmt.Sprintf("metric{deployment=%q} 1.5\n", "a\"b\\c\nd")
+	if b.String() != expected {
+		t.Errorf("expected %s, got %s", expected, b.String())
+	}
+}
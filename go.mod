@@ -11,6 +11,7 @@ require (
 	github.com/mitchellh/pointerstructure v1.2.0
 	github.com/onsi/gomega v1.11.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/stretchr/testify v1.7.0 // indirect
 	golang.org/x/crypto v0.0.0-20210314154223-e6e6c4f2bb5b
 	golang.org/x/mod v0.4.2 // indirect
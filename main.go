@@ -7,47 +7,103 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"os/user"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/mattn/go-isatty"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/compat"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/expiry"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/exporter"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/om"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/preflight"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/rotate"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/validate"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
-const dateFormat = "01/02/2006"
-
 var Version = "0.0.0-dev"
 
 var cli struct {
-	Username             string `short:"u" env:"RIIC_USERNAME" help:"The Operations Manager Username"`
-	UseClientSecret      bool   `short:"c" env:"RIIC_USE_CLIENT_SECRET" help:"Use client ID/secret instead of password auth"`
-	RunOutsideOpsManager bool   `hidden:"" env:"RIIC_RUN_EXTERNALLY" short:"x" help:"Bypass checks that verify we're running on Operations Manager"`
-	Interactive          bool   `short:"i" help:"Set or update required values from the console"`
+	Username             string   `short:"u" env:"RIIC_USERNAME" help:"The Operations Manager Username"`
+	UseClientSecret      bool     `short:"c" env:"RIIC_USE_CLIENT_SECRET" help:"Use client ID/secret instead of password auth"`
+	RunOutsideOpsManager bool     `hidden:"" env:"RIIC_RUN_EXTERNALLY" short:"x" help:"Bypass checks that verify we're running on Operations Manager"`
+	Interactive          bool     `short:"i" help:"Set or update required values from the console"`
+	Workspace            string   `env:"RIIC_WORKSPACE" default:"${workspace}" help:"Directory where riic records rotation progress"`
+	Deployment           []string `placeholder:"PATTERN" help:"Only act on deployments matching a name glob or product name (cf|p-isolation-segment|pas-windows), may be repeated"`
+	ExcludeDeployment    []string `placeholder:"PATTERN" help:"Skip deployments matching a name glob or product name, may be repeated"`
+	BoshCLI              bool     `name:"bosh-cli" env:"RIIC_BOSH_CLI" help:"Shell out to the bosh CLI instead of using the BOSH Director API"`
+	CredhubCLI           bool     `name:"credhub-cli" env:"RIIC_CREDHUB_CLI" help:"Shell out to the credhub CLI instead of using the CredHub API"`
+	CredhubURL           string   `name:"credhub-url" env:"RIIC_CREDHUB_URL" placeholder:"URL" help:"The CredHub server URL, defaults to port 8844 on the BOSH Director"`
+	CredhubCACert        string   `name:"credhub-ca-cert" env:"RIIC_CREDHUB_CA_CERT" placeholder:"FILE" help:"The CredHub CA certificate, defaults to the BOSH Director CA"`
+	CompatMatrix         string   `name:"compat-matrix" env:"RIIC_COMPAT_MATRIX" type:"existingfile" placeholder:"FILE" help:"Use this compatibility matrix of supported product versions instead of the embedded one"`
+	TrustSpec            string   `name:"trust-spec" env:"RIIC_TRUST_SPEC" type:"existingfile" placeholder:"FILE" help:"Use this spec of the job properties that trust the instance identity certs instead of the embedded one"`
 
 	Version kong.VersionFlag `short:"v" help:"Show the version and exit"`
 
 	Password             string `kong:"-"`
 	DecryptionPassphrase string `kong:"-"`
-
-	CheckExpiry struct{} `cmd:"" help:"Check the certificate expiration date"`
-	Rotate      struct {
-		StartPhase string `hidden:"" default:"bosh" help:"Specify the starting point (bosh|credhub|apply|cleanup)"`
+	BackupPassphrase     string `kong:"-"`
+
+	CheckExpiry struct {
+		Format        string `enum:"table,json,yaml,csv" default:"table" help:"The output format (table|json|yaml|csv)"`
+		WarnDays      int    `default:"90" help:"Warn when a cert expires within this many days, the exit code is 2"`
+		CriticalDays  int    `default:"30" help:"Critical when a cert expires within this many days, the exit code is 3. Expired certs exit with 4"`
+		FromInstances bool   `help:"Also check the certs deployed on each diego cell and router, and flag those that expire sooner than credhub"`
+	} `cmd:"" help:"Check the certificate expiration date"`
+	Exporter struct {
+		Listen   string        `default:":9683" help:"The address to serve Prometheus metrics on"`
+		Interval time.Duration `default:"1h" help:"How often to refresh the certificate expiration dates"`
+	} `cmd:"" help:"Serve the certificate expiration dates as Prometheus metrics"`
+	Rotate struct {
+		StartPhase       string        `hidden:"" default:"bosh" help:"Specify the starting point (bosh|credhub|apply|cleanup)"`
+		Resume           bool          `help:"Resume the rotation recorded in the workspace journal from where it stopped"`
+		Plan             string        `type:"existingfile" help:"Refuse to rotate if the foundation drifted from this saved plan archive"`
+		MaxDeploys       int           `default:"4" help:"Maximum number of isolation segment and Windows deployments to BOSH deploy at once"`
+		EventsJSON       string        `name:"events-json" placeholder:"FILE|-" help:"Write a JSON object per line for each rotation event to a file, or - for stdout"`
+		SkipPreflight    bool          `help:"Rotate even if the preflight checks fail"`
+		HealthTimeout    time.Duration `default:"15m" help:"How long to wait for each deployment to become healthy before stopping the rotation"`
+		HealthSample     int           `default:"1" help:"Number of diego cells and routers in each deployment whose certs are validated by the health gate"`
+		CanaryURL        string        `name:"canary-url" placeholder:"URL" help:"An app route that must respond with a 2xx status for a deployment to be healthy"`
+		SkipHealthGate   bool          `help:"Don't wait for each deployment to become healthy before continuing"`
+		IntermediateOnly bool          `help:"Rotate only the selected deployments' intermediates, signed by the existing root"`
 	} `cmd:"" help:"Perform the certificate rotation"`
-	Validate struct{} `cmd:"" help:"Validate that the certs in Credhub match what's deployed to VMs"`
+	Validate struct {
+		EventsJSON  string `name:"events-json" placeholder:"FILE|-" help:"Write a JSON object per line for each validation event to a file, or - for stdout"`
+		Parallelism int    `default:"10" help:"Maximum number of instances in each deployment whose certs are fetched and checked at once"`
+		Format      string `enum:"table,json" default:"table" help:"The report format (table|json), the exit code is 2 when an instance is unreachable, 3 for a parse error and 4 for a mismatch"`
+	} `cmd:"" help:"Validate that the certs in Credhub match what's deployed to VMs"`
+	Preflight struct{} `cmd:"" help:"Check the foundation is safe to rotate"`
+	Status    struct{} `cmd:"" help:"Report how far a rotation has progressed and the safe phase to start at"`
+	Plan      struct {
+		Out              string `type:"path" help:"Save the plan to a .tgz archive for review"`
+		IntermediateOnly bool   `help:"Plan rotating only the selected deployments' intermediates, signed by the existing root"`
+	} `cmd:"" help:"Show every change a rotation would make without changing anything"`
+	Rollback struct {
+		MaxDeploys int `default:"4" help:"Maximum number of isolation segment and Windows deployments to BOSH deploy at once"`
+	} `cmd:"" help:"Restore the certificates and manifests saved before the last rotation"`
+	Backup struct {
+		Restore struct {
+			Bundle string `arg:"" type:"existingfile" help:"The encrypted backup to import into Credhub"`
+		} `cmd:"" help:"Import an encrypted Credhub backup taken during a rotation or rollback"`
+	} `cmd:"" help:"Manage the encrypted Credhub backups"`
 }
 
 var stdin = bufio.NewReader(os.Stdin)
@@ -66,142 +122,656 @@ func main() {
 		}
 	}
 
+	sink, output, err := newEventSink()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	stop, abort := interruptContexts(ctx.Command())
+
 	client := &http.Client{
 		Timeout: 5 * time.Second,
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		},
 	}
-	om := om.NewAPI("https://127.0.0.1", cli.Username, cli.Password, cli.DecryptionPassphrase, cli.UseClientSecret, client)
-	err = ValidateVersion(om)
+	om := om.NewAPI("https://127.0.0.1", cli.Username, cli.Password, cli.DecryptionPassphrase, cli.UseClientSecret, client,
+		om.WithEvents(sink))
+	matrix, err := compat.Load(cli.CompatMatrix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	supports, err := ValidateVersion(abort, om, matrix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	trust, err := manifest.LoadTrustSpec(cli.TrustSpec)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
 
-	env, err := om.GetDirectorCredentials()
+	env, err := om.GetDirectorCredentials(abort)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "could not get director credentials: %v", err)
 		os.Exit(1)
 	}
 	env = append(env, os.Environ()...)
 
-	boshRunner := bosh.NewRunner(env)
-	credhubRunner := credhub.NewRunner(env)
-	manifestLoader := manifest.NewLoader(om, boshRunner)
+	selector, err := manifest.NewSelector(cli.Deployment, cli.ExcludeDeployment)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	boshRunner, err := newBoshClient(output, env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	credhubRunner, err := newCredhubClient(env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	manifestLoader := manifest.NewLoader(om, boshRunner,
+		append(manifestProfiles(supports), manifest.WithTrustSpec(trust))...)
 	certExpirationValidator := validate.NewCertExpiration(credhubRunner)
-	diegoValidator := validate.NewDiego(boshRunner, credhubRunner)
-	routerValidator := validate.NewRouter(boshRunner, credhubRunner)
+	// the rotator judges the validations after each phase by their report
+	validationReport := validate.NewReport()
+	validatorOpts := []validate.Option{validate.WithEvents(sink), validate.WithReport(validationReport)}
+	diegoValidator := validate.NewDiego(boshRunner, credhubRunner, validatorOpts...)
+	routerValidator := validate.NewRouter(boshRunner, credhubRunner, validatorOpts...)
+	trustValidator := validate.NewTrust(boshRunner, credhubRunner, validatorOpts...)
 
 	switch ctx.Command() {
 	case "check-expiry":
-		expired := func(name string, t time.Time) bool {
-			if time.Now().After(t) {
-				fmt.Printf("❌ %s cert expired on: %s\n", name, t.Format(dateFormat))
-				return true
-			}
-			return false
-		}
-		check := func(name string, t time.Time) {
-			timeLeft := time.Until(t)
-			days := int(timeLeft.Hours() / 24)
-			s := "✅"
-			if days <= 90 {
-				s = "⚠️"
-			}
-			fmt.Printf("%s %s cert is valid for %d more days, it expires on: %s\n",
-				s, name, days, t.Format(dateFormat))
+		thresholds := expiry.Thresholds{WarnDays: cli.CheckExpiry.WarnDays, CriticalDays: cli.CheckExpiry.CriticalDays}
+		if err = thresholds.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
 		}
+		report := expiry.NewReport(thresholds, time.Now())
+		instanceCerts := validate.NewInstanceCerts(boshRunner, validate.WithEvents(sink))
 
 		// check the shared root CA cert
-		rootExpiration, err := certExpirationValidator.CheckRootCertExpiration()
+		root, err := certExpirationValidator.RootCertInfo(abort)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err)
 			os.Exit(1)
 		}
-		if !expired("Root", rootExpiration) {
-			check("Root", rootExpiration)
-		}
+		report.Add(expiry.CertRoot, "", *root)
 
 		// check each deployment's intermediate cert
-		manifests, err := manifestLoader.GetAllManifestsWithDiegoCells()
+		manifests, err := manifestLoader.GetManifestsWithDiegoCells(abort, selector)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err)
 			os.Exit(1)
 		}
 
 		for _, m := range manifests {
-			intermediateExpiration, err := certExpirationValidator.CheckIntermediateCertExpiration(&m)
+			intermediate, err := certExpirationValidator.IntermediateCertInfo(abort, &m)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "could not check expiration date: %v\n", err)
 				os.Exit(1)
 			}
-			n := fmt.Sprintf("%s intermediate", m.DeploymentName)
-			if !expired(n, intermediateExpiration) {
-				check(n, intermediateExpiration)
+			report.Add(expiry.CertIntermediate, m.DeploymentName, *intermediate)
+
+			if !cli.CheckExpiry.FromInstances {
+				continue
+			}
+			deployed, err := instanceCerts.GetDeployedCerts(abort, &m)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not check deployed certs: %v\n", err)
+				os.Exit(1)
+			}
+			for _, d := range deployed {
+				report.AddDeployed(m.DeploymentName, d, *intermediate, *root)
 			}
 		}
 
+		if err = report.Write(os.Stdout, cli.CheckExpiry.Format); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(report.Status.ExitCode())
+
+	case "exporter":
+		if cli.Exporter.Interval <= 0 {
+			fmt.Fprintf(os.Stderr, "The refresh interval must be positive\n")
+			os.Exit(1)
+		}
+		e := exporter.New(certExpirationValidator, manifestLoader, selector, exporter.WithEvents(sink))
+		go e.Run(context.Background(), cli.Exporter.Interval)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", e)
+		log.Printf("serving certificate expiration metrics on %s/metrics, refreshing every %s\n",
+			cli.Exporter.Listen, cli.Exporter.Interval)
+		if err = http.ListenAndServe(cli.Exporter.Listen, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
 	case "rotate":
-		printBanner()
-		u, err := user.Current()
+		printBanner(output)
+		requireTempestWeb()
+
+		journal, err := rotate.OpenJournal(cli.Workspace)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "could not get the current user: %v\n", err)
+			fmt.Fprintf(os.Stderr, "%s\n", err)
 			os.Exit(1)
 		}
-		if u.Username != "tempest-web" {
-			fmt.Fprintf(os.Stderr, "Cannot proceed as %s, expected to be running under tempest-web user\n", u.Username)
+		snapshot, err := rotate.OpenSnapshot(cli.Workspace)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
 			os.Exit(1)
 		}
 
-		rotator := rotate.NewCertRotator(om, boshRunner, credhubRunner, manifestLoader, diegoValidator, routerValidator)
-		err = rotator.RotateCerts(cli.Rotate.StartPhase)
+		// an unfinished rotation left the regen credentials in credhub
+		inProgress := cli.Rotate.Resume || cli.Rotate.StartPhase != rotate.PhaseBosh || selector.Partial() ||
+			(journal.Started() && !journal.Finished())
+		checker := newPreflightChecker(om, boshRunner, credhubRunner, manifestLoader, selector, supports, inProgress)
+		if !runPreflight(abort, output, checker) {
+			if !cli.Rotate.SkipPreflight {
+				fmt.Fprintf(os.Stderr, "\nRefusing to rotate until the failed preflight checks are fixed, or --skip-preflight is set\n")
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "\nRotating despite the failed preflight checks because --skip-preflight is set\n")
+		}
+
+		opts := []rotate.Option{
+			rotate.WithJournal(journal), rotate.WithSnapshot(snapshot),
+			rotate.WithBackup(backupDir(), requireBackupPassphrase()), rotate.WithSelector(selector),
+			rotate.WithMaxDeploys(cli.Rotate.MaxDeploys), rotate.WithEvents(sink), rotate.WithOutput(output), rotate.WithStop(stop.Done()),
+			rotate.WithTrustValidator(trustValidator), rotate.WithValidationReport(validationReport),
+		}
+		if !cli.Rotate.SkipHealthGate {
+			opts = append(opts, rotate.WithHealthGate(rotate.HealthGate{
+				Timeout:   cli.Rotate.HealthTimeout,
+				Sample:    cli.Rotate.HealthSample,
+				CanaryURL: cli.Rotate.CanaryURL,
+			}))
+		}
+		if cli.Rotate.IntermediateOnly {
+			opts = append(opts, rotate.WithIntermediateOnly())
+		}
+		rotator := rotate.NewCertRotator(om, boshRunner, credhubRunner, manifestLoader, diegoValidator, routerValidator,
+			opts...)
+		if cli.Rotate.Plan != "" {
+			if cli.Rotate.Resume {
+				fmt.Fprintf(os.Stderr, "Cannot use --plan with --resume, the plan only describes a rotation from the beginning\n")
+				os.Exit(1)
+			}
+			plan, err := rotate.LoadPlan(cli.Rotate.Plan)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+			if err = rotator.CheckPlan(abort, plan, cli.Rotate.StartPhase); err != nil {
+				fmt.Fprintf(os.Stderr, "Refusing to rotate: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		if cli.Rotate.Resume {
+			err = rotator.Resume(abort)
+		} else {
+			err = rotator.RotateCerts(abort, cli.Rotate.StartPhase)
+		}
+		if errors.Is(err, rotate.ErrRotationStaged) {
+			fmt.Fprintf(output, "\n\nFinished deploying regen certs to the selected deployments\n%s\n", err)
+			fmt.Fprintln(output, "Run 'riic rotate' selecting the remaining deployments to continue")
+			os.Exit(0)
+		}
+		if errors.Is(err, rotate.ErrInterrupted) {
+			fmt.Fprintf(os.Stderr, "\n\nRotation stopped after an interrupt\n")
+			if err != rotate.ErrInterrupted {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+			fmt.Fprintf(os.Stderr, "Progress was recorded in %s, run 'riic rotate --resume' to continue\n", journal.Path())
+			os.Exit(130)
+		}
+		if errors.Is(err, rotate.ErrUnhealthy) {
+			sink.Publish(events.Event{Type: events.Error, Error: err.Error()})
+			fmt.Fprintf(os.Stderr, "\n\nRotation stopped, %s\n", err)
+			fmt.Fprintf(os.Stderr, "Fix the deployment, then run 'riic rotate --resume' to continue from %s\n", journal.Path())
+			os.Exit(1)
+		}
 		if err != nil {
+			sink.Publish(events.Event{Type: events.Error, Error: err.Error()})
 			fmt.Fprintf(os.Stderr, "Rotation Failed, exiting due to error: %s\n", err)
+			if journal.Started() && !errors.Is(err, rotate.ErrJournalMismatch) {
+				fmt.Fprintf(os.Stderr, "Progress was recorded in %s, run 'riic rotate --resume' to continue\n", journal.Path())
+			}
 			os.Exit(1)
 		}
-		fmt.Print("\n\nFinished rotating certs\n\n")
+		fmt.Fprint(output, "\n\nFinished rotating certs\n\n")
 
-	case "validate":
-		manifests, err := manifestLoader.GetAllManifestsWithDiegoCells()
+	case "preflight":
+		journal, err := rotate.OpenJournal(cli.Workspace)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err)
 			os.Exit(1)
 		}
+		inProgress := selector.Partial() || (journal.Started() && !journal.Finished())
+		if !runPreflight(abort, output, newPreflightChecker(om, boshRunner, credhubRunner, manifestLoader, selector, supports, inProgress)) {
+			os.Exit(1)
+		}
 
-		for _, m := range manifests {
-			err = diegoValidator.ValidateCerts(&m, validate.AllInstancesFilter)
-			if err != nil {
+	case "status":
+		journal, err := rotate.OpenJournal(cli.Workspace)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		rotator := rotate.NewCertRotator(om, boshRunner, credhubRunner, manifestLoader, diegoValidator, routerValidator,
+			rotate.WithSelector(selector))
+		status, err := rotator.Status(abort)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		printStatus(status, journal)
+
+	case "plan":
+		opts := []rotate.Option{rotate.WithSelector(selector)}
+		if cli.Plan.IntermediateOnly {
+			opts = append(opts, rotate.WithIntermediateOnly())
+		}
+		rotator := rotate.NewCertRotator(om, boshRunner, credhubRunner, manifestLoader, diegoValidator, routerValidator,
+			opts...)
+		plan, err := rotator.Plan(abort)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		if err = plan.Render(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		if cli.Plan.Out != "" {
+			if err = plan.Save(cli.Plan.Out); err != nil {
 				fmt.Fprintf(os.Stderr, "%s\n", err)
 				os.Exit(1)
 			}
-			err = routerValidator.ValidateCerts(&m, validate.AllInstancesFilter)
-			if err != nil {
+			fmt.Printf("\nSaved plan to %s, pass it to 'riic rotate --plan %s' to rotate exactly what was reviewed\n",
+				cli.Plan.Out, cli.Plan.Out)
+		}
+
+	case "rollback":
+		printBanner(output)
+		requireTempestWeb()
+		if selector.Partial() {
+			fmt.Fprintf(os.Stderr, "Cannot select deployments, rollback restores the shared root and always includes every deployment\n")
+			os.Exit(1)
+		}
+
+		journal, err := rotate.OpenJournal(cli.Workspace)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		snapshot, err := rotate.OpenSnapshot(cli.Workspace)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+		rotator := rotate.NewCertRotator(om, boshRunner, credhubRunner, manifestLoader, diegoValidator, routerValidator,
+			rotate.WithJournal(journal), rotate.WithSnapshot(snapshot),
+			rotate.WithBackup(backupDir(), requireBackupPassphrase()), rotate.WithMaxDeploys(cli.Rollback.MaxDeploys),
+			rotate.WithEvents(sink), rotate.WithOutput(output), rotate.WithStop(stop.Done()))
+		err = rotator.Rollback(abort)
+		if errors.Is(err, rotate.ErrInterrupted) {
+			fmt.Fprintf(os.Stderr, "\n\nRollback stopped after an interrupt, run 'riic rollback' again to finish it\n")
+			if err != rotate.ErrInterrupted {
 				fmt.Fprintf(os.Stderr, "%s\n", err)
-				os.Exit(1)
+			}
+			os.Exit(130)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Rollback Failed, exiting due to error: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("\n\nFinished rolling back to the snapshot taken %s\n\n", snapshot.Created.Format(time.RFC1123))
+
+	case "backup restore <bundle>":
+		requireTempestWeb()
+
+		certs, err := credhub.ReadBackup(cli.Backup.Restore.Bundle, requireBackupPassphrase())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		for _, c := range certs {
+			fmt.Printf("Restoring %s\n", c.Name)
+		}
+		if err = credhubRunner.ImportCertificates(abort, certs); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nRestored %d credentials from %s\n", len(certs), cli.Backup.Restore.Bundle)
+
+	case "validate":
+		manifests, err := manifestLoader.GetManifestsWithDiegoCells(abort, selector)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+		// the validators share each deployment's VMs, and record the result
+		// of every instance in the report
+		inventory := validate.NewInventory(boshRunner)
+		report := validate.NewReport()
+		opts := []validate.Option{validate.WithEvents(sink), validate.WithParallelism(cli.Validate.Parallelism),
+			validate.WithReport(report)}
+		diegoValidator := validate.NewDiego(inventory, credhubRunner, opts...)
+		routerValidator := validate.NewRouter(inventory, credhubRunner, opts...)
+		trustValidator := validate.NewTrust(inventory, credhubRunner, opts...)
+
+		for _, m := range manifests {
+			m := m
+			for _, validation := range []func() error{
+				func() error { return diegoValidator.ValidateCerts(abort, &m, validate.AllInstancesFilter) },
+				func() error { return routerValidator.ValidateCerts(abort, &m, validate.AllInstancesFilter) },
+				func() error {
+					return trustValidator.ValidateTrust(abort, &m, manifest.RootCertName, validate.AllInstances)
+				},
+			} {
+				// failed instances are in the report, anything else stops the validation
+				var instanceErr *validate.InstanceError
+				if err = validation(); err != nil && !errors.As(err, &instanceErr) {
+					sink.Publish(events.Event{Type: events.Error, Deployment: m.DeploymentName, Error: err.Error()})
+					fmt.Fprintf(os.Stderr, "%s\n", err)
+					os.Exit(1)
+				}
 			}
 		}
+
+		if err = report.Write(output, cli.Validate.Format); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(report.Status().ExitCode())
+	}
+}
+
+// boshClient is implemented by the BOSH Director API client and the bosh CLI
+// runner
+type boshClient interface {
+	rotate.BoshRunner
+	validate.BoshRunner
+	manifest.BoshExecutor
+	preflight.BoshRunner
+}
+
+// newBoshClient returns the BOSH Director API client, or the bosh CLI runner
+// when --bosh-cli is set. Deploy output is written to output.
+func newBoshClient(output io.Writer, env []string) (boshClient, error) {
+	opts := []bosh.Option{bosh.WithOutput(output)}
+	if cli.BoshCLI {
+		return bosh.NewRunner(env, opts...), nil
+	}
+	director, err := bosh.NewDirector(env, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w, set --bosh-cli to use the bosh CLI instead", err)
 	}
+	return director, nil
 }
 
-func ValidateVersion(om *om.API) error {
-	cfVersion, err := om.GetDeployedProductVersion("cf")
+// credhubClient is implemented by the CredHub API client and the credhub CLI
+// runner
+type credhubClient interface {
+	rotate.CredhubRunner
+	validate.CredhubRunner
+	preflight.CredhubRunner
+}
+
+// newCredhubClient returns the CredHub API client, or the credhub CLI runner
+// when --credhub-cli is set. The server and CA from Operations Manager are
+// replaced by --credhub-url and --credhub-ca-cert.
+func newCredhubClient(env []string) (credhubClient, error) {
+	if cli.CredhubURL != "" {
+		env = setEnv(env, "CREDHUB_SERVER", cli.CredhubURL)
+	}
+	if cli.CredhubCACert != "" {
+		env = setEnv(env, "CREDHUB_CA_CERT", cli.CredhubCACert)
+	}
+	if cli.CredhubCLI {
+		return credhub.NewRunner(env), nil
+	}
+	client, err := credhub.NewClient(env)
 	if err != nil {
-		return fmt.Errorf("couldn't check cf version: %w", err)
+		return nil, fmt.Errorf("%w, set --credhub-cli to use the credhub CLI instead", err)
 	}
+	return client, nil
+}
+
+// setEnv returns env with every value of the environment variable replaced
+func setEnv(env []string, name, value string) []string {
+	result := []string{name + "=" + value}
+	for _, e := range env {
+		if !strings.HasPrefix(e, name+"=") {
+			result = append(result, e)
+		}
+	}
+	return result
+}
 
-	parts := strings.Split(cfVersion, ".")
+// newPreflightChecker returns a preflight checker for the selected
+// deployments. The bosh CLI is always required to copy certs from VMs, the
+// credhub CLI only when --credhub-cli is set.
+func newPreflightChecker(om *om.API, b boshClient, c credhubClient, loader *manifest.Loader,
+	selector manifest.Selector, supports []compat.Support, inProgress bool) *preflight.Checker {
+	required, optional := []string{"bosh", "credhub"}, []string(nil)
+	if !cli.CredhubCLI {
+		required, optional = []string{"bosh"}, []string{"credhub"}
+	}
+	return preflight.New(om, b, c, loader,
+		preflight.WithSelector(selector), preflight.WithCLIs(required, optional),
+		preflight.WithRotationInProgress(inProgress), preflight.WithProductSupport(supports))
+}
 
-	if parts[0] != "2" {
-		return fmt.Errorf("invalid cf version %s", cfVersion)
+// runPreflight prints the preflight report, it returns false if a check
+// failed.
+func runPreflight(ctx context.Context, w io.Writer, checker *preflight.Checker) bool {
+	fmt.Fprint(w, "Running preflight checks\n\n")
+	report := checker.Run(ctx)
+	if err := report.Render(w); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 	}
+	fmt.Fprintln(w)
+	return !report.Failed()
+}
 
-	switch parts[1] {
-	case "4", "5", "6":
-		return nil
-	default:
-		return fmt.Errorf("invalid cf version %s", cfVersion)
+// interruptContexts returns the contexts cancelled by SIGINT and SIGTERM
+// while rotating or rolling back. The first signal cancels stop, which lets
+// the deploys and apply changes in progress finish before stopping at the
+// next step. The second signal cancels abort, which stops following them
+// immediately. Other commands keep the default signal handling.
+func interruptContexts(command string) (stop, abort context.Context) {
+	if command != "rotate" && command != "rollback" {
+		return context.Background(), context.Background()
+	}
+	stop, cancelStop := context.WithCancel(context.Background())
+	abort, cancelAbort := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Fprintf(os.Stderr, "\nInterrupted, stopping after the operations in progress finish. Interrupt again to abort them immediately\n")
+		cancelStop()
+		<-signals
+		fmt.Fprintf(os.Stderr, "\nAborting, the operations in progress are no longer followed\n")
+		cancelAbort()
+		signal.Stop(signals)
+	}()
+	return stop, abort
+}
+
+// newEventSink returns the sink for rotation and validation events, and the
+// writer for the rest of the command's output. Events are always logged to
+// stderr, and also written as JSON lines when --events-json is set. When
+// writing JSON to stdout the rest of the output is written to stderr.
+func newEventSink() (events.Sink, io.Writer, error) {
+	path := cli.Rotate.EventsJSON
+	if path == "" {
+		path = cli.Validate.EventsJSON
+	}
+	if path == "" {
+		return events.NewLogSink(), os.Stdout, nil
+	}
+
+	if path == "-" {
+		return events.NewMultiSink(events.NewLogSink(), events.NewJSONSink(os.Stdout)), os.Stderr, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create events file %s: %w", path, err)
+	}
+	return events.NewMultiSink(events.NewLogSink(), events.NewJSONSink(f)), os.Stdout, nil
+}
+
+// backupDir returns the directory where encrypted credhub backups are
+// written.
+func backupDir() string {
+	return filepath.Join(cli.Workspace, "backups")
+}
+
+// requireBackupPassphrase returns the passphrase used to encrypt and decrypt
+// credhub backups, prompting for it if $RIIC_BACKUP_PASSPHRASE isn't set. It
+// exits if a passphrase isn't provided.
+func requireBackupPassphrase() string {
+	if cli.BackupPassphrase != "" {
+		return cli.BackupPassphrase
+	}
+	cli.BackupPassphrase = os.Getenv("RIIC_BACKUP_PASSPHRASE")
+	if cli.BackupPassphrase == "" || cli.Interactive {
+		err := handleInput("Credhub Backup Passphrase", "no flag available", "RIIC_BACKUP_PASSPHRASE", &cli.BackupPassphrase, canBeInteractive(), true, true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not get backup passphrase from console: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	return cli.BackupPassphrase
+}
+
+// requireTempestWeb exits unless running as the Operations Manager user
+func requireTempestWeb() {
+	u, err := user.Current()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not get the current user: %v\n", err)
+		os.Exit(1)
+	}
+	if u.Username != "tempest-web" {
+		fmt.Fprintf(os.Stderr, "Cannot proceed as %s, expected to be running under tempest-web user\n", u.Username)
+		os.Exit(1)
+	}
+}
+
+func printStatus(status *rotate.Status, journal *rotate.Journal) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DEPLOYMENT\tREGEN CERT IN CREDHUB\tMANIFEST USES REGEN\tCREDHUB SWAPPED\tVMS HAVE REGEN CERT")
+	fmt.Fprintf(w, "%s (root)\t%s\t-\t%s\t-\n", manifest.RootCertName, status.RegenRootExists, status.RootSwapped)
+	for _, d := range status.Deployments {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", d.DeploymentName,
+			d.RegenCertExists, d.ManifestReferencesRegen, d.CertSwapped, d.VMsHaveRegenCert)
+	}
+	w.Flush()
+
+	fmt.Printf("\nRotation status: %s\n", status.Phase)
+	if status.StartPhase == "" {
+		fmt.Println("No rotation is in progress, run 'riic rotate' to start one")
+		return
+	}
+	if status.IntermediateOnly {
+		fmt.Println("Only the intermediates are being rotated, the existing root is kept")
 	}
+	// the journal knows which deployments finished each phase, the start
+	// phase is only a fallback for rotations that weren't journaled
+	if journal.Started() && !journal.Finished() {
+		fmt.Printf("Progress was recorded in %s, run 'riic rotate --resume' to continue\n", journal.Path())
+		return
+	}
+	fmt.Printf("No rotation journal found in the workspace, recommended start phase: %s (riic rotate --start-phase=%s)\n",
+		status.StartPhase, status.StartPhase)
+}
+
+// requiredFeatures are the Operations Manager features riic can't run without
+var requiredFeatures = []string{
+	compat.FeatureSelectiveApplyChanges,
+	compat.FeaturePendingChanges,
+	compat.FeatureRunningInstallations,
+}
+
+// ValidateVersion checks the Operations Manager and cf versions are in the
+// compatibility matrix, and returns whether each deployed product is
+// supported. Unsupported isolation segment and Windows versions are reported
+// by the preflight checks instead.
+func ValidateVersion(ctx context.Context, om *om.API, matrix *compat.Matrix) ([]compat.Support, error) {
+	omVersion, err := om.GetOpsManagerVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't check Operations Manager version: %w", err)
+	}
+	deployed, err := om.GetDeployedProducts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't check product versions: %w", err)
+	}
+
+	products := []compat.Product{{Name: compat.OpsManager, Version: omVersion}}
+	for _, d := range deployed {
+		if d.Name != compat.OpsManager {
+			products = append(products, compat.Product{Name: d.Name, GUID: d.GUID, Version: d.Version})
+		}
+	}
+	supports := matrix.Check(products)
+
+	foundCF := false
+	for _, s := range supports {
+		switch s.Name {
+		case compat.OpsManager:
+			if s.Err != nil {
+				return nil, fmt.Errorf("invalid Operations Manager version %s: %w", s.Version, s.Err)
+			}
+			for _, f := range requiredFeatures {
+				if !s.Entry.Supports(f) {
+					return nil, fmt.Errorf("Operations Manager %s doesn't support %s", s.Version, f)
+				}
+			}
+		case "cf":
+			if s.Err != nil {
+				return nil, fmt.Errorf("invalid cf version %s: %w, pass --compat-matrix to allow an untested version", s.Version, s.Err)
+			}
+			foundCF = true
+		}
+	}
+	if !foundCF {
+		return nil, errors.New("couldn't check cf version: unable to find deployed product with name cf")
+	}
+	return supports, nil
+}
+
+// manifestProfiles updates each deployment's manifest with the profile of
+// its product version, keyed by the product GUID that names its deployment.
+// Deployments of unsupported products use the cf profile.
+func manifestProfiles(supports []compat.Support) []manifest.Option {
+	profiles := map[string]*manifest.Profile{}
+	var opts []manifest.Option
+	for _, s := range supports {
+		if s.Err != nil || s.Entry.ManifestProfile() == nil {
+			continue
+		}
+		profiles[s.GUID] = s.Entry.ManifestProfile()
+		if s.Name == "cf" {
+			log.Printf("Found cf %s, using the %s manifest profile", s.Version, s.Entry.ManifestProfile().Name)
+			opts = append(opts, manifest.WithProfile(s.Entry.ManifestProfile()))
+		}
+	}
+	return append(opts, manifest.WithProfiles(profiles))
 }
 
 func buildContext() (*kong.Context, error) {
@@ -210,10 +780,12 @@ func buildContext() (*kong.Context, error) {
 		kong.Description(`
 riic rotates Diego instance-identity certificates
 
-To run non-interactively set the $RIIC_PASSWORD and $RIIC_DECRYPTION_PASSPHRASE environment variables
+To run non-interactively set the $RIIC_PASSWORD and $RIIC_DECRYPTION_PASSPHRASE environment variables,
+and $RIIC_BACKUP_PASSPHRASE for the rotate, rollback and backup restore commands
 `),
 		kong.Vars{
-			"version": Version,
+			"version":   Version,
+			"workspace": defaultWorkspace(),
 		},
 	)
 
@@ -247,6 +819,16 @@ To run non-interactively set the $RIIC_PASSWORD and $RIIC_DECRYPTION_PASSPHRASE
 	return ctx, nil
 }
 
+// defaultWorkspace returns the directory used to record rotation progress
+// when one isn't specified.
+func defaultWorkspace() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "riic")
+	}
+	return filepath.Join(home, ".riic")
+}
+
 func handleInput(prompt string, flag string, envar string, value *string, inTTY bool, isSecret bool, isRequired bool) error {
 	if value == nil {
 		return errors.New("cannot pass a nil value pointer")
@@ -313,6 +895,6 @@ const banner = `       O O
  |_|  |_|_|\___|
 `
 
-func printBanner() {
-	fmt.Println(banner)
+func printBanner(w io.Writer) {
+	fmt.Fprint(w, banner, "\n")
 }
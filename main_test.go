@@ -0,0 +1,101 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+)
+
+// fakeBosh is a bosh CLI that prints what a deploy prints
+const fakeBosh = `#!/bin/sh
+echo "Using deployment '$4'"
+echo "Task 42"
+echo "Task 42 | 10:00:00 | Updating instance diego_cell: diego_cell/0 (canary)"
+echo "Deprecation: the director is old" >&2
+echo "Succeeded"
+`
+
+func TestEventsJSONToStdout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake bosh CLI is a shell script")
+	}
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "bosh"), []byte(fakeBosh), 0755); err != nil {
+		t.Fatal(err)
+	}
+	manifestFile := filepath.Join(dir, "cf.yml")
+	if err := ioutil.WriteFile(manifestFile, []byte("name: cf-guid\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	var written bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(&written, r)
+		close(done)
+	}()
+
+	cli.Rotate.EventsJSON = "-"
+	cli.BoshCLI = true
+	defer func() {
+		cli.Rotate.EventsJSON = ""
+		cli.BoshCLI = false
+	}()
+
+	sink, output, err := newEventSink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	boshRunner, err := newBoshClient(output, os.Environ())
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	sink.Publish(events.Event{Type: events.DeployStarted, Deployment: "cf-guid"})
+	err = boshRunner.DeployWithFlags(context.Background(), "cf-guid", manifestFile)
+	sink.Publish(events.Event{Type: events.DeployFinished, Deployment: "cf-guid"}.Finished(started, err))
+	if err != nil {
+		t.Fatalf("expected the deploy to succeed, but got %v", err)
+	}
+
+	os.Stdout = stdout
+	w.Close()
+	<-done
+
+	var types []string
+	scanner := bufio.NewScanner(&written)
+	for scanner.Scan() {
+		var e events.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("expected only JSON events on stdout, but got %q: %v", scanner.Text(), err)
+		}
+		types = append(types, e.Type)
+	}
+	if strings.Join(types, ",") != events.DeployStarted+","+events.DeployFinished {
+		t.Errorf("expected the deploy events on stdout, but got %v", types)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// unifiedDiff returns a unified diff between the before and after text, or
+// an empty string if they're identical.
+func unifiedDiff(fromName, toName string, before, after []byte) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(before),
+		B:        splitLines(after),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  diffContext,
+	})
+}
+
+// splitLines splits the text into lines that each end in a newline, a
+// missing newline at the end of the text isn't a change
+func splitLines(b []byte) []string {
+	s := strings.TrimSuffix(string(b), "\n")
+	if s == "" {
+		return nil
+	}
+	return difflib.SplitLines(s)
+}
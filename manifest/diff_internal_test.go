@@ -0,0 +1,142 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		expected      string
+	}{
+		{
+			name:   "separate hunks",
+			before: "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n",
+			after:  "a\nb\nc\nd\nE\nf\ng\nh\ni\nj\nk\nl\nm\nn\n",
+			expected: `--- a/x.yml
++++ b/x.yml
+@@ -2,7 +2,7 @@
+ b
+ c
+ d
+-e
++E
+ f
+ g
+ h
+@@ -11,3 +11,4 @@
+ k
+ l
+ m
++n
+`,
+		},
+		{
+			name:   "changes within twice the context share a hunk",
+			before: "a\nb\nc\nd\ne\nf\ng\nh\ni\n",
+			after:  "A\nb\nc\nd\ne\nf\ng\nH\ni\n",
+			expected: `--- a/x.yml
++++ b/x.yml
+@@ -1,9 +1,9 @@
+-a
++A
+ b
+ c
+ d
+ e
+ f
+ g
+-h
++H
+ i
+`,
+		},
+		{
+			name:     "identical",
+			before:   "a\nb\n",
+			after:    "a\nb\n",
+			expected: "",
+		},
+		{
+			name:     "both empty",
+			expected: "",
+		},
+		{
+			name:  "from empty",
+			after: "a\nb\n",
+			expected: `--- a/x.yml
++++ b/x.yml
+@@ -0,0 +1,2 @@
++a
++b
+`,
+		},
+		{
+			name:   "to empty",
+			before: "a\n",
+			expected: `--- a/x.yml
++++ b/x.yml
+@@ -1 +0,0 @@
+-a
+`,
+		},
+		{
+			name:     "missing trailing newline",
+			before:   "a\nb",
+			after:    "a\nb\n",
+			expected: "",
+		},
+		{
+			name:   "change without a trailing newline",
+			before: "a\nb",
+			after:  "a\nB",
+			expected: `--- a/x.yml
++++ b/x.yml
+@@ -1,2 +1,2 @@
+ a
+-b
++B
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := unifiedDiff("a/x.yml", "b/x.yml", []byte(tc.before), []byte(tc.after))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d != tc.expected {
+				t.Errorf("expected diff:\n%s\nbut got:\n%s", tc.expected, d)
+			}
+		})
+	}
+}
+
+func TestUpdateDiff(t *testing.T) {
+	m, err := NewManifest("p-bosh", "testdata/cf-manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := m.UpdateDiff()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"name: " + IntermediateCertRegenName, "+- name: " + RootCertRegenName, "instance_identity_ca_cert: " + IntermediateCertRegenVariable} {
+		if !strings.Contains(d, want) {
+			t.Errorf("expected the diff to contain %q", want)
+		}
+	}
+
+	// the diff must not modify the manifest
+	if _, err := m.cloneVariable(IntermediateCertRegenName, "unused"); err == nil {
+		t.Errorf("expected the original manifest to be unmodified")
+	}
+}
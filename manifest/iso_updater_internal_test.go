@@ -7,33 +7,28 @@ import (
 	"testing"
 )
 
-func TestIsoInstanceGroupNaming(t *testing.T) {
+func TestIsoInstanceGroupSuffix(t *testing.T) {
 	type test struct {
-		instanceGroupName         string
-		deploymentName            string
-		expectedInstanceGroupName string
+		deploymentName string
+		expectedSuffix string
 	}
 
 	tests := []test{
 		{
-			instanceGroupName:         "isolated_router",
-			deploymentName:            "p-isolation-segment-iso1-pub-065aba009c17a59d5cc9",
-			expectedInstanceGroupName: "isolated_router_iso1_pub",
+			deploymentName: "p-isolation-segment-065aba009c17a59d5cc9",
+			expectedSuffix: "",
 		},
 		{
-			instanceGroupName:         "isolated_router",
-			deploymentName:            "p-isolation-segment-iso1_pub-065aba009c17a59d5cc9",
-			expectedInstanceGroupName: "isolated_router_iso1_pub",
+			deploymentName: "p-isolation-segment-iso1-pub-065aba009c17a59d5cc9",
+			expectedSuffix: "_iso1_pub",
 		},
 		{
-			instanceGroupName:         "isolated_router",
-			deploymentName:            "p-isolation-segment-iso1 pub-065aba009c17a59d5cc9",
-			expectedInstanceGroupName: "isolated_router_iso1_pub",
+			deploymentName: "p-isolation-segment-iso1_pub-065aba009c17a59d5cc9",
+			expectedSuffix: "_iso1_pub",
 		},
 		{
-			instanceGroupName:         "diego_cell",
-			deploymentName:            "p-isolation-segment-iso1 pub-065aba009c17a59d5cc9",
-			expectedInstanceGroupName: "diego_cell_iso1_pub",
+			deploymentName: "p-isolation-segment-iso1 pub-065aba009c17a59d5cc9",
+			expectedSuffix: "_iso1_pub",
 		},
 	}
 
@@ -48,9 +43,9 @@ func TestIsoInstanceGroupNaming(t *testing.T) {
 		}
 		u.manifest.DeploymentName = tc.deploymentName
 
-		ig := u.instanceGroupName(tc.instanceGroupName)
-		if ig != tc.expectedInstanceGroupName {
-			t.Fatalf("Expected instance group name to match %s, but got %s", tc.expectedInstanceGroupName, ig)
+		kind, suffix := u.trustKind()
+		if kind != KindIsolationSegment || suffix != tc.expectedSuffix {
+			t.Fatalf("Expected instance group suffix to match %s, but got %s", tc.expectedSuffix, suffix)
 		}
 	}
 }
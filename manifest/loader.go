@@ -4,55 +4,74 @@
 package manifest
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 )
 
 type BoshExecutor interface {
-	GetDiegoDeployments() (deployments []string, err error)
+	GetDiegoDeployments(ctx context.Context) (deployments []string, err error)
 }
 
 type OpsManExecutor interface {
-	GetBoshDirectorName() (string, error)
-	GetBoshManifest(deploymentName string) ([]byte, error)
+	GetBoshDirectorName(ctx context.Context) (string, error)
+	GetBoshManifest(ctx context.Context, deploymentName string) ([]byte, error)
 }
 
 type Loader struct {
 	bosh BoshExecutor
 	om   OpsManExecutor
+	opts []Option
 }
 
-func NewLoader(om OpsManExecutor, b BoshExecutor) *Loader {
+// NewLoader creates a loader, the options are applied to every manifest it
+// loads.
+func NewLoader(om OpsManExecutor, b BoshExecutor, opts ...Option) *Loader {
 	return &Loader{
 		bosh: b,
 		om:   om,
+		opts: opts,
 	}
 }
 
-func (l *Loader) GetAllManifestsWithDiegoCells() (manifests []Manifest, err error) {
-	deployments, err := l.bosh.GetDiegoDeployments()
+func (l *Loader) GetAllManifestsWithDiegoCells(ctx context.Context) (manifests []Manifest, err error) {
+	return l.GetManifestsWithDiegoCells(ctx, Selector{})
+}
+
+// GetManifestsWithDiegoCells returns the manifests of the deployments with
+// diego cells chosen by the selector. It's an error if a partial selector
+// doesn't choose any deployment.
+func (l *Loader) GetManifestsWithDiegoCells(ctx context.Context, selector Selector) (manifests []Manifest, err error) {
+	deployments, err := l.bosh.GetDiegoDeployments(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("could not get diego deployments from bosh: %w", err)
 	}
 
 	for _, d := range deployments {
-		m, err := l.newManifestFromDeployment(d)
+		if !selector.Matches(d) {
+			continue
+		}
+		m, err := l.newManifestFromDeployment(ctx, d)
 		if err != nil {
 			return nil, err
 		}
 		manifests = append(manifests, *m)
 	}
+
+	if len(manifests) == 0 && selector.Partial() {
+		return nil, fmt.Errorf("no diego deployments match %s, found %v", selector, deployments)
+	}
 	return manifests, nil
 }
 
-func (l *Loader) newManifestFromDeployment(deploymentName string) (*Manifest, error) {
-	mbytes, err := l.om.GetBoshManifest(deploymentName)
+func (l *Loader) newManifestFromDeployment(ctx context.Context, deploymentName string) (*Manifest, error) {
+	mbytes, err := l.om.GetBoshManifest(ctx, deploymentName)
 	if err != nil {
 		return nil, fmt.Errorf("could not get bosh manifest for deployment %s: %w",
 			deploymentName, err)
 	}
 
-	directorName, err := l.om.GetBoshDirectorName()
+	directorName, err := l.om.GetBoshDirectorName(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("could not get bosh director name: %w", err)
 	}
@@ -62,7 +81,7 @@ func (l *Loader) newManifestFromDeployment(deploymentName string) (*Manifest, er
 		return nil, err
 	}
 
-	m, err := NewManifest(directorName, manifestFile)
+	m, err := NewManifest(directorName, manifestFile, l.opts...)
 	if err != nil {
 		return nil, fmt.Errorf("could not create manifest instance from manifest file %s: %w",
 			manifestFile, err)
@@ -4,6 +4,7 @@
 package manifest_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
@@ -12,15 +13,15 @@ import (
 type boshExecutor struct{}
 type omExecutor struct{}
 
-func (b boshExecutor) GetDiegoDeployments() (manifests []string, err error) {
+func (b boshExecutor) GetDiegoDeployments(ctx context.Context) (manifests []string, err error) {
 	return []string{"cf-guid"}, nil
 }
 
-func (o omExecutor) GetBoshManifest(deploymentName string) ([]byte, error) {
+func (o omExecutor) GetBoshManifest(ctx context.Context, deploymentName string) ([]byte, error) {
 	return []byte("name: cf-guid\nvariables:\n  - name: diego-instance-identity-intermediate-ca-2018"), nil
 }
 
-func (o omExecutor) GetBoshDirectorName() (string, error) {
+func (o omExecutor) GetBoshDirectorName(ctx context.Context) (string, error) {
 	return "p-bosh", nil
 }
 
@@ -32,7 +33,7 @@ func TestNewLoader(t *testing.T) {
 		t.Errorf("couldn't create loader")
 	}
 
-	manifests, err := l.GetAllManifestsWithDiegoCells()
+	manifests, err := l.GetAllManifestsWithDiegoCells(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
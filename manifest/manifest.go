@@ -6,9 +6,12 @@
 package manifest
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"strings"
 
@@ -17,14 +20,18 @@ import (
 )
 
 const (
+	RegenSuffix    = "-riic-regen"
+	OriginalSuffix = "-riic-original"
+
 	IntermediateCertName                = "diego-instance-identity-intermediate-ca-2018"
-	IntermediateCertRegenName           = IntermediateCertName + "-riic-regen"
+	IntermediateCertRegenName           = IntermediateCertName + RegenSuffix
 	IntermediateCertRegenVariable       = "((" + IntermediateCertRegenName + ".certificate))"
 	IntermediatePrivateKeyRegenVariable = "((" + IntermediateCertRegenName + ".private_key))"
 
 	RootCertName          = "/cf/diego-instance-identity-root-ca"
-	RootCertRegenName     = RootCertName + "-riic-regen"
+	RootCertRegenName     = RootCertName + RegenSuffix
 	RootCertRegenVariable = "((" + RootCertRegenName + ".certificate))"
+	RootCertOriginalName  = RootCertName + OriginalSuffix
 )
 
 type Manifest struct {
@@ -33,10 +40,43 @@ type Manifest struct {
 	Path           string
 	Content        map[string]interface{}
 	updater        Updater
+	profile        *Profile
+	trust          *TrustSpec
+}
+
+// Option configures optional Manifest behavior
+type Option func(m *Manifest)
+
+// WithProfile updates the manifest for the specified TAS release line,
+// DefaultProfile is used otherwise.
+func WithProfile(p *Profile) Option {
+	return func(m *Manifest) {
+		m.profile = p
+	}
+}
+
+// WithProfiles updates the manifest of each deployment for the TAS release
+// line of its product. The map is keyed by product GUID, which Operations
+// Manager uses as the name of the product's deployment. It overrides
+// WithProfile for the deployments it includes.
+func WithProfiles(profiles map[string]*Profile) Option {
+	return func(m *Manifest) {
+		if p, ok := profiles[m.DeploymentName]; ok {
+			m.profile = p
+		}
+	}
+}
+
+// WithTrustSpec updates the manifest's trust locations listed in the spec,
+// the embedded spec is used otherwise.
+func WithTrustSpec(s *TrustSpec) Option {
+	return func(m *Manifest) {
+		m.trust = s
+	}
 }
 
 // NewManifest creates and deserializes the manifest from the specified file
-func NewManifest(directorName, path string) (*Manifest, error) {
+func NewManifest(directorName, path string, opts ...Option) (*Manifest, error) {
 	// Read the source unmodified manifest into a struct
 	f, err := os.Open(path)
 	if err != nil {
@@ -47,23 +87,108 @@ func NewManifest(directorName, path string) (*Manifest, error) {
 	m := &Manifest{
 		Path:         path,
 		DirectorName: directorName,
+		profile:      DefaultProfile,
 	}
 	if err := yaml.NewDecoder(f).Decode(&m.Content); err != nil {
 		return nil, fmt.Errorf("could not deserialize bosh manifest %s: %v", path, err)
 	}
 	m.DeploymentName = m.Content["name"].(string)
 
-	if strings.HasPrefix(m.DeploymentName, "cf-") {
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.trust == nil {
+		if m.trust, err = DefaultTrustSpec(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.selectUpdater(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// selectUpdater picks the updater for the type of deployment
+func (m *Manifest) selectUpdater() error {
+	if strings.HasPrefix(m.DeploymentName, "cf-") && isSmallFootprint(m) {
+		m.updater = NewSmallFootprintUpdater(m)
+	} else if strings.HasPrefix(m.DeploymentName, "cf-") {
 		m.updater = NewCFUpdater(m)
 	} else if strings.HasPrefix(m.DeploymentName, "p-isolation-segment") {
 		m.updater = NewIsoUpdater(m)
 	} else if strings.HasPrefix(m.DeploymentName, "pas-windows-") {
 		m.updater = NewWinUpdater(m)
 	} else {
-		return nil, fmt.Errorf("unknown manifest deployment type %s", m.DeploymentName)
+		return fmt.Errorf("unknown manifest deployment type %s", m.DeploymentName)
+	}
+	return nil
+}
+
+// clone makes a deep copy of the manifest so it can be updated without
+// modifying the original.
+func (m *Manifest) clone() (*Manifest, error) {
+	b, err := yaml.Marshal(m.Content)
+	if err != nil {
+		return nil, fmt.Errorf("could not serialize bosh manifest %s: %w", m.DeploymentName, err)
 	}
 
-	return m, nil
+	c := &Manifest{
+		DirectorName:   m.DirectorName,
+		DeploymentName: m.DeploymentName,
+		Path:           m.Path,
+		profile:        m.profile,
+		trust:          m.trust,
+	}
+	if err := yaml.Unmarshal(b, &c.Content); err != nil {
+		return nil, fmt.Errorf("could not deserialize bosh manifest %s: %w", m.DeploymentName, err)
+	}
+	if err := c.selectUpdater(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// UpdateDiff returns a unified diff of the changes Update would make to the
+// manifest, without modifying it.
+func (m *Manifest) UpdateDiff() (string, error) {
+	return m.updateDiff((*Manifest).Update)
+}
+
+// UpdateIntermediateOnlyDiff returns a unified diff of the changes
+// UpdateIntermediateOnly would make to the manifest, without modifying it.
+func (m *Manifest) UpdateIntermediateOnlyDiff() (string, error) {
+	return m.updateDiff((*Manifest).UpdateIntermediateOnly)
+}
+
+func (m *Manifest) updateDiff(update func(*Manifest, io.Writer) error) (string, error) {
+	before, err := yaml.Marshal(m.Content)
+	if err != nil {
+		return "", fmt.Errorf("could not serialize bosh manifest %s: %w", m.DeploymentName, err)
+	}
+
+	c, err := m.clone()
+	if err != nil {
+		return "", err
+	}
+
+	var after bytes.Buffer
+	if err := update(c, &after); err != nil {
+		return "", err
+	}
+
+	return unifiedDiff("a/"+m.DeploymentName+".yml", "b/"+m.DeploymentName+".yml", before, after.Bytes())
+}
+
+// Checksum returns the SHA-256 checksum of the manifest file, it can be used
+// to detect whether a deployment's manifest changed.
+func (m *Manifest) Checksum() (string, error) {
+	b, err := ioutil.ReadFile(m.Path)
+	if err != nil {
+		return "", fmt.Errorf("could not read bosh manifest from %s: %w", m.Path, err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
 }
 
 // Update performs modifications to the source manifest that can be used to
@@ -74,21 +199,39 @@ func NewManifest(directorName, path string) (*Manifest, error) {
 // before intermediate) to avoid the bosh deploy error: "Config Server failed
 // to generate value".
 func (m *Manifest) Update(withNewIntermediate io.Writer) error {
-	if err := m.updater.useNewIntermediateCert(); err != nil {
+	if err := m.updater.useNewIntermediateCert(RootCertRegenName); err != nil {
 		return err
 	}
 	if err := m.updater.useNewRootCert(); err != nil {
 		return err
 	}
+	return m.write(withNewIntermediate)
+}
 
-	// Serialize the mutated manifest back out to the new file
-	if err := yaml.NewEncoder(withNewIntermediate).Encode(m.Content); err != nil {
-		return fmt.Errorf("cannot add new intermediate CA: %w", err)
+// UpdateIntermediateOnly performs modifications to the source manifest that
+// can be used to rotate only the intermediate CA. The new intermediate is
+// signed by the existing root, so the jobs that trust the root don't change.
+func (m *Manifest) UpdateIntermediateOnly(withNewIntermediate io.Writer) error {
+	if err := m.updater.useNewIntermediateCert(RootCertName); err != nil {
+		return err
 	}
+	return m.write(withNewIntermediate)
+}
 
+// write serializes the mutated manifest
+func (m *Manifest) write(w io.Writer) error {
+	if err := yaml.NewEncoder(w).Encode(m.Content); err != nil {
+		return fmt.Errorf("cannot add new intermediate CA: %w", err)
+	}
 	return nil
 }
 
+// ReferencesRegenCerts returns true if the serialized manifest references
+// any of the temporary regen certificate variables.
+func ReferencesRegenCerts(content []byte) bool {
+	return bytes.Contains(content, []byte(RegenSuffix))
+}
+
 // OpsManProductName returns the associated Opsman tile product name as is
 // returned from 'om available-products'
 func (m *Manifest) OpsManProductName() string {
@@ -107,22 +250,28 @@ func (m *Manifest) IntermediateCertRegenPath() string {
 	return m.credhubDeploymentPath(IntermediateCertRegenName)
 }
 
+// IntermediateCertOriginalPath returns the full credhub deployment specific
+// path to the copy of the intermediate CA saved before rotating.
+func (m *Manifest) IntermediateCertOriginalPath() string {
+	return m.credhubDeploymentPath(IntermediateCertName + OriginalSuffix)
+}
+
 // credhubDeploymentPath returns the full deployment specific path to the cert.
 func (m *Manifest) credhubDeploymentPath(certName string) string {
 	return fmt.Sprintf("/%s/%s/%s", m.DirectorName, m.DeploymentName, certName)
 }
 
-func (m *Manifest) addIntermediateCertRegenVariable() error {
-	// Add a new intermediate signed the new root and make sure the diego cells
+func (m *Manifest) addIntermediateCertRegenVariable(ca string) error {
+	// Add a new intermediate signed by the ca and make sure the diego cells
 	// are using this new intermediate instead of the one about to expire
 	intermediate, err := m.cloneVariable(IntermediateCertName, IntermediateCertRegenName)
 	if err != nil {
 		return err
 	}
-	if _, err := pointerstructure.Set(intermediate, "/ca", RootCertRegenName); err != nil {
+	if _, err := pointerstructure.Set(intermediate, "/ca", ca); err != nil {
 		return fmt.Errorf("could not set .ca on new intermediate cert: %v", err)
 	}
-	if _, err := pointerstructure.Set(intermediate, "/options/ca", RootCertRegenName); err != nil {
+	if _, err := pointerstructure.Set(intermediate, "/options/ca", ca); err != nil {
 		return fmt.Errorf("could not set .options.ca on new intermediate cert: %v", err)
 	}
 	return nil
@@ -4,9 +4,16 @@
 package manifest_test
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/mitchellh/pointerstructure"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+	"gopkg.in/yaml.v2"
 )
 
 func TestOpsManProductName(t *testing.T) {
@@ -15,6 +22,7 @@ func TestOpsManProductName(t *testing.T) {
 		productName string
 	}{
 		{"testdata/cf-manifest.yml", "cf"},
+		{"testdata/small-footprint/cf-manifest.yml", "cf"},
 		{"testdata/p-isolation-segment-manifest.yml", "p-isolation-segment"},
 		{"testdata/pas-windows-manifest.yml", "pas-windows"},
 	}
@@ -31,3 +39,404 @@ func TestOpsManProductName(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdateIntermediateOnly(t *testing.T) {
+	for _, path := range []string{
+		"testdata/cf-manifest.yml",
+		"testdata/small-footprint/cf-manifest.yml",
+		"testdata/p-isolation-segment-manifest.yml",
+		"testdata/pas-windows-manifest.yml",
+	} {
+		t.Run(path, func(t *testing.T) {
+			m, err := manifest.NewManifest("p-bosh", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			if err = m.UpdateIntermediateOnly(&out); err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Contains(out.String(), manifest.RootCertRegenName) {
+				t.Errorf("expected the manifest not to reference %s", manifest.RootCertRegenName)
+			}
+			if !strings.Contains(out.String(), manifest.IntermediateCertRegenVariable) ||
+				!strings.Contains(out.String(), manifest.IntermediatePrivateKeyRegenVariable) {
+				t.Errorf("expected rep to use the regen intermediate")
+			}
+
+			var content struct {
+				Variables []struct {
+					Name    string                 `yaml:"name"`
+					Options map[string]interface{} `yaml:"options"`
+				} `yaml:"variables"`
+			}
+			if err = yaml.Unmarshal(out.Bytes(), &content); err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, v := range content.Variables {
+				if v.Name == manifest.IntermediateCertRegenName {
+					found = true
+					if v.Options["ca"] != manifest.RootCertName {
+						t.Errorf("expected the regen intermediate to be signed by %s, but got %v", manifest.RootCertName, v.Options["ca"])
+					}
+				}
+			}
+			if !found {
+				t.Errorf("expected the manifest to have the %s variable", manifest.IntermediateCertRegenName)
+			}
+		})
+	}
+}
+
+func TestProfileNamed(t *testing.T) {
+	for _, want := range manifest.Profiles {
+		p, err := manifest.ProfileNamed(want.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Name != want.Name {
+			t.Errorf("expected the %s profile, but got %s", want.Name, p.Name)
+		}
+	}
+
+	if _, err := manifest.ProfileNamed("TAS 1.12"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
+
+func TestUpdateProfiles(t *testing.T) {
+	type location struct {
+		instanceGroup, job, path string
+	}
+
+	tests := []struct {
+		manifest string
+		profile  string
+		trusted  []location
+		// untrusted locations must not be changed
+		untrusted []location
+	}{
+		{
+			manifest: "testdata/tas-2.13/cf-manifest.yml",
+			profile:  "TAS 2.13",
+			trusted: []location{
+				{"router", "gorouter", "/router/ca_certs"},
+				{"credhub", "credhub", "/credhub/authentication/mutual_tls/trusted_cas"},
+				{"diego_brain", "ssh_proxy", "/diego/ssh_proxy/bbs/ca_cert"},
+				{"diego_brain", "ssh_proxy", "/backends/tls/ca_certificates"},
+				{"diego_cell", "rep", "/containers/trusted_ca_certificates"},
+				{"diego_cell", "cflinuxfs3-rootfs-setup", "/cflinuxfs3-rootfs/trusted_certs"},
+				{"diego_cell", "cflinuxfs4-rootfs-setup", "/cflinuxfs4-rootfs/trusted_certs"},
+			},
+		},
+		{
+			manifest: "testdata/small-footprint/cf-manifest.yml",
+			profile:  "TAS 2.13",
+			trusted: []location{
+				{"control", "gorouter", "/router/ca_certs"},
+				{"control", "credhub", "/credhub/authentication/mutual_tls/trusted_cas"},
+				{"control", "ssh_proxy", "/diego/ssh_proxy/bbs/ca_cert"},
+				{"control", "ssh_proxy", "/backends/tls/ca_certificates"},
+				{"compute", "rep", "/containers/trusted_ca_certificates"},
+				{"compute", "cflinuxfs3-rootfs-setup", "/cflinuxfs3-rootfs/trusted_certs"},
+				{"compute", "cflinuxfs4-rootfs-setup", "/cflinuxfs4-rootfs/trusted_certs"},
+			},
+		},
+		{
+			manifest: "testdata/tas-2.13/p-isolation-segment-manifest.yml",
+			profile:  "TAS 2.13",
+			trusted: []location{
+				{"isolated_router_is1", "gorouter", "/router/ca_certs"},
+				{"isolated_diego_cell_is1", "rep", "/containers/trusted_ca_certificates"},
+				{"isolated_diego_cell_is1", "cflinuxfs3-rootfs-setup", "/cflinuxfs3-rootfs/trusted_certs"},
+				{"isolated_diego_cell_is1", "cflinuxfs4-rootfs-setup", "/cflinuxfs4-rootfs/trusted_certs"},
+			},
+		},
+		{
+			manifest: "testdata/tas-2.13/pas-windows-manifest.yml",
+			profile:  "TAS 2.13",
+			trusted: []location{
+				{"windows_diego_cell", "rep_windows", "/containers/trusted_ca_certificates"},
+				{"windows_diego_cell", "windows2019fs", "/windows-rootfs/trusted_certs"},
+			},
+		},
+		{
+			// the rootfs jobs of earlier release lines are updated whenever
+			// they're deployed
+			manifest: "testdata/cf-manifest.yml",
+			profile:  "TAS 2.13",
+			trusted: []location{
+				{"diego_cell", "cflinuxfs2-rootfs-setup", "/cflinuxfs2-rootfs/trusted_certs"},
+				{"diego_cell", "cflinuxfs3-rootfs-setup", "/cflinuxfs3-rootfs/trusted_certs"},
+			},
+		},
+		{
+			manifest: "testdata/pas-windows-manifest.yml",
+			profile:  "TAS 6.0",
+			trusted: []location{
+				{"windows_diego_cell", "windows1803fs", "/windows-rootfs/trusted_certs"},
+			},
+		},
+		{
+			manifest: "testdata/tas-6.0/cf-manifest.yml",
+			profile:  "TAS 6.0",
+			trusted: []location{
+				{"router", "gorouter", "/router/ca_certs"},
+				{"credhub", "credhub", "/credhub/authentication/mutual_tls/trusted_cas"},
+				{"diego_brain", "ssh_proxy", "/diego/ssh_proxy/bbs/ca_cert"},
+				{"diego_cell", "rep", "/containers/trusted_ca_certificates"},
+				{"diego_cell", "cflinuxfs4-rootfs-setup", "/cflinuxfs4-rootfs/trusted_certs"},
+			},
+			untrusted: []location{
+				{"diego_brain", "ssh_proxy", "/backends/tls/ca_certificates"},
+			},
+		},
+		{
+			manifest: "testdata/tas-6.0/p-isolation-segment-manifest.yml",
+			profile:  "TAS 6.0",
+			trusted: []location{
+				{"isolated_router_is1", "gorouter", "/router/ca_certs"},
+				{"isolated_diego_cell_is1", "rep", "/containers/trusted_ca_certificates"},
+				{"isolated_diego_cell_is1", "cflinuxfs4-rootfs-setup", "/cflinuxfs4-rootfs/trusted_certs"},
+			},
+		},
+		{
+			manifest: "testdata/tas-6.0/pas-windows-manifest.yml",
+			profile:  "TAS 6.0",
+			trusted: []location{
+				{"windows_diego_cell", "rep_windows", "/containers/trusted_ca_certificates"},
+				{"windows_diego_cell", "windows2019fs", "/windows-rootfs/trusted_certs"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.manifest, func(t *testing.T) {
+			p, err := manifest.ProfileNamed(tc.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := manifest.NewManifest("p-bosh", tc.manifest, manifest.WithProfile(p))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			if err = m.Update(&out); err != nil {
+				t.Fatal(err)
+			}
+
+			var content struct {
+				InstanceGroups []struct {
+					Name string `yaml:"name"`
+					Jobs []struct {
+						Name       string                      `yaml:"name"`
+						Properties map[interface{}]interface{} `yaml:"properties"`
+					} `yaml:"jobs"`
+				} `yaml:"instance_groups"`
+			}
+			if err = yaml.Unmarshal(out.Bytes(), &content); err != nil {
+				t.Fatal(err)
+			}
+			get := func(l location) string {
+				t.Helper()
+				for _, ig := range content.InstanceGroups {
+					for _, j := range ig.Jobs {
+						if ig.Name != l.instanceGroup || j.Name != l.job {
+							continue
+						}
+						v, err := pointerstructure.Get(j.Properties, l.path)
+						if err != nil {
+							t.Fatalf("couldn't get %s %s %s: %v", l.instanceGroup, l.job, l.path, err)
+						}
+						return fmt.Sprint(v)
+					}
+				}
+				t.Fatalf("couldn't find the %s job in %s", l.job, l.instanceGroup)
+				return ""
+			}
+
+			for _, l := range tc.trusted {
+				if !strings.Contains(get(l), manifest.RootCertRegenVariable) {
+					t.Errorf("expected %s %s %s to trust the regen root", l.instanceGroup, l.job, l.path)
+				}
+			}
+			for _, l := range tc.untrusted {
+				if strings.Contains(get(l), manifest.RootCertRegenVariable) {
+					t.Errorf("expected %s %s %s not to change", l.instanceGroup, l.job, l.path)
+				}
+			}
+		})
+	}
+}
+
+func TestTrustSpec(t *testing.T) {
+	write := func(content string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "trust.yml")
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	update := func(spec *manifest.TrustSpec) (string, error) {
+		t.Helper()
+		m, err := manifest.NewManifest("p-bosh", "testdata/tas-2.13/cf-manifest.yml", manifest.WithTrustSpec(spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		err = m.Update(&out)
+		return out.String(), err
+	}
+
+	t.Run("updates the listed locations", func(t *testing.T) {
+		spec, err := manifest.LoadTrustSpec(write(`
+kinds:
+  cf:
+    intermediate:
+    - instance_group: diego_*
+      job: rep
+      cert: /diego/executor/instance_identity_ca_cert
+      key: /diego/executor/instance_identity_key
+    root:
+    - instance_group: diego_*
+      job: ssh_proxy
+      path: /diego/ssh_proxy/bbs/ca_cert
+    - instance_group: router
+      job: extra-job
+      path: /extra/trusted_certs
+      optional: true
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := update(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "instance_identity_key: "+manifest.IntermediatePrivateKeyRegenVariable) {
+			t.Error("expected rep to use the regen intermediate")
+		}
+		if strings.Count(out, manifest.RootCertRegenVariable) != 1 {
+			t.Error("expected only ssh_proxy to trust the regen root")
+		}
+	})
+
+	t.Run("fails when a required job is missing", func(t *testing.T) {
+		spec, err := manifest.LoadTrustSpec(write(`
+kinds:
+  cf:
+    root:
+    - instance_group: router
+      job: extra-job
+      path: /extra/trusted_certs
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = update(spec); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	for name, content := range map[string]string{
+		"unknown kinds":    "kinds:\n  cf-runtime: {}\n",
+		"unknown fields":   "kinds:\n  cf:\n    root:\n    - instance_group: router\n      jobs: gorouter\n      path: /router/ca_certs\n",
+		"missing jobs":     "kinds:\n  cf:\n    root:\n    - instance_group: router\n      path: /router/ca_certs\n",
+		"invalid patterns": "kinds:\n  cf:\n    root:\n    - instance_group: \"[router\"\n      job: gorouter\n      path: /router/ca_certs\n",
+		"invalid paths":    "kinds:\n  cf:\n    root:\n    - instance_group: router\n      job: gorouter\n      path: router/ca_certs\n",
+		"unknown profiles": "kinds:\n  cf:\n    root:\n    - instance_group: router\n      job: gorouter\n      path: /router/ca_certs\n      profiles: [TAS 1.12]\n",
+		"relative files":   "kinds:\n  cf:\n    root:\n    - instance_group: router\n      job: gorouter\n      path: /router/ca_certs\n      rendered:\n        file: gorouter.yml\n",
+	} {
+		t.Run("rejects "+name, func(t *testing.T) {
+			if _, err := manifest.LoadTrustSpec(write(content)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestRootTrust(t *testing.T) {
+	for _, tc := range []struct {
+		path     string
+		expected []string
+	}{
+		{
+			path: "testdata/tas-2.13/p-isolation-segment-manifest.yml",
+			expected: []string{
+				"isolated_router_is1/gorouter /router/ca_certs",
+				"isolated_diego_cell_is1/rep /containers/trusted_ca_certificates",
+				"isolated_diego_cell_is1/cflinuxfs3-rootfs-setup /cflinuxfs3-rootfs/trusted_certs",
+			},
+		},
+		{
+			path: "testdata/small-footprint/cf-manifest.yml",
+			expected: []string{
+				"control/gorouter /router/ca_certs",
+				"control/credhub /credhub/authentication/mutual_tls/trusted_cas",
+				"compute/rep /containers/trusted_ca_certificates",
+				"compute/cflinuxfs3-rootfs-setup /cflinuxfs3-rootfs/trusted_certs",
+				"control/ssh_proxy /diego/ssh_proxy/bbs/ca_cert",
+				"control/ssh_proxy /backends/tls/ca_certificates",
+			},
+		},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			m, err := manifest.NewManifest("p-bosh", tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trust, err := m.RootTrust()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, jt := range trust {
+				actual = append(actual, jt.String())
+				if jt.Rendered == nil || !strings.HasPrefix(jt.Rendered.File, "/var/vcap/jobs/"+jt.Job+"/") {
+					t.Errorf("expected %s to be rendered in the %s job, but got %v", jt, jt.Job, jt.Rendered)
+				}
+			}
+			if strings.Join(actual, "\n") != strings.Join(tc.expected, "\n") {
+				t.Errorf("expected the jobs\n%s\nbut got\n%s", strings.Join(tc.expected, "\n"), strings.Join(actual, "\n"))
+			}
+		})
+	}
+}
+
+func TestWithProfiles(t *testing.T) {
+	// count how many locations trust the regen root
+	trusted := func(opts ...manifest.Option) int {
+		t.Helper()
+		m, err := manifest.NewManifest("p-bosh", "testdata/tas-2.13/cf-manifest.yml", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err = m.Update(&out); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(out.String(), manifest.RootCertRegenVariable)
+	}
+
+	oldest := &manifest.Profiles[0]
+	newer := &manifest.Profiles[1]
+	without := trusted(manifest.WithProfile(oldest))
+
+	// the deployment of a tile is named after its product GUID
+	byGUID := trusted(manifest.WithProfile(oldest),
+		manifest.WithProfiles(map[string]*manifest.Profile{"cf-0a1b2c3d4e5f60718293": newer}))
+	if byGUID <= without {
+		t.Errorf("expected the profile of the deployment's product GUID to trust the cflinuxfs4 rootfs")
+	}
+
+	other := trusted(manifest.WithProfile(oldest),
+		manifest.WithProfiles(map[string]*manifest.Profile{"cf-ffffffffffffffffffff": newer}))
+	if other != without {
+		t.Errorf("expected another product's profile not to apply")
+	}
+}
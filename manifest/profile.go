@@ -0,0 +1,37 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"fmt"
+)
+
+// Profile is a TAS release line, trust spec entries can be limited to the
+// release lines whose jobs use them.
+type Profile struct {
+	// Name identifies the profile in the compatibility matrix and trust spec
+	Name string
+}
+
+// Profiles are the TAS release lines riic is tested against, oldest first,
+// each has manifest fixtures in testdata. The compatibility matrix maps
+// product versions to them.
+var Profiles = []Profile{
+	{Name: "TAS 2.4-2.6"},
+	{Name: "TAS 2.13"},
+	{Name: "TAS 6.0"},
+}
+
+// DefaultProfile is used for manifests created without a profile
+var DefaultProfile = &Profiles[0]
+
+// ProfileNamed returns the profile with the specified name
+func ProfileNamed(name string) (*Profile, error) {
+	for i := range Profiles {
+		if Profiles[i].Name == name {
+			return &Profiles[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown manifest profile %q", name)
+}
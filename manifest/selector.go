@@ -0,0 +1,80 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"fmt"
+	"path"
+)
+
+// Selector chooses deployments by deployment name glob (e.g.
+// p-isolation-segment-*) or by Operations Manager product name (e.g.
+// pas-windows). An empty selector chooses every deployment.
+type Selector struct {
+	include []string
+	exclude []string
+}
+
+// NewSelector creates a selector that chooses the deployments matching any
+// of the include patterns, or every deployment if there are none, then
+// removes the deployments matching any of the exclude patterns.
+func NewSelector(include, exclude []string) (Selector, error) {
+	for _, p := range append(append([]string(nil), include...), exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return Selector{}, fmt.Errorf("invalid deployment pattern %q: %w", p, err)
+		}
+	}
+	return Selector{
+		include: include,
+		exclude: exclude,
+	}, nil
+}
+
+// Partial returns true if the selector may not choose every deployment
+func (s Selector) Partial() bool {
+	return len(s.include) > 0 || len(s.exclude) > 0
+}
+
+// Matches returns true if the deployment is chosen by the selector
+func (s Selector) Matches(deploymentName string) bool {
+	if len(s.include) > 0 && !matchesAny(s.include, deploymentName) {
+		return false
+	}
+	return !matchesAny(s.exclude, deploymentName)
+}
+
+func (s Selector) String() string {
+	switch {
+	case len(s.include) > 0 && len(s.exclude) > 0:
+		return fmt.Sprintf("%v excluding %v", s.include, s.exclude)
+	case len(s.include) > 0:
+		return fmt.Sprintf("%v", s.include)
+	case len(s.exclude) > 0:
+		return fmt.Sprintf("all excluding %v", s.exclude)
+	}
+	return "all"
+}
+
+func matchesAny(patterns []string, deploymentName string) bool {
+	product := productName(deploymentName)
+	for _, p := range patterns {
+		if p == product {
+			return true
+		}
+		if ok, _ := path.Match(p, deploymentName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// productName returns the Operations Manager product name of the deployment
+// or an empty string if it isn't a deployment with diego cells.
+func productName(deploymentName string) string {
+	m := &Manifest{DeploymentName: deploymentName}
+	if err := m.selectUpdater(); err != nil {
+		return ""
+	}
+	return m.OpsManProductName()
+}
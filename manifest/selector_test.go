@@ -0,0 +1,79 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+)
+
+func TestSelector(t *testing.T) {
+	deployments := []string{
+		"cf-a7e7cd52009e7c121d7e",
+		"p-isolation-segment-is1-0c69a3f8c3d2a5d4a1e0",
+		"p-isolation-segment-is2-99d1d1e5b4d7c2a3f6e1",
+		"pas-windows-f9239c09b3772fdf6a12",
+	}
+
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		expected []bool
+	}{
+		{"empty selects everything", nil, nil, []bool{true, true, true, true}},
+		{"product name", []string{"pas-windows"}, nil, []bool{false, false, false, true}},
+		{"glob", []string{"p-isolation-segment-is1-*"}, nil, []bool{false, true, false, false}},
+		{"exact deployment", []string{"cf-a7e7cd52009e7c121d7e"}, nil, []bool{true, false, false, false}},
+		{"exclude product", nil, []string{"p-isolation-segment"}, []bool{true, false, false, true}},
+		{"exclude wins", []string{"p-isolation-segment"}, []string{"*-is2-*"}, []bool{false, true, false, false}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := manifest.NewSelector(tc.include, tc.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, d := range deployments {
+				if s.Matches(d) != tc.expected[i] {
+					t.Errorf("expected %s selected to be %t", d, tc.expected[i])
+				}
+			}
+			if s.Partial() != (tc.include != nil || tc.exclude != nil) {
+				t.Errorf("unexpected partial selector %s", s)
+			}
+		})
+	}
+
+	if _, err := manifest.NewSelector([]string{"cf-["}, nil); err == nil {
+		t.Error("expected an invalid pattern to be rejected")
+	}
+}
+
+func TestLoaderSelection(t *testing.T) {
+	l := manifest.NewLoader(omExecutor{}, boshExecutor{})
+
+	s, err := manifest.NewSelector([]string{"cf"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := l.GetManifestsWithDiegoCells(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 1 {
+		t.Errorf("Expected 1 manifest, but got %d", len(manifests))
+	}
+
+	s, err = manifest.NewSelector(nil, []string{"cf-*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.GetManifestsWithDiegoCells(context.Background(), s); err == nil {
+		t.Error("expected an error when no deployments are selected")
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+// The Small Footprint Runtime colocates the diego cell jobs on the compute
+// instance group, and the router, credhub and diego brain jobs on control.
+const sfComputeInstanceGroup = "compute"
+
+type SmallFootprintUpdater struct {
+	manifest *Manifest
+}
+
+func NewSmallFootprintUpdater(manifest *Manifest) Updater {
+	return &SmallFootprintUpdater{
+		manifest: manifest,
+	}
+}
+
+// isSmallFootprint returns true if the manifest has the Small Footprint
+// Runtime layout, with rep on the compute instance group
+func isSmallFootprint(m *Manifest) bool {
+	return m.properties(sfComputeInstanceGroup, "rep") != nil
+}
+
+func (u *SmallFootprintUpdater) opsmanProductName() string {
+	return "cf"
+}
+
+func (u *SmallFootprintUpdater) trustKind() (kind, suffix string) {
+	return KindSmallFootprint, ""
+}
+
+func (u *SmallFootprintUpdater) useNewIntermediateCert(ca string) error {
+	err := u.manifest.addIntermediateCertRegenVariable(ca)
+	if err != nil {
+		return err
+	}
+	return u.manifest.useIntermediateLocations(KindSmallFootprint, "")
+}
+
+// useNewRootCert ensures that the new root certificate variable is a trusted
+// CA for each of the small footprint trust locations in the manifest
+func (u *SmallFootprintUpdater) useNewRootCert() error {
+	err := u.manifest.addRootCertRegenVariable()
+	if err != nil {
+		return err
+	}
+	return u.manifest.useRootLocations(KindSmallFootprint, "")
+}
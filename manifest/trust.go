@@ -0,0 +1,371 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	_ "embed" // the default trust spec is embedded
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/mitchellh/pointerstructure"
+	"gopkg.in/yaml.v2"
+)
+
+// The kinds of deployments in the trust spec
+const (
+	KindCF               = "cf"
+	KindSmallFootprint   = "small-footprint"
+	KindIsolationSegment = "p-isolation-segment"
+	KindWindows          = "pas-windows"
+)
+
+// instanceGroupSuffixVar is replaced by the instance group suffix of
+// replicated tiles in the instance group patterns
+const instanceGroupSuffixVar = "${suffix}"
+
+//go:embed trust.yml
+var defaultTrustSpec []byte
+
+// TrustSpec lists, for each kind of deployment, the job properties that use
+// the instance identity intermediate and trust the root
+type TrustSpec struct {
+	Kinds map[string]TrustKind `yaml:"kinds"`
+}
+
+// TrustKind are the trust locations of a kind of deployment
+type TrustKind struct {
+	// Intermediate are the jobs that sign the app instance identity certs
+	Intermediate []IntermediateLocation `yaml:"intermediate"`
+	// Root are the job properties that must trust the root
+	Root []RootLocation `yaml:"root"`
+}
+
+// JobPattern matches jobs by their instance group and job names
+type JobPattern struct {
+	// InstanceGroup is a glob of the instance group name, ${suffix} is
+	// replaced by the suffix of replicated tiles
+	InstanceGroup string `yaml:"instance_group"`
+	// Job is a glob of the job name
+	Job string `yaml:"job"`
+	// Optional entries are skipped when no job matches
+	Optional bool `yaml:"optional"`
+	// Profiles limits the entry to the TAS release lines, it applies to
+	// all of them when empty
+	Profiles []string `yaml:"profiles"`
+}
+
+// IntermediateLocation are the job properties set to the intermediate
+type IntermediateLocation struct {
+	JobPattern `yaml:",inline"`
+	// Cert is the path of the intermediate certificate property
+	Cert string `yaml:"cert"`
+	// Key is the path of the intermediate private key property
+	Key string `yaml:"key"`
+}
+
+// RootLocation is a job property listing the trusted CA certs
+type RootLocation struct {
+	JobPattern `yaml:",inline"`
+	// Path is the path of the trusted CA certs property
+	Path string `yaml:"path"`
+	// Enabled is the path of a property that must be true for the root to
+	// be trusted, like whether TLS to the app instances is enabled
+	Enabled string `yaml:"enabled"`
+	// Rendered is where the job renders the trusted CA certs on its
+	// instances, the location isn't validated when it's nil
+	Rendered *RenderedConfig `yaml:"rendered"`
+}
+
+// RenderedConfig is a file the job renders on its instances
+type RenderedConfig struct {
+	// File is the absolute path of the file on the instance
+	File string `yaml:"file"`
+	// Property is the path of the trusted CA certs in the YAML or JSON file,
+	// every PEM certificate in the file is trusted when it's empty
+	Property string `yaml:"property"`
+}
+
+// JobTrust is a job in a manifest that must trust the root
+type JobTrust struct {
+	InstanceGroup string
+	Job           string
+	RootLocation
+}
+
+func (t JobTrust) String() string {
+	return t.InstanceGroup + "/" + t.Job + " " + t.Path
+}
+
+// DefaultTrustSpec returns the trust spec embedded in riic
+func DefaultTrustSpec() (*TrustSpec, error) {
+	return ParseTrustSpec(defaultTrustSpec)
+}
+
+// LoadTrustSpec reads the trust spec from the file, the embedded spec is
+// returned when path is empty.
+func LoadTrustSpec(path string) (*TrustSpec, error) {
+	if path == "" {
+		return DefaultTrustSpec()
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the trust spec: %w", err)
+	}
+	s, err := ParseTrustSpec(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// ParseTrustSpec deserializes the trust spec and checks every entry is valid
+func ParseTrustSpec(b []byte) (*TrustSpec, error) {
+	s := &TrustSpec{}
+	if err := yaml.UnmarshalStrict(b, s); err != nil {
+		return nil, fmt.Errorf("could not deserialize the trust spec: %w", err)
+	}
+
+	for kind, k := range s.Kinds {
+		if !isKind(kind) {
+			return nil, fmt.Errorf("unknown kind of deployment %q", kind)
+		}
+		for _, l := range k.Intermediate {
+			if err := l.validate(l.Cert, l.Key); err != nil {
+				return nil, fmt.Errorf("%s intermediate: %w", kind, err)
+			}
+		}
+		for _, l := range k.Root {
+			if err := l.validate(l.Path); err != nil {
+				return nil, fmt.Errorf("%s root: %w", kind, err)
+			}
+			if err := l.Rendered.validate(); err != nil {
+				return nil, fmt.Errorf("%s root %s: %w", kind, l, err)
+			}
+		}
+	}
+	return s, nil
+}
+
+func isKind(name string) bool {
+	for _, k := range []string{KindCF, KindSmallFootprint, KindIsolationSegment, KindWindows} {
+		if name == k {
+			return true
+		}
+	}
+	return false
+}
+
+// kind returns the trust locations of the kind of deployment
+func (s *TrustSpec) kind(name string) (TrustKind, error) {
+	k, ok := s.Kinds[name]
+	if !ok {
+		return k, fmt.Errorf("the trust spec has no %s deployments", name)
+	}
+	return k, nil
+}
+
+func (p JobPattern) validate(paths ...string) error {
+	if p.InstanceGroup == "" || p.Job == "" {
+		return errors.New("instance_group and job are required")
+	}
+	for _, pattern := range []string{p.InstanceGroup, p.Job} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	for _, prop := range paths {
+		if !strings.HasPrefix(prop, "/") {
+			return fmt.Errorf("invalid property path %q", prop)
+		}
+	}
+	for _, name := range p.Profiles {
+		if _, err := ProfileNamed(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RenderedConfig) validate() error {
+	if r == nil {
+		return nil
+	}
+	if !strings.HasPrefix(r.File, "/") {
+		return fmt.Errorf("invalid rendered file %q", r.File)
+	}
+	if r.Property != "" && !strings.HasPrefix(r.Property, "/") {
+		return fmt.Errorf("invalid rendered property path %q", r.Property)
+	}
+	return nil
+}
+
+func (p JobPattern) String() string {
+	return p.InstanceGroup + "/" + p.Job
+}
+
+// appliesTo returns true if the entry applies to the TAS release line
+func (p JobPattern) appliesTo(profile *Profile) bool {
+	if len(p.Profiles) == 0 {
+		return true
+	}
+	for _, name := range p.Profiles {
+		if name == profile.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// jobMatch is a job matching a pattern
+type jobMatch struct {
+	instanceGroup string
+	job           string
+	properties    map[interface{}]interface{}
+}
+
+// matchJobs returns each job matching the pattern, it's an error if no job
+// matches a required pattern
+func (m *Manifest) matchJobs(p JobPattern, suffix string) ([]jobMatch, error) {
+	igPattern := strings.ReplaceAll(p.InstanceGroup, instanceGroupSuffixVar, suffix)
+
+	var matched []jobMatch
+	igs, _ := m.Content["instance_groups"].([]interface{})
+	for _, ig := range igs {
+		i, ok := ig.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := i["name"].(string)
+		if ok, _ := path.Match(igPattern, name); !ok {
+			continue
+		}
+		jobs, _ := i["jobs"].([]interface{})
+		for _, job := range jobs {
+			j, ok := job.(map[interface{}]interface{})
+			if !ok {
+				continue
+			}
+			jobName, _ := j["name"].(string)
+			if ok, _ := path.Match(p.Job, jobName); !ok {
+				continue
+			}
+			props, _ := j["properties"].(map[interface{}]interface{})
+			matched = append(matched, jobMatch{instanceGroup: name, job: jobName, properties: props})
+		}
+	}
+
+	if len(matched) == 0 && !p.Optional {
+		return nil, fmt.Errorf("no %s job in the %s manifest", p.Job, m.DeploymentName)
+	}
+	return matched, nil
+}
+
+// enabledIn returns true if the root location applies to the job properties
+func (l RootLocation) enabledIn(props map[interface{}]interface{}) (bool, error) {
+	if l.Enabled == "" {
+		return true, nil
+	}
+	enabled, err := pointerstructure.Get(props, l.Enabled)
+	if err != nil {
+		return false, fmt.Errorf("couldn't check whether %s %s is enabled: %w", l, l.Enabled, err)
+	}
+	e, ok := enabled.(bool)
+	return ok && e, nil
+}
+
+// useIntermediateLocations makes the jobs of the kind of deployment sign
+// with the regen intermediate
+func (m *Manifest) useIntermediateLocations(kind, suffix string) error {
+	k, err := m.trust.kind(kind)
+	if err != nil {
+		return err
+	}
+	for _, l := range k.Intermediate {
+		if !l.appliesTo(m.profile) {
+			continue
+		}
+		matched, err := m.matchJobs(l.JobPattern, suffix)
+		if err != nil {
+			return err
+		}
+		for _, j := range matched {
+			props := j.properties
+			if _, err := pointerstructure.Set(props, l.Cert, IntermediateCertRegenVariable); err != nil {
+				return fmt.Errorf("cannot set %s %s: %w", l, l.Cert, err)
+			}
+			if _, err := pointerstructure.Set(props, l.Key, IntermediatePrivateKeyRegenVariable); err != nil {
+				return fmt.Errorf("cannot set %s %s: %w", l, l.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// useRootLocations makes the jobs of the kind of deployment trust the regen
+// root
+func (m *Manifest) useRootLocations(kind, suffix string) error {
+	k, err := m.trust.kind(kind)
+	if err != nil {
+		return err
+	}
+	for _, l := range k.Root {
+		if !l.appliesTo(m.profile) {
+			continue
+		}
+		matched, err := m.matchJobs(l.JobPattern, suffix)
+		if err != nil {
+			return err
+		}
+		for _, j := range matched {
+			enabled, err := l.enabledIn(j.properties)
+			if err != nil {
+				return err
+			}
+			if !enabled {
+				continue
+			}
+			if err := addRootCertRegen(j.properties, l.Path); err != nil {
+				return fmt.Errorf("%s: %w", l, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RootTrust returns each job in the manifest that must trust the root, for
+// the trust spec's root locations that apply to the manifest
+func (m *Manifest) RootTrust() ([]JobTrust, error) {
+	if m.updater == nil {
+		return nil, fmt.Errorf("unknown manifest deployment type %s", m.DeploymentName)
+	}
+	kind, suffix := m.updater.trustKind()
+	k, err := m.trust.kind(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	var trust []JobTrust
+	for _, l := range k.Root {
+		if !l.appliesTo(m.profile) {
+			continue
+		}
+		matched, err := m.matchJobs(l.JobPattern, suffix)
+		if err != nil {
+			return nil, err
+		}
+		for _, j := range matched {
+			enabled, err := l.enabledIn(j.properties)
+			if err != nil {
+				return nil, err
+			}
+			if enabled {
+				trust = append(trust, JobTrust{InstanceGroup: j.instanceGroup, Job: j.job, RootLocation: l})
+			}
+		}
+	}
+	return trust, nil
+}
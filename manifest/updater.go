@@ -9,9 +9,14 @@ import (
 )
 
 type Updater interface {
-	useNewIntermediateCert() error
+	// useNewIntermediateCert adds a new intermediate signed by the ca
+	// variable and makes the diego cells use it
+	useNewIntermediateCert(ca string) error
 	useNewRootCert() error
 	opsmanProductName() string
+	// trustKind returns the kind of deployment in the trust spec and its
+	// instance group suffix
+	trustKind() (kind, suffix string)
 }
 
 func replaceInvalidNameChars(isoName string) string {
@@ -7,32 +7,32 @@ import (
 	"testing"
 )
 
-func TestWinInstanceGroupNaming(t *testing.T) {
+func TestWinInstanceGroupSuffix(t *testing.T) {
 	type test struct {
-		deploymentName            string
-		expectedInstanceGroupName string
+		deploymentName string
+		expectedSuffix string
 	}
 
 	tests := []test{
 		{
-			deploymentName:            "pas-windows-065aba009c17a59d5cc9",
-			expectedInstanceGroupName: "windows_diego_cell",
+			deploymentName: "pas-windows-065aba009c17a59d5cc9",
+			expectedSuffix: "",
 		},
 		{
-			deploymentName:            "pas-windows-paswin-pub-065aba009c17a59d5cc9",
-			expectedInstanceGroupName: "windows_diego_cell_paswin_pub",
+			deploymentName: "pas-windows-paswin-pub-065aba009c17a59d5cc9",
+			expectedSuffix: "_paswin_pub",
 		},
 		{
-			deploymentName:            "pas-windows-paswin_pub-065aba009c17a59d5cc9",
-			expectedInstanceGroupName: "windows_diego_cell_paswin_pub",
+			deploymentName: "pas-windows-paswin_pub-065aba009c17a59d5cc9",
+			expectedSuffix: "_paswin_pub",
 		},
 		{
-			deploymentName:            "pas-windows-paswin pub-065aba009c17a59d5cc9",
-			expectedInstanceGroupName: "windows_diego_cell_paswin_pub",
+			deploymentName: "pas-windows-paswin pub-065aba009c17a59d5cc9",
+			expectedSuffix: "_paswin_pub",
 		},
 		{
-			deploymentName:            "pas-windows-_paswin_pub-065aba009c17a59d5cc9",
-			expectedInstanceGroupName: "windows_diego_cell__paswin_pub",
+			deploymentName: "pas-windows-_paswin_pub-065aba009c17a59d5cc9",
+			expectedSuffix: "__paswin_pub",
 		},
 	}
 
@@ -47,9 +47,9 @@ func TestWinInstanceGroupNaming(t *testing.T) {
 		}
 		u.manifest.DeploymentName = tc.deploymentName
 
-		ig := u.instanceGroupName()
-		if ig != tc.expectedInstanceGroupName {
-			t.Fatalf("Expected Windows diego cell instance group name to match %s, but got %s", tc.expectedInstanceGroupName, ig)
+		kind, suffix := u.trustKind()
+		if kind != KindWindows || suffix != tc.expectedSuffix {
+			t.Fatalf("Expected Windows diego cell instance group suffix to match %s, but got %s", tc.expectedSuffix, suffix)
 		}
 	}
 }
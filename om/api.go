@@ -11,8 +11,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 )
@@ -24,7 +26,24 @@ type API struct {
 	password             string
 	decryptionPassphrase string
 	useClientCredentials bool
-	ctx                  context.Context
+	hc                   *http.Client
+	events               events.Sink
+
+	// tokenMu guards token, the cached UAA token that's reused until it
+	// expires
+	tokenMu sync.Mutex
+	token   *oauth2.Token
+}
+
+// Option configures optional API behavior
+type Option func(a *API)
+
+// WithEvents publishes the progress of long running operations, like apply
+// changes, to the specified sink.
+func WithEvents(s events.Sink) Option {
+	return func(a *API) {
+		a.events = s
+	}
 }
 
 // ErrBadStatusCode is an error that is thrown when a non-200 status code is returned from an HTTP call. It can be read with
@@ -36,24 +55,51 @@ var ErrBadStatusCode = errors.New("expected 2xx error code")
 //
 // If you pass a nil http.Client, http.DefaultClient will be used for all calls. If you require skipping TLS validation or using
 // custom certs, you must create a Client that does those things and pass it in.
-func NewAPI(host string, username string, password string, decryptionPassphrase string, useClientCredentials bool, hc *http.Client) *API {
+func NewAPI(host string, username string, password string, decryptionPassphrase string, useClientCredentials bool, hc *http.Client, opts ...Option) *API {
 	a := &API{
 		host:                 host,
 		username:             username,
 		password:             password,
 		decryptionPassphrase: decryptionPassphrase,
 		useClientCredentials: useClientCredentials,
+		events:               events.Discard,
+	}
+	for _, opt := range opts {
+		opt(a)
 	}
 
 	if hc == nil {
 		hc = http.DefaultClient
 	}
-
-	a.ctx = context.WithValue(context.Background(), oauth2.HTTPClient, hc)
+	a.hc = hc
 	return a
 }
 
-func (a *API) getHTTPClient() (*http.Client, error) {
+// clientContext returns ctx with the http.Client that UAA and API requests
+// are sent with.
+func (a *API) clientContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, a.hc)
+}
+
+// getHTTPClient returns a client authenticated with the Ops Manager UAA. The
+// token is cached and reused until it expires so long running processes don't
+// request a token for every call.
+func (a *API) getHTTPClient(ctx context.Context) (*http.Client, error) {
+	a.tokenMu.Lock()
+	defer a.tokenMu.Unlock()
+	if !a.token.Valid() {
+		token, err := a.getToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		a.token = token
+	}
+
+	return oauth2.NewClient(a.clientContext(ctx), oauth2.StaticTokenSource(a.token)), nil
+}
+
+func (a *API) getToken(ctx context.Context) (*oauth2.Token, error) {
+	ctx = a.clientContext(ctx)
 	tokenURL := fmt.Sprintf("%s/uaa/oauth/token", a.host)
 
 	if a.useClientCredentials {
@@ -64,7 +110,7 @@ func (a *API) getHTTPClient() (*http.Client, error) {
 			TokenURL:     tokenURL,
 		}
 
-		return config.Client(a.ctx), nil
+		return config.Token(ctx)
 	}
 
 	// opsman is an implicit client with no secret, used to get password tokens
@@ -76,20 +122,24 @@ func (a *API) getHTTPClient() (*http.Client, error) {
 		},
 	}
 
-	token, err := config.PasswordCredentialsToken(a.ctx, a.username, a.password)
+	return config.PasswordCredentialsToken(ctx, a.username, a.password)
+}
+
+// get sends a GET request that's cancelled with ctx
+func (a *API) get(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
-
-	return config.Client(a.ctx, token), nil
+	return client.Do(req)
 }
 
 // EnsureAvailability will attempt up to numRetries times to unlock the API
 // with the decryption passphrase provided in NewAPI.
-func (a *API) EnsureAvailability(numRetries uint) error {
+func (a *API) EnsureAvailability(ctx context.Context, numRetries uint) error {
 	var i uint = 0
 	for ; i < numRetries; i++ {
-		err := a.unlock()
+		err := a.unlock(ctx)
 		if err == nil {
 			return nil
 		}
@@ -100,24 +150,28 @@ func (a *API) EnsureAvailability(numRetries uint) error {
 		}
 
 		if a.username != "TESTING" && a.password != "TESTING" {
-			time.Sleep(10 * time.Second)
+			select {
+			case <-time.After(10 * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
 	}
 
 	return fmt.Errorf("failed to unlock after %d tries", numRetries+1)
 }
 
-func (a *API) unlock() error {
+func (a *API) unlock(ctx context.Context) error {
 	body := bytes.NewBufferString(fmt.Sprintf(`{"passphrase":%q}`, a.decryptionPassphrase))
 
-	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/v0/unlock", a.host), body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, fmt.Sprintf("%s/api/v0/unlock", a.host), body)
 	if err != nil {
 		return err
 	}
 
 	var retrieveError *oauth2.RetrieveError
 
-	client, err := a.getHTTPClient() // this makes an OpsManager UAA call, and should be tested for unauthorized
+	client, err := a.getHTTPClient(ctx) // this makes an OpsManager UAA call, and should be tested for unauthorized
 	if err != nil {
 		if errors.As(err, &retrieveError) {
 			if retrieveError.Response.StatusCode != http.StatusOK {
@@ -4,6 +4,7 @@
 package om_test
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -26,7 +27,7 @@ func TestEnsureAvailability(t *testing.T) {
 		defer server.Close()
 		t.Run(fmt.Sprintf("with %d retries", numRetries), func(t *testing.T) {
 			api := om.NewAPI(server.URL, "TESTING", "TESTING", decryptionPassphrase, false, getClient())
-			err := api.EnsureAvailability(numRetries)
+			err := api.EnsureAvailability(context.Background(), numRetries)
 			if shouldFail {
 				if err == nil {
 					t.Fatal("an error was expected but it did not occur")
@@ -51,6 +52,34 @@ func TestEnsureAvailability(t *testing.T) {
 	runner(3, "test-passphrase", false, false)
 }
 
+func TestTokenIsReused(t *testing.T) {
+	tokenRequests := 0
+	handlers := map[string]http.Handler{}
+	handlers["/uaa/oauth/token"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		w.Header().Set("content-type", "application/json")
+		writeString(w, fmt.Sprintf(`{"access_token":%q,"expires_in":3600}`, uaaToken))
+	})
+	handlers["/api/v0/unlock"] = unlockHandler(0, "")
+	handlers["/api/v0/deployed/products"] = http.HandlerFunc(getDeployedProductsHandler)
+	server := getServer(handlers, false)
+	defer server.Close()
+
+	for _, useClientCredentials := range []bool{false, true} {
+		tokenRequests = 0
+		api := om.NewAPI(server.URL, "TESTING", "TESTING", "", useClientCredentials, getClient())
+		for i := 0; i < 3; i++ {
+			if _, err := api.GetDeployedProductVersion(context.Background(), "component-type1"); err != nil {
+				t.Fatalf("an unexpected error occured: %v", err)
+			}
+		}
+		if tokenRequests != 1 {
+			t.Errorf("expected the token to be requested once using client credentials %v, but it was requested %d times",
+				useClientCredentials, tokenRequests)
+		}
+	}
+}
+
 func getClient() *http.Client {
 	return &http.Client{
 		Transport: &teapotHandler{
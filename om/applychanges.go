@@ -6,6 +6,7 @@ package om
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,6 +14,9 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
 )
 
 type errandConfig struct {
@@ -38,8 +42,20 @@ type applyChangesRequest struct {
 // if output is nil, the operation will return immediately and is effectively
 // asynchronous. If not, the logs of the just-trigged installation will be
 // streamed to output.
-func (a *API) ApplyChanges(output io.Writer, ignoreWarnings bool, products ...string) error {
-	deployedProducts, err := a.getDeployedProducts()
+//
+// When ctx is done before the installation is triggered no installation is
+// started. Once triggered, the installation is followed until it finishes or
+// ctx is done, and keeps running in Operations Manager if ctx is done first.
+//
+// The start, progress and outcome are published as events.
+func (a *API) ApplyChanges(ctx context.Context, output io.Writer, ignoreWarnings bool, products ...string) (err error) {
+	started := time.Now()
+	a.events.Publish(events.Event{Type: events.ApplyChangesStarted, Products: products})
+	defer func() {
+		a.events.Publish(events.Event{Type: events.ApplyChangesFinished, Products: products}.Finished(started, err))
+	}()
+
+	deployedProducts, err := a.getDeployedProducts(ctx)
 	if err != nil {
 		return err
 	}
@@ -101,7 +117,7 @@ func (a *API) ApplyChanges(output io.Writer, ignoreWarnings bool, products ...st
 	errands := map[string]errandConfig{}
 
 	for _, guid := range productGUIDs {
-		ec, err := a.getErrandConfig(guid)
+		ec, err := a.getErrandConfig(ctx, guid)
 		if err != nil {
 			return err
 		}
@@ -121,13 +137,13 @@ func (a *API) ApplyChanges(output io.Writer, ignoreWarnings bool, products ...st
 		return err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/v0/installations", a.host), bodyReader)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/api/v0/installations", a.host), bodyReader)
 	if err != nil {
 		return err
 	}
 	req.Header.Set("content-type", "application/json")
 
-	client, err := a.getHTTPClient()
+	client, err := a.getHTTPClient(ctx)
 	if err != nil {
 		return err
 	}
@@ -156,20 +172,25 @@ func (a *API) ApplyChanges(output io.Writer, ignoreWarnings bool, products ...st
 		return err
 	}
 
-	return a.streamLog(output)
+	err = a.streamLog(ctx, output)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("stopped following installation %d, it's still running in Operations Manager: %w",
+			acResponseBody.Install.ID, err)
+	}
+	return err
 }
 
-func (a *API) streamLog(output io.Writer) error {
+func (a *API) streamLog(ctx context.Context, output io.Writer) error {
 	if output == nil {
 		return nil
 	}
 
-	client, err := a.getHTTPClient()
+	client, err := a.getHTTPClient(ctx)
 	if err != nil {
 		return err
 	}
 
-	resp, err := client.Get(fmt.Sprintf("%s/api/v0/installations/current_log", a.host))
+	resp, err := a.get(ctx, client, fmt.Sprintf("%s/api/v0/installations/current_log", a.host))
 	if err != nil {
 		return err
 	}
@@ -202,6 +223,7 @@ func (a *API) streamLog(output io.Writer) error {
 
 		if strings.HasPrefix(line, "data:") {
 			line := line[len("data:"):]
+			a.events.Publish(events.Event{Type: events.ApplyChangesProgress, Output: strings.TrimRight(line, "\n")})
 			_, err = io.WriteString(output, line)
 			if err != nil {
 				return err
@@ -228,7 +250,7 @@ func (a *API) streamLog(output io.Writer) error {
 	}
 }
 
-func (a *API) getErrandConfig(guid string) (errandConfig, error) {
+func (a *API) getErrandConfig(ctx context.Context, guid string) (errandConfig, error) {
 	errandBody := struct {
 		Errands []struct {
 			Name       string      `json:"name"`
@@ -236,12 +258,12 @@ func (a *API) getErrandConfig(guid string) (errandConfig, error) {
 		} `json:"errands"`
 	}{}
 
-	client, err := a.getHTTPClient()
+	client, err := a.getHTTPClient(ctx)
 	if err != nil {
 		return errandConfig{}, err
 	}
 
-	resp, err := client.Get(fmt.Sprintf("%s/api/v0/staged/products/%s/errands", a.host, guid))
+	resp, err := a.get(ctx, client, fmt.Sprintf("%s/api/v0/staged/products/%s/errands", a.host, guid))
 	if err != nil {
 		return errandConfig{}, err
 	}
@@ -5,6 +5,7 @@ package om_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -16,6 +17,7 @@ import (
 	"time"
 
 	"github.com/mitchellh/pointerstructure"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/om"
 )
 
@@ -39,7 +41,7 @@ func TestApplyChanges(t *testing.T) {
 
 	t.Run("director changes only without streaming", func(t *testing.T) {
 		streamCalled = false
-		err := api.ApplyChanges(nil, true)
+		err := api.ApplyChanges(context.Background(), nil, true)
 		if err != nil {
 			t.Fatalf("unexpected error occured: %s", err)
 		}
@@ -81,7 +83,7 @@ func TestApplyChanges(t *testing.T) {
 
 	t.Run("apply all changes without streaming", func(t *testing.T) {
 		streamCalled = false
-		err := api.ApplyChanges(nil, false, "all")
+		err := api.ApplyChanges(context.Background(), nil, false, "all")
 		if err != nil {
 			t.Fatalf("unexpected error occured: %s", err)
 		}
@@ -126,7 +128,7 @@ func TestApplyChanges(t *testing.T) {
 		buf := &bytes.Buffer{}
 
 		streamCalled = false
-		if err := api.ApplyChanges(buf, false, "component-type1"); err != nil {
+		if err := api.ApplyChanges(context.Background(), buf, false, "component-type1"); err != nil {
 			t.Fatalf("unexpected error occured: %v", err)
 		}
 		if !streamCalled {
@@ -170,11 +172,63 @@ func TestApplyChangesWithError(t *testing.T) {
 	server := getServer(handlers, true)
 	defer server.Close()
 
-	api := om.NewAPI(server.URL, "", "", "", true, getClient())
-	err := api.ApplyChanges(ioutil.Discard, false)
+	var published []events.Event
+	sink := sinkFunc(func(e events.Event) { published = append(published, e) })
+
+	api := om.NewAPI(server.URL, "", "", "", true, getClient(), om.WithEvents(sink))
+	err := api.ApplyChanges(context.Background(), ioutil.Discard, false)
 	if err == nil || err.Error() != "installation failed with code 1" {
 		t.Fatalf("an expected error did not occur. the error that did occur was %v", err)
 	}
+
+	// started, 3 substeps and the exit event, then finished
+	if len(published) != 6 {
+		t.Fatalf("expected 6 events, but got %d: %v", len(published), published)
+	}
+	if published[0].Type != events.ApplyChangesStarted {
+		t.Errorf("expected the first event to be %s, but got %s", events.ApplyChangesStarted, published[0].Type)
+	}
+	if published[1].Type != events.ApplyChangesProgress || published[1].Output != "substep 0 of step 0" {
+		t.Errorf("unexpected progress event %+v", published[1])
+	}
+	finished := published[5]
+	if finished.Type != events.ApplyChangesFinished || finished.Status != events.StatusFailed || finished.Error != err.Error() {
+		t.Errorf("unexpected finished event %+v", finished)
+	}
+}
+
+func TestApplyChangesCancelled(t *testing.T) {
+	requestBody := make(chan io.Reader, 1)
+
+	handlers := map[string]http.Handler{
+		"/api/v0/unlock":                    unlockHandler(0, ""),
+		"/api/v0/installations":             startInstallationHandler(t, requestBody),
+		"/api/v0/staged/products/":          http.HandlerFunc(errandConfigHandler),
+		"/api/v0/deployed/products":         http.HandlerFunc(getDeployedProductsHandler),
+		"/api/v0/installations/current_log": http.HandlerFunc(currentLogHandler),
+	}
+
+	server := getServer(handlers, true)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	api := om.NewAPI(server.URL, "", "", "", true, getClient())
+	if err := api.ApplyChanges(ctx, ioutil.Discard, false, "component-type1"); err == nil {
+		t.Fatal("expected the cancelled apply changes to fail")
+	}
+	select {
+	case <-requestBody:
+		t.Error("expected no installation to be started")
+	default:
+	}
+}
+
+type sinkFunc func(e events.Event)
+
+func (f sinkFunc) Publish(e events.Event) {
+	f(e)
 }
 
 func startInstallationHandler(t *testing.T, requestBody chan io.Reader) http.Handler {
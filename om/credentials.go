@@ -5,6 +5,7 @@ package om
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,18 +17,18 @@ import (
 // GetDirectorCredentials will return a slice of environment variables (in the form KEY=VALUE),
 // suitable for use in exec.Command, that will facilitate connections to the Ops Manager's
 // BOSH Director
-func (a *API) GetDirectorCredentials() ([]string, error) {
-	if err := a.EnsureAvailability(30); err != nil {
+func (a *API) GetDirectorCredentials(ctx context.Context) ([]string, error) {
+	if err := a.EnsureAvailability(ctx, 30); err != nil {
 		return nil, err
 	}
 
-	client, err := a.getHTTPClient()
+	client, err := a.getHTTPClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	respBody := map[string]string{}
-	resp, err := client.Get(fmt.Sprintf("%s/api/v0/deployed/director/credentials/bosh_commandline_credentials", a.host))
+	resp, err := a.get(ctx, client, fmt.Sprintf("%s/api/v0/deployed/director/credentials/bosh_commandline_credentials", a.host))
 	if err != nil {
 		return nil, err
 	}
@@ -4,6 +4,7 @@
 package om_test
 
 import (
+	"context"
 	"net/http"
 	"reflect"
 	"testing"
@@ -26,7 +27,7 @@ func TestGetDirectorCredentials(t *testing.T) {
 	defer server.Close()
 
 	api := om.NewAPI(server.URL, "", "", "", true, getClient())
-	creds, err := api.GetDirectorCredentials()
+	creds, err := api.GetDirectorCredentials(context.Background())
 	if err != nil {
 		t.Fatalf("unexpected error occured: %v", err)
 	}
@@ -0,0 +1,59 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package om
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// CheckRunningInstallation returns the ID of the installation (apply
+// changes) that's running in Operations Manager, and false if there isn't
+// one.
+func (a *API) CheckRunningInstallation(ctx context.Context) (int, bool, error) {
+	client, err := a.getHTTPClient(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	resp, err := a.get(ctx, client, fmt.Sprintf("%s/api/v0/installations", a.host))
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	buf := &bytes.Buffer{}
+	_, err = io.Copy(buf, resp.Body)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, fmt.Errorf("%w, got %d with body %s", ErrBadStatusCode, resp.StatusCode, buf.String())
+	}
+
+	installations := struct {
+		Installations []struct {
+			ID     int    `json:"id"`
+			Status string `json:"status"`
+		} `json:"installations"`
+	}{}
+
+	err = json.NewDecoder(buf).Decode(&installations)
+	if err != nil {
+		return 0, false, fmt.Errorf("got unexpected JSON from Ops Manager: %w", err)
+	}
+
+	for _, i := range installations.Installations {
+		if i.Status == "running" {
+			return i.ID, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package om_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/om"
+)
+
+func TestCheckRunningInstallation(t *testing.T) {
+	runner := func(name string, output string, expectedID int, expectedRunning bool) {
+		t.Run(name, func(t *testing.T) {
+			handlers := map[string]http.Handler{}
+			handlers["/api/v0/installations"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				writeString(w, output)
+			})
+			server := getServer(handlers, true)
+			defer server.Close()
+
+			api := om.NewAPI(server.URL, "", "", "", true, getClient())
+			id, running, err := api.CheckRunningInstallation(context.Background())
+			if err != nil {
+				t.Fatalf("an unexpected error occured: %v", err)
+			}
+			if running != expectedRunning || id != expectedID {
+				t.Fatalf("expected installation %d running %t, but got %d running %t", expectedID, expectedRunning, id, running)
+			}
+		})
+	}
+
+	runner("none", `{"installations":[]}`, 0, false)
+	runner("finished", `{"installations":[{"id":12,"status":"succeeded"},{"id":11,"status":"failed"}]}`, 0, false)
+	runner("running", `{"installations":[{"id":13,"status":"running"},{"id":12,"status":"succeeded"}]}`, 13, true)
+}
@@ -5,6 +5,7 @@ package om
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,17 +14,17 @@ import (
 	"github.com/mitchellh/pointerstructure"
 )
 
-func (a *API) GetBoshDirectorName() (string, error) {
-	if err := a.EnsureAvailability(30); err != nil {
+func (a *API) GetBoshDirectorName(ctx context.Context) (string, error) {
+	if err := a.EnsureAvailability(ctx, 30); err != nil {
 		return "", err
 	}
 
-	client, err := a.getHTTPClient()
+	client, err := a.getHTTPClient(ctx)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := client.Get(fmt.Sprintf("%s/api/v0/deployed/director/manifest", a.host))
+	resp, err := a.get(ctx, client, fmt.Sprintf("%s/api/v0/deployed/director/manifest", a.host))
 	if err != nil {
 		return "", err
 	}
@@ -47,17 +48,17 @@ func (a *API) GetBoshDirectorName() (string, error) {
 }
 
 // GetBoshManifest returns the latest attemped OpsMan generated BOSH manifest
-func (a *API) GetBoshManifest(deploymentName string) ([]byte, error) {
-	if err := a.EnsureAvailability(30); err != nil {
+func (a *API) GetBoshManifest(ctx context.Context, deploymentName string) ([]byte, error) {
+	if err := a.EnsureAvailability(ctx, 30); err != nil {
 		return nil, err
 	}
 
-	client, err := a.getHTTPClient()
+	client, err := a.getHTTPClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := client.Get(fmt.Sprintf("%s/api/v0/deployed/products/%s/manifest", a.host, deploymentName))
+	resp, err := a.get(ctx, client, fmt.Sprintf("%s/api/v0/deployed/products/%s/manifest", a.host, deploymentName))
 	if err != nil {
 		return nil, err
 	}
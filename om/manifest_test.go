@@ -4,6 +4,7 @@
 package om_test
 
 import (
+	"context"
 	"net/http"
 	"testing"
 
@@ -23,7 +24,7 @@ func TestGetBoshDirectorName(t *testing.T) {
 	defer server.Close()
 
 	api := om.NewAPI(server.URL, "", "", "", true, getClient())
-	directorName, err := api.GetBoshDirectorName()
+	directorName, err := api.GetBoshDirectorName(context.Background())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -46,7 +47,7 @@ func TestGetCFBoshManifest(t *testing.T) {
 	defer server.Close()
 
 	api := om.NewAPI(server.URL, "", "", "", true, getClient())
-	manifestBytes, err := api.GetBoshManifest("cf-guid")
+	manifestBytes, err := api.GetBoshManifest(context.Background(), "cf-guid")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -5,6 +5,7 @@ package om
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,18 +15,18 @@ import (
 // CheckPendingChanges will return true if an apply
 // changes would actually do anything (config changes,
 // version updates, stemcell updates, etc)
-func (a *API) CheckPendingChanges() (bool, error) {
-	err := a.EnsureAvailability(30)
+func (a *API) CheckPendingChanges(ctx context.Context) (bool, error) {
+	err := a.EnsureAvailability(ctx, 30)
 	if err != nil {
 		return false, err
 	}
 
-	client, err := a.getHTTPClient()
+	client, err := a.getHTTPClient(ctx)
 	if err != nil {
 		return false, err
 	}
 
-	resp, err := client.Get(fmt.Sprintf("%s/api/v0/staged/pending_changes", a.host))
+	resp, err := a.get(ctx, client, fmt.Sprintf("%s/api/v0/staged/pending_changes", a.host))
 	if err != nil {
 		return false, err
 	}
@@ -4,6 +4,7 @@
 package om_test
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"strings"
@@ -23,7 +24,7 @@ func TestPendingChanges(t *testing.T) {
 
 		t.Run("", func(t *testing.T) {
 			api := om.NewAPI(server.URL, "", "", "", true, getClient())
-			changes, err := api.CheckPendingChanges()
+			changes, err := api.CheckPendingChanges(context.Background())
 			if shouldFail {
 				if err == nil {
 					t.Fatal("an error was expected but it did not occur")
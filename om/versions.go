@@ -5,6 +5,7 @@ package om
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,22 +19,23 @@ type omVersion struct {
 	} `json:"info"`
 }
 
-type deployedProduct struct {
+// DeployedProduct is a product deployed by Ops Manager
+type DeployedProduct struct {
 	GUID    string `json:"guid"`
 	Name    string `json:"type"`
 	Version string `json:"product_version"`
 }
 
-type deployedProducts []deployedProduct
+type deployedProducts []DeployedProduct
 
 // GetOpsManagerVersion returns the version of Ops Manager as reported by the /api/v0/info endpoint
-func (a *API) GetOpsManagerVersion() (string, error) {
-	client, err := a.getHTTPClient()
+func (a *API) GetOpsManagerVersion(ctx context.Context) (string, error) {
+	client, err := a.getHTTPClient(ctx)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := client.Get(fmt.Sprintf("%s/api/v0/info", a.host))
+	resp, err := a.get(ctx, client, fmt.Sprintf("%s/api/v0/info", a.host))
 	if err != nil {
 		return "", err
 	}
@@ -61,12 +63,12 @@ func (a *API) GetOpsManagerVersion() (string, error) {
 // GetDeployedProductVersion will return the version of the requested product that
 // is currently deployed. It will error if the product is p-bosh. For that, you should use
 // GetOpsManagerVersion. It will also error if the product is not found.
-func (a *API) GetDeployedProductVersion(productName string) (string, error) {
+func (a *API) GetDeployedProductVersion(ctx context.Context, productName string) (string, error) {
 	if productName == "p-bosh" {
 		return "", errors.New("this method cannot return the version of the BOSH director")
 	}
 
-	products, err := a.getDeployedProducts()
+	products, err := a.getDeployedProducts(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -80,18 +82,24 @@ func (a *API) GetDeployedProductVersion(productName string) (string, error) {
 	return "", fmt.Errorf("unable to find deployed product with name %s", productName)
 }
 
-func (a *API) getDeployedProducts() (deployedProducts, error) {
-	err := a.EnsureAvailability(30)
+// GetDeployedProducts returns every product that is currently deployed,
+// including the BOSH director which has no version.
+func (a *API) GetDeployedProducts(ctx context.Context) ([]DeployedProduct, error) {
+	return a.getDeployedProducts(ctx)
+}
+
+func (a *API) getDeployedProducts(ctx context.Context) (deployedProducts, error) {
+	err := a.EnsureAvailability(ctx, 30)
 	if err != nil {
 		return nil, err
 	}
 
-	client, err := a.getHTTPClient()
+	client, err := a.getHTTPClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := client.Get(fmt.Sprintf("%s/api/v0/deployed/products", a.host))
+	resp, err := a.get(ctx, client, fmt.Sprintf("%s/api/v0/deployed/products", a.host))
 	if err != nil {
 		return nil, err
 	}
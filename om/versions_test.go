@@ -4,6 +4,7 @@
 package om_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"testing"
@@ -23,7 +24,7 @@ func TestGetOpsManagerVersion(t *testing.T) {
 	defer server.Close()
 
 	api := om.NewAPI(server.URL, "", "", "", true, getClient())
-	version, err := api.GetOpsManagerVersion()
+	version, err := api.GetOpsManagerVersion(context.Background())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -46,7 +47,7 @@ func TestGetDeployedProductVersion(t *testing.T) {
 
 	runner := func(productName string, expectedVersion string, shouldFail bool) {
 		t.Run(fmt.Sprintf("test-%s", productName), func(t *testing.T) {
-			version, err := api.GetDeployedProductVersion(productName)
+			version, err := api.GetDeployedProductVersion(context.Background(), productName)
 			if shouldFail {
 				if err == nil {
 					t.Fatalf("an expected error did not occur")
@@ -70,6 +71,35 @@ func TestGetDeployedProductVersion(t *testing.T) {
 	runner("cf", "2.4.27", true)
 }
 
+func TestGetDeployedProducts(t *testing.T) {
+	handlers := map[string]http.Handler{}
+
+	handlers["/api/v0/unlock"] = unlockHandler(0, "")
+	handlers["/api/v0/deployed/products"] = http.HandlerFunc(getDeployedProductsHandler)
+
+	server := getServer(handlers, true)
+	defer server.Close()
+
+	api := om.NewAPI(server.URL, "", "", "", true, getClient())
+	products, err := api.GetDeployedProducts(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []om.DeployedProduct{
+		{GUID: "component-type1-guid", Name: "component-type1", Version: "1.0"},
+		{GUID: "p-bosh-guid", Name: "p-bosh"},
+	}
+	if len(products) != len(expected) {
+		t.Fatalf("expected %d products, but got %v", len(expected), products)
+	}
+	for i, p := range products {
+		if p != expected[i] {
+			t.Errorf("expected %v, but got %v", expected[i], p)
+		}
+	}
+}
+
 func getDeployedProductsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,467 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package preflight checks that the foundation is safe to rotate before any
+// changes are made.
+package preflight
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/compat"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+)
+
+// defaultMinFreeSpace is the free space required in the temp directory,
+// where manifests and credhub imports are written
+const defaultMinFreeSpace = 100 << 20
+
+// Result is the outcome of a check
+type Result int
+
+const (
+	Pass Result = iota
+	Warn
+	Fail
+)
+
+func (r Result) String() string {
+	switch r {
+	case Pass:
+		return "pass"
+	case Warn:
+		return "warn"
+	default:
+		return "fail"
+	}
+}
+
+// Check is the outcome of a single preflight check
+type Check struct {
+	Name   string
+	Result Result
+	// Detail describes what was found
+	Detail string
+	// Remediation describes how to fix a warning or failure
+	Remediation string
+}
+
+// Report is every preflight check in the order they ran
+type Report struct {
+	Checks []Check
+}
+
+// Failed returns true if any check failed
+func (r *Report) Failed() bool {
+	for _, c := range r.Checks {
+		if c.Result == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// Render writes the checks as a table, followed by the remediation for
+// each warning and failure.
+func (r *Report) Render(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESULT\tCHECK\tDETAIL")
+	for _, c := range r.Checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Result, c.Name, c.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, c := range r.Checks {
+		if c.Result != Pass && c.Remediation != "" {
+			if _, err := fmt.Fprintf(w, "\n%s: %s\n  %s\n", c.Result, c.Name, c.Remediation); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Report) add(c Check) {
+	r.Checks = append(r.Checks, c)
+}
+
+// BoshRunner interfaces with bosh
+type BoshRunner interface {
+	GetRunningTasks(ctx context.Context) ([]bosh.Task, error)
+	GetDeploymentVMStates(ctx context.Context, deploymentName string) ([]bosh.VM, error)
+}
+
+// CredhubRunner interfaces with credhub
+type CredhubRunner interface {
+	GetCertificate(ctx context.Context, certPath string) (*credhub.Certificate, error)
+	Find(ctx context.Context, nameLike string) ([]string, error)
+}
+
+// OpsManager interfaces with opsman
+type OpsManager interface {
+	CheckPendingChanges(ctx context.Context) (bool, error)
+	CheckRunningInstallation(ctx context.Context) (int, bool, error)
+}
+
+// ManifestLoader loads bosh manifests from existing deployments
+type ManifestLoader interface {
+	GetManifestsWithDiegoCells(ctx context.Context, selector manifest.Selector) ([]manifest.Manifest, error)
+}
+
+// Option configures optional Checker behavior
+type Option func(c *Checker)
+
+// WithSelector only checks the VMs and certs of the selected deployments
+func WithSelector(s manifest.Selector) Option {
+	return func(c *Checker) {
+		c.selector = s
+	}
+}
+
+// WithCLIs sets the CLIs that must be installed, and the CLIs that are only
+// reported if they're missing. By default the bosh and credhub CLIs are
+// required.
+func WithCLIs(required []string, optional []string) Option {
+	return func(c *Checker) {
+		c.requiredCLIs = required
+		c.optionalCLIs = optional
+	}
+}
+
+// WithTempDir sets the directory that must have at least minFree bytes
+// free. By default it's the system temp directory with 100MiB free.
+func WithTempDir(dir string, minFree uint64) Option {
+	return func(c *Checker) {
+		c.tempDir = dir
+		c.minFree = minFree
+	}
+}
+
+// WithRotationInProgress expects the regen credentials of an unfinished
+// rotation to exist.
+func WithRotationInProgress(inProgress bool) Option {
+	return func(c *Checker) {
+		c.inProgress = inProgress
+	}
+}
+
+// WithProductSupport reports whether each deployed product's version is in
+// the compatibility matrix.
+func WithProductSupport(supports []compat.Support) Option {
+	return func(c *Checker) {
+		c.supports = supports
+	}
+}
+
+// Checker runs the preflight checks
+type Checker struct {
+	om             OpsManager
+	bosh           BoshRunner
+	credhub        CredhubRunner
+	manifestLoader ManifestLoader
+	selector       manifest.Selector
+	requiredCLIs   []string
+	optionalCLIs   []string
+	tempDir        string
+	minFree        uint64
+	inProgress     bool
+	supports       []compat.Support
+
+	lookPath  func(file string) (string, error)
+	freeSpace func(dir string) (uint64, error)
+}
+
+// New creates a preflight Checker
+func New(om OpsManager, bosh BoshRunner, credhub CredhubRunner, manifestLoader ManifestLoader, opts ...Option) *Checker {
+	c := &Checker{
+		om:             om,
+		bosh:           bosh,
+		credhub:        credhub,
+		manifestLoader: manifestLoader,
+		requiredCLIs:   []string{"bosh", "credhub"},
+		tempDir:        os.TempDir(),
+		minFree:        defaultMinFreeSpace,
+		lookPath:       exec.LookPath,
+		freeSpace:      freeSpace,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Run runs every check, a check that couldn't be run is a failure. It makes
+// no changes.
+func (c *Checker) Run(ctx context.Context) *Report {
+	r := &Report{}
+	c.checkCLIs(r)
+	c.checkVersions(r)
+	c.checkBosh(ctx, r)
+	c.checkCredhub(ctx, r)
+	c.checkOpsManager(ctx, r)
+	c.checkDeployments(ctx, r)
+	c.checkDiskSpace(r)
+	return r
+}
+
+func (c *Checker) checkCLIs(r *Report) {
+	for _, name := range c.requiredCLIs {
+		c.checkCLI(r, name, Fail)
+	}
+	for _, name := range c.optionalCLIs {
+		c.checkCLI(r, name, Warn)
+	}
+}
+
+func (c *Checker) checkCLI(r *Report, name string, missing Result) {
+	check := Check{Name: fmt.Sprintf("%s CLI installed", name)}
+	path, err := c.lookPath(name)
+	if err != nil {
+		check.Result = missing
+		check.Detail = fmt.Sprintf("%s was not found in the PATH", name)
+		check.Remediation = fmt.Sprintf("Install the %s CLI, it's included on the Operations Manager VM", name)
+	} else {
+		check.Detail = path
+	}
+	r.add(check)
+}
+
+func (c *Checker) checkVersions(r *Report) {
+	for _, s := range c.supports {
+		check := Check{Name: fmt.Sprintf("%s %s supported", s.Name, s.Version)}
+		switch {
+		case s.Err != nil:
+			check.Result = Fail
+			check.Detail = s.Err.Error()
+			check.Remediation = fmt.Sprintf("Upgrade %s to a supported version, or pass a compatibility matrix "+
+				"that includes it with --compat-matrix", s.Name)
+		case s.Entry.ManifestProfile() != nil:
+			check.Detail = fmt.Sprintf("%s manifest profile", s.Entry.ManifestProfile().Name)
+		default:
+			check.Detail = strings.Join(s.Entry.Features, ", ")
+		}
+		r.add(check)
+	}
+}
+
+func (c *Checker) checkBosh(ctx context.Context, r *Report) {
+	tasks, err := c.bosh.GetRunningTasks(ctx)
+	if err != nil {
+		r.add(Check{
+			Name:        "BOSH Director reachable",
+			Result:      Fail,
+			Detail:      err.Error(),
+			Remediation: "Check the director is running and BOSH_ENVIRONMENT, BOSH_CLIENT, BOSH_CLIENT_SECRET, and BOSH_CA_CERT are correct",
+		})
+		return
+	}
+	r.add(Check{Name: "BOSH Director reachable"})
+
+	check := Check{Name: "No BOSH tasks in progress"}
+	if len(tasks) > 0 {
+		var running []string
+		for _, t := range tasks {
+			running = append(running, fmt.Sprintf("task %d %s %s", t.ID, t.State, describeTask(t)))
+		}
+		check.Result = Fail
+		check.Detail = strings.Join(running, ", ")
+		check.Remediation = "Wait for the tasks to finish, 'bosh tasks' lists them and 'bosh task ID' follows one"
+	}
+	r.add(check)
+}
+
+func describeTask(t bosh.Task) string {
+	if t.Deployment == "" {
+		return t.Description
+	}
+	return fmt.Sprintf("%s (%s)", t.Description, t.Deployment)
+}
+
+func (c *Checker) checkCredhub(ctx context.Context, r *Report) {
+	names, err := c.credhub.Find(ctx, manifest.RegenSuffix)
+	if err != nil {
+		r.add(Check{
+			Name:        "CredHub reachable",
+			Result:      Fail,
+			Detail:      err.Error(),
+			Remediation: "Check CredHub is running and CREDHUB_SERVER, CREDHUB_CLIENT, CREDHUB_SECRET, and CREDHUB_CA_CERT are correct",
+		})
+		return
+	}
+	r.add(Check{Name: "CredHub reachable"})
+
+	check := Check{Name: "No leftover regen credentials"}
+	switch {
+	case len(names) == 0:
+	case c.inProgress:
+		check.Detail = fmt.Sprintf("found %d from the rotation in progress", len(names))
+	default:
+		check.Result = Fail
+		check.Detail = strings.Join(names, ", ")
+		check.Remediation = "A previous rotation didn't finish, run 'riic status' to see how far it got and " +
+			"'riic rotate --resume' to finish it, or delete the credentials with 'credhub delete -n NAME'"
+	}
+	r.add(check)
+}
+
+func (c *Checker) checkOpsManager(ctx context.Context, r *Report) {
+	check := Check{Name: "No Operations Manager installation in progress"}
+	id, running, err := c.om.CheckRunningInstallation(ctx)
+	switch {
+	case err != nil:
+		check.Result = Fail
+		check.Detail = err.Error()
+		check.Remediation = "Check Operations Manager is running and the credentials are correct"
+	case running:
+		check.Result = Fail
+		check.Detail = fmt.Sprintf("installation %d is running", id)
+		check.Remediation = "Wait for the apply changes to finish in Operations Manager"
+	}
+	r.add(check)
+
+	check = Check{Name: "No pending changes in Operations Manager"}
+	changes, err := c.om.CheckPendingChanges(ctx)
+	switch {
+	case err != nil:
+		check.Result = Fail
+		check.Detail = err.Error()
+		check.Remediation = "Check Operations Manager is running and the credentials are correct"
+	case changes:
+		check.Result = Fail
+		check.Detail = "there are staged changes that haven't been applied"
+		check.Remediation = "Apply or revert the pending changes in Operations Manager"
+	}
+	r.add(check)
+}
+
+func (c *Checker) checkDeployments(ctx context.Context, r *Report) {
+	manifests, err := c.manifestLoader.GetManifestsWithDiegoCells(ctx, c.selector)
+	if err != nil {
+		r.add(Check{
+			Name:        "Diego deployments found",
+			Result:      Fail,
+			Detail:      err.Error(),
+			Remediation: "Check the BOSH Director and Operations Manager are reachable",
+		})
+		return
+	}
+	defer func() {
+		for _, m := range manifests {
+			os.Remove(m.Path)
+		}
+	}()
+	if len(manifests) == 0 {
+		r.add(Check{
+			Name:        "Diego deployments found",
+			Result:      Fail,
+			Detail:      "no deployments with diego cells were selected",
+			Remediation: "Check the --deployment and --exclude-deployment patterns",
+		})
+		return
+	}
+
+	for _, m := range manifests {
+		c.checkVMs(ctx, r, m.DeploymentName)
+	}
+
+	root, err := c.getCertificate(ctx, manifest.RootCertName)
+	if err != nil {
+		r.add(Check{
+			Name:        "Root CA in CredHub",
+			Result:      Fail,
+			Detail:      err.Error(),
+			Remediation: fmt.Sprintf("Check %s exists with 'credhub get -n %s'", manifest.RootCertName, manifest.RootCertName),
+		})
+		return
+	}
+	for _, m := range manifests {
+		m := m
+		c.checkChain(ctx, r, &m, root)
+	}
+}
+
+func (c *Checker) checkVMs(ctx context.Context, r *Report, deploymentName string) {
+	check := Check{Name: fmt.Sprintf("%s VMs running", deploymentName)}
+	vms, err := c.bosh.GetDeploymentVMStates(ctx, deploymentName)
+	if err != nil {
+		check.Result = Fail
+		check.Detail = err.Error()
+		check.Remediation = "Check the BOSH Director is reachable"
+		r.add(check)
+		return
+	}
+
+	var notRunning []string
+	for _, vm := range vms {
+		if vm.ProcessState != "running" {
+			notRunning = append(notRunning, fmt.Sprintf("%s is %s", vm.Name, vm.ProcessState))
+		}
+	}
+	if len(notRunning) > 0 {
+		check.Result = Fail
+		check.Detail = strings.Join(notRunning, ", ")
+		check.Remediation = fmt.Sprintf("Resolve the failing VMs, for example with 'bosh -d %s cck' or by recreating them, "+
+			"a deploy fails if any VM isn't running", deploymentName)
+	} else {
+		check.Detail = fmt.Sprintf("%d VMs", len(vms))
+	}
+	r.add(check)
+}
+
+func (c *Checker) checkChain(ctx context.Context, r *Report, m *manifest.Manifest, root *x509.Certificate) {
+	check := Check{Name: fmt.Sprintf("%s intermediate chains to the root", m.DeploymentName)}
+	intermediate, err := c.getCertificate(ctx, m.IntermediateCertPath())
+	if err == nil {
+		err = intermediate.CheckSignatureFrom(root)
+	}
+	if err != nil {
+		check.Result = Fail
+		check.Detail = err.Error()
+		check.Remediation = fmt.Sprintf("%s wasn't signed by %s, run 'riic status' to check for a partial rotation",
+			m.IntermediateCertPath(), manifest.RootCertName)
+	}
+	r.add(check)
+}
+
+func (c *Checker) getCertificate(ctx context.Context, certPath string) (*x509.Certificate, error) {
+	cred, err := c.credhub.GetCertificate(ctx, certPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(cred.Value.Certificate))
+	if block == nil {
+		return nil, errors.New("failed to parse certificate PEM")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func (c *Checker) checkDiskSpace(r *Report) {
+	check := Check{Name: fmt.Sprintf("Free disk space in %s", c.tempDir)}
+	free, err := c.freeSpace(c.tempDir)
+	switch {
+	case err != nil:
+		check.Result = Warn
+		check.Detail = err.Error()
+		check.Remediation = "Check the temp directory exists, riic writes manifests and credhub imports to it"
+	case free < c.minFree:
+		check.Result = Fail
+		check.Detail = fmt.Sprintf("%d MiB free, %d MiB required", free>>20, c.minFree>>20)
+		check.Remediation = "Free up disk space, or set TMPDIR to a directory with more space"
+	default:
+		check.Detail = fmt.Sprintf("%d MiB free", free>>20)
+	}
+	r.add(check)
+}
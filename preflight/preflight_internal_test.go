@@ -0,0 +1,260 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/compat"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+)
+
+type fakeBosh struct {
+	tasks    []bosh.Task
+	tasksErr error
+	vms      map[string][]bosh.VM
+}
+
+func (f *fakeBosh) GetRunningTasks(ctx context.Context) ([]bosh.Task, error) {
+	return f.tasks, f.tasksErr
+}
+
+func (f *fakeBosh) GetDeploymentVMStates(ctx context.Context, deploymentName string) ([]bosh.VM, error) {
+	return f.vms[deploymentName], nil
+}
+
+type fakeCredhub struct {
+	certs map[string]string
+	found []string
+}
+
+func (f *fakeCredhub) GetCertificate(ctx context.Context, certPath string) (*credhub.Certificate, error) {
+	cert, ok := f.certs[certPath]
+	if !ok {
+		return nil, credhub.ErrCredentialNotFound
+	}
+	return credhub.NewCertificate(certPath, "certificate", cert, "", ""), nil
+}
+
+func (f *fakeCredhub) Find(ctx context.Context, nameLike string) ([]string, error) {
+	return f.found, nil
+}
+
+type fakeOpsManager struct {
+	installation int
+	changes      bool
+}
+
+func (f *fakeOpsManager) CheckPendingChanges(ctx context.Context) (bool, error) {
+	return f.changes, nil
+}
+
+func (f *fakeOpsManager) CheckRunningInstallation(ctx context.Context) (int, bool, error) {
+	return f.installation, f.installation != 0, nil
+}
+
+type fakeLoader []string
+
+func (f fakeLoader) GetManifestsWithDiegoCells(ctx context.Context, selector manifest.Selector) (manifests []manifest.Manifest, err error) {
+	for _, name := range f {
+		manifests = append(manifests, manifest.Manifest{DeploymentName: name})
+	}
+	return manifests, nil
+}
+
+// newCert returns a PEM CA certificate signed by parent, or self signed if
+// parent is nil
+func newCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (string, *x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), cert, key
+}
+
+func newChecker(t *testing.T, b *fakeBosh, c *fakeCredhub, om *fakeOpsManager, opts ...Option) *Checker {
+	checker := New(om, b, c, fakeLoader{"cf-guid", "p-isolation-segment-guid"}, opts...)
+	checker.lookPath = func(file string) (string, error) {
+		return "/usr/local/bin/" + file, nil
+	}
+	checker.freeSpace = func(dir string) (uint64, error) {
+		return 10 << 30, nil
+	}
+	return checker
+}
+
+func healthy(t *testing.T) (*fakeBosh, *fakeCredhub, *fakeOpsManager) {
+	rootPEM, root, rootKey := newCert(t, "root", nil, nil)
+	cfPEM, _, _ := newCert(t, "cf", root, rootKey)
+	isoPEM, _, _ := newCert(t, "iso", root, rootKey)
+
+	cf := manifest.Manifest{DeploymentName: "cf-guid"}
+	iso := manifest.Manifest{DeploymentName: "p-isolation-segment-guid"}
+	b := &fakeBosh{vms: map[string][]bosh.VM{
+		"cf-guid":                  {{Name: "diego_cell/1", ProcessState: "running"}},
+		"p-isolation-segment-guid": {{Name: "isolated_diego_cell/1", ProcessState: "running"}},
+	}}
+	c := &fakeCredhub{certs: map[string]string{
+		manifest.RootCertName:      rootPEM,
+		cf.IntermediateCertPath():  cfPEM,
+		iso.IntermediateCertPath(): isoPEM,
+	}}
+	return b, c, &fakeOpsManager{}
+}
+
+func TestPreflightPasses(t *testing.T) {
+	b, c, om := healthy(t)
+	report := newChecker(t, b, c, om).Run(context.Background())
+	if report.Failed() {
+		t.Fatalf("expected every check to pass, got %+v", report.Checks)
+	}
+	// CLIs, director, tasks, credhub, regen, installation, pending
+	// changes, 2 VMs, 2 chains, and disk space
+	if len(report.Checks) != 13 {
+		t.Errorf("expected 13 checks but got %d: %+v", len(report.Checks), report.Checks)
+	}
+}
+
+func TestPreflightFailures(t *testing.T) {
+	b, c, om := healthy(t)
+	b.tasks = []bosh.Task{{ID: 41, State: "processing", Description: "create deployment", Deployment: "cf-guid"}}
+	b.vms["p-isolation-segment-guid"][0].ProcessState = "failing"
+	c.found = []string{"/cf/diego-instance-identity-root-ca-riic-regen"}
+	om.installation = 12
+	otherPEM, _, _ := newCert(t, "other", nil, nil)
+	cf := manifest.Manifest{DeploymentName: "cf-guid"}
+	c.certs[cf.IntermediateCertPath()] = otherPEM
+
+	checker := newChecker(t, b, c, om)
+	checker.lookPath = func(file string) (string, error) {
+		if file == "credhub" {
+			return "", errors.New("not found")
+		}
+		return "/usr/local/bin/" + file, nil
+	}
+	checker.freeSpace = func(dir string) (uint64, error) {
+		return 10 << 20, nil
+	}
+	report := checker.Run(context.Background())
+
+	failed := map[string]string{}
+	for _, check := range report.Checks {
+		if check.Result == Fail {
+			failed[check.Name] = check.Detail
+		}
+	}
+	expected := []string{
+		"credhub CLI installed",
+		"No BOSH tasks in progress",
+		"No leftover regen credentials",
+		"No Operations Manager installation in progress",
+		"p-isolation-segment-guid VMs running",
+		"cf-guid intermediate chains to the root",
+		"Free disk space in " + checker.tempDir,
+	}
+	for _, name := range expected {
+		if _, ok := failed[name]; !ok {
+			t.Errorf("expected %q to fail, failures were %v", name, failed)
+		}
+	}
+	if len(failed) != len(expected) {
+		t.Errorf("expected %d failures but got %v", len(expected), failed)
+	}
+	if !strings.Contains(failed["No BOSH tasks in progress"], "task 41") {
+		t.Errorf("expected the running task to be reported, got %q", failed["No BOSH tasks in progress"])
+	}
+
+	var buf bytes.Buffer
+	if err := report.Render(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "bosh -d p-isolation-segment-guid cck") {
+		t.Errorf("expected remediation in the report, got:\n%s", buf.String())
+	}
+}
+
+func TestPreflightRotationInProgress(t *testing.T) {
+	b, c, om := healthy(t)
+	c.found = []string{"/cf/diego-instance-identity-root-ca-riic-regen"}
+	b.tasksErr = errors.New("connection refused")
+
+	report := newChecker(t, b, c, om, WithRotationInProgress(true), WithCLIs([]string{"bosh"}, []string{"credhub"})).Run(context.Background())
+	for _, check := range report.Checks {
+		switch check.Name {
+		case "No leftover regen credentials":
+			if check.Result != Pass {
+				t.Errorf("expected regen credentials to be expected during a rotation, got %+v", check)
+			}
+		case "BOSH Director reachable":
+			if check.Result != Fail || check.Detail != "connection refused" {
+				t.Errorf("expected the director to be unreachable, got %+v", check)
+			}
+		case "No BOSH tasks in progress":
+			t.Errorf("tasks can't be checked when the director is unreachable")
+		}
+	}
+}
+
+func TestPreflightProductSupport(t *testing.T) {
+	matrix, err := compat.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	supports := matrix.Check([]compat.Product{
+		{Name: compat.OpsManager, Version: "2.10.38-build.268"},
+		{Name: "cf", GUID: "cf-guid", Version: "2.13.1"},
+		{Name: "p-isolation-segment", GUID: "p-isolation-segment-guid", Version: "2.3.0"},
+	})
+
+	b, c, om := healthy(t)
+	report := newChecker(t, b, c, om, WithProductSupport(supports)).Run(context.Background())
+
+	results := map[string]Check{}
+	for _, check := range report.Checks {
+		results[check.Name] = check
+	}
+	if check := results["p-bosh 2.10.38-build.268 supported"]; check.Result != Pass {
+		t.Errorf("expected Operations Manager to be supported, got %+v", check)
+	}
+	if check := results["cf 2.13.1 supported"]; check.Result != Pass || !strings.Contains(check.Detail, "TAS 2.13") {
+		t.Errorf("expected cf to be supported with its profile, got %+v", check)
+	}
+	if check := results["p-isolation-segment 2.3.0 supported"]; check.Result != Fail || check.Remediation == "" {
+		t.Errorf("expected the isolation segment to be unsupported, got %+v", check)
+	}
+}
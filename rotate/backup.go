@@ -0,0 +1,63 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package rotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+)
+
+// BackupExtension is the file extension of encrypted credhub backups
+const BackupExtension = ".riic-backup"
+
+// backupConfig is where and how credhub backups are written
+type backupConfig struct {
+	dir        string
+	passphrase string
+}
+
+// backupCredhub writes an encrypted backup of every credential the rotation
+// may overwrite, the root, each intermediate, and any existing regen certs.
+// It does nothing when backups aren't configured.
+func (r *CertRotator) backupCredhub(ctx context.Context, manifests []manifest.Manifest) error {
+	if r.backup == nil {
+		return nil
+	}
+
+	paths := []string{manifest.RootCertName, manifest.RootCertRegenName}
+	for _, m := range manifests {
+		paths = append(paths, m.IntermediateCertPath(), m.IntermediateCertRegenPath())
+	}
+
+	var certs []credhub.Certificate
+	for _, p := range paths {
+		cert, err := r.getCertificate(ctx, p)
+		if errors.Is(err, credhub.ErrCredentialNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not back up credhub: %w", err)
+		}
+		certs = append(certs, *cert)
+	}
+
+	if err := os.MkdirAll(r.backup.dir, 0700); err != nil {
+		return fmt.Errorf("could not create backup directory %s: %w", r.backup.dir, err)
+	}
+	path := filepath.Join(r.backup.dir, "credhub-"+time.Now().UTC().Format("20060102T150405Z")+BackupExtension)
+	if err := credhub.WriteBackup(path, r.backup.passphrase, certs); err != nil {
+		return err
+	}
+
+	events.Infof(r.events, "Backed up %d credhub credentials to %s, keep an offline copy before continuing", len(certs), path)
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package rotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/validate"
+)
+
+// ErrUnhealthy is returned when a deployment didn't pass the health gate
+var ErrUnhealthy = errors.New("deployment is unhealthy")
+
+// HealthGate configures the checks a deployment must pass after it's
+// deployed before the rotation continues to the next deployment.
+type HealthGate struct {
+	// Timeout is how long to wait for the deployment to become healthy
+	Timeout time.Duration
+	// Interval is how long to wait between checks
+	Interval time.Duration
+	// Sample is the number of diego cells and routers whose certs are
+	// validated
+	Sample int
+	// CanaryURL is an app route that must respond with a 2xx status, it's
+	// not probed when empty
+	CanaryURL string
+}
+
+// HealthError is returned when a deployment didn't pass the health gate, it
+// wraps the last check that failed.
+type HealthError struct {
+	Deployment string
+	Err        error
+}
+
+func (e *HealthError) Error() string {
+	return fmt.Sprintf("%s didn't pass the health gate: %v", e.Deployment, e.Err)
+}
+
+// Unwrap returns the last check that failed
+func (e *HealthError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true for ErrUnhealthy
+func (e *HealthError) Is(target error) bool {
+	return target == ErrUnhealthy
+}
+
+// checkHealth waits until the deployment passes the health gate, the diego
+// cells must have the intermediate cert at certPath. A cert mismatch fails
+// immediately, the other checks are retried until the gate times out. Only
+// the validation results of the final check are kept in the report.
+func (r *CertRotator) checkHealth(ctx context.Context, phase string, m *manifest.Manifest, certPath string) error {
+	if r.health == nil {
+		return nil
+	}
+
+	started := time.Now()
+	deadline := started.Add(r.health.Timeout)
+	events.Infof(r.events, "Waiting up to %s for %s to pass the health gate", r.health.Timeout, m.DeploymentName)
+	for {
+		round := r.newRound([]manifest.Manifest{*m})
+		err := r.healthy(ctx, m, certPath)
+		if err != nil && !errors.Is(err, validate.CertMismatchError) && time.Now().Before(deadline) {
+			round.Discard()
+			events.Warnf(r.events, "%s isn't healthy yet, checking again in %s: %v", m.DeploymentName, r.health.Interval, err)
+			select {
+			case <-time.After(r.health.Interval):
+				continue
+			case <-ctx.Done():
+			case <-r.stop:
+			}
+			err = fmt.Errorf("%w while waiting for %s to become healthy", ErrInterrupted, m.DeploymentName)
+			r.events.Publish(events.Event{Type: events.HealthGate, Phase: phase, Deployment: m.DeploymentName}.Finished(started, err))
+			return err
+		}
+
+		round.Commit()
+		if err != nil {
+			err = &HealthError{Deployment: m.DeploymentName, Err: err}
+		}
+		r.events.Publish(events.Event{Type: events.HealthGate, Phase: phase, Deployment: m.DeploymentName}.Finished(started, err))
+		return err
+	}
+}
+
+// healthy checks every instance is running, the certs on a sample of the
+// diego cells and routers, that a sample of each job that must trust the root
+// trusts it, and the canary app route.
+func (r *CertRotator) healthy(ctx context.Context, m *manifest.Manifest, certPath string) error {
+	vms, err := r.bosh.GetDeploymentVMStates(ctx, m.DeploymentName)
+	if err != nil {
+		return err
+	}
+	var notRunning []string
+	for _, vm := range vms {
+		if vm.ProcessState != "running" {
+			notRunning = append(notRunning, fmt.Sprintf("%s is %s", vm.Name, vm.ProcessState))
+		}
+	}
+	if len(notRunning) > 0 {
+		return fmt.Errorf("instances aren't running: %s", strings.Join(notRunning, ", "))
+	}
+
+	if err = r.diegoValidator.ValidateCertsMatch(ctx, m, certPath, validate.SampleFilter(r.health.Sample)); err != nil {
+		return err
+	}
+	if err = r.routerValidator.ValidateCerts(ctx, m, validate.SampleFilter(r.health.Sample)); err != nil {
+		return err
+	}
+	if r.trustValidator != nil {
+		// the regen intermediate is deployed along with the regen root
+		rootPath := manifest.RootCertName
+		if certPath == m.IntermediateCertRegenPath() {
+			rootPath = r.regenRootPath()
+		}
+		sample := func() func(bosh.VM) bool { return validate.SampleFilter(r.health.Sample) }
+		if err = r.trustValidator.ValidateTrust(ctx, m, rootPath, sample); err != nil {
+			return err
+		}
+	}
+
+	if r.health.CanaryURL == "" {
+		return nil
+	}
+	return r.probeCanary(ctx)
+}
+
+// probeCanary requests the canary app route, any 2xx status is healthy
+func (r *CertRotator) probeCanary(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.health.CanaryURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("canary app %s is unreachable: %w", r.health.CanaryURL, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("canary app %s responded with %d", r.health.CanaryURL, resp.StatusCode)
+	}
+	return nil
+}
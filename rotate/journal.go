@@ -0,0 +1,270 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package rotate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The rotation phases in the order they're executed.
+const (
+	PhaseBosh    = "bosh"
+	PhaseCredhub = "credhub"
+	PhaseApply   = "apply"
+	PhaseCleanup = "cleanup"
+)
+
+var phases = []string{PhaseBosh, PhaseCredhub, PhaseApply, PhaseCleanup}
+
+// The status of a journal entry
+const (
+	StatusStarted   = "started"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+)
+
+const journalFileName = "journal.json"
+
+// ErrJournalMismatch is returned when resuming a rotation and the journal
+// doesn't agree with the current state of the foundation.
+var ErrJournalMismatch = errors.New("rotation journal doesn't match the foundation")
+
+// JournalEntry is a single step recorded in the journal. Entries without a
+// deployment apply to the phase as a whole.
+type JournalEntry struct {
+	Phase      string    `json:"phase"`
+	Deployment string    `json:"deployment,omitempty"`
+	Status     string    `json:"status"`
+	Time       time.Time `json:"time"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Journal durably records the progress of a rotation so an interrupted
+// rotation can be resumed from the phase and deployment that failed.
+type Journal struct {
+	path string
+	mu   sync.Mutex
+
+	Deployments []string `json:"deployments"`
+	// IntermediateOnly is whether the rotation keeps the existing root
+	IntermediateOnly bool           `json:"intermediate_only,omitempty"`
+	Entries          []JournalEntry `json:"entries"`
+}
+
+// OpenJournal opens the rotation journal in the specified workspace
+// directory, creating the directory if it doesn't exist. An existing journal
+// is loaded so it can be resumed.
+func OpenJournal(workspace string) (*Journal, error) {
+	if err := os.MkdirAll(workspace, 0700); err != nil {
+		return nil, fmt.Errorf("could not create workspace %s: %w", workspace, err)
+	}
+
+	j := &Journal{
+		path: filepath.Join(workspace, journalFileName),
+	}
+
+	b, err := ioutil.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read rotation journal %s: %w", j.path, err)
+	}
+
+	if err := json.Unmarshal(b, j); err != nil {
+		return nil, fmt.Errorf("could not parse rotation journal %s: %w", j.path, err)
+	}
+	return j, nil
+}
+
+// newMemoryJournal creates a journal that is never written to disk
+func newMemoryJournal() *Journal {
+	return &Journal{}
+}
+
+// Path returns the location of the journal on disk
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Begin discards any previous progress and records the deployments that
+// are part of a new rotation, and whether it only rotates the intermediates.
+func (j *Journal) Begin(deployments []string, intermediateOnly bool) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.Deployments = append([]string(nil), deployments...)
+	j.IntermediateOnly = intermediateOnly
+	j.Entries = nil
+	return j.save()
+}
+
+// Start records that a step has started
+func (j *Journal) Start(phase, deployment string) error {
+	return j.record(phase, deployment, StatusStarted, nil)
+}
+
+// Complete records that a step completed successfully
+func (j *Journal) Complete(phase, deployment string) error {
+	return j.record(phase, deployment, StatusCompleted, nil)
+}
+
+// Fail records that a step failed with the specified error
+func (j *Journal) Fail(phase, deployment string, err error) error {
+	return j.record(phase, deployment, StatusFailed, err)
+}
+
+// Skip records that a phase was intentionally skipped by the operator
+func (j *Journal) Skip(phase string) error {
+	return j.record(phase, "", StatusSkipped, nil)
+}
+
+// Done returns true if the most recent entry for the step is completed or
+// the step was skipped.
+func (j *Journal) Done(phase, deployment string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.done(phase, deployment)
+}
+
+// Started returns true if there is any recorded progress
+func (j *Journal) Started() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.Entries) > 0
+}
+
+// Finished returns true if every phase of the rotation has completed
+func (j *Journal) Finished() bool {
+	return j.ResumePhase() == ""
+}
+
+// ResumePhase returns the first phase that hasn't completed, or an empty
+// string if the whole rotation completed.
+func (j *Journal) ResumePhase() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, p := range phases {
+		if !j.done(p, "") {
+			return p
+		}
+	}
+	return ""
+}
+
+// LastFailure returns the most recent failed entry, if any
+func (j *Journal) LastFailure() (JournalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		if j.Entries[i].Status == StatusFailed {
+			return j.Entries[i], true
+		}
+	}
+	return JournalEntry{}, false
+}
+
+// matchesDeployments returns true if the journal was recorded against the
+// same set of deployments.
+func (j *Journal) matchesDeployments(deployments []string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.Deployments) != len(deployments) {
+		return false
+	}
+	a := append([]string(nil), j.Deployments...)
+	b := append([]string(nil), deployments...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (j *Journal) record(phase, deployment, status string, err error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e := JournalEntry{
+		Phase:      phase,
+		Deployment: deployment,
+		Status:     status,
+		Time:       time.Now().UTC(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	j.Entries = append(j.Entries, e)
+	return j.save()
+}
+
+// done returns true if the step completed or was skipped, callers must hold
+// the lock.
+func (j *Journal) done(phase, deployment string) bool {
+	s := j.status(phase, deployment)
+	return s == StatusCompleted || s == StatusSkipped
+}
+
+// status returns the status of the most recent entry for the step, callers
+// must hold the lock.
+func (j *Journal) status(phase, deployment string) string {
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		e := j.Entries[i]
+		if e.Phase == phase && e.Deployment == deployment {
+			return e.Status
+		}
+	}
+	return ""
+}
+
+// save atomically replaces the journal on disk, callers must hold the lock.
+func (j *Journal) save() error {
+	if j.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not serialize rotation journal: %w", err)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(j.path), journalFileName+".*")
+	if err != nil {
+		return fmt.Errorf("could not write rotation journal: %w", err)
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("could not write rotation journal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("could not sync rotation journal: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("could not write rotation journal: %w", err)
+	}
+	if err := os.Rename(f.Name(), j.path); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("could not replace rotation journal %s: %w", j.path, err)
+	}
+	return nil
+}
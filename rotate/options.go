@@ -0,0 +1,129 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package rotate
+
+import (
+	"io"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/validate"
+)
+
+// Option configures optional CertRotator behavior
+type Option func(r *CertRotator)
+
+// WithJournal records the rotation progress to the specified journal so it
+// can be resumed. Without a journal, progress is only tracked in memory.
+func WithJournal(j *Journal) Option {
+	return func(r *CertRotator) {
+		r.journal = j
+	}
+}
+
+// WithSnapshot saves the original manifests and certificates to the
+// specified snapshot before rotating so the rotation can be rolled back.
+func WithSnapshot(s *Snapshot) Option {
+	return func(r *CertRotator) {
+		r.snapshot = s
+	}
+}
+
+// WithBackup writes an encrypted backup of the credhub certificates to the
+// specified directory before they're overwritten.
+func WithBackup(dir, passphrase string) Option {
+	return func(r *CertRotator) {
+		r.backup = &backupConfig{
+			dir:        dir,
+			passphrase: passphrase,
+		}
+	}
+}
+
+// WithSelector only deploys the regen certs to the selected deployments. The
+// root and intermediates are swapped for every deployment once they've all
+// been deployed with the regen certs.
+func WithSelector(s manifest.Selector) Option {
+	return func(r *CertRotator) {
+		r.selector = s
+	}
+}
+
+// WithMaxDeploys sets how many deployments are deployed via BOSH at once
+// after CF, the default is one at a time.
+func WithMaxDeploys(n int) Option {
+	return func(r *CertRotator) {
+		if n > 0 {
+			r.maxDeploys = n
+		}
+	}
+}
+
+// WithEvents publishes the rotation progress to the specified sink instead
+// of the standard logger.
+func WithEvents(s events.Sink) Option {
+	return func(r *CertRotator) {
+		r.events = s
+	}
+}
+
+// WithOutput writes the output of the operations the rotation runs, like the
+// apply changes logs, to w instead of stdout.
+func WithOutput(w io.Writer) Option {
+	return func(r *CertRotator) {
+		r.output = w
+	}
+}
+
+// WithStop stops the rotation at the next safe point once stop is closed.
+// Deploys and apply changes in progress finish, but no new step starts and
+// ErrInterrupted is returned.
+func WithStop(stop <-chan struct{}) Option {
+	return func(r *CertRotator) {
+		r.stop = stop
+	}
+}
+
+// WithIntermediateOnly rotates only the intermediates, the new
+// intermediates are signed by the existing root so the jobs that trust the
+// root aren't changed. Every phase acts on just the selected deployments.
+func WithIntermediateOnly() Option {
+	return func(r *CertRotator) {
+		r.intermediateOnly = true
+	}
+}
+
+// WithTrustValidator checks that every job that must trust the root trusts
+// the expected root after each phase, and in the health gate.
+func WithTrustValidator(v TrustValidator) Option {
+	return func(r *CertRotator) {
+		r.trustValidator = v
+	}
+}
+
+// WithValidationReport decides the outcome of the validations after each
+// phase from the report, which must be shared with the validators. Every
+// validation runs, and the rotation fails if any instance's certs don't
+// match.
+func WithValidationReport(report *validate.Report) Option {
+	return func(r *CertRotator) {
+		r.report = report
+	}
+}
+
+// WithHealthGate waits for each deployment to pass the health gate after
+// it's deployed or its changes are applied. The rotation stops before the
+// next deployment if it doesn't pass before the timeout.
+func WithHealthGate(g HealthGate) Option {
+	return func(r *CertRotator) {
+		if g.Sample < 1 {
+			g.Sample = 1
+		}
+		if g.Interval <= 0 {
+			g.Interval = 30 * time.Second
+		}
+		r.health = &g
+	}
+}
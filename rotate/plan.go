@@ -0,0 +1,362 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package rotate
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+)
+
+const planFileName = "plan.json"
+
+// ErrPlanDrift is returned when the foundation no longer matches a
+// previously reviewed plan.
+var ErrPlanDrift = errors.New("the foundation has drifted from the plan")
+
+// PlannedDeployment is the change that will be made to a single deployment
+type PlannedDeployment struct {
+	Name             string   `json:"name"`
+	ManifestChecksum string   `json:"manifest_sha256"`
+	DeployFlags      []string `json:"deploy_flags,omitempty"`
+	Diff             string   `json:"-"`
+}
+
+// CredhubOperation is a single credhub operation the rotation will perform
+type CredhubOperation struct {
+	Operation string `json:"operation"`
+	Name      string `json:"name"`
+	Source    string `json:"source,omitempty"`
+}
+
+// Plan describes every change a rotation would make to the foundation
+type Plan struct {
+	Created time.Time `json:"created"`
+	// IntermediateOnly is whether the rotation keeps the existing root
+	IntermediateOnly  bool                `json:"intermediate_only,omitempty"`
+	Deployments       []PlannedDeployment `json:"deployments"`
+	CredhubOperations []CredhubOperation  `json:"credhub_operations"`
+	ApplyChanges      []string            `json:"apply_changes"`
+	// CredhubChecksums are the SHA-256 checksums of the certificates the
+	// rotation will overwrite
+	CredhubChecksums map[string]string `json:"credhub_sha256"`
+}
+
+// Plan determines every change the rotation would make without deploying or
+// writing anything.
+func (r *CertRotator) Plan(ctx context.Context) (*Plan, error) {
+	manifests, err := r.getDiegoCellManifestsSorted(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the selected intermediates are rotated when the root is kept
+	if r.intermediateOnly {
+		manifests = r.selected(manifests)
+	}
+
+	p := &Plan{
+		Created:          time.Now().UTC(),
+		IntermediateOnly: r.intermediateOnly,
+		CredhubChecksums: map[string]string{},
+	}
+
+	if !r.intermediateOnly {
+		if err = r.planCredhubChecksum(ctx, p, manifest.RootCertName); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, m := range r.selected(manifests) {
+		events.Infof(r.events, "Planning changes to %s", m.DeploymentName)
+
+		var diff string
+		if r.intermediateOnly {
+			diff, err = m.UpdateIntermediateOnlyDiff()
+		} else {
+			diff, err = m.UpdateDiff()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not plan %s manifest changes: %w", m.DeploymentName, err)
+		}
+		checksum, err := m.Checksum()
+		if err != nil {
+			return nil, err
+		}
+		p.Deployments = append(p.Deployments, PlannedDeployment{
+			Name:             m.DeploymentName,
+			ManifestChecksum: checksum,
+			DeployFlags:      deployFlags(&m),
+			Diff:             diff,
+		})
+	}
+
+	// credhub and apply changes include every deployment unless the root is
+	// kept
+	for _, m := range manifests {
+		if err = r.planCredhubChecksum(ctx, p, m.IntermediateCertPath()); err != nil {
+			return nil, err
+		}
+		p.ApplyChanges = append(p.ApplyChanges, m.OpsManProductName())
+	}
+
+	if !r.intermediateOnly {
+		p.CredhubOperations = append(p.CredhubOperations,
+			CredhubOperation{Operation: "get", Name: manifest.RootCertRegenName})
+	}
+	for _, m := range manifests {
+		p.CredhubOperations = append(p.CredhubOperations,
+			CredhubOperation{Operation: "get", Name: m.IntermediateCertRegenPath()})
+	}
+	if !r.intermediateOnly {
+		p.CredhubOperations = append(p.CredhubOperations,
+			CredhubOperation{Operation: "import", Name: manifest.RootCertName, Source: manifest.RootCertRegenName})
+	}
+	for _, m := range manifests {
+		p.CredhubOperations = append(p.CredhubOperations,
+			CredhubOperation{Operation: "import", Name: m.IntermediateCertPath(), Source: m.IntermediateCertRegenPath()})
+	}
+	for _, m := range manifests {
+		p.CredhubOperations = append(p.CredhubOperations,
+			CredhubOperation{Operation: "delete", Name: m.IntermediateCertRegenPath()})
+	}
+	if !r.intermediateOnly {
+		p.CredhubOperations = append(p.CredhubOperations,
+			CredhubOperation{Operation: "delete", Name: manifest.RootCertRegenName})
+	}
+
+	return p, nil
+}
+
+// CheckPlan returns ErrPlanDrift if the foundation has changed since the plan
+// was created. A plan describes a rotation from the beginning, so it can't
+// be checked for a rotation starting at a later phase.
+func (r *CertRotator) CheckPlan(ctx context.Context, reviewed *Plan, startStage string) error {
+	if isPhase(startStage) && startStage != PhaseBosh {
+		return fmt.Errorf("the plan describes a rotation from the %s phase, it can't be used to start at the %s phase",
+			PhaseBosh, startStage)
+	}
+
+	events.Infof(r.events, "Checking the foundation against the reviewed plan")
+
+	current, err := r.Plan(ctx)
+	if err != nil {
+		return err
+	}
+
+	var drift []string
+	if current.IntermediateOnly != reviewed.IntermediateOnly {
+		drift = append(drift, fmt.Sprintf("planned %s, but running %s",
+			planMode(reviewed.IntermediateOnly), planMode(current.IntermediateOnly)))
+	}
+	if len(current.Deployments) != len(reviewed.Deployments) {
+		drift = append(drift, fmt.Sprintf("planned %d deployments, but found %d",
+			len(reviewed.Deployments), len(current.Deployments)))
+	}
+	for i, d := range reviewed.Deployments {
+		if i >= len(current.Deployments) {
+			break
+		}
+		c := current.Deployments[i]
+		switch {
+		case c.Name != d.Name:
+			drift = append(drift, fmt.Sprintf("planned to deploy %s, but found %s", d.Name, c.Name))
+		case c.ManifestChecksum != d.ManifestChecksum:
+			drift = append(drift, fmt.Sprintf("%s manifest changed", d.Name))
+		case strings.Join(c.DeployFlags, " ") != strings.Join(d.DeployFlags, " "):
+			drift = append(drift, fmt.Sprintf("%s deploy flags changed", d.Name))
+		}
+	}
+	for name, checksum := range reviewed.CredhubChecksums {
+		c, ok := current.CredhubChecksums[name]
+		switch {
+		case !ok:
+			drift = append(drift, fmt.Sprintf("%s is no longer rotated", name))
+		case c != checksum:
+			drift = append(drift, fmt.Sprintf("%s changed in credhub", name))
+		}
+	}
+	for name := range current.CredhubChecksums {
+		if _, ok := reviewed.CredhubChecksums[name]; !ok {
+			drift = append(drift, fmt.Sprintf("%s wasn't planned to be rotated", name))
+		}
+	}
+
+	if len(drift) > 0 {
+		return fmt.Errorf("%w: %s", ErrPlanDrift, strings.Join(drift, ", "))
+	}
+	return nil
+}
+
+// planMode describes whether a plan keeps the existing root
+func planMode(intermediateOnly bool) string {
+	if intermediateOnly {
+		return "an intermediate only rotation"
+	}
+	return "a full rotation"
+}
+
+func (r *CertRotator) planCredhubChecksum(ctx context.Context, p *Plan, certPath string) error {
+	cert, err := r.getCertificate(ctx, certPath)
+	if err != nil {
+		return err
+	}
+	p.CredhubChecksums[certPath] = fmt.Sprintf("%x", sha256.Sum256([]byte(cert.Value.Certificate)))
+	return nil
+}
+
+// Render writes a human readable description of the plan
+func (p *Plan) Render(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("BOSH deployments (in order):\n")
+	for _, d := range p.Deployments {
+		fmt.Fprintf(&b, "  bosh -d %s deploy %s\n", d.Name, strings.Join(d.DeployFlags, " "))
+	}
+
+	b.WriteString("\nCredhub operations:\n")
+	for _, op := range p.CredhubOperations {
+		if op.Source != "" {
+			fmt.Fprintf(&b, "  %s %s (from %s)\n", op.Operation, op.Name, op.Source)
+		} else {
+			fmt.Fprintf(&b, "  %s %s\n", op.Operation, op.Name)
+		}
+	}
+
+	b.WriteString("\nOperations Manager apply changes (in order):\n")
+	for _, product := range p.ApplyChanges {
+		fmt.Fprintf(&b, "  %s\n", product)
+	}
+
+	b.WriteString("\nManifest changes:\n")
+	for _, d := range p.Deployments {
+		b.WriteString("\n")
+		b.WriteString(d.Diff)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Save writes the plan to a gzipped tar archive containing the plan, its
+// rendered description and each deployment's manifest diff.
+func (p *Plan) Save(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not create plan archive %s: %w", path, err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	planJSON, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	var rendered strings.Builder
+	if err = p.Render(&rendered); err != nil {
+		return err
+	}
+
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{planFileName, planJSON},
+		{"plan.txt", []byte(rendered.String())},
+	}
+	for _, d := range p.Deployments {
+		files = append(files, struct {
+			name    string
+			content []byte
+		}{"manifests/" + d.Name + ".diff", []byte(d.Diff)})
+	}
+
+	for _, file := range files {
+		hdr := &tar.Header{
+			Name:    file.name,
+			Mode:    0600,
+			Size:    int64(len(file.content)),
+			ModTime: p.Created,
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("could not write plan archive %s: %w", path, err)
+		}
+		if _, err = tw.Write(file.content); err != nil {
+			return fmt.Errorf("could not write plan archive %s: %w", path, err)
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return fmt.Errorf("could not write plan archive %s: %w", path, err)
+	}
+	if err = gz.Close(); err != nil {
+		return fmt.Errorf("could not write plan archive %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// LoadPlan reads a plan previously saved with Save
+func LoadPlan(path string) (*Plan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open plan archive %s: %w", path, err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not read plan archive %s: %w", path, err)
+	}
+
+	var p *Plan
+	diffs := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read plan archive %s: %w", path, err)
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("could not read plan archive %s: %w", path, err)
+		}
+
+		switch {
+		case hdr.Name == planFileName:
+			p = &Plan{}
+			if err = json.Unmarshal(content, p); err != nil {
+				return nil, fmt.Errorf("could not parse %s in plan archive %s: %w", planFileName, path, err)
+			}
+		case strings.HasPrefix(hdr.Name, "manifests/"):
+			name := strings.TrimSuffix(strings.TrimPrefix(hdr.Name, "manifests/"), ".diff")
+			diffs[name] = string(content)
+		}
+	}
+
+	if p == nil {
+		return nil, fmt.Errorf("plan archive %s is missing %s", path, planFileName)
+	}
+	for i := range p.Deployments {
+		p.Deployments[i].Diff = diffs[p.Deployments[i].Name]
+	}
+	return p, nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package rotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+)
+
+// takeSnapshot saves the deployed manifests and copies the original root and
+// intermediate CAs within credhub so the rotation can be rolled back, the
+// root is only copied when it's rotated. It must
+// run before anything is deployed or overwritten, rerunning an unfinished
+// rotation keeps what was saved when it started.
+func (r *CertRotator) takeSnapshot(ctx context.Context, manifests []manifest.Manifest) error {
+	events.Infof(r.events, "Saving the original manifests and certificates to %s", r.snapshot.Dir())
+
+	for _, m := range manifests {
+		content, err := r.bosh.GetDeploymentManifest(ctx, m.DeploymentName)
+		if err != nil {
+			return fmt.Errorf("could not snapshot %s manifest: %w", m.DeploymentName, err)
+		}
+
+		// keep the original manifest of a deployment from an earlier stage
+		if manifest.ReferencesRegenCerts(content) {
+			if r.snapshot.HasDeployment(m.DeploymentName) {
+				continue
+			}
+			events.Warnf(r.events, "%s is already deployed with regen certs, rollback will redeploy it as is", m.DeploymentName)
+		}
+
+		if err = r.snapshot.saveManifest(m.DeploymentName, content); err != nil {
+			return err
+		}
+	}
+
+	var originals []credhub.Certificate
+	if !r.intermediateOnly {
+		root, err := r.originalToCopy(ctx, manifest.RootCertName, manifest.RootCertOriginalName, manifest.RootCertRegenName)
+		if err != nil {
+			return err
+		}
+		if root != nil {
+			originals = append(originals, *root)
+		}
+	}
+
+	for _, m := range manifests {
+		intermediate, err := r.originalToCopy(ctx, m.IntermediateCertPath(), m.IntermediateCertOriginalPath(), m.IntermediateCertRegenPath())
+		if err != nil {
+			return err
+		}
+		if intermediate != nil {
+			originals = append(originals, *intermediate)
+		}
+	}
+
+	if len(originals) > 0 {
+		// copying the originals is the first write to credhub
+		if err := r.backupCredhub(ctx, manifests); err != nil {
+			return err
+		}
+		if err := r.importCertificates(ctx, originals); err != nil {
+			return fmt.Errorf("could not save original certificates in credhub: %w", err)
+		}
+	}
+
+	return r.snapshot.commit(deploymentNames(manifests), r.intermediateOnly)
+}
+
+// originalToCopy returns the cert at path renamed to originalPath so it can be
+// copied for rollback. Once the regen cert exists the cert at path may have
+// been swapped for it already, so the copy taken when the unfinished rotation
+// started is kept and nil is returned. It's an error if the cert was swapped
+// without a copy of the original.
+func (r *CertRotator) originalToCopy(ctx context.Context, path, originalPath, regenPath string) (*credhub.Certificate, error) {
+	regen, err := r.getCertificate(ctx, regenPath)
+	if errors.Is(err, credhub.ErrCredentialNotFound) {
+		regen = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if regen != nil {
+		_, err = r.getCertificate(ctx, originalPath)
+		if err == nil {
+			events.Infof(r.events, "Keeping %s saved when the unfinished rotation started", originalPath)
+			return nil, nil
+		}
+		if !errors.Is(err, credhub.ErrCredentialNotFound) {
+			return nil, err
+		}
+	}
+
+	cert, err := r.getCertificate(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if regen != nil && cert.Value.Certificate == regen.Value.Certificate {
+		return nil, fmt.Errorf("%s was already swapped for %s, there's no original to save", path, regenPath)
+	}
+	cert.Name = originalPath
+	return cert, nil
+}
+
+// Rollback restores the certificates saved before the rotation started by
+// rotating back to them. The originals are copied over the regen certs and
+// deployed via BOSH, CF first, so every job trusts both roots while the diego
+// cells switch back to the original intermediates. If the rotation swapped
+// credhub, the originals are swapped back. Applying changes through
+// Operations Manager then removes the rotated root, and the regen certs are
+// deleted. Running it again after it stopped continues the rollback.
+func (r *CertRotator) Rollback(ctx context.Context) error {
+	if r.snapshot == nil || !r.snapshot.Exists() {
+		return errors.New("no snapshot to roll back to, a snapshot is only taken when a rotation starts at the bosh phase")
+	}
+
+	if err := r.checkPendingChanges(ctx); err != nil {
+		return err
+	}
+
+	manifests, err := r.getDiegoCellManifestsSorted(ctx)
+	if err != nil {
+		return err
+	}
+	if manifests, err = r.snapshotted(manifests); err != nil {
+		return err
+	}
+	r.intermediateOnly = r.snapshot.IntermediateOnly
+
+	// everything is read before credhub is changed, so a snapshot missing
+	// any original fails without changing anything
+	originals, err := r.getOriginals(ctx, manifests)
+	if err != nil {
+		return err
+	}
+	swapped, err := r.credhubSwapped(ctx, manifests, originals)
+	if err != nil {
+		return err
+	}
+	if !swapped && !r.intermediateOnly {
+		// without a rotated root in credhub or deployed, only the
+		// intermediates need to be restored
+		_, err = r.getCertificate(ctx, manifest.RootCertRegenName)
+		if errors.Is(err, credhub.ErrCredentialNotFound) {
+			r.intermediateOnly = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// the rolled back rotation can't be resumed, the rollback's own progress
+	// is only tracked in memory
+	if err = r.journal.Begin(nil, false); err != nil {
+		return err
+	}
+	r.journal = newMemoryJournal()
+	if err = r.journal.Begin(deploymentNames(manifests), r.intermediateOnly); err != nil {
+		return err
+	}
+	if !swapped {
+		events.Infof(r.events, "Credhub still holds the original certificates, skipping the %s phase", PhaseCredhub)
+		if err = r.journal.Skip(PhaseCredhub); err != nil {
+			return err
+		}
+	}
+
+	if err = r.stageOriginals(ctx, manifests, originals, swapped); err != nil {
+		return err
+	}
+	return r.rotate(ctx, manifests, PhaseBosh)
+}
+
+// snapshotted returns the manifests of the deployments in the snapshot. A
+// rotation of the root changed every deployment, so it's an error if the
+// snapshot doesn't include all of them. It's also an error if a deployment
+// in the snapshot no longer exists.
+func (r *CertRotator) snapshotted(manifests []manifest.Manifest) ([]manifest.Manifest, error) {
+	var found []manifest.Manifest
+	for _, m := range manifests {
+		if r.snapshot.HasDeployment(m.DeploymentName) {
+			found = append(found, m)
+			continue
+		}
+		if !r.snapshot.IntermediateOnly {
+			return nil, fmt.Errorf("the snapshot in %s doesn't include %s", r.snapshot.Dir(), m.DeploymentName)
+		}
+	}
+	if len(found) != len(r.snapshot.Deployments) {
+		return nil, fmt.Errorf("the snapshot in %s includes %v, but found %v",
+			r.snapshot.Dir(), r.snapshot.Deployments, deploymentNames(found))
+	}
+	return found, nil
+}
+
+// originalPaths returns the credhub paths of the certs the rotation replaced
+// and of the originals the snapshot copied them to
+func (r *CertRotator) originalPaths(manifests []manifest.Manifest) (paths []string, originalPaths []string) {
+	if !r.intermediateOnly {
+		paths = append(paths, manifest.RootCertName)
+		originalPaths = append(originalPaths, manifest.RootCertOriginalName)
+	}
+	for _, m := range manifests {
+		paths = append(paths, m.IntermediateCertPath())
+		originalPaths = append(originalPaths, m.IntermediateCertOriginalPath())
+	}
+	return paths, originalPaths
+}
+
+// getOriginals gets the originals copied by the snapshot, keyed by their
+// path
+func (r *CertRotator) getOriginals(ctx context.Context, manifests []manifest.Manifest) (map[string]*credhub.Certificate, error) {
+	_, originalPaths := r.originalPaths(manifests)
+	originals := map[string]*credhub.Certificate{}
+	for _, p := range originalPaths {
+		cert, err := r.getCertificate(ctx, p)
+		if err != nil {
+			return nil, fmt.Errorf("could not get %s saved by the snapshot: %w", p, err)
+		}
+		originals[p] = cert
+	}
+	return originals, nil
+}
+
+// credhubSwapped returns true if credhub no longer holds the originals at
+// the paths the rotation replaced
+func (r *CertRotator) credhubSwapped(ctx context.Context, manifests []manifest.Manifest, originals map[string]*credhub.Certificate) (bool, error) {
+	paths, originalPaths := r.originalPaths(manifests)
+	for i, p := range paths {
+		cert, err := r.getCertificate(ctx, p)
+		if err != nil {
+			return false, err
+		}
+		if cert.Value.Certificate != originals[originalPaths[i]].Value.Certificate {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// stageOriginals copies the originals over the regen certs so the rotation
+// phases deploy them. The root is only copied once credhub was swapped,
+// before that the regen root is the rotated root and must stay trusted while
+// the diego cells still use the rotated intermediates.
+func (r *CertRotator) stageOriginals(ctx context.Context, manifests []manifest.Manifest, originals map[string]*credhub.Certificate, swapped bool) error {
+	events.Infof(r.events, "Staging the original identity certs in Credhub")
+
+	var certsToImport []credhub.Certificate
+	if swapped && !r.intermediateOnly {
+		root := *originals[manifest.RootCertOriginalName]
+		root.Name = manifest.RootCertRegenName
+		certsToImport = append(certsToImport, root)
+	}
+	for _, m := range manifests {
+		intermediate := *originals[m.IntermediateCertOriginalPath()]
+		intermediate.Name = m.IntermediateCertRegenPath()
+		certsToImport = append(certsToImport, intermediate)
+	}
+
+	if err := r.backupCredhub(ctx, manifests); err != nil {
+		return err
+	}
+	if err := r.interrupted(ctx); err != nil {
+		return err
+	}
+	if err := r.importCertificates(ctx, certsToImport); err != nil {
+		return fmt.Errorf("could not stage the original certificates in credhub: %w", err)
+	}
+	return nil
+}
@@ -4,18 +4,35 @@
 package rotate
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"net/http"
 	"os"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/validate"
 )
 
+// ErrRotationStaged is returned when the selected deployments were deployed
+// with the regen certs, but other deployments must be deployed before the
+// rotation can continue.
+var ErrRotationStaged = errors.New("rotation staged")
+
+// ErrInterrupted is returned when the rotation stopped at a safe point
+// because it was asked to stop, or because the context of the operations in
+// progress was done.
+var ErrInterrupted = errors.New("interrupted")
+
 // CertRotator rotates diego instance identity and associated root CA certs
 type CertRotator struct {
 	om              OpsManager
@@ -24,6 +41,20 @@ type CertRotator struct {
 	manifestLoader  ManifestLoader
 	diegoValidator  DiegoValidator
 	routerValidator RouterValidator
+	trustValidator  TrustValidator
+	report          *validate.Report
+	journal         *Journal
+	snapshot        *Snapshot
+	backup          *backupConfig
+	selector        manifest.Selector
+	maxDeploys      int
+	events          events.Sink
+	output          io.Writer
+	stop            <-chan struct{}
+	health          *HealthGate
+	httpClient      *http.Client
+	// intermediateOnly keeps the existing root
+	intermediateOnly bool
 }
 
 // NewCertRotator creates a new CertRotator instance
@@ -33,15 +64,25 @@ func NewCertRotator(
 	credhub CredhubRunner,
 	manifestLoader ManifestLoader,
 	diegoValidator DiegoValidator,
-	routerValidator RouterValidator) *CertRotator {
-	return &CertRotator{
+	routerValidator RouterValidator,
+	opts ...Option) *CertRotator {
+	r := &CertRotator{
 		om:              om,
 		credhub:         credhub,
 		bosh:            bosh,
 		manifestLoader:  manifestLoader,
 		diegoValidator:  diegoValidator,
 		routerValidator: routerValidator,
+		journal:         newMemoryJournal(),
+		maxDeploys:      1,
+		events:          events.NewLogSink(),
+		output:          os.Stdout,
+		httpClient:      &http.Client{Timeout: 10 * time.Second},
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 // RotateCerts rotates all the instance identity certs for all deployements
@@ -50,58 +91,306 @@ func NewCertRotator(
 // This is a 2 phase deployment process. The first bosh deploys are done
 // directly via BOSH to add a new root and intermediate CAs. The final deploy
 // is via Operations Manager which removes the temporary regen certs.
-func (r *CertRotator) RotateCerts(startStage string) error {
-	if err := r.checkPendingChanges(); err != nil {
+//
+// Any progress previously recorded in the journal is discarded, use Resume
+// to continue an interrupted rotation.
+func (r *CertRotator) RotateCerts(ctx context.Context, startStage string) error {
+	if err := r.checkPendingChanges(ctx); err != nil {
 		return err
 	}
 
-	manifests, err := r.getDiegoCellManifestsSorted()
+	manifests, err := r.getDiegoCellManifestsSorted(ctx)
 	if err != nil {
 		return err
 	}
+	if err = r.checkSelection(manifests); err != nil {
+		return err
+	}
+	// the intermediates are independent of each other when the root is
+	// kept, so only the selected deployments are rotated
+	if r.intermediateOnly {
+		manifests = r.selected(manifests)
+	}
 
-	switch startStage {
-	default:
-		log.Printf("[WARNING]: unknown start phase %s, starting at beginning", startStage)
-		fallthrough
-	case "bosh": // start by generating new manfiests and bosh deploying
-		if err = r.addRegenCertsToBoshDeployments(manifests); err != nil {
+	// a staged rotation is started once for each group of deployments
+	if r.journal.Started() && !r.journal.Finished() && (!r.selector.Partial() || r.intermediateOnly) {
+		events.Warnf(r.events, "discarding the unfinished rotation recorded in %s, use --resume to continue it instead", r.journal.Path())
+	}
+	if err = r.journal.Begin(deploymentNames(manifests), r.intermediateOnly); err != nil {
+		return err
+	}
+
+	if !isPhase(startStage) {
+		events.Warnf(r.events, "unknown start phase %s, starting at beginning", startStage)
+		startStage = PhaseBosh
+	}
+
+	// record the phases the operator chose to skip so a resume won't run them
+	for _, p := range phases {
+		if p == startStage {
+			break
+		}
+		if err = r.journal.Skip(p); err != nil {
 			return err
 		}
-		fallthrough
-	case "credhub": // start with the credhub overwrite and apply changes
-		if err = r.rotateCertsInCredhub(manifests); err != nil {
+	}
+
+	// only a rotation from the beginning sees the original certs
+	if r.snapshot != nil && startStage == PhaseBosh {
+		if err = r.takeSnapshot(ctx, manifests); err != nil {
+			return fmt.Errorf("could not take a snapshot for rollback: %w", err)
+		}
+	}
+
+	return r.rotate(ctx, manifests, startStage)
+}
+
+// Resume continues the rotation recorded in the journal from the phase and
+// deployment that didn't complete. It refuses to run if the journal doesn't
+// agree with the current state of the foundation.
+func (r *CertRotator) Resume(ctx context.Context) error {
+	if !r.journal.Started() {
+		return fmt.Errorf("no rotation to resume, the journal %s is empty", r.journal.Path())
+	}
+
+	startStage := r.journal.ResumePhase()
+	if startStage == "" {
+		return fmt.Errorf("the rotation recorded in %s already finished", r.journal.Path())
+	}
+
+	// the rotation continues in the mode it started in
+	if r.intermediateOnly && !r.journal.IntermediateOnly {
+		return fmt.Errorf("%w: the rotation recorded in %s also rotates the root, it can't be resumed as intermediate only",
+			ErrJournalMismatch, r.journal.Path())
+	}
+	r.intermediateOnly = r.journal.IntermediateOnly
+
+	if err := r.checkPendingChanges(ctx); err != nil {
+		return err
+	}
+
+	manifests, err := r.getDiegoCellManifestsSorted(ctx)
+	if err != nil {
+		return err
+	}
+	if r.intermediateOnly {
+		manifests = r.journaled(manifests)
+	}
+
+	if err = r.checkSelection(manifests); err != nil {
+		return err
+	}
+	if err = r.checkJournal(ctx, manifests); err != nil {
+		return err
+	}
+
+	if e, ok := r.journal.LastFailure(); ok {
+		events.Infof(r.events, "Previous rotation failed during the %s phase %s: %s", e.Phase, e.Deployment, e.Error)
+	}
+	events.Infof(r.events, "Resuming rotation at the %s phase", startStage)
+	return r.rotate(ctx, manifests, startStage)
+}
+
+// rotate runs every phase starting at the specified phase, recording each
+// in the journal.
+func (r *CertRotator) rotate(ctx context.Context, manifests []manifest.Manifest, startStage string) error {
+	started := false
+	for _, p := range phases {
+		if p == startStage {
+			started = true
+		}
+		if !started || r.journal.Done(p, "") {
+			continue
+		}
+
+		// the shared root can only be swapped once every deployment trusts
+		// the regen root
+		if p == PhaseCredhub && r.selector.Partial() && !r.intermediateOnly {
+			if err := r.checkStaged(ctx, manifests); err != nil {
+				return err
+			}
+		}
+
+		err := r.step(ctx, p, "", func() error {
+			return r.runPhase(ctx, p, manifests)
+		})
+		if err != nil {
 			return err
 		}
-		err = r.validateCertsWereRotated(manifests)
-		if errors.Is(err, validate.CertMismatchError) {
+	}
+	return nil
+}
+
+func (r *CertRotator) runPhase(ctx context.Context, phase string, manifests []manifest.Manifest) error {
+	switch phase {
+	case PhaseBosh: // start by generating new manfiests and bosh deploying
+		selected := r.selected(manifests)
+		if err := r.addRegenCertsToBoshDeployments(ctx, selected); err != nil {
 			return err
 		}
-		if err != nil {
-			log.Println("[WARNING]: could not validate certs:", err)
+		round := r.newRound(selected)
+		return r.checkValidation(round, r.validateRootTrusted(ctx, selected, r.regenRootPath()))
+	case PhaseCredhub: // start with the credhub overwrite and apply changes
+		if err := r.rotateCertsInCredhub(ctx, manifests); err != nil {
+			return err
 		}
-		fallthrough
-	case "apply": // start with the apply changes
-		if err = r.applyChanges(manifests); err != nil {
+		return r.validateRotation(ctx, manifests)
+	case PhaseApply: // start with the apply changes
+		if err := r.applyChanges(ctx, manifests); err != nil {
 			return err
 		}
+		return r.validateRotation(ctx, manifests)
+	case PhaseCleanup:
+		return r.cleanupRegenCerts(ctx, manifests)
+	}
+	return fmt.Errorf("unknown rotation phase %s", phase)
+}
+
+// step runs fn and records its start and outcome in the journal, steps for
+// a whole phase are also published.
+func (r *CertRotator) step(ctx context.Context, phase, deployment string, fn func() error) error {
+	if err := r.interrupted(ctx); err != nil {
+		return err
+	}
+	if err := r.journal.Start(phase, deployment); err != nil {
+		return err
+	}
+
+	started := time.Now()
+	if deployment == "" {
+		r.events.Publish(events.Event{Type: events.PhaseStarted, Phase: phase})
+	}
+	err := fn()
+	if deployment == "" {
+		r.events.Publish(events.Event{Type: events.PhaseFinished, Phase: phase}.Finished(started, err))
+	}
+
+	// a step cut short by an interrupt isn't a failure, it's redone on resume
+	if err != nil && !errors.Is(err, ErrInterrupted) && r.interrupted(ctx) != nil {
+		err = fmt.Errorf("%w: %v", ErrInterrupted, err)
+	}
+	if errors.Is(err, ErrInterrupted) {
+		return err
+	}
+	if err != nil {
+		if jerr := r.journal.Fail(phase, deployment, err); jerr != nil {
+			events.Warnf(r.events, "could not record failure in the rotation journal: %v", jerr)
+		}
+		return err
+	}
+	return r.journal.Complete(phase, deployment)
+}
+
+// interrupted returns ErrInterrupted if the rotation should stop, either
+// it was asked to stop or ctx is done
+func (r *CertRotator) interrupted(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	select {
+	case <-r.stop:
+		return ErrInterrupted
+	default:
+		return nil
+	}
+}
 
-		err = r.validateCertsWereRotated(manifests)
-		if errors.Is(err, validate.CertMismatchError) {
+// validateRotation spot checks the deployed certs and that the rotated root
+// is trusted, only a cert mismatch fails the rotation.
+func (r *CertRotator) validateRotation(ctx context.Context, manifests []manifest.Manifest) error {
+	round := r.newRound(manifests)
+	err := r.validateCertsWereRotated(ctx, manifests)
+	if err == nil {
+		err = r.validateRootTrusted(ctx, manifests, manifest.RootCertName)
+	}
+	return r.checkValidation(round, err)
+}
+
+// newRound stages the validation results of the deployments in a new round
+// of the validation report, it's nil without a report
+func (r *CertRotator) newRound(manifests []manifest.Manifest) *validate.Round {
+	if r.report == nil {
+		return nil
+	}
+	return r.report.NewRound(deploymentNames(manifests)...)
+}
+
+// checkValidation returns the validation error if it's a cert mismatch,
+// other errors are only warned about. With a validation report the
+// validations that ran are judged by their round, which is then committed to
+// the report.
+func (r *CertRotator) checkValidation(round *validate.Round, err error) error {
+	defer round.Commit()
+	if err == nil {
+		err = round.Err()
+	}
+	if errors.Is(err, validate.CertMismatchError) {
+		return err
+	}
+	if err != nil {
+		events.Warnf(r.events, "could not validate certs: %v", err)
+	}
+	return nil
+}
+
+// checkJournal ensures the journal and the foundation agree before resuming
+func (r *CertRotator) checkJournal(ctx context.Context, manifests []manifest.Manifest) error {
+	if !r.journal.matchesDeployments(deploymentNames(manifests)) {
+		return fmt.Errorf("%w: the journal recorded deployments %v but found %v",
+			ErrJournalMismatch, r.journal.Deployments, deploymentNames(manifests))
+	}
+
+	credhubDone := r.journal.Done(PhaseCredhub, "")
+	anyDeployed := false
+	for _, m := range manifests {
+		if !r.journal.Done(PhaseBosh, m.DeploymentName) || r.journal.Done(PhaseCleanup, m.DeploymentName) {
+			continue
+		}
+		anyDeployed = true
+
+		regen, err := r.getCertificate(ctx, m.IntermediateCertRegenPath())
+		if err != nil {
+			return fmt.Errorf("%w: %s was deployed with regen certs, but they're not in credhub: %v",
+				ErrJournalMismatch, m.DeploymentName, err)
+		}
+		if !credhubDone {
+			continue
+		}
+		current, err := r.getCertificate(ctx, m.IntermediateCertPath())
+		if err != nil {
 			return err
 		}
+		if current.Value.Certificate != regen.Value.Certificate {
+			return fmt.Errorf("%w: the journal recorded the credhub phase as complete, but %s doesn't match %s",
+				ErrJournalMismatch, m.IntermediateCertPath(), m.IntermediateCertRegenPath())
+		}
+	}
+
+	if !anyDeployed || r.intermediateOnly {
+		return nil
+	}
+
+	regenRoot, err := r.getCertificate(ctx, manifest.RootCertRegenName)
+	if err != nil {
+		return fmt.Errorf("%w: deployments were deployed with regen certs, but %s is not in credhub: %v",
+			ErrJournalMismatch, manifest.RootCertRegenName, err)
+	}
+	if credhubDone {
+		root, err := r.getCertificate(ctx, manifest.RootCertName)
 		if err != nil {
-			log.Println("[WARNING]: could not validate certs", err)
+			return err
+		}
+		if root.Value.Certificate != regenRoot.Value.Certificate {
+			return fmt.Errorf("%w: the journal recorded the credhub phase as complete, but %s doesn't match %s",
+				ErrJournalMismatch, manifest.RootCertName, manifest.RootCertRegenName)
 		}
-		fallthrough
-	case "cleanup":
-		return r.cleanupRegenCerts(manifests)
 	}
+	return nil
 }
 
-func (r *CertRotator) checkPendingChanges() error {
-	log.Println("Checking for pending changes")
-	hasChanges, err := r.om.CheckPendingChanges()
+func (r *CertRotator) checkPendingChanges(ctx context.Context) error {
+	events.Infof(r.events, "Checking for pending changes")
+	hasChanges, err := r.om.CheckPendingChanges(ctx)
 	if err != nil {
 		return fmt.Errorf("cannot check for pending changes: %w", err)
 	}
@@ -116,10 +405,10 @@ func (r *CertRotator) checkPendingChanges() error {
 // getDiegoCellManifestsSorted returns all bosh manifests that have diego cells
 // sorted with CF first, then alphabetical. It's important to modify the CF
 // deployment before any optional isolation segments or windows segments.
-func (r *CertRotator) getDiegoCellManifestsSorted() ([]manifest.Manifest, error) {
-	log.Println("Retrieving BOSH manifests for all Diego deployments")
+func (r *CertRotator) getDiegoCellManifestsSorted(ctx context.Context) ([]manifest.Manifest, error) {
+	events.Infof(r.events, "Retrieving BOSH manifests for all Diego deployments")
 
-	manifests, err := r.manifestLoader.GetAllManifestsWithDiegoCells()
+	manifests, err := r.manifestLoader.GetAllManifestsWithDiegoCells(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -134,43 +423,81 @@ func (r *CertRotator) getDiegoCellManifestsSorted() ([]manifest.Manifest, error)
 	return manifests, nil
 }
 
-func (r *CertRotator) addRegenCertsToBoshDeployments(manifests []manifest.Manifest) error {
+// addRegenCertsToBoshDeployments deploys CF with the regen certs, then every
+// other deployment concurrently.
+func (r *CertRotator) addRegenCertsToBoshDeployments(ctx context.Context, manifests []manifest.Manifest) error {
+	var pending []manifest.Manifest
 	for _, m := range manifests {
-		err := r.rotateManifestCerts(&m)
-		if err != nil {
+		if r.journal.Done(PhaseBosh, m.DeploymentName) {
+			events.Infof(r.events, "Skipping %s, it was already deployed with regen certs", m.DeploymentName)
+			continue
+		}
+		pending = append(pending, m)
+	}
+
+	cf, others := splitCF(pending)
+	deploy := func(m *manifest.Manifest) error {
+		return r.step(ctx, PhaseBosh, m.DeploymentName, func() error {
+			return r.rotateManifestCerts(ctx, m)
+		})
+	}
+
+	for _, m := range cf {
+		m := m
+		if err := deploy(&m); err != nil {
 			return err
 		}
 	}
-	return nil
+
+	// the regen root is generated by the CF deployment, every other
+	// deployment only references it
+	if len(cf) == 0 && len(others) > 0 && !r.intermediateOnly {
+		if err := r.checkRootRegenExists(ctx); err != nil {
+			return err
+		}
+	}
+
+	return r.deployConcurrently(ctx, others, deploy)
 }
 
-func (r *CertRotator) rotateCertsInCredhub(manifests []manifest.Manifest) error {
-	log.Println("Rotating identity certs in Credhub")
+func (r *CertRotator) rotateCertsInCredhub(ctx context.Context, manifests []manifest.Manifest) error {
+	events.Infof(r.events, "Rotating identity certs in Credhub")
 
-	rootRegenCert, err := r.credhub.GetCertificate(manifest.RootCertRegenName)
-	if err != nil {
+	if err := r.backupCredhub(ctx, manifests); err != nil {
 		return err
 	}
 
-	// update the name to point at the original cert location so it's overwritten
-	rootRegenCert.Name = manifest.RootCertName
+	var certsToImport []credhub.Certificate
+	if !r.intermediateOnly {
+		rootRegenCert, err := r.getCertificate(ctx, manifest.RootCertRegenName)
+		if err != nil {
+			return err
+		}
 
-	certsToImport := []credhub.Certificate{*rootRegenCert}
+		// update the name to point at the original cert location so it's overwritten
+		rootRegenCert.Name = manifest.RootCertName
+		certsToImport = append(certsToImport, *rootRegenCert)
+	}
 
-	// add all the intermediate identity certs to credhub
+	// add all the intermediate identity certs to credhub, the import is a
+	// single operation so every deployment is always included and the phase
+	// is only journaled once the import succeeds
 	for _, m := range manifests {
-		log.Printf("Updating %s Credhub references to overwrite old certificates", m.DeploymentName)
+		events.Infof(r.events, "Updating %s Credhub references to overwrite old certificates", m.DeploymentName)
 
-		intermediateRegenCert, err := r.credhub.GetCertificate(m.IntermediateCertRegenPath())
+		intermediateRegenCert, err := r.getCertificate(ctx, m.IntermediateCertRegenPath())
 		if err != nil {
-			return err
+			return fmt.Errorf("could not get the %s regen intermediate: %w", m.DeploymentName, err)
 		}
 
 		intermediateRegenCert.Name = m.IntermediateCertPath()
 		certsToImport = append(certsToImport, *intermediateRegenCert)
 	}
 
-	err = r.credhub.ImportCertificates(certsToImport)
+	if err := r.interrupted(ctx); err != nil {
+		return err
+	}
+	err := r.importCertificates(ctx, certsToImport)
 	if err != nil {
 		return fmt.Errorf("could not overwrite values in credhub: %w", err)
 	}
@@ -180,12 +507,23 @@ func (r *CertRotator) rotateCertsInCredhub(manifests []manifest.Manifest) error
 
 const ignoreWarnings = true
 
-func (r *CertRotator) applyChanges(manifests []manifest.Manifest) (err error) {
-	log.Println("Removing temporary regen certificate enties from BOSH deployments")
+func (r *CertRotator) applyChanges(ctx context.Context, manifests []manifest.Manifest) (err error) {
+	events.Infof(r.events, "Removing temporary regen certificate enties from BOSH deployments")
 
 	for _, m := range manifests {
-		log.Printf("Applying changes to %s", m.OpsManProductName())
-		if err = r.om.ApplyChanges(os.Stdout, ignoreWarnings, m.OpsManProductName()); err != nil {
+		if r.journal.Done(PhaseApply, m.DeploymentName) {
+			events.Infof(r.events, "Skipping %s, changes were already applied", m.OpsManProductName())
+			continue
+		}
+		m := m
+		err = r.step(ctx, PhaseApply, m.DeploymentName, func() error {
+			events.Infof(r.events, "Applying changes to %s", m.OpsManProductName())
+			if err := r.om.ApplyChanges(ctx, r.output, ignoreWarnings, m.OpsManProductName()); err != nil {
+				return err
+			}
+			return r.checkHealth(ctx, PhaseApply, &m, m.IntermediateCertPath())
+		})
+		if err != nil {
 			return err
 		}
 	}
@@ -193,16 +531,16 @@ func (r *CertRotator) applyChanges(manifests []manifest.Manifest) (err error) {
 	return nil
 }
 
-func (r *CertRotator) validateCertsWereRotated(manifests []manifest.Manifest) error {
+func (r *CertRotator) validateCertsWereRotated(ctx context.Context, manifests []manifest.Manifest) error {
 	for _, m := range manifests {
 		// check the cert only on the first diego cell as a sanity check
-		err := r.diegoValidator.ValidateCerts(&m, validate.FirstInstanceFilter())
+		err := r.collect(r.diegoValidator.ValidateCerts(ctx, &m, validate.FirstInstanceFilter()))
 		if err != nil {
 			return err
 		}
 
 		// check the first gorouter
-		err = r.routerValidator.ValidateCerts(&m, validate.FirstInstanceFilter())
+		err = r.collect(r.routerValidator.ValidateCerts(ctx, &m, validate.FirstInstanceFilter()))
 		if err != nil {
 			return err
 		}
@@ -210,43 +548,323 @@ func (r *CertRotator) validateCertsWereRotated(manifests []manifest.Manifest) er
 	return nil
 }
 
-func (r *CertRotator) cleanupRegenCerts(manifests []manifest.Manifest) error {
-	log.Println("Removing duplicate regen certificates from credhub")
+// validateRootTrusted checks the first instance of each job that must trust
+// the root trusts the root at rootPath
+func (r *CertRotator) validateRootTrusted(ctx context.Context, manifests []manifest.Manifest, rootPath string) error {
+	if r.trustValidator == nil {
+		return nil
+	}
 	for _, m := range manifests {
-		err := r.credhub.Delete(m.IntermediateCertRegenPath())
+		m := m
+		if err := r.collect(r.trustValidator.ValidateTrust(ctx, &m, rootPath, validate.FirstInstanceFilter)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collect ignores a failed instance when it's recorded in the validation
+// report, so the remaining validations still run
+func (r *CertRotator) collect(err error) error {
+	var instanceErr *validate.InstanceError
+	if r.report != nil && errors.As(err, &instanceErr) {
+		return nil
+	}
+	return err
+}
+
+// regenRootPath is the root the deployments trust once they're deployed with
+// the regen certs, the existing root when only the intermediates are rotated
+func (r *CertRotator) regenRootPath() string {
+	if r.intermediateOnly {
+		return manifest.RootCertName
+	}
+	return manifest.RootCertRegenName
+}
+
+func (r *CertRotator) cleanupRegenCerts(ctx context.Context, manifests []manifest.Manifest) error {
+	events.Infof(r.events, "Removing duplicate regen certificates from credhub")
+	for _, m := range manifests {
+		if r.journal.Done(PhaseCleanup, m.DeploymentName) {
+			continue
+		}
+		m := m
+		err := r.step(ctx, PhaseCleanup, m.DeploymentName, func() error {
+			return r.deleteCredential(ctx, m.IntermediateCertRegenPath())
+		})
 		if err != nil {
 			return err
 		}
 	}
-	return r.credhub.Delete(manifest.RootCertRegenName)
+	if r.intermediateOnly {
+		return nil
+	}
+	return r.deleteCredential(ctx, manifest.RootCertRegenName)
+}
+
+// DeployErrors is returned when more than one concurrent deploy failed
+type DeployErrors []error
+
+func (e DeployErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d deploys failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// deployConcurrently runs deploy for each manifest, running at most
+// maxDeploys at once. Every deploy runs even if another fails, the failures
+// are returned together. Once a deployment fails the health gate no more
+// deploys are started.
+func (r *CertRotator) deployConcurrently(ctx context.Context, manifests []manifest.Manifest, deploy func(m *manifest.Manifest) error) error {
+	var (
+		wg          sync.WaitGroup
+		mu          sync.Mutex
+		errs        DeployErrors
+		unhealthy   bool
+		interrupted error
+	)
+
+	limit := make(chan struct{}, r.maxDeploys)
+	for _, m := range manifests {
+		m := m
+		limit <- struct{}{}
+		// don't start any more deploys, but let those in progress finish
+		if interrupted = r.interrupted(ctx); interrupted != nil {
+			<-limit
+			break
+		}
+		mu.Lock()
+		halt := unhealthy
+		mu.Unlock()
+		if halt {
+			<-limit
+			events.Warnf(r.events, "Not deploying %s or the remaining deployments until the unhealthy deployment is fixed", m.DeploymentName)
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-limit }()
+
+			if err := deploy(&m); err != nil {
+				events.Warnf(r.events, "%s deploy failed, waiting for the other deploys to finish: %v", m.DeploymentName, err)
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", m.DeploymentName, err))
+				unhealthy = unhealthy || errors.Is(err, ErrUnhealthy)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	switch len(errs) {
+	case 0:
+		return interrupted
+	case 1:
+		return errs[0]
+	}
+	return errs
+}
+
+// splitCF separates the CF deployment from the other deployments, CF must
+// always be deployed before the others.
+func splitCF(manifests []manifest.Manifest) (cf []manifest.Manifest, others []manifest.Manifest) {
+	for _, m := range manifests {
+		if m.OpsManProductName() == "cf" {
+			cf = append(cf, m)
+		} else {
+			others = append(others, m)
+		}
+	}
+	return cf, others
+}
+
+// selected returns the manifests chosen by the selector, in order
+func (r *CertRotator) selected(manifests []manifest.Manifest) []manifest.Manifest {
+	var selected []manifest.Manifest
+	for _, m := range manifests {
+		if r.selector.Matches(m.DeploymentName) {
+			selected = append(selected, m)
+		}
+	}
+	return selected
+}
+
+// journaled returns the manifests of the deployments recorded in the
+// journal, in order
+func (r *CertRotator) journaled(manifests []manifest.Manifest) []manifest.Manifest {
+	var journaled []manifest.Manifest
+	for _, m := range manifests {
+		for _, d := range r.journal.Deployments {
+			if m.DeploymentName == d {
+				journaled = append(journaled, m)
+				break
+			}
+		}
+	}
+	return journaled
+}
+
+// checkSelection ensures the selector chooses at least one deployment
+func (r *CertRotator) checkSelection(manifests []manifest.Manifest) error {
+	if len(r.selected(manifests)) == 0 {
+		return fmt.Errorf("no diego deployments match %s, found %v", r.selector, deploymentNames(manifests))
+	}
+	if r.selector.Partial() {
+		events.Infof(r.events, "Deploying regen certs to %v", deploymentNames(r.selected(manifests)))
+	}
+	return nil
+}
+
+// checkStaged returns ErrRotationStaged unless every deployment has been
+// deployed with the regen certs, including those that aren't selected.
+func (r *CertRotator) checkStaged(ctx context.Context, manifests []manifest.Manifest) error {
+	var remaining []string
+	for _, m := range manifests {
+		content, err := r.bosh.GetDeploymentManifest(ctx, m.DeploymentName)
+		if err != nil {
+			return fmt.Errorf("could not inspect %s manifest: %w", m.DeploymentName, err)
+		}
+		if !bytes.Contains(content, []byte(manifest.RootCertRegenName)) {
+			remaining = append(remaining, m.DeploymentName)
+		}
+	}
+
+	if len(remaining) > 0 {
+		return fmt.Errorf("%w: %v must be deployed with the regen certs before the root can be swapped",
+			ErrRotationStaged, remaining)
+	}
+
+	events.Infof(r.events, "Every deployment trusts the regen root, continuing the rotation for all deployments")
+	return nil
+}
+
+// checkRootRegenExists ensures the regen root was generated by the CF
+// deployment before deploying a deployment that references it
+func (r *CertRotator) checkRootRegenExists(ctx context.Context) error {
+	_, err := r.getCertificate(ctx, manifest.RootCertRegenName)
+	if errors.Is(err, credhub.ErrCredentialNotFound) {
+		return fmt.Errorf("%s doesn't exist, the cf deployment must be deployed with the regen certs first", manifest.RootCertRegenName)
+	}
+	return err
+}
+
+func deploymentNames(manifests []manifest.Manifest) []string {
+	names := make([]string, 0, len(manifests))
+	for _, m := range manifests {
+		names = append(names, m.DeploymentName)
+	}
+	return names
+}
+
+func isPhase(phase string) bool {
+	for _, p := range phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
 }
 
 // rotateManifestCerts performs the instance identity certificate rotation on
 // the specified deployment's manifest
-func (r *CertRotator) rotateManifestCerts(cfManifest *manifest.Manifest) error {
-	log.Printf("Creating BOSH manifest with regen certs for %s", cfManifest.DeploymentName)
+func (r *CertRotator) rotateManifestCerts(ctx context.Context, cfManifest *manifest.Manifest) error {
+	events.Infof(r.events, "Creating BOSH manifest with regen certs for %s", cfManifest.DeploymentName)
 	withIntermediate, err := ioutil.TempFile("", cfManifest.DeploymentName+"-intermediate-regen-*.yml")
 	if err != nil {
 		return err
 	}
 
-	if err := cfManifest.Update(withIntermediate); err != nil {
+	update := cfManifest.Update
+	if r.intermediateOnly {
+		update = cfManifest.UpdateIntermediateOnly
+	}
+	if err := update(withIntermediate); err != nil {
 		withIntermediate.Close()
 		os.RemoveAll(withIntermediate.Name())
 		return err
 	}
 	withIntermediate.Close()
 
-	// add --recreate for TASW, as the cert injector gets stuck otherwise
-	var flags []string
-	if cfManifest.OpsManProductName() == "pas-windows" {
-		flags = append(flags, "--recreate")
-	}
-	log.Printf("BOSH deploying %s with new identity certs", cfManifest.DeploymentName)
-	err = r.bosh.DeployWithFlags(cfManifest.DeploymentName, withIntermediate.Name(), flags...)
+	err = r.deploy(ctx, PhaseBosh, cfManifest, withIntermediate.Name(),
+		fmt.Sprintf("BOSH deploying %s with new identity certs", cfManifest.DeploymentName))
 	if err != nil {
 		return fmt.Errorf("bosh deploy with new identity certs failed: %w", err)
 	}
 
-	return nil
+	return r.checkHealth(ctx, PhaseBosh, cfManifest, cfManifest.IntermediateCertRegenPath())
+}
+
+// deploy runs a bosh deploy of the manifest file, publishing its start and
+// outcome
+func (r *CertRotator) deploy(ctx context.Context, phase string, m *manifest.Manifest, manifestFilename string, message string) error {
+	started := time.Now()
+	r.events.Publish(events.Event{
+		Type:       events.DeployStarted,
+		Phase:      phase,
+		Deployment: m.DeploymentName,
+		Message:    message,
+	})
+
+	err := r.bosh.DeployWithFlags(ctx, m.DeploymentName, manifestFilename, deployFlags(m)...)
+
+	r.events.Publish(events.Event{
+		Type:       events.DeployFinished,
+		Phase:      phase,
+		Deployment: m.DeploymentName,
+	}.Finished(started, err))
+	return err
+}
+
+// getCertificate gets the certificate from credhub, publishing the operation
+func (r *CertRotator) getCertificate(ctx context.Context, name string) (*credhub.Certificate, error) {
+	cert, err := r.credhub.GetCertificate(ctx, name)
+	r.publishCredhub("get", []string{name}, err)
+	return cert, err
+}
+
+// importCertificates imports the certificates into credhub, publishing the
+// operation
+func (r *CertRotator) importCertificates(ctx context.Context, certs []credhub.Certificate) error {
+	names := make([]string, 0, len(certs))
+	for _, c := range certs {
+		names = append(names, c.Name)
+	}
+	err := r.credhub.ImportCertificates(ctx, certs)
+	r.publishCredhub("import", names, err)
+	return err
+}
+
+// deleteCredential deletes the credential from credhub, publishing the
+// operation
+func (r *CertRotator) deleteCredential(ctx context.Context, name string) error {
+	err := r.credhub.Delete(ctx, name)
+	r.publishCredhub("delete", []string{name}, err)
+	return err
+}
+
+func (r *CertRotator) publishCredhub(operation string, names []string, err error) {
+	e := events.Event{
+		Type:      events.CredhubOperation,
+		Operation: operation,
+		Names:     names,
+		Status:    events.StatusSucceeded,
+	}
+	if err != nil {
+		e.Status = events.StatusFailed
+		e.Error = err.Error()
+	}
+	r.events.Publish(e)
+}
+
+// deployFlags returns the additional bosh deploy flags for the deployment
+func deployFlags(m *manifest.Manifest) []string {
+	// add --recreate for TASW, as the cert injector gets stuck otherwise
+	var flags []string
+	if m.OpsManProductName() == "pas-windows" {
+		flags = append(flags, "--recreate")
+	}
+	return flags
 }
@@ -4,13 +4,23 @@
 package rotate_test
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	boshpkg "github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/rotate"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/rotate/rotatefakes"
@@ -72,7 +82,7 @@ func TestRotate(t *testing.T) {
 	t.Run("refuses to rotate with pending changes", func(t *testing.T) {
 		setup()
 		om.CheckPendingChangesReturns(true, nil)
-		if err := r.RotateCerts("bosh"); err == nil {
+		if err := r.RotateCerts(context.Background(), "bosh"); err == nil {
 			t.Fatal("expected error due to pending changes, but rotation succeeded")
 		}
 		if count := ml.GetAllManifestsWithDiegoCellsCallCount(); count > 0 {
@@ -82,7 +92,7 @@ func TestRotate(t *testing.T) {
 
 	t.Run("full rotate", func(t *testing.T) {
 		setup()
-		if err := r.RotateCerts("bosh"); err != nil {
+		if err := r.RotateCerts(context.Background(), "bosh"); err != nil {
 			t.Fatal(err)
 		}
 
@@ -93,7 +103,7 @@ func TestRotate(t *testing.T) {
 			t.Errorf("expected 1 apply changes, but got %d", count)
 		}
 
-		_, _, products := om.ApplyChangesArgsForCall(0)
+		_, _, _, products := om.ApplyChangesArgsForCall(0)
 		if p := products[0]; p != "cf" {
 			t.Errorf("expected selective apply for cf, but apply was for product %v", p)
 		}
@@ -111,7 +121,7 @@ func TestRotate(t *testing.T) {
 
 	t.Run("invalid start phase starts at beginning", func(t *testing.T) {
 		setup()
-		if err := r.RotateCerts("NOT-A-VALID-START-PHASE"); err != nil {
+		if err := r.RotateCerts(context.Background(), "NOT-A-VALID-START-PHASE"); err != nil {
 			t.Fatal(err)
 		}
 		if count := bosh.DeployWithFlagsCallCount(); count != 1 {
@@ -121,7 +131,7 @@ func TestRotate(t *testing.T) {
 
 	t.Run("start phase cleanup", func(t *testing.T) {
 		setup()
-		if err := r.RotateCerts("cleanup"); err != nil {
+		if err := r.RotateCerts(context.Background(), "cleanup"); err != nil {
 			t.Fatal(err)
 		}
 		// expect we delete the duplicate copies of the root and the intermediate
@@ -134,8 +144,8 @@ func TestRotate(t *testing.T) {
 		}
 
 		// ensure we delete the correct certs
-		intermediate := ch.DeleteArgsForCall(0)
-		root := ch.DeleteArgsForCall(1)
+		_, intermediate := ch.DeleteArgsForCall(0)
+		_, root := ch.DeleteArgsForCall(1)
 
 		if intermediate != "/p-bosh-12345/cf-a7e7cd52009e7c121d7e/diego-instance-identity-intermediate-ca-2018-riic-regen" {
 			t.Errorf("deleted intermediate at %v from credhub, expected /p-bosh-12345/cf-a7e7cd52009e7c121d7e/diego-instance-identity-intermediate-ca-2018-riic-regen", intermediate)
@@ -148,7 +158,7 @@ func TestRotate(t *testing.T) {
 	t.Run("validate fails with cert mismatch", func(t *testing.T) {
 		setup()
 		dv.ValidateCertsReturns(validate.CertMismatchError)
-		if err := r.RotateCerts("bosh"); err == nil {
+		if err := r.RotateCerts(context.Background(), "bosh"); err == nil {
 			t.Fatal("expected error due to cert mismatch, but operation succeeded")
 		}
 	})
@@ -156,7 +166,7 @@ func TestRotate(t *testing.T) {
 	t.Run("validate fails with unknown error, rotation continues", func(t *testing.T) {
 		setup()
 		dv.ValidateCertsReturns(errors.New("unexpected error"))
-		if err := r.RotateCerts("bosh"); err != nil {
+		if err := r.RotateCerts(context.Background(), "bosh"); err != nil {
 			t.Fatal("expected rotation to complete despite validation failure, got error", err)
 		}
 		if count := om.ApplyChangesCallCount(); count != 1 {
@@ -167,6 +177,71 @@ func TestRotate(t *testing.T) {
 		}
 	})
 
+	t.Run("validates the root is trusted after each phase", func(t *testing.T) {
+		setup()
+		tv := &rotatefakes.FakeTrustValidator{}
+		r = rotate.NewCertRotator(om, bosh, ch, ml, dv, rv, rotate.WithTrustValidator(tv))
+		if err := r.RotateCerts(context.Background(), "bosh"); err != nil {
+			t.Fatal(err)
+		}
+
+		var roots []string
+		for i := 0; i < tv.ValidateTrustCallCount(); i++ {
+			_, _, rootPath, _ := tv.ValidateTrustArgsForCall(i)
+			roots = append(roots, rootPath)
+		}
+		expected := []string{manifest.RootCertRegenName, manifest.RootCertName, manifest.RootCertName}
+		if strings.Join(roots, ",") != strings.Join(expected, ",") {
+			t.Errorf("expected the bosh, credhub and apply phases to check %v is trusted, but got %v", expected, roots)
+		}
+	})
+
+	t.Run("untrusted root fails the rotation", func(t *testing.T) {
+		setup()
+		tv := &rotatefakes.FakeTrustValidator{}
+		tv.ValidateTrustReturns(validate.RootNotTrustedError)
+		r = rotate.NewCertRotator(om, bosh, ch, ml, dv, rv, rotate.WithTrustValidator(tv))
+		if err := r.RotateCerts(context.Background(), "bosh"); !errors.Is(err, validate.RootNotTrustedError) {
+			t.Fatal("expected the rotation to fail because the root isn't trusted, got", err)
+		}
+		if count := ch.ImportCertificatesCallCount(); count != 0 {
+			t.Errorf("expected the rotation to stop before credhub, but got %d imports", count)
+		}
+	})
+
+	t.Run("validations continue past failed instances in the validation report", func(t *testing.T) {
+		setup()
+		dv.ValidateCertsReturns(&validate.InstanceError{
+			VM:     boshpkg.VM{Name: "diego_cell/0"},
+			Result: validate.ResultUnreachable,
+			Err:    errors.New("unreachable"),
+		})
+		r = rotate.NewCertRotator(om, bosh, ch, ml, dv, rv, rotate.WithValidationReport(validate.NewReport()))
+		if err := r.RotateCerts(context.Background(), "bosh"); err != nil {
+			t.Fatal(err)
+		}
+		if count := rv.ValidateCertsCallCount(); count != dv.ValidateCertsCallCount() {
+			t.Errorf("expected the routers to be validated after every failed diego cell validation, but got %d of %d",
+				count, dv.ValidateCertsCallCount())
+		}
+	})
+
+	t.Run("apply changes uses the rotation's context and output", func(t *testing.T) {
+		setup()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var output bytes.Buffer
+		r = rotate.NewCertRotator(om, bosh, ch, ml, dv, rv, rotate.WithOutput(&output))
+		if err := r.RotateCerts(ctx, "apply"); err != nil {
+			t.Fatal(err)
+		}
+
+		applyCtx, w, _, _ := om.ApplyChangesArgsForCall(0)
+		if applyCtx != ctx || w != &output {
+			t.Errorf("expected apply changes to be passed the rotation's context and output")
+		}
+	})
+
 	t.Run("windows uses --recreate", func(t *testing.T) {
 		setup()
 
@@ -176,11 +251,11 @@ func TestRotate(t *testing.T) {
 		}
 		ml.GetAllManifestsWithDiegoCellsReturns([]manifest.Manifest{*win}, nil)
 
-		if err := r.RotateCerts("bosh"); err != nil {
+		if err := r.RotateCerts(context.Background(), "bosh"); err != nil {
 			t.Fatal("expected rotation to complete despite validation failure, got error", err)
 		}
 
-		_, _, args := bosh.DeployWithFlagsArgsForCall(0)
+		_, _, _, args := bosh.DeployWithFlagsArgsForCall(0)
 		foundRecreate := false
 		for _, arg := range args {
 			if arg == "--recreate" {
@@ -195,3 +270,1455 @@ func TestRotate(t *testing.T) {
 
 	})
 }
+
+func TestResume(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	var (
+		om   *rotatefakes.FakeOpsManager
+		bosh *rotatefakes.FakeBoshRunner
+		ch   *rotatefakes.FakeCredhubRunner
+		ml   *rotatefakes.FakeManifestLoader
+		dv   *rotatefakes.FakeDiegoValidator
+		rv   *rotatefakes.FakeRouterValidator
+
+		workspace string
+	)
+
+	setup := func() {
+		t.Helper()
+
+		om = &rotatefakes.FakeOpsManager{}
+		bosh = &rotatefakes.FakeBoshRunner{}
+		ch = &rotatefakes.FakeCredhubRunner{}
+		ml = &rotatefakes.FakeManifestLoader{}
+		dv = &rotatefakes.FakeDiegoValidator{}
+		rv = &rotatefakes.FakeRouterValidator{}
+
+		cf, err := manifest.NewManifest("p-bosh-12345", "testdata/cf-manifest.yml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		win, err := manifest.NewManifest("p-bosh-12345", "testdata/pas-windows-manifest.yml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ml.GetAllManifestsWithDiegoCellsReturns([]manifest.Manifest{*cf, *win}, nil)
+
+		cert := &credhub.Certificate{
+			Name: "/p-bosh-12345/some-cert",
+			Type: "certificate",
+		}
+		cert.Value.Certificate = "---SOME CERTIFICATE---"
+		ch.GetCertificateReturns(cert, nil)
+
+		workspace = t.TempDir()
+	}
+
+	newRotator := func() *rotate.CertRotator {
+		t.Helper()
+		j, err := rotate.OpenJournal(workspace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rotate.NewCertRotator(om, bosh, ch, ml, dv, rv, rotate.WithJournal(j))
+	}
+
+	t.Run("resumes at the failed deployment", func(t *testing.T) {
+		setup()
+		bosh.DeployWithFlagsReturnsOnCall(1, errors.New("deploy failed"))
+		if err := newRotator().RotateCerts(context.Background(), "bosh"); err == nil {
+			t.Fatal("expected the rotation to fail")
+		}
+
+		if err := newRotator().Resume(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		// cf deployed once, windows failed and was retried
+		if count := bosh.DeployWithFlagsCallCount(); count != 3 {
+			t.Errorf("expected 3 bosh deployments, but got %d", count)
+		}
+		if _, name, _, _ := bosh.DeployWithFlagsArgsForCall(2); name != "pas-windows-f9239c09b3772fdf6a12" {
+			t.Errorf("expected the resume to deploy pas-windows-f9239c09b3772fdf6a12, but deployed %s", name)
+		}
+		if count := om.ApplyChangesCallCount(); count != 2 {
+			t.Errorf("expected 2 apply changes, but got %d", count)
+		}
+	})
+
+	t.Run("resumes apply changes after the last applied product", func(t *testing.T) {
+		setup()
+		om.ApplyChangesReturnsOnCall(1, errors.New("apply failed"))
+		if err := newRotator().RotateCerts(context.Background(), "bosh"); err == nil {
+			t.Fatal("expected the rotation to fail")
+		}
+
+		if err := newRotator().Resume(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if count := bosh.DeployWithFlagsCallCount(); count != 2 {
+			t.Errorf("expected no additional bosh deployments, but got %d", count)
+		}
+		if count := om.ApplyChangesCallCount(); count != 3 {
+			t.Errorf("expected 3 apply changes, but got %d", count)
+		}
+		if _, _, _, products := om.ApplyChangesArgsForCall(2); products[0] != "pas-windows" {
+			t.Errorf("expected the resume to apply changes to pas-windows, but applied %v", products)
+		}
+	})
+
+	t.Run("resumes a failed credhub import", func(t *testing.T) {
+		setup()
+		ch.ImportCertificatesReturns(errors.New("import failed"))
+		if err := newRotator().RotateCerts(context.Background(), "credhub"); err == nil {
+			t.Fatal("expected the rotation to fail")
+		}
+
+		j, err := rotate.OpenJournal(workspace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range []string{"", "cf-a7e7cd52009e7c121d7e", "pas-windows-f9239c09b3772fdf6a12"} {
+			if j.Done(rotate.PhaseCredhub, d) {
+				t.Errorf("expected the credhub phase of %q to not be journaled before the import succeeds", d)
+			}
+		}
+
+		ch.ImportCertificatesReturns(nil)
+		if err := newRotator().Resume(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if count := ch.ImportCertificatesCallCount(); count != 2 {
+			t.Fatalf("expected the import to be retried, but got %d imports", count)
+		}
+		if _, certs := ch.ImportCertificatesArgsForCall(1); len(certs) != 3 {
+			t.Errorf("expected the root and both intermediates to be imported, but got %d certs", len(certs))
+		}
+	})
+
+	t.Run("resume respects skipped phases", func(t *testing.T) {
+		setup()
+		ch.DeleteReturnsOnCall(0, errors.New("delete failed"))
+		if err := newRotator().RotateCerts(context.Background(), "cleanup"); err == nil {
+			t.Fatal("expected the rotation to fail")
+		}
+
+		if err := newRotator().Resume(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if count := bosh.DeployWithFlagsCallCount(); count != 0 {
+			t.Errorf("expected no bosh deployments, but got %d", count)
+		}
+		if count := om.ApplyChangesCallCount(); count != 0 {
+			t.Errorf("expected no apply changes, but got %d", count)
+		}
+	})
+
+	t.Run("refuses to resume when deployments changed", func(t *testing.T) {
+		setup()
+		om.ApplyChangesReturns(errors.New("apply failed"))
+		if err := newRotator().RotateCerts(context.Background(), "bosh"); err == nil {
+			t.Fatal("expected the rotation to fail")
+		}
+
+		cf, err := manifest.NewManifest("p-bosh-12345", "testdata/cf-manifest.yml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ml.GetAllManifestsWithDiegoCellsReturns([]manifest.Manifest{*cf}, nil)
+
+		if err := newRotator().Resume(context.Background()); !errors.Is(err, rotate.ErrJournalMismatch) {
+			t.Fatalf("expected a journal mismatch error, but got %v", err)
+		}
+	})
+
+	t.Run("refuses to resume when regen certs are missing", func(t *testing.T) {
+		setup()
+		om.ApplyChangesReturns(errors.New("apply failed"))
+		if err := newRotator().RotateCerts(context.Background(), "bosh"); err == nil {
+			t.Fatal("expected the rotation to fail")
+		}
+
+		ch.GetCertificateReturns(nil, errors.New("credential does not exist"))
+		if err := newRotator().Resume(context.Background()); !errors.Is(err, rotate.ErrJournalMismatch) {
+			t.Fatalf("expected a journal mismatch error, but got %v", err)
+		}
+	})
+
+	t.Run("nothing to resume", func(t *testing.T) {
+		setup()
+		if err := newRotator().Resume(context.Background()); err == nil {
+			t.Fatal("expected an error resuming without a journal")
+		}
+
+		if err := newRotator().RotateCerts(context.Background(), "bosh"); err != nil {
+			t.Fatal(err)
+		}
+		if err := newRotator().Resume(context.Background()); err == nil {
+			t.Fatal("expected an error resuming a finished rotation")
+		}
+	})
+}
+
+func TestStatus(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	newCert := func(value string) *credhub.Certificate {
+		c := &credhub.Certificate{Type: "certificate"}
+		c.Value.Certificate = value
+		return c
+	}
+
+	tests := []struct {
+		name       string
+		certs      map[string]string
+		manifest   string
+		vmErr      error
+		startPhase string
+	}{
+		{
+			name: "no rotation",
+			certs: map[string]string{
+				manifest.RootCertName: "root",
+				"/p-bosh-12345/cf-a7e7cd52009e7c121d7e/" + manifest.IntermediateCertName: "intermediate",
+			},
+			manifest:   "name: cf",
+			startPhase: "",
+		},
+		{
+			name: "partially deployed",
+			certs: map[string]string{
+				manifest.RootCertName:      "root",
+				manifest.RootCertRegenName: "new-root",
+				"/p-bosh-12345/cf-a7e7cd52009e7c121d7e/" + manifest.IntermediateCertName: "intermediate",
+			},
+			manifest:   "name: cf",
+			startPhase: rotate.PhaseBosh,
+		},
+		{
+			name: "deployed with regen certs",
+			certs: map[string]string{
+				manifest.RootCertName:      "root",
+				manifest.RootCertRegenName: "new-root",
+				"/p-bosh-12345/cf-a7e7cd52009e7c121d7e/" + manifest.IntermediateCertName:      "intermediate",
+				"/p-bosh-12345/cf-a7e7cd52009e7c121d7e/" + manifest.IntermediateCertRegenName: "new-intermediate",
+			},
+			manifest:   "ca: " + manifest.RootCertRegenVariable,
+			startPhase: rotate.PhaseCredhub,
+		},
+		{
+			name: "credhub swapped",
+			certs: map[string]string{
+				manifest.RootCertName:      "new-root",
+				manifest.RootCertRegenName: "new-root",
+				"/p-bosh-12345/cf-a7e7cd52009e7c121d7e/" + manifest.IntermediateCertName:      "new-intermediate",
+				"/p-bosh-12345/cf-a7e7cd52009e7c121d7e/" + manifest.IntermediateCertRegenName: "new-intermediate",
+			},
+			manifest:   "ca: " + manifest.RootCertRegenVariable,
+			startPhase: rotate.PhaseApply,
+		},
+		{
+			name: "changes applied",
+			certs: map[string]string{
+				manifest.RootCertName:      "new-root",
+				manifest.RootCertRegenName: "new-root",
+				"/p-bosh-12345/cf-a7e7cd52009e7c121d7e/" + manifest.IntermediateCertName:      "new-intermediate",
+				"/p-bosh-12345/cf-a7e7cd52009e7c121d7e/" + manifest.IntermediateCertRegenName: "new-intermediate",
+			},
+			manifest:   "name: cf",
+			vmErr:      validate.CertMismatchError,
+			startPhase: rotate.PhaseCleanup,
+		},
+		{
+			name: "intermediate only deployed",
+			certs: map[string]string{
+				manifest.RootCertName: "root",
+				"/p-bosh-12345/cf-a7e7cd52009e7c121d7e/" + manifest.IntermediateCertName:      "intermediate",
+				"/p-bosh-12345/cf-a7e7cd52009e7c121d7e/" + manifest.IntermediateCertRegenName: "new-intermediate",
+			},
+			manifest:   "ca_cert: " + manifest.IntermediateCertRegenVariable,
+			startPhase: rotate.PhaseCredhub,
+		},
+		{
+			name: "intermediate only swapped",
+			certs: map[string]string{
+				manifest.RootCertName: "root",
+				"/p-bosh-12345/cf-a7e7cd52009e7c121d7e/" + manifest.IntermediateCertName:      "new-intermediate",
+				"/p-bosh-12345/cf-a7e7cd52009e7c121d7e/" + manifest.IntermediateCertRegenName: "new-intermediate",
+			},
+			manifest:   "ca_cert: " + manifest.IntermediateCertRegenVariable,
+			startPhase: rotate.PhaseApply,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ch := &rotatefakes.FakeCredhubRunner{}
+			ch.GetCertificateStub = func(_ context.Context, path string) (*credhub.Certificate, error) {
+				if v, ok := tc.certs[path]; ok {
+					return newCert(v), nil
+				}
+				return nil, credhub.ErrCredentialNotFound
+			}
+
+			bosh := &rotatefakes.FakeBoshRunner{}
+			bosh.GetDeploymentManifestReturns([]byte(tc.manifest), nil)
+
+			dv := &rotatefakes.FakeDiegoValidator{}
+			dv.ValidateCertsMatchReturns(tc.vmErr)
+
+			cf, err := manifest.NewManifest("p-bosh-12345", "testdata/cf-manifest.yml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ml := &rotatefakes.FakeManifestLoader{}
+			ml.GetAllManifestsWithDiegoCellsReturns([]manifest.Manifest{*cf}, nil)
+
+			r := rotate.NewCertRotator(&rotatefakes.FakeOpsManager{}, bosh, ch, ml, dv, &rotatefakes.FakeRouterValidator{})
+			status, err := r.Status(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.StartPhase != tc.startPhase {
+				t.Errorf("expected start phase %q, but got %q (%s)", tc.startPhase, status.StartPhase, status.Phase)
+			}
+			if tc.vmErr != nil && status.Deployments[0].VMsHaveRegenCert != rotate.No {
+				t.Errorf("expected the VMs to not have the regen cert, but got %s", status.Deployments[0].VMsHaveRegenCert)
+			}
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	om := &rotatefakes.FakeOpsManager{}
+	bosh := &rotatefakes.FakeBoshRunner{}
+	ch := &rotatefakes.FakeCredhubRunner{}
+	ml := &rotatefakes.FakeManifestLoader{}
+
+	cf, err := manifest.NewManifest("p-bosh-12345", "testdata/cf-manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	win, err := manifest.NewManifest("p-bosh-12345", "testdata/pas-windows-manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ml.GetAllManifestsWithDiegoCellsReturns([]manifest.Manifest{*win, *cf}, nil)
+
+	cert := &credhub.Certificate{Type: "certificate"}
+	cert.Value.Certificate = "---SOME CERTIFICATE---"
+	ch.GetCertificateReturns(cert, nil)
+
+	r := rotate.NewCertRotator(om, bosh, ch, ml, &rotatefakes.FakeDiegoValidator{}, &rotatefakes.FakeRouterValidator{})
+	plan, err := r.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := bosh.DeployWithFlagsCallCount() + ch.ImportCertificatesCallCount() + ch.DeleteCallCount() + om.ApplyChangesCallCount(); count != 0 {
+		t.Fatalf("expected planning to make no changes, but got %d calls", count)
+	}
+
+	if len(plan.Deployments) != 2 || plan.Deployments[0].Name != cf.DeploymentName {
+		t.Fatalf("expected cf to be planned first, but got %v", plan.Deployments)
+	}
+	if !strings.Contains(plan.Deployments[0].Diff, manifest.RootCertRegenVariable) {
+		t.Errorf("expected the cf diff to add the regen root, but got:\n%s", plan.Deployments[0].Diff)
+	}
+	if flags := plan.Deployments[1].DeployFlags; len(flags) != 1 || flags[0] != "--recreate" {
+		t.Errorf("expected windows to be deployed with --recreate, but got %v", flags)
+	}
+	if strings.Join(plan.ApplyChanges, ",") != "cf,pas-windows" {
+		t.Errorf("expected apply changes to cf then pas-windows, but got %v", plan.ApplyChanges)
+	}
+
+	var rendered bytes.Buffer
+	if err = plan.Render(&rendered); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rendered.String(), "delete "+manifest.RootCertRegenName) {
+		t.Errorf("expected the plan to delete the regen root, but got:\n%s", rendered.String())
+	}
+
+	archive := filepath.Join(t.TempDir(), "plan.tgz")
+	if err = plan.Save(archive); err != nil {
+		t.Fatal(err)
+	}
+	reviewed, err := rotate.LoadPlan(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reviewed.Deployments[0].Diff != plan.Deployments[0].Diff {
+		t.Errorf("expected the saved diff to be loaded from the archive")
+	}
+
+	t.Run("no drift", func(t *testing.T) {
+		if err := r.CheckPlan(context.Background(), reviewed, rotate.PhaseBosh); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("credhub drift", func(t *testing.T) {
+		changed := &credhub.Certificate{Type: "certificate"}
+		changed.Value.Certificate = "---SOME OTHER CERTIFICATE---"
+		ch.GetCertificateReturns(changed, nil)
+		defer ch.GetCertificateReturns(cert, nil)
+
+		if err := r.CheckPlan(context.Background(), reviewed, rotate.PhaseBosh); !errors.Is(err, rotate.ErrPlanDrift) {
+			t.Fatalf("expected a plan drift error, but got %v", err)
+		}
+	})
+
+	t.Run("later start phase", func(t *testing.T) {
+		err := r.CheckPlan(context.Background(), reviewed, rotate.PhaseCredhub)
+		if err == nil || !strings.Contains(err.Error(), rotate.PhaseCredhub) {
+			t.Fatalf("expected the plan to be refused for a rotation starting at the credhub phase, but got %v", err)
+		}
+	})
+
+	t.Run("mode drift", func(t *testing.T) {
+		intermediateOnly := rotate.NewCertRotator(om, bosh, ch, ml, &rotatefakes.FakeDiegoValidator{},
+			&rotatefakes.FakeRouterValidator{}, rotate.WithIntermediateOnly())
+		err := intermediateOnly.CheckPlan(context.Background(), reviewed, rotate.PhaseBosh)
+		if !errors.Is(err, rotate.ErrPlanDrift) || !strings.Contains(err.Error(), "intermediate only") {
+			t.Fatalf("expected a plan drift error, but got %v", err)
+		}
+	})
+
+	t.Run("credhub certs drift", func(t *testing.T) {
+		partial := *reviewed
+		partial.CredhubChecksums = map[string]string{}
+		for name, checksum := range reviewed.CredhubChecksums {
+			if name != manifest.RootCertName {
+				partial.CredhubChecksums[name] = checksum
+			}
+		}
+		if err := r.CheckPlan(context.Background(), &partial, rotate.PhaseBosh); !errors.Is(err, rotate.ErrPlanDrift) {
+			t.Fatalf("expected a plan drift error, but got %v", err)
+		}
+	})
+
+	t.Run("deployment drift", func(t *testing.T) {
+		ml.GetAllManifestsWithDiegoCellsReturns([]manifest.Manifest{*cf}, nil)
+		if err := r.CheckPlan(context.Background(), reviewed, rotate.PhaseBosh); !errors.Is(err, rotate.ErrPlanDrift) {
+			t.Fatalf("expected a plan drift error, but got %v", err)
+		}
+	})
+}
+
+func TestRollback(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	om := &rotatefakes.FakeOpsManager{}
+	bosh := &rotatefakes.FakeBoshRunner{}
+	ch := &rotatefakes.FakeCredhubRunner{}
+	ml := &rotatefakes.FakeManifestLoader{}
+	dv := &rotatefakes.FakeDiegoValidator{}
+	rv := &rotatefakes.FakeRouterValidator{}
+
+	cf, err := manifest.NewManifest("p-bosh-12345", "testdata/cf-manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	win, err := manifest.NewManifest("p-bosh-12345", "testdata/pas-windows-manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ml.GetAllManifestsWithDiegoCellsReturns([]manifest.Manifest{*win, *cf}, nil)
+	bosh.GetDeploymentManifestStub = func(_ context.Context, name string) ([]byte, error) {
+		return []byte("name: " + name + "\n"), nil
+	}
+	store := newCredhubStore(ch, manifest.RootCertName, cf.IntermediateCertPath(), win.IntermediateCertPath())
+
+	workspace := t.TempDir()
+	newRotator := func() *rotate.CertRotator {
+		t.Helper()
+		j, err := rotate.OpenJournal(workspace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := rotate.OpenSnapshot(workspace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rotate.NewCertRotator(om, bosh, ch, ml, dv, rv, rotate.WithJournal(j), rotate.WithSnapshot(s))
+	}
+
+	t.Run("nothing to roll back", func(t *testing.T) {
+		if err := newRotator().Rollback(context.Background()); err == nil {
+			t.Fatal("expected an error without a snapshot")
+		}
+	})
+
+	t.Run("rotation takes a snapshot before deploying", func(t *testing.T) {
+		bosh.DeployWithFlagsReturnsOnCall(0, errors.New("deploy failed"))
+		if err := newRotator().RotateCerts(context.Background(), "bosh"); err == nil {
+			t.Fatal("expected the rotation to fail")
+		}
+
+		if count := ch.ImportCertificatesCallCount(); count != 1 {
+			t.Fatalf("expected the originals to be copied in credhub, but got %d imports", count)
+		}
+		_, originals := ch.ImportCertificatesArgsForCall(0)
+		if len(originals) != 3 || originals[0].Name != manifest.RootCertOriginalName ||
+			originals[0].Value.Certificate != "---"+manifest.RootCertName+"---" {
+			t.Fatalf("expected the original root to be copied first, but got %v", originals)
+		}
+		if originals[1].Name != cf.IntermediateCertOriginalPath() {
+			t.Errorf("expected %s, but got %s", cf.IntermediateCertOriginalPath(), originals[1].Name)
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(workspace, "snapshot", "manifests", win.DeploymentName+".yml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "name: "+win.DeploymentName+"\n" {
+			t.Errorf("unexpected snapshot manifest %q", content)
+		}
+	})
+
+	t.Run("a rerun after the credhub swap keeps the originals", func(t *testing.T) {
+		for _, name := range []string{manifest.RootCertName, cf.IntermediateCertPath(), win.IntermediateCertPath()} {
+			store.set(name+manifest.RegenSuffix, "---new "+name+"---")
+			store.set(name, "---new "+name+"---")
+		}
+
+		bosh.DeployWithFlagsReturnsOnCall(bosh.DeployWithFlagsCallCount(), errors.New("deploy failed"))
+		imports := ch.ImportCertificatesCallCount()
+		if err := newRotator().RotateCerts(context.Background(), "bosh"); err == nil {
+			t.Fatal("expected the rotation to fail")
+		}
+
+		if count := ch.ImportCertificatesCallCount() - imports; count != 0 {
+			t.Fatalf("expected the originals to be kept, but got %d imports", count)
+		}
+		if original := store.get(manifest.RootCertOriginalName); original != "---"+manifest.RootCertName+"---" {
+			t.Errorf("expected the original root to be kept, but got %s", original)
+		}
+	})
+
+	// importedNames returns the names of the certs imported since the
+	// specified number of imports
+	importedNames := func(since int) [][]string {
+		var imports [][]string
+		for i := since; i < ch.ImportCertificatesCallCount(); i++ {
+			var names []string
+			_, certs := ch.ImportCertificatesArgsForCall(i)
+			for _, c := range certs {
+				names = append(names, c.Name)
+			}
+			imports = append(imports, names)
+		}
+		return imports
+	}
+	originalRoot := "---" + manifest.RootCertName + "---"
+
+	t.Run("restores the originals after the credhub swap", func(t *testing.T) {
+		deploys := bosh.DeployWithFlagsCallCount()
+		imports := ch.ImportCertificatesCallCount()
+
+		// the cells switch back to the original intermediates while every
+		// job trusts both roots
+		bosh.DeployWithFlagsStub = func(_ context.Context, name string, _ string, _ ...string) error {
+			if root := store.get(manifest.RootCertName); root == originalRoot {
+				t.Errorf("expected %s to be deployed before the root was swapped back", name)
+			}
+			if regen := store.get(manifest.RootCertRegenName); regen != originalRoot {
+				t.Errorf("expected %s to be deployed trusting the original root, but got %s", name, regen)
+			}
+			for _, m := range []*manifest.Manifest{cf, win} {
+				if regen := store.get(m.IntermediateCertRegenPath()); regen != "---"+m.IntermediateCertPath()+"---" {
+					t.Errorf("expected %s to be deployed with the original intermediate, but got %s", name, regen)
+				}
+			}
+			return nil
+		}
+		defer func() { bosh.DeployWithFlagsStub = nil }()
+
+		if err := newRotator().Rollback(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{manifest.RootCertName, cf.IntermediateCertPath(), win.IntermediateCertPath()} {
+			if c := store.get(name); c != "---"+name+"---" {
+				t.Errorf("expected %s to be restored, but got %s", name, c)
+			}
+			if regen := store.get(name + manifest.RegenSuffix); regen != "" {
+				t.Errorf("expected the regen cert %s to be deleted", name+manifest.RegenSuffix)
+			}
+		}
+		names := importedNames(imports)
+		if len(names) != 2 || names[0][0] != manifest.RootCertRegenName || names[1][0] != manifest.RootCertName {
+			t.Errorf("expected the originals to be staged as the regen certs, then swapped back, but got %v", names)
+		}
+
+		if count := bosh.DeployWithFlagsCallCount() - deploys; count != 2 {
+			t.Fatalf("expected 2 deploys, but got %d", count)
+		}
+		if _, name, _, _ := bosh.DeployWithFlagsArgsForCall(deploys); name != cf.DeploymentName {
+			t.Errorf("expected cf to be deployed first, but got %s", name)
+		}
+		_, _, _, flags := bosh.DeployWithFlagsArgsForCall(deploys + 1)
+		if len(flags) != 1 || flags[0] != "--recreate" {
+			t.Errorf("expected windows to be deployed with --recreate, but got %v", flags)
+		}
+
+		if _, _, _, products := om.ApplyChangesArgsForCall(0); products[0] != "cf" {
+			t.Errorf("expected apply changes to cf first, but got %v", products)
+		}
+		if dv.ValidateCertsCallCount() == 0 || rv.ValidateCertsCallCount() == 0 {
+			t.Error("expected the rollback to be validated")
+		}
+
+		if err := newRotator().Resume(context.Background()); err == nil {
+			t.Error("expected the rolled back rotation to not be resumable")
+		}
+	})
+
+	t.Run("fails validation with cert mismatch", func(t *testing.T) {
+		dv.ValidateCertsReturns(validate.CertMismatchError)
+		defer dv.ValidateCertsReturns(nil)
+		if err := newRotator().Rollback(context.Background()); !errors.Is(err, validate.CertMismatchError) {
+			t.Fatalf("expected a cert mismatch error, but got %v", err)
+		}
+	})
+
+	t.Run("keeps the rotated root trusted before the credhub swap", func(t *testing.T) {
+		// the rotation stopped after deploying its regen certs
+		for _, name := range []string{manifest.RootCertName, cf.IntermediateCertPath(), win.IntermediateCertPath()} {
+			store.set(name+manifest.RegenSuffix, "---new "+name+"---")
+		}
+		deploys := bosh.DeployWithFlagsCallCount()
+		imports := ch.ImportCertificatesCallCount()
+
+		bosh.DeployWithFlagsStub = func(_ context.Context, name string, _ string, _ ...string) error {
+			if regen := store.get(manifest.RootCertRegenName); regen != "---new "+manifest.RootCertName+"---" {
+				t.Errorf("expected %s to be deployed trusting the rotated root, but got %s", name, regen)
+			}
+			return nil
+		}
+		defer func() { bosh.DeployWithFlagsStub = nil }()
+
+		if err := newRotator().Rollback(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if count := bosh.DeployWithFlagsCallCount() - deploys; count != 2 {
+			t.Fatalf("expected 2 deploys, but got %d", count)
+		}
+		names := importedNames(imports)
+		if len(names) != 1 || strings.Join(names[0], ",") != cf.IntermediateCertRegenPath()+","+win.IntermediateCertRegenPath() {
+			t.Errorf("expected only the original intermediates to be staged, but got %v", names)
+		}
+		if root := store.get(manifest.RootCertName); root != originalRoot {
+			t.Errorf("expected the original root to be kept, but got %s", root)
+		}
+		if regen := store.get(manifest.RootCertRegenName); regen != "" {
+			t.Errorf("expected the rotated root to be deleted")
+		}
+	})
+
+	t.Run("refuses a snapshot missing an original before changing anything", func(t *testing.T) {
+		saved := store.get(win.IntermediateCertOriginalPath())
+		store.set(win.IntermediateCertOriginalPath(), "")
+		defer store.set(win.IntermediateCertOriginalPath(), saved)
+
+		deploys := bosh.DeployWithFlagsCallCount()
+		imports := ch.ImportCertificatesCallCount()
+		if err := newRotator().Rollback(context.Background()); err == nil {
+			t.Fatal("expected the rollback to fail")
+		}
+		if bosh.DeployWithFlagsCallCount() != deploys || ch.ImportCertificatesCallCount() != imports {
+			t.Error("expected nothing to be deployed or imported")
+		}
+	})
+
+	t.Run("rolls back an intermediate only rotation", func(t *testing.T) {
+		workspace = t.TempDir()
+		s, err := manifest.NewSelector([]string{"pas-windows"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := newRotator()
+		rotate.WithIntermediateOnly()(r)
+		rotate.WithSelector(s)(r)
+
+		// bosh generates the regen intermediate, the rotation stops before
+		// it's cleaned up
+		bosh.DeployWithFlagsStub = func(context.Context, string, string, ...string) error {
+			store.set(win.IntermediateCertRegenPath(), "---new "+win.IntermediateCertPath()+"---")
+			return nil
+		}
+		deleteStub := ch.DeleteStub
+		ch.DeleteStub = func(context.Context, string) error {
+			return errors.New("delete failed")
+		}
+		if err := r.RotateCerts(context.Background(), "bosh"); err == nil {
+			t.Fatal("expected the rotation to fail")
+		}
+		bosh.DeployWithFlagsStub = nil
+		ch.DeleteStub = deleteStub
+		if c := store.get(win.IntermediateCertPath()); c != "---new "+win.IntermediateCertPath()+"---" {
+			t.Fatalf("expected the windows intermediate to be rotated, but got %s", c)
+		}
+
+		deploys := bosh.DeployWithFlagsCallCount()
+		imports := ch.ImportCertificatesCallCount()
+		if err := newRotator().Rollback(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if count := bosh.DeployWithFlagsCallCount() - deploys; count != 1 {
+			t.Fatalf("expected only windows to be deployed, but got %d deploys", count)
+		}
+		for _, names := range importedNames(imports) {
+			for _, name := range names {
+				if strings.HasPrefix(name, manifest.RootCertName) {
+					t.Errorf("expected the root to be kept, but %s was imported", name)
+				}
+			}
+		}
+		if c := store.get(win.IntermediateCertPath()); c != "---"+win.IntermediateCertPath()+"---" {
+			t.Errorf("expected the windows intermediate to be restored, but got %s", c)
+		}
+	})
+}
+
+func TestBackup(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	om := &rotatefakes.FakeOpsManager{}
+	ch := &rotatefakes.FakeCredhubRunner{}
+	ml := &rotatefakes.FakeManifestLoader{}
+
+	cf, err := manifest.NewManifest("p-bosh-12345", "testdata/cf-manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ml.GetAllManifestsWithDiegoCellsReturns([]manifest.Manifest{*cf}, nil)
+	ch.GetCertificateStub = func(_ context.Context, name string) (*credhub.Certificate, error) {
+		cert := &credhub.Certificate{Name: name, Type: "certificate"}
+		cert.Value.Certificate = "---" + name + "---"
+		return cert, nil
+	}
+	dir := t.TempDir()
+	r := rotate.NewCertRotator(om, &rotatefakes.FakeBoshRunner{}, ch, ml,
+		&rotatefakes.FakeDiegoValidator{}, &rotatefakes.FakeRouterValidator{}, rotate.WithBackup(dir, "correct horse"))
+	ch.ImportCertificatesStub = func(context.Context, []credhub.Certificate) error {
+		if matches, _ := filepath.Glob(filepath.Join(dir, "*"+rotate.BackupExtension)); len(matches) != 1 {
+			t.Errorf("expected a backup to be written before importing, but found %v", matches)
+		}
+		return nil
+	}
+	if err = r.RotateCerts(context.Background(), "credhub"); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+rotate.BackupExtension))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("expected a single backup, but got %v %v", matches, err)
+	}
+	certs, err := credhub.ReadBackup(matches[0], "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, c := range certs {
+		names = append(names, c.Name)
+	}
+	expected := []string{manifest.RootCertName, manifest.RootCertRegenName, cf.IntermediateCertPath(), cf.IntermediateCertRegenPath()}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected the backup to contain %v, but got %v", expected, names)
+	}
+
+	// the snapshot copies the originals within credhub before the bosh phase
+	dir = t.TempDir()
+	newCredhubStore(ch, manifest.RootCertName, cf.IntermediateCertPath())
+	ch.ImportCertificatesStub = func(context.Context, []credhub.Certificate) error {
+		if matches, _ := filepath.Glob(filepath.Join(dir, "*"+rotate.BackupExtension)); len(matches) != 1 {
+			t.Errorf("expected a backup to be written before importing, but found %v", matches)
+		}
+		return nil
+	}
+	s, err := rotate.OpenSnapshot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bosh := &rotatefakes.FakeBoshRunner{}
+	bosh.DeployWithFlagsReturns(errors.New("deploy failed"))
+	r = rotate.NewCertRotator(om, bosh, ch, ml, &rotatefakes.FakeDiegoValidator{}, &rotatefakes.FakeRouterValidator{},
+		rotate.WithBackup(dir, "correct horse"), rotate.WithSnapshot(s))
+	imports := ch.ImportCertificatesCallCount()
+	if err = r.RotateCerts(context.Background(), "bosh"); err == nil {
+		t.Fatal("expected the rotation to fail")
+	}
+	if count := ch.ImportCertificatesCallCount() - imports; count != 1 {
+		t.Fatalf("expected the snapshot to import the originals, but got %d imports", count)
+	}
+}
+
+func TestStagedRotation(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	var (
+		om   *rotatefakes.FakeOpsManager
+		bosh *rotatefakes.FakeBoshRunner
+		ch   *rotatefakes.FakeCredhubRunner
+
+		deployed  map[string]bool
+		rootRegen bool
+	)
+
+	cf, err := manifest.NewManifest("p-bosh-12345", "testdata/cf-manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	win, err := manifest.NewManifest("p-bosh-12345", "testdata/pas-windows-manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newRotator := func(include ...string) *rotate.CertRotator {
+		t.Helper()
+
+		om = &rotatefakes.FakeOpsManager{}
+		bosh = &rotatefakes.FakeBoshRunner{}
+		ch = &rotatefakes.FakeCredhubRunner{}
+		ml := &rotatefakes.FakeManifestLoader{}
+		ml.GetAllManifestsWithDiegoCellsReturns([]manifest.Manifest{*cf, *win}, nil)
+
+		bosh.DeployWithFlagsStub = func(_ context.Context, name string, _ string, _ ...string) error {
+			deployed[name] = true
+			return nil
+		}
+		bosh.GetDeploymentManifestStub = func(_ context.Context, name string) ([]byte, error) {
+			if deployed[name] {
+				return []byte("ca: ((" + manifest.RootCertRegenName + ".certificate))"), nil
+			}
+			return []byte("ca: ((" + manifest.RootCertName + ".certificate))"), nil
+		}
+		ch.GetCertificateStub = func(_ context.Context, name string) (*credhub.Certificate, error) {
+			if name == manifest.RootCertRegenName && !rootRegen {
+				return nil, credhub.ErrCredentialNotFound
+			}
+			return &credhub.Certificate{Name: name}, nil
+		}
+
+		s, err := manifest.NewSelector(include, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rotate.NewCertRotator(om, bosh, ch, ml, &rotatefakes.FakeDiegoValidator{}, &rotatefakes.FakeRouterValidator{},
+			rotate.WithSelector(s))
+	}
+
+	t.Run("refuses to deploy windows before the regen root exists", func(t *testing.T) {
+		deployed, rootRegen = map[string]bool{}, false
+		if err := newRotator("pas-windows").RotateCerts(context.Background(), "bosh"); err == nil {
+			t.Fatal("expected an error without the regen root")
+		}
+		if count := bosh.DeployWithFlagsCallCount(); count != 0 {
+			t.Fatalf("expected no deploys, but got %d", count)
+		}
+	})
+
+	t.Run("stops after deploying cf", func(t *testing.T) {
+		deployed, rootRegen = map[string]bool{}, false
+		err := newRotator("cf").RotateCerts(context.Background(), "bosh")
+		if !errors.Is(err, rotate.ErrRotationStaged) {
+			t.Fatalf("expected the rotation to be staged, but got %v", err)
+		}
+		if !deployed[cf.DeploymentName] || deployed[win.DeploymentName] {
+			t.Fatalf("expected only cf to be deployed, but got %v", deployed)
+		}
+		if count := ch.ImportCertificatesCallCount(); count != 0 {
+			t.Fatalf("expected credhub to be unchanged, but got %d imports", count)
+		}
+	})
+
+	t.Run("continues for every deployment after the last stage", func(t *testing.T) {
+		deployed, rootRegen = map[string]bool{cf.DeploymentName: true}, true
+		if err := newRotator("pas-windows-*").RotateCerts(context.Background(), "bosh"); err != nil {
+			t.Fatal(err)
+		}
+		if count := bosh.DeployWithFlagsCallCount(); count != 1 {
+			t.Fatalf("expected only windows to be deployed, but got %d deploys", count)
+		}
+		if _, certs := ch.ImportCertificatesArgsForCall(0); len(certs) != 3 {
+			t.Errorf("expected the root and both intermediates to be swapped, but got %v", certs)
+		}
+		if count := om.ApplyChangesCallCount(); count != 2 {
+			t.Errorf("expected changes to be applied to both products, but got %d", count)
+		}
+	})
+}
+
+func TestIntermediateOnly(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	var (
+		om   *rotatefakes.FakeOpsManager
+		bosh *rotatefakes.FakeBoshRunner
+		ch   *rotatefakes.FakeCredhubRunner
+
+		workspace string
+		deployed  map[string]string
+	)
+
+	cf, err := manifest.NewManifest("p-bosh-12345", "testdata/cf-manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	win, err := manifest.NewManifest("p-bosh-12345", "testdata/pas-windows-manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setup := func() {
+		om = &rotatefakes.FakeOpsManager{}
+		bosh = &rotatefakes.FakeBoshRunner{}
+		ch = &rotatefakes.FakeCredhubRunner{}
+		workspace = t.TempDir()
+		deployed = map[string]string{}
+
+		bosh.DeployWithFlagsStub = func(_ context.Context, name string, manifestFile string, _ ...string) error {
+			content, err := ioutil.ReadFile(manifestFile)
+			deployed[name] = string(content)
+			return err
+		}
+		ch.GetCertificateStub = func(_ context.Context, name string) (*credhub.Certificate, error) {
+			if name == manifest.RootCertRegenName {
+				return nil, credhub.ErrCredentialNotFound
+			}
+			return &credhub.Certificate{Name: name}, nil
+		}
+	}
+
+	newRotator := func(include []string, opts ...rotate.Option) *rotate.CertRotator {
+		t.Helper()
+		ml := &rotatefakes.FakeManifestLoader{}
+		ml.GetAllManifestsWithDiegoCellsReturns([]manifest.Manifest{*cf, *win}, nil)
+		j, err := rotate.OpenJournal(workspace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := manifest.NewSelector(include, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts = append(opts, rotate.WithJournal(j), rotate.WithSelector(s))
+		return rotate.NewCertRotator(om, bosh, ch, ml, &rotatefakes.FakeDiegoValidator{}, &rotatefakes.FakeRouterValidator{},
+			opts...)
+	}
+
+	t.Run("rotates only the selected intermediate", func(t *testing.T) {
+		setup()
+		if err := newRotator([]string{"pas-windows"}, rotate.WithIntermediateOnly()).RotateCerts(context.Background(), "bosh"); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(deployed) != 1 {
+			t.Fatalf("expected only windows to be deployed, but got %d deploys", len(deployed))
+		}
+		content := deployed[win.DeploymentName]
+		if strings.Contains(content, manifest.RootCertRegenName) {
+			t.Error("expected the deployed manifest to keep the existing root")
+		}
+		if !strings.Contains(content, manifest.IntermediateCertRegenName) {
+			t.Error("expected the deployed manifest to use the regen intermediate")
+		}
+
+		if count := ch.ImportCertificatesCallCount(); count != 1 {
+			t.Fatalf("expected a single import, but got %d", count)
+		}
+		_, certs := ch.ImportCertificatesArgsForCall(0)
+		if len(certs) != 1 || certs[0].Name != win.IntermediateCertPath() {
+			t.Errorf("expected only the windows intermediate to be swapped, but got %v", certs)
+		}
+
+		if count := ch.DeleteCallCount(); count != 1 {
+			t.Fatalf("expected a single regen cert to be deleted, but got %d", count)
+		}
+		if _, name := ch.DeleteArgsForCall(0); name != win.IntermediateCertRegenPath() {
+			t.Errorf("expected %s to be deleted, but got %s", win.IntermediateCertRegenPath(), name)
+		}
+
+		if count := om.ApplyChangesCallCount(); count != 1 {
+			t.Fatalf("expected changes to be applied to windows only, but got %d", count)
+		}
+		if _, _, _, products := om.ApplyChangesArgsForCall(0); len(products) != 1 || products[0] != "pas-windows" {
+			t.Errorf("expected changes to be applied to pas-windows, but got %v", products)
+		}
+	})
+
+	t.Run("resumes in the mode it started in", func(t *testing.T) {
+		setup()
+		om.ApplyChangesReturns(errors.New("apply changes failed"))
+		if err := newRotator([]string{"pas-windows"}, rotate.WithIntermediateOnly()).RotateCerts(context.Background(), "bosh"); err == nil {
+			t.Fatal("expected the apply changes to fail")
+		}
+
+		om.ApplyChangesReturns(nil)
+		if err := newRotator(nil).Resume(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if count := om.ApplyChangesCallCount(); count != 2 {
+			t.Errorf("expected windows changes to be applied again, but got %d apply changes", count)
+		}
+		for i := 0; i < ch.DeleteCallCount(); i++ {
+			if _, name := ch.DeleteArgsForCall(i); name != win.IntermediateCertRegenPath() {
+				t.Errorf("expected only the windows regen intermediate to be deleted, but got %s", name)
+			}
+		}
+	})
+
+	t.Run("won't resume a full rotation as intermediate only", func(t *testing.T) {
+		setup()
+		om.ApplyChangesReturns(errors.New("apply changes failed"))
+		ch.GetCertificateStub = nil
+		ch.GetCertificateReturns(&credhub.Certificate{}, nil)
+		if err := newRotator(nil).RotateCerts(context.Background(), "bosh"); err == nil {
+			t.Fatal("expected the apply changes to fail")
+		}
+
+		err := newRotator(nil, rotate.WithIntermediateOnly()).Resume(context.Background())
+		if !errors.Is(err, rotate.ErrJournalMismatch) {
+			t.Fatalf("expected a journal mismatch, but got %v", err)
+		}
+	})
+}
+
+func TestConcurrentDeploys(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	var manifests []manifest.Manifest
+	for _, path := range []string{
+		"testdata/cf-manifest.yml",
+		"../manifest/testdata/p-isolation-segment-manifest.yml",
+		"../manifest/testdata/p-isolation-segment-no-routers-manifest.yml",
+		"testdata/pas-windows-manifest.yml",
+	} {
+		m, err := manifest.NewManifest("p-bosh-12345", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifests = append(manifests, *m)
+	}
+
+	bosh := &rotatefakes.FakeBoshRunner{}
+	ml := &rotatefakes.FakeManifestLoader{}
+	ml.GetAllManifestsWithDiegoCellsReturns(manifests, nil)
+	ch := &rotatefakes.FakeCredhubRunner{}
+	ch.GetCertificateReturns(&credhub.Certificate{}, nil)
+
+	var (
+		mu               sync.Mutex
+		order            []string
+		inFlight, maxRun int
+		bothStarted      = make(chan struct{})
+		once             sync.Once
+	)
+	bosh.DeployWithFlagsStub = func(_ context.Context, name string, _ string, _ ...string) error {
+		mu.Lock()
+		order = append(order, name)
+		inFlight++
+		if inFlight > maxRun {
+			maxRun = inFlight
+		}
+		if inFlight == 2 {
+			once.Do(func() { close(bothStarted) })
+		}
+		mu.Unlock()
+
+		if !strings.HasPrefix(name, "cf-") {
+			select {
+			case <-bothStarted:
+			case <-time.After(5 * time.Second):
+			}
+		}
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		if strings.HasPrefix(name, "p-isolation-segment-") {
+			return errors.New("deploy failed")
+		}
+		return nil
+	}
+
+	r := rotate.NewCertRotator(&rotatefakes.FakeOpsManager{}, bosh, ch, ml,
+		&rotatefakes.FakeDiegoValidator{}, &rotatefakes.FakeRouterValidator{}, rotate.WithMaxDeploys(2))
+	err := r.RotateCerts(context.Background(), "bosh")
+
+	var deployErrs rotate.DeployErrors
+	if !errors.As(err, &deployErrs) || len(deployErrs) != 2 {
+		t.Fatalf("expected both isolation segment failures, but got %v", err)
+	}
+	if len(order) != 4 || order[0] != manifests[0].DeploymentName {
+		t.Fatalf("expected cf to be deployed first followed by every other deployment, but got %v", order)
+	}
+	if maxRun != 2 {
+		t.Errorf("expected 2 concurrent deploys, but got %d", maxRun)
+	}
+	if count := ch.ImportCertificatesCallCount(); count != 0 {
+		t.Errorf("expected the rotation to stop after the failed deploys, but got %d imports", count)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	var manifests []manifest.Manifest
+	for _, path := range []string{
+		"testdata/cf-manifest.yml",
+		"../manifest/testdata/p-isolation-segment-manifest.yml",
+		"../manifest/testdata/p-isolation-segment-no-routers-manifest.yml",
+	} {
+		m, err := manifest.NewManifest("p-bosh-12345", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifests = append(manifests, *m)
+	}
+
+	bosh := &rotatefakes.FakeBoshRunner{}
+	ml := &rotatefakes.FakeManifestLoader{}
+	ml.GetAllManifestsWithDiegoCellsReturns(manifests, nil)
+	ch := &rotatefakes.FakeCredhubRunner{}
+	ch.GetCertificateReturns(&credhub.Certificate{}, nil)
+
+	// interrupt while the first isolation segment is deploying, it should
+	// finish but the second shouldn't start
+	stop := make(chan struct{})
+	bosh.DeployWithFlagsStub = func(_ context.Context, name string, _ string, _ ...string) error {
+		if name == manifests[1].DeploymentName {
+			close(stop)
+		}
+		return nil
+	}
+
+	journal, err := rotate.OpenJournal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rotate.NewCertRotator(&rotatefakes.FakeOpsManager{}, bosh, ch, ml,
+		&rotatefakes.FakeDiegoValidator{}, &rotatefakes.FakeRouterValidator{},
+		rotate.WithJournal(journal), rotate.WithStop(stop))
+	err = r.RotateCerts(context.Background(), "bosh")
+	if !errors.Is(err, rotate.ErrInterrupted) {
+		t.Fatalf("expected the rotation to be interrupted, but got %v", err)
+	}
+
+	if count := bosh.DeployWithFlagsCallCount(); count != 2 {
+		t.Fatalf("expected the deploy in progress to finish and no more to start, but got %d deploys", count)
+	}
+	if count := ch.ImportCertificatesCallCount(); count != 0 {
+		t.Errorf("expected the rotation to stop before credhub, but got %d imports", count)
+	}
+	for _, m := range manifests[:2] {
+		if !journal.Done(rotate.PhaseBosh, m.DeploymentName) {
+			t.Errorf("expected the journal to record %s as deployed", m.DeploymentName)
+		}
+	}
+	if journal.Done(rotate.PhaseBosh, manifests[2].DeploymentName) {
+		t.Errorf("expected the journal not to record %s as deployed", manifests[2].DeploymentName)
+	}
+	if phase := journal.ResumePhase(); phase != rotate.PhaseBosh {
+		t.Errorf("expected to resume at the bosh phase, but got %s", phase)
+	}
+}
+
+func TestInterruptedStep(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	m, err := manifest.NewManifest("p-bosh-12345", "testdata/cf-manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRotator := func(om rotate.OpsManager, opts ...rotate.Option) (*rotate.CertRotator, *rotate.Journal) {
+		ml := &rotatefakes.FakeManifestLoader{}
+		ml.GetAllManifestsWithDiegoCellsReturns([]manifest.Manifest{*m}, nil)
+		ch := &rotatefakes.FakeCredhubRunner{}
+		ch.GetCertificateReturns(&credhub.Certificate{}, nil)
+		journal, err := rotate.OpenJournal(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts = append(opts, rotate.WithJournal(journal))
+		return rotate.NewCertRotator(om, &rotatefakes.FakeBoshRunner{}, ch, ml,
+			&rotatefakes.FakeDiegoValidator{}, &rotatefakes.FakeRouterValidator{}, opts...), journal
+	}
+	checkStopped := func(t *testing.T, err error, journal *rotate.Journal) {
+		if !errors.Is(err, rotate.ErrInterrupted) {
+			t.Fatalf("expected the rotation to be interrupted, but got %v", err)
+		}
+		if e, ok := journal.LastFailure(); ok {
+			t.Errorf("expected no failure in the journal, but got %s %s: %s", e.Phase, e.Deployment, e.Error)
+		}
+		if phase := journal.ResumePhase(); phase != rotate.PhaseApply {
+			t.Errorf("expected to resume at the apply phase, but got %s", phase)
+		}
+	}
+
+	t.Run("an operation that fails after a stop is interrupted", func(t *testing.T) {
+		stop := make(chan struct{})
+		om := &rotatefakes.FakeOpsManager{}
+		om.ApplyChangesStub = func(context.Context, io.Writer, bool, ...string) error {
+			close(stop)
+			return errors.New("stopped following installation 7")
+		}
+		r, journal := newRotator(om, rotate.WithStop(stop))
+		err := r.RotateCerts(context.Background(), rotate.PhaseApply)
+		checkStopped(t, err, journal)
+		if !strings.Contains(err.Error(), "installation 7") {
+			t.Errorf("expected the error to say what was stopped, but got %v", err)
+		}
+	})
+
+	t.Run("apply changes is interrupted when its context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		om := &rotatefakes.FakeOpsManager{}
+		om.ApplyChangesStub = func(ctx context.Context, _ io.Writer, _ bool, _ ...string) error {
+			cancel()
+			return ctx.Err()
+		}
+		r, journal := newRotator(om)
+		checkStopped(t, r.RotateCerts(ctx, rotate.PhaseApply), journal)
+	})
+
+	t.Run("a failure without an interrupt is recorded", func(t *testing.T) {
+		om := &rotatefakes.FakeOpsManager{}
+		om.ApplyChangesReturns(errors.New("installation failed"))
+		r, journal := newRotator(om)
+		if err := r.RotateCerts(context.Background(), rotate.PhaseApply); err == nil || errors.Is(err, rotate.ErrInterrupted) {
+			t.Fatalf("expected the rotation to fail, but got %v", err)
+		}
+		if _, ok := journal.LastFailure(); !ok {
+			t.Errorf("expected the failure to be recorded in the journal")
+		}
+	})
+}
+
+func TestHealthGate(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	var manifests []manifest.Manifest
+	for _, path := range []string{
+		"testdata/cf-manifest.yml",
+		"../manifest/testdata/p-isolation-segment-manifest.yml",
+		"../manifest/testdata/p-isolation-segment-no-routers-manifest.yml",
+	} {
+		m, err := manifest.NewManifest("p-bosh-12345", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifests = append(manifests, *m)
+	}
+
+	running := []boshpkg.VM{{Name: "diego_cell/0", ProcessState: "running"}}
+	failing := []boshpkg.VM{{Name: "diego_cell/0", ProcessState: "failing"}}
+	canary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer canary.Close()
+
+	tests := []struct {
+		name       string
+		unhealthy  string
+		canaryPath string
+		deploys    int
+		err        string
+	}{
+		{name: "healthy", deploys: 3},
+		{name: "cf unhealthy", unhealthy: manifests[0].DeploymentName, deploys: 1, err: "diego_cell/0 is failing"},
+		{name: "isolation segment unhealthy", unhealthy: manifests[1].DeploymentName, deploys: 2, err: "diego_cell/0 is failing"},
+		{name: "canary down", canaryPath: "/down", deploys: 1, err: "responded with 503"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bosh := &rotatefakes.FakeBoshRunner{}
+			// every deployment is failing when it's first checked
+			var mu sync.Mutex
+			checked := map[string]int{}
+			bosh.GetDeploymentVMStatesStub = func(_ context.Context, name string) ([]boshpkg.VM, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				checked[name]++
+				if checked[name] == 1 || name == tc.unhealthy {
+					return failing, nil
+				}
+				return running, nil
+			}
+			ml := &rotatefakes.FakeManifestLoader{}
+			ml.GetAllManifestsWithDiegoCellsReturns(manifests, nil)
+			ch := &rotatefakes.FakeCredhubRunner{}
+			ch.GetCertificateReturns(&credhub.Certificate{}, nil)
+			dv := &rotatefakes.FakeDiegoValidator{}
+			journal, err := rotate.OpenJournal(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := rotate.NewCertRotator(&rotatefakes.FakeOpsManager{}, bosh, ch, ml, dv, &rotatefakes.FakeRouterValidator{},
+				rotate.WithJournal(journal), rotate.WithHealthGate(rotate.HealthGate{
+					Timeout:   100 * time.Millisecond,
+					Interval:  time.Millisecond,
+					CanaryURL: canary.URL + tc.canaryPath,
+				}))
+			err = r.RotateCerts(context.Background(), "bosh")
+
+			if count := bosh.DeployWithFlagsCallCount(); count != tc.deploys {
+				t.Errorf("expected %d deploys, but got %d", tc.deploys, count)
+			}
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				_, _, certPath, _ := dv.ValidateCertsMatchArgsForCall(0)
+				if certPath != manifests[0].IntermediateCertRegenPath() {
+					t.Errorf("expected the cells to be validated against the regen cert after the bosh deploy, but got %s", certPath)
+				}
+				return
+			}
+
+			if !errors.Is(err, rotate.ErrUnhealthy) || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected an unhealthy error containing %q, but got %v", tc.err, err)
+			}
+			if count := ch.ImportCertificatesCallCount(); count != 0 {
+				t.Errorf("expected the rotation to stop before credhub, but got %d imports", count)
+			}
+			if phase := journal.ResumePhase(); phase != rotate.PhaseBosh {
+				t.Errorf("expected to resume at the bosh phase, but got %s", phase)
+			}
+		})
+	}
+}
+
+// credhubStore backs a fake credhub runner with an in-memory store, so the
+// certificates it imports and deletes are seen by later gets
+type credhubStore struct {
+	mu    sync.Mutex
+	certs map[string]string
+}
+
+// newCredhubStore stubs the fake to use a store holding the named
+// certificates
+func newCredhubStore(ch *rotatefakes.FakeCredhubRunner, names ...string) *credhubStore {
+	s := &credhubStore{certs: map[string]string{}}
+	for _, name := range names {
+		s.certs[name] = "---" + name + "---"
+	}
+
+	ch.GetCertificateStub = func(_ context.Context, name string) (*credhub.Certificate, error) {
+		value := s.get(name)
+		if value == "" {
+			return nil, credhub.ErrCredentialNotFound
+		}
+		cert := &credhub.Certificate{Name: name, Type: "certificate"}
+		cert.Value.Certificate = value
+		return cert, nil
+	}
+	ch.ImportCertificatesStub = func(_ context.Context, certs []credhub.Certificate) error {
+		for _, c := range certs {
+			s.set(c.Name, c.Value.Certificate)
+		}
+		return nil
+	}
+	ch.DeleteStub = func(_ context.Context, name string) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.certs, name)
+		return nil
+	}
+	return s
+}
+
+func (s *credhubStore) get(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.certs[name]
+}
+
+func (s *credhubStore) set(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certs[name] = value
+}
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (r *eventRecorder) Publish(e events.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) types() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var types []string
+	for _, e := range r.events {
+		if e.Type != events.Info {
+			types = append(types, e.Type+":"+e.Phase+e.Operation)
+		}
+	}
+	return types
+}
+
+func TestEvents(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	cf, err := manifest.NewManifest("p-bosh-12345", "testdata/cf-manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ml := &rotatefakes.FakeManifestLoader{}
+	ml.GetAllManifestsWithDiegoCellsReturns([]manifest.Manifest{*cf}, nil)
+	ch := &rotatefakes.FakeCredhubRunner{}
+	ch.GetCertificateReturns(&credhub.Certificate{}, nil)
+	ch.DeleteReturnsOnCall(1, errors.New("credhub unavailable"))
+
+	rec := &eventRecorder{}
+	r := rotate.NewCertRotator(&rotatefakes.FakeOpsManager{}, &rotatefakes.FakeBoshRunner{}, ch, ml,
+		&rotatefakes.FakeDiegoValidator{}, &rotatefakes.FakeRouterValidator{}, rotate.WithEvents(rec))
+	if err = r.RotateCerts(context.Background(), "bosh"); err == nil {
+		t.Fatal("expected the cleanup to fail")
+	}
+
+	expected := []string{
+		"phase_started:bosh",
+		"deploy_started:bosh",
+		"deploy_finished:bosh",
+		"phase_finished:bosh",
+		"phase_started:credhub",
+		"credhub_operation:get",
+		"credhub_operation:get",
+		"credhub_operation:import",
+		"phase_finished:credhub",
+		"phase_started:apply",
+		"phase_finished:apply",
+		"phase_started:cleanup",
+		"credhub_operation:delete",
+		"credhub_operation:delete",
+		"phase_finished:cleanup",
+	}
+	if types := rec.types(); strings.Join(types, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected events:\n%v\nbut got:\n%v", expected, types)
+	}
+
+	last := rec.events[len(rec.events)-1]
+	if last.Status != events.StatusFailed || last.Error == "" {
+		t.Errorf("expected the cleanup phase to fail, but got %+v", last)
+	}
+}
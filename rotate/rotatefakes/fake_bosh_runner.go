@@ -2,6 +2,7 @@
 package rotatefakes
 
 import (
+	"context"
 	"sync"
 
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
@@ -9,11 +10,12 @@ import (
 )
 
 type FakeBoshRunner struct {
-	DeployStub        func(string, string) error
+	DeployStub        func(context.Context, string, string) error
 	deployMutex       sync.RWMutex
 	deployArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
+		arg3 string
 	}
 	deployReturns struct {
 		result1 error
@@ -21,12 +23,13 @@ type FakeBoshRunner struct {
 	deployReturnsOnCall map[int]struct {
 		result1 error
 	}
-	DeployWithFlagsStub        func(string, string, ...string) error
+	DeployWithFlagsStub        func(context.Context, string, string, ...string) error
 	deployWithFlagsMutex       sync.RWMutex
 	deployWithFlagsArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
-		arg3 []string
+		arg3 string
+		arg4 []string
 	}
 	deployWithFlagsReturns struct {
 		result1 error
@@ -34,10 +37,39 @@ type FakeBoshRunner struct {
 	deployWithFlagsReturnsOnCall map[int]struct {
 		result1 error
 	}
-	GetDeploymentVMsStub        func(string) ([]bosh.VM, error)
+	GetDeploymentManifestStub        func(context.Context, string) ([]byte, error)
+	getDeploymentManifestMutex       sync.RWMutex
+	getDeploymentManifestArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	getDeploymentManifestReturns struct {
+		result1 []byte
+		result2 error
+	}
+	getDeploymentManifestReturnsOnCall map[int]struct {
+		result1 []byte
+		result2 error
+	}
+	GetDeploymentVMStatesStub        func(context.Context, string) ([]bosh.VM, error)
+	getDeploymentVMStatesMutex       sync.RWMutex
+	getDeploymentVMStatesArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	getDeploymentVMStatesReturns struct {
+		result1 []bosh.VM
+		result2 error
+	}
+	getDeploymentVMStatesReturnsOnCall map[int]struct {
+		result1 []bosh.VM
+		result2 error
+	}
+	GetDeploymentVMsStub        func(context.Context, string) ([]bosh.VM, error)
 	getDeploymentVMsMutex       sync.RWMutex
 	getDeploymentVMsArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
+		arg2 string
 	}
 	getDeploymentVMsReturns struct {
 		result1 []bosh.VM
@@ -51,19 +83,20 @@ type FakeBoshRunner struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeBoshRunner) Deploy(arg1 string, arg2 string) error {
+func (fake *FakeBoshRunner) Deploy(arg1 context.Context, arg2 string, arg3 string) error {
 	fake.deployMutex.Lock()
 	ret, specificReturn := fake.deployReturnsOnCall[len(fake.deployArgsForCall)]
 	fake.deployArgsForCall = append(fake.deployArgsForCall, struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
-	}{arg1, arg2})
+		arg3 string
+	}{arg1, arg2, arg3})
 	stub := fake.DeployStub
 	fakeReturns := fake.deployReturns
-	fake.recordInvocation("Deploy", []interface{}{arg1, arg2})
+	fake.recordInvocation("Deploy", []interface{}{arg1, arg2, arg3})
 	fake.deployMutex.Unlock()
 	if stub != nil {
-		return stub(arg1, arg2)
+		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1
@@ -77,17 +110,17 @@ func (fake *FakeBoshRunner) DeployCallCount() int {
 	return len(fake.deployArgsForCall)
 }
 
-func (fake *FakeBoshRunner) DeployCalls(stub func(string, string) error) {
+func (fake *FakeBoshRunner) DeployCalls(stub func(context.Context, string, string) error) {
 	fake.deployMutex.Lock()
 	defer fake.deployMutex.Unlock()
 	fake.DeployStub = stub
 }
 
-func (fake *FakeBoshRunner) DeployArgsForCall(i int) (string, string) {
+func (fake *FakeBoshRunner) DeployArgsForCall(i int) (context.Context, string, string) {
 	fake.deployMutex.RLock()
 	defer fake.deployMutex.RUnlock()
 	argsForCall := fake.deployArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
 func (fake *FakeBoshRunner) DeployReturns(result1 error) {
@@ -113,20 +146,21 @@ func (fake *FakeBoshRunner) DeployReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *FakeBoshRunner) DeployWithFlags(arg1 string, arg2 string, arg3 ...string) error {
+func (fake *FakeBoshRunner) DeployWithFlags(arg1 context.Context, arg2 string, arg3 string, arg4 ...string) error {
 	fake.deployWithFlagsMutex.Lock()
 	ret, specificReturn := fake.deployWithFlagsReturnsOnCall[len(fake.deployWithFlagsArgsForCall)]
 	fake.deployWithFlagsArgsForCall = append(fake.deployWithFlagsArgsForCall, struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
-		arg3 []string
-	}{arg1, arg2, arg3})
+		arg3 string
+		arg4 []string
+	}{arg1, arg2, arg3, arg4})
 	stub := fake.DeployWithFlagsStub
 	fakeReturns := fake.deployWithFlagsReturns
-	fake.recordInvocation("DeployWithFlags", []interface{}{arg1, arg2, arg3})
+	fake.recordInvocation("DeployWithFlags", []interface{}{arg1, arg2, arg3, arg4})
 	fake.deployWithFlagsMutex.Unlock()
 	if stub != nil {
-		return stub(arg1, arg2, arg3...)
+		return stub(arg1, arg2, arg3, arg4...)
 	}
 	if specificReturn {
 		return ret.result1
@@ -140,17 +174,17 @@ func (fake *FakeBoshRunner) DeployWithFlagsCallCount() int {
 	return len(fake.deployWithFlagsArgsForCall)
 }
 
-func (fake *FakeBoshRunner) DeployWithFlagsCalls(stub func(string, string, ...string) error) {
+func (fake *FakeBoshRunner) DeployWithFlagsCalls(stub func(context.Context, string, string, ...string) error) {
 	fake.deployWithFlagsMutex.Lock()
 	defer fake.deployWithFlagsMutex.Unlock()
 	fake.DeployWithFlagsStub = stub
 }
 
-func (fake *FakeBoshRunner) DeployWithFlagsArgsForCall(i int) (string, string, []string) {
+func (fake *FakeBoshRunner) DeployWithFlagsArgsForCall(i int) (context.Context, string, string, []string) {
 	fake.deployWithFlagsMutex.RLock()
 	defer fake.deployWithFlagsMutex.RUnlock()
 	argsForCall := fake.deployWithFlagsArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
 }
 
 func (fake *FakeBoshRunner) DeployWithFlagsReturns(result1 error) {
@@ -176,18 +210,149 @@ func (fake *FakeBoshRunner) DeployWithFlagsReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *FakeBoshRunner) GetDeploymentVMs(arg1 string) ([]bosh.VM, error) {
+func (fake *FakeBoshRunner) GetDeploymentManifest(arg1 context.Context, arg2 string) ([]byte, error) {
+	fake.getDeploymentManifestMutex.Lock()
+	ret, specificReturn := fake.getDeploymentManifestReturnsOnCall[len(fake.getDeploymentManifestArgsForCall)]
+	fake.getDeploymentManifestArgsForCall = append(fake.getDeploymentManifestArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.GetDeploymentManifestStub
+	fakeReturns := fake.getDeploymentManifestReturns
+	fake.recordInvocation("GetDeploymentManifest", []interface{}{arg1, arg2})
+	fake.getDeploymentManifestMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeBoshRunner) GetDeploymentManifestCallCount() int {
+	fake.getDeploymentManifestMutex.RLock()
+	defer fake.getDeploymentManifestMutex.RUnlock()
+	return len(fake.getDeploymentManifestArgsForCall)
+}
+
+func (fake *FakeBoshRunner) GetDeploymentManifestCalls(stub func(context.Context, string) ([]byte, error)) {
+	fake.getDeploymentManifestMutex.Lock()
+	defer fake.getDeploymentManifestMutex.Unlock()
+	fake.GetDeploymentManifestStub = stub
+}
+
+func (fake *FakeBoshRunner) GetDeploymentManifestArgsForCall(i int) (context.Context, string) {
+	fake.getDeploymentManifestMutex.RLock()
+	defer fake.getDeploymentManifestMutex.RUnlock()
+	argsForCall := fake.getDeploymentManifestArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBoshRunner) GetDeploymentManifestReturns(result1 []byte, result2 error) {
+	fake.getDeploymentManifestMutex.Lock()
+	defer fake.getDeploymentManifestMutex.Unlock()
+	fake.GetDeploymentManifestStub = nil
+	fake.getDeploymentManifestReturns = struct {
+		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBoshRunner) GetDeploymentManifestReturnsOnCall(i int, result1 []byte, result2 error) {
+	fake.getDeploymentManifestMutex.Lock()
+	defer fake.getDeploymentManifestMutex.Unlock()
+	fake.GetDeploymentManifestStub = nil
+	if fake.getDeploymentManifestReturnsOnCall == nil {
+		fake.getDeploymentManifestReturnsOnCall = make(map[int]struct {
+			result1 []byte
+			result2 error
+		})
+	}
+	fake.getDeploymentManifestReturnsOnCall[i] = struct {
+		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBoshRunner) GetDeploymentVMStates(arg1 context.Context, arg2 string) ([]bosh.VM, error) {
+	fake.getDeploymentVMStatesMutex.Lock()
+	ret, specificReturn := fake.getDeploymentVMStatesReturnsOnCall[len(fake.getDeploymentVMStatesArgsForCall)]
+	fake.getDeploymentVMStatesArgsForCall = append(fake.getDeploymentVMStatesArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.GetDeploymentVMStatesStub
+	fakeReturns := fake.getDeploymentVMStatesReturns
+	fake.recordInvocation("GetDeploymentVMStates", []interface{}{arg1, arg2})
+	fake.getDeploymentVMStatesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeBoshRunner) GetDeploymentVMStatesCallCount() int {
+	fake.getDeploymentVMStatesMutex.RLock()
+	defer fake.getDeploymentVMStatesMutex.RUnlock()
+	return len(fake.getDeploymentVMStatesArgsForCall)
+}
+
+func (fake *FakeBoshRunner) GetDeploymentVMStatesCalls(stub func(context.Context, string) ([]bosh.VM, error)) {
+	fake.getDeploymentVMStatesMutex.Lock()
+	defer fake.getDeploymentVMStatesMutex.Unlock()
+	fake.GetDeploymentVMStatesStub = stub
+}
+
+func (fake *FakeBoshRunner) GetDeploymentVMStatesArgsForCall(i int) (context.Context, string) {
+	fake.getDeploymentVMStatesMutex.RLock()
+	defer fake.getDeploymentVMStatesMutex.RUnlock()
+	argsForCall := fake.getDeploymentVMStatesArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBoshRunner) GetDeploymentVMStatesReturns(result1 []bosh.VM, result2 error) {
+	fake.getDeploymentVMStatesMutex.Lock()
+	defer fake.getDeploymentVMStatesMutex.Unlock()
+	fake.GetDeploymentVMStatesStub = nil
+	fake.getDeploymentVMStatesReturns = struct {
+		result1 []bosh.VM
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBoshRunner) GetDeploymentVMStatesReturnsOnCall(i int, result1 []bosh.VM, result2 error) {
+	fake.getDeploymentVMStatesMutex.Lock()
+	defer fake.getDeploymentVMStatesMutex.Unlock()
+	fake.GetDeploymentVMStatesStub = nil
+	if fake.getDeploymentVMStatesReturnsOnCall == nil {
+		fake.getDeploymentVMStatesReturnsOnCall = make(map[int]struct {
+			result1 []bosh.VM
+			result2 error
+		})
+	}
+	fake.getDeploymentVMStatesReturnsOnCall[i] = struct {
+		result1 []bosh.VM
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBoshRunner) GetDeploymentVMs(arg1 context.Context, arg2 string) ([]bosh.VM, error) {
 	fake.getDeploymentVMsMutex.Lock()
 	ret, specificReturn := fake.getDeploymentVMsReturnsOnCall[len(fake.getDeploymentVMsArgsForCall)]
 	fake.getDeploymentVMsArgsForCall = append(fake.getDeploymentVMsArgsForCall, struct {
-		arg1 string
-	}{arg1})
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
 	stub := fake.GetDeploymentVMsStub
 	fakeReturns := fake.getDeploymentVMsReturns
-	fake.recordInvocation("GetDeploymentVMs", []interface{}{arg1})
+	fake.recordInvocation("GetDeploymentVMs", []interface{}{arg1, arg2})
 	fake.getDeploymentVMsMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -201,17 +366,17 @@ func (fake *FakeBoshRunner) GetDeploymentVMsCallCount() int {
 	return len(fake.getDeploymentVMsArgsForCall)
 }
 
-func (fake *FakeBoshRunner) GetDeploymentVMsCalls(stub func(string) ([]bosh.VM, error)) {
+func (fake *FakeBoshRunner) GetDeploymentVMsCalls(stub func(context.Context, string) ([]bosh.VM, error)) {
 	fake.getDeploymentVMsMutex.Lock()
 	defer fake.getDeploymentVMsMutex.Unlock()
 	fake.GetDeploymentVMsStub = stub
 }
 
-func (fake *FakeBoshRunner) GetDeploymentVMsArgsForCall(i int) string {
+func (fake *FakeBoshRunner) GetDeploymentVMsArgsForCall(i int) (context.Context, string) {
 	fake.getDeploymentVMsMutex.RLock()
 	defer fake.getDeploymentVMsMutex.RUnlock()
 	argsForCall := fake.getDeploymentVMsArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeBoshRunner) GetDeploymentVMsReturns(result1 []bosh.VM, result2 error) {
@@ -247,6 +412,10 @@ func (fake *FakeBoshRunner) Invocations() map[string][][]interface{} {
 	defer fake.deployMutex.RUnlock()
 	fake.deployWithFlagsMutex.RLock()
 	defer fake.deployWithFlagsMutex.RUnlock()
+	fake.getDeploymentManifestMutex.RLock()
+	defer fake.getDeploymentManifestMutex.RUnlock()
+	fake.getDeploymentVMStatesMutex.RLock()
+	defer fake.getDeploymentVMStatesMutex.RUnlock()
 	fake.getDeploymentVMsMutex.RLock()
 	defer fake.getDeploymentVMsMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
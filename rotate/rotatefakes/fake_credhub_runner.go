@@ -2,6 +2,7 @@
 package rotatefakes
 
 import (
+	"context"
 	"sync"
 
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
@@ -9,10 +10,11 @@ import (
 )
 
 type FakeCredhubRunner struct {
-	DeleteStub        func(string) error
+	DeleteStub        func(context.Context, string) error
 	deleteMutex       sync.RWMutex
 	deleteArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
+		arg2 string
 	}
 	deleteReturns struct {
 		result1 error
@@ -20,10 +22,11 @@ type FakeCredhubRunner struct {
 	deleteReturnsOnCall map[int]struct {
 		result1 error
 	}
-	GetCertificateStub        func(string) (*credhub.Certificate, error)
+	GetCertificateStub        func(context.Context, string) (*credhub.Certificate, error)
 	getCertificateMutex       sync.RWMutex
 	getCertificateArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
+		arg2 string
 	}
 	getCertificateReturns struct {
 		result1 *credhub.Certificate
@@ -33,10 +36,11 @@ type FakeCredhubRunner struct {
 		result1 *credhub.Certificate
 		result2 error
 	}
-	ImportStub        func(string) error
+	ImportStub        func(context.Context, string) error
 	importMutex       sync.RWMutex
 	importArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
+		arg2 string
 	}
 	importReturns struct {
 		result1 error
@@ -44,10 +48,11 @@ type FakeCredhubRunner struct {
 	importReturnsOnCall map[int]struct {
 		result1 error
 	}
-	ImportCertificatesStub        func([]credhub.Certificate) error
+	ImportCertificatesStub        func(context.Context, []credhub.Certificate) error
 	importCertificatesMutex       sync.RWMutex
 	importCertificatesArgsForCall []struct {
-		arg1 []credhub.Certificate
+		arg1 context.Context
+		arg2 []credhub.Certificate
 	}
 	importCertificatesReturns struct {
 		result1 error
@@ -59,18 +64,19 @@ type FakeCredhubRunner struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeCredhubRunner) Delete(arg1 string) error {
+func (fake *FakeCredhubRunner) Delete(arg1 context.Context, arg2 string) error {
 	fake.deleteMutex.Lock()
 	ret, specificReturn := fake.deleteReturnsOnCall[len(fake.deleteArgsForCall)]
 	fake.deleteArgsForCall = append(fake.deleteArgsForCall, struct {
-		arg1 string
-	}{arg1})
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
 	stub := fake.DeleteStub
 	fakeReturns := fake.deleteReturns
-	fake.recordInvocation("Delete", []interface{}{arg1})
+	fake.recordInvocation("Delete", []interface{}{arg1, arg2})
 	fake.deleteMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1
@@ -84,17 +90,17 @@ func (fake *FakeCredhubRunner) DeleteCallCount() int {
 	return len(fake.deleteArgsForCall)
 }
 
-func (fake *FakeCredhubRunner) DeleteCalls(stub func(string) error) {
+func (fake *FakeCredhubRunner) DeleteCalls(stub func(context.Context, string) error) {
 	fake.deleteMutex.Lock()
 	defer fake.deleteMutex.Unlock()
 	fake.DeleteStub = stub
 }
 
-func (fake *FakeCredhubRunner) DeleteArgsForCall(i int) string {
+func (fake *FakeCredhubRunner) DeleteArgsForCall(i int) (context.Context, string) {
 	fake.deleteMutex.RLock()
 	defer fake.deleteMutex.RUnlock()
 	argsForCall := fake.deleteArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeCredhubRunner) DeleteReturns(result1 error) {
@@ -120,18 +126,19 @@ func (fake *FakeCredhubRunner) DeleteReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *FakeCredhubRunner) GetCertificate(arg1 string) (*credhub.Certificate, error) {
+func (fake *FakeCredhubRunner) GetCertificate(arg1 context.Context, arg2 string) (*credhub.Certificate, error) {
 	fake.getCertificateMutex.Lock()
 	ret, specificReturn := fake.getCertificateReturnsOnCall[len(fake.getCertificateArgsForCall)]
 	fake.getCertificateArgsForCall = append(fake.getCertificateArgsForCall, struct {
-		arg1 string
-	}{arg1})
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
 	stub := fake.GetCertificateStub
 	fakeReturns := fake.getCertificateReturns
-	fake.recordInvocation("GetCertificate", []interface{}{arg1})
+	fake.recordInvocation("GetCertificate", []interface{}{arg1, arg2})
 	fake.getCertificateMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -145,17 +152,17 @@ func (fake *FakeCredhubRunner) GetCertificateCallCount() int {
 	return len(fake.getCertificateArgsForCall)
 }
 
-func (fake *FakeCredhubRunner) GetCertificateCalls(stub func(string) (*credhub.Certificate, error)) {
+func (fake *FakeCredhubRunner) GetCertificateCalls(stub func(context.Context, string) (*credhub.Certificate, error)) {
 	fake.getCertificateMutex.Lock()
 	defer fake.getCertificateMutex.Unlock()
 	fake.GetCertificateStub = stub
 }
 
-func (fake *FakeCredhubRunner) GetCertificateArgsForCall(i int) string {
+func (fake *FakeCredhubRunner) GetCertificateArgsForCall(i int) (context.Context, string) {
 	fake.getCertificateMutex.RLock()
 	defer fake.getCertificateMutex.RUnlock()
 	argsForCall := fake.getCertificateArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeCredhubRunner) GetCertificateReturns(result1 *credhub.Certificate, result2 error) {
@@ -184,18 +191,19 @@ func (fake *FakeCredhubRunner) GetCertificateReturnsOnCall(i int, result1 *credh
 	}{result1, result2}
 }
 
-func (fake *FakeCredhubRunner) Import(arg1 string) error {
+func (fake *FakeCredhubRunner) Import(arg1 context.Context, arg2 string) error {
 	fake.importMutex.Lock()
 	ret, specificReturn := fake.importReturnsOnCall[len(fake.importArgsForCall)]
 	fake.importArgsForCall = append(fake.importArgsForCall, struct {
-		arg1 string
-	}{arg1})
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
 	stub := fake.ImportStub
 	fakeReturns := fake.importReturns
-	fake.recordInvocation("Import", []interface{}{arg1})
+	fake.recordInvocation("Import", []interface{}{arg1, arg2})
 	fake.importMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1
@@ -209,17 +217,17 @@ func (fake *FakeCredhubRunner) ImportCallCount() int {
 	return len(fake.importArgsForCall)
 }
 
-func (fake *FakeCredhubRunner) ImportCalls(stub func(string) error) {
+func (fake *FakeCredhubRunner) ImportCalls(stub func(context.Context, string) error) {
 	fake.importMutex.Lock()
 	defer fake.importMutex.Unlock()
 	fake.ImportStub = stub
 }
 
-func (fake *FakeCredhubRunner) ImportArgsForCall(i int) string {
+func (fake *FakeCredhubRunner) ImportArgsForCall(i int) (context.Context, string) {
 	fake.importMutex.RLock()
 	defer fake.importMutex.RUnlock()
 	argsForCall := fake.importArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeCredhubRunner) ImportReturns(result1 error) {
@@ -245,23 +253,24 @@ func (fake *FakeCredhubRunner) ImportReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *FakeCredhubRunner) ImportCertificates(arg1 []credhub.Certificate) error {
-	var arg1Copy []credhub.Certificate
-	if arg1 != nil {
-		arg1Copy = make([]credhub.Certificate, len(arg1))
-		copy(arg1Copy, arg1)
+func (fake *FakeCredhubRunner) ImportCertificates(arg1 context.Context, arg2 []credhub.Certificate) error {
+	var arg2Copy []credhub.Certificate
+	if arg2 != nil {
+		arg2Copy = make([]credhub.Certificate, len(arg2))
+		copy(arg2Copy, arg2)
 	}
 	fake.importCertificatesMutex.Lock()
 	ret, specificReturn := fake.importCertificatesReturnsOnCall[len(fake.importCertificatesArgsForCall)]
 	fake.importCertificatesArgsForCall = append(fake.importCertificatesArgsForCall, struct {
-		arg1 []credhub.Certificate
-	}{arg1Copy})
+		arg1 context.Context
+		arg2 []credhub.Certificate
+	}{arg1, arg2Copy})
 	stub := fake.ImportCertificatesStub
 	fakeReturns := fake.importCertificatesReturns
-	fake.recordInvocation("ImportCertificates", []interface{}{arg1Copy})
+	fake.recordInvocation("ImportCertificates", []interface{}{arg1, arg2Copy})
 	fake.importCertificatesMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1
@@ -275,17 +284,17 @@ func (fake *FakeCredhubRunner) ImportCertificatesCallCount() int {
 	return len(fake.importCertificatesArgsForCall)
 }
 
-func (fake *FakeCredhubRunner) ImportCertificatesCalls(stub func([]credhub.Certificate) error) {
+func (fake *FakeCredhubRunner) ImportCertificatesCalls(stub func(context.Context, []credhub.Certificate) error) {
 	fake.importCertificatesMutex.Lock()
 	defer fake.importCertificatesMutex.Unlock()
 	fake.ImportCertificatesStub = stub
 }
 
-func (fake *FakeCredhubRunner) ImportCertificatesArgsForCall(i int) []credhub.Certificate {
+func (fake *FakeCredhubRunner) ImportCertificatesArgsForCall(i int) (context.Context, []credhub.Certificate) {
 	fake.importCertificatesMutex.RLock()
 	defer fake.importCertificatesMutex.RUnlock()
 	argsForCall := fake.importCertificatesArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeCredhubRunner) ImportCertificatesReturns(result1 error) {
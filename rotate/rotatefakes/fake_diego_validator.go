@@ -2,6 +2,7 @@
 package rotatefakes
 
 import (
+	"context"
 	"sync"
 
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
@@ -10,11 +11,12 @@ import (
 )
 
 type FakeDiegoValidator struct {
-	ValidateCertsStub        func(*manifest.Manifest, func(bosh.VM) bool) error
+	ValidateCertsStub        func(context.Context, *manifest.Manifest, func(bosh.VM) bool) error
 	validateCertsMutex       sync.RWMutex
 	validateCertsArgsForCall []struct {
-		arg1 *manifest.Manifest
-		arg2 func(bosh.VM) bool
+		arg1 context.Context
+		arg2 *manifest.Manifest
+		arg3 func(bosh.VM) bool
 	}
 	validateCertsReturns struct {
 		result1 error
@@ -22,23 +24,38 @@ type FakeDiegoValidator struct {
 	validateCertsReturnsOnCall map[int]struct {
 		result1 error
 	}
+	ValidateCertsMatchStub        func(context.Context, *manifest.Manifest, string, func(bosh.VM) bool) error
+	validateCertsMatchMutex       sync.RWMutex
+	validateCertsMatchArgsForCall []struct {
+		arg1 context.Context
+		arg2 *manifest.Manifest
+		arg3 string
+		arg4 func(bosh.VM) bool
+	}
+	validateCertsMatchReturns struct {
+		result1 error
+	}
+	validateCertsMatchReturnsOnCall map[int]struct {
+		result1 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeDiegoValidator) ValidateCerts(arg1 *manifest.Manifest, arg2 func(bosh.VM) bool) error {
+func (fake *FakeDiegoValidator) ValidateCerts(arg1 context.Context, arg2 *manifest.Manifest, arg3 func(bosh.VM) bool) error {
 	fake.validateCertsMutex.Lock()
 	ret, specificReturn := fake.validateCertsReturnsOnCall[len(fake.validateCertsArgsForCall)]
 	fake.validateCertsArgsForCall = append(fake.validateCertsArgsForCall, struct {
-		arg1 *manifest.Manifest
-		arg2 func(bosh.VM) bool
-	}{arg1, arg2})
+		arg1 context.Context
+		arg2 *manifest.Manifest
+		arg3 func(bosh.VM) bool
+	}{arg1, arg2, arg3})
 	stub := fake.ValidateCertsStub
 	fakeReturns := fake.validateCertsReturns
-	fake.recordInvocation("ValidateCerts", []interface{}{arg1, arg2})
+	fake.recordInvocation("ValidateCerts", []interface{}{arg1, arg2, arg3})
 	fake.validateCertsMutex.Unlock()
 	if stub != nil {
-		return stub(arg1, arg2)
+		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1
@@ -52,17 +69,17 @@ func (fake *FakeDiegoValidator) ValidateCertsCallCount() int {
 	return len(fake.validateCertsArgsForCall)
 }
 
-func (fake *FakeDiegoValidator) ValidateCertsCalls(stub func(*manifest.Manifest, func(bosh.VM) bool) error) {
+func (fake *FakeDiegoValidator) ValidateCertsCalls(stub func(context.Context, *manifest.Manifest, func(bosh.VM) bool) error) {
 	fake.validateCertsMutex.Lock()
 	defer fake.validateCertsMutex.Unlock()
 	fake.ValidateCertsStub = stub
 }
 
-func (fake *FakeDiegoValidator) ValidateCertsArgsForCall(i int) (*manifest.Manifest, func(bosh.VM) bool) {
+func (fake *FakeDiegoValidator) ValidateCertsArgsForCall(i int) (context.Context, *manifest.Manifest, func(bosh.VM) bool) {
 	fake.validateCertsMutex.RLock()
 	defer fake.validateCertsMutex.RUnlock()
 	argsForCall := fake.validateCertsArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
 func (fake *FakeDiegoValidator) ValidateCertsReturns(result1 error) {
@@ -88,11 +105,77 @@ func (fake *FakeDiegoValidator) ValidateCertsReturnsOnCall(i int, result1 error)
 	}{result1}
 }
 
+func (fake *FakeDiegoValidator) ValidateCertsMatch(arg1 context.Context, arg2 *manifest.Manifest, arg3 string, arg4 func(bosh.VM) bool) error {
+	fake.validateCertsMatchMutex.Lock()
+	ret, specificReturn := fake.validateCertsMatchReturnsOnCall[len(fake.validateCertsMatchArgsForCall)]
+	fake.validateCertsMatchArgsForCall = append(fake.validateCertsMatchArgsForCall, struct {
+		arg1 context.Context
+		arg2 *manifest.Manifest
+		arg3 string
+		arg4 func(bosh.VM) bool
+	}{arg1, arg2, arg3, arg4})
+	stub := fake.ValidateCertsMatchStub
+	fakeReturns := fake.validateCertsMatchReturns
+	fake.recordInvocation("ValidateCertsMatch", []interface{}{arg1, arg2, arg3, arg4})
+	fake.validateCertsMatchMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeDiegoValidator) ValidateCertsMatchCallCount() int {
+	fake.validateCertsMatchMutex.RLock()
+	defer fake.validateCertsMatchMutex.RUnlock()
+	return len(fake.validateCertsMatchArgsForCall)
+}
+
+func (fake *FakeDiegoValidator) ValidateCertsMatchCalls(stub func(context.Context, *manifest.Manifest, string, func(bosh.VM) bool) error) {
+	fake.validateCertsMatchMutex.Lock()
+	defer fake.validateCertsMatchMutex.Unlock()
+	fake.ValidateCertsMatchStub = stub
+}
+
+func (fake *FakeDiegoValidator) ValidateCertsMatchArgsForCall(i int) (context.Context, *manifest.Manifest, string, func(bosh.VM) bool) {
+	fake.validateCertsMatchMutex.RLock()
+	defer fake.validateCertsMatchMutex.RUnlock()
+	argsForCall := fake.validateCertsMatchArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+}
+
+func (fake *FakeDiegoValidator) ValidateCertsMatchReturns(result1 error) {
+	fake.validateCertsMatchMutex.Lock()
+	defer fake.validateCertsMatchMutex.Unlock()
+	fake.ValidateCertsMatchStub = nil
+	fake.validateCertsMatchReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDiegoValidator) ValidateCertsMatchReturnsOnCall(i int, result1 error) {
+	fake.validateCertsMatchMutex.Lock()
+	defer fake.validateCertsMatchMutex.Unlock()
+	fake.ValidateCertsMatchStub = nil
+	if fake.validateCertsMatchReturnsOnCall == nil {
+		fake.validateCertsMatchReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.validateCertsMatchReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeDiegoValidator) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.validateCertsMutex.RLock()
 	defer fake.validateCertsMutex.RUnlock()
+	fake.validateCertsMatchMutex.RLock()
+	defer fake.validateCertsMatchMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -2,6 +2,7 @@
 package rotatefakes
 
 import (
+	"context"
 	"sync"
 
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
@@ -9,9 +10,10 @@ import (
 )
 
 type FakeManifestLoader struct {
-	GetAllManifestsWithDiegoCellsStub        func() ([]manifest.Manifest, error)
+	GetAllManifestsWithDiegoCellsStub        func(context.Context) ([]manifest.Manifest, error)
 	getAllManifestsWithDiegoCellsMutex       sync.RWMutex
 	getAllManifestsWithDiegoCellsArgsForCall []struct {
+		arg1 context.Context
 	}
 	getAllManifestsWithDiegoCellsReturns struct {
 		result1 []manifest.Manifest
@@ -25,17 +27,18 @@ type FakeManifestLoader struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeManifestLoader) GetAllManifestsWithDiegoCells() ([]manifest.Manifest, error) {
+func (fake *FakeManifestLoader) GetAllManifestsWithDiegoCells(arg1 context.Context) ([]manifest.Manifest, error) {
 	fake.getAllManifestsWithDiegoCellsMutex.Lock()
 	ret, specificReturn := fake.getAllManifestsWithDiegoCellsReturnsOnCall[len(fake.getAllManifestsWithDiegoCellsArgsForCall)]
 	fake.getAllManifestsWithDiegoCellsArgsForCall = append(fake.getAllManifestsWithDiegoCellsArgsForCall, struct {
-	}{})
+		arg1 context.Context
+	}{arg1})
 	stub := fake.GetAllManifestsWithDiegoCellsStub
 	fakeReturns := fake.getAllManifestsWithDiegoCellsReturns
-	fake.recordInvocation("GetAllManifestsWithDiegoCells", []interface{}{})
+	fake.recordInvocation("GetAllManifestsWithDiegoCells", []interface{}{arg1})
 	fake.getAllManifestsWithDiegoCellsMutex.Unlock()
 	if stub != nil {
-		return stub()
+		return stub(arg1)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -49,12 +52,19 @@ func (fake *FakeManifestLoader) GetAllManifestsWithDiegoCellsCallCount() int {
 	return len(fake.getAllManifestsWithDiegoCellsArgsForCall)
 }
 
-func (fake *FakeManifestLoader) GetAllManifestsWithDiegoCellsCalls(stub func() ([]manifest.Manifest, error)) {
+func (fake *FakeManifestLoader) GetAllManifestsWithDiegoCellsCalls(stub func(context.Context) ([]manifest.Manifest, error)) {
 	fake.getAllManifestsWithDiegoCellsMutex.Lock()
 	defer fake.getAllManifestsWithDiegoCellsMutex.Unlock()
 	fake.GetAllManifestsWithDiegoCellsStub = stub
 }
 
+func (fake *FakeManifestLoader) GetAllManifestsWithDiegoCellsArgsForCall(i int) context.Context {
+	fake.getAllManifestsWithDiegoCellsMutex.RLock()
+	defer fake.getAllManifestsWithDiegoCellsMutex.RUnlock()
+	argsForCall := fake.getAllManifestsWithDiegoCellsArgsForCall[i]
+	return argsForCall.arg1
+}
+
 func (fake *FakeManifestLoader) GetAllManifestsWithDiegoCellsReturns(result1 []manifest.Manifest, result2 error) {
 	fake.getAllManifestsWithDiegoCellsMutex.Lock()
 	defer fake.getAllManifestsWithDiegoCellsMutex.Unlock()
@@ -2,6 +2,7 @@
 package rotatefakes
 
 import (
+	"context"
 	"io"
 	"sync"
 
@@ -9,12 +10,13 @@ import (
 )
 
 type FakeOpsManager struct {
-	ApplyChangesStub        func(io.Writer, bool, ...string) error
+	ApplyChangesStub        func(context.Context, io.Writer, bool, ...string) error
 	applyChangesMutex       sync.RWMutex
 	applyChangesArgsForCall []struct {
-		arg1 io.Writer
-		arg2 bool
-		arg3 []string
+		arg1 context.Context
+		arg2 io.Writer
+		arg3 bool
+		arg4 []string
 	}
 	applyChangesReturns struct {
 		result1 error
@@ -22,9 +24,10 @@ type FakeOpsManager struct {
 	applyChangesReturnsOnCall map[int]struct {
 		result1 error
 	}
-	CheckPendingChangesStub        func() (bool, error)
+	CheckPendingChangesStub        func(context.Context) (bool, error)
 	checkPendingChangesMutex       sync.RWMutex
 	checkPendingChangesArgsForCall []struct {
+		arg1 context.Context
 	}
 	checkPendingChangesReturns struct {
 		result1 bool
@@ -38,20 +41,21 @@ type FakeOpsManager struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeOpsManager) ApplyChanges(arg1 io.Writer, arg2 bool, arg3 ...string) error {
+func (fake *FakeOpsManager) ApplyChanges(arg1 context.Context, arg2 io.Writer, arg3 bool, arg4 ...string) error {
 	fake.applyChangesMutex.Lock()
 	ret, specificReturn := fake.applyChangesReturnsOnCall[len(fake.applyChangesArgsForCall)]
 	fake.applyChangesArgsForCall = append(fake.applyChangesArgsForCall, struct {
-		arg1 io.Writer
-		arg2 bool
-		arg3 []string
-	}{arg1, arg2, arg3})
+		arg1 context.Context
+		arg2 io.Writer
+		arg3 bool
+		arg4 []string
+	}{arg1, arg2, arg3, arg4})
 	stub := fake.ApplyChangesStub
 	fakeReturns := fake.applyChangesReturns
-	fake.recordInvocation("ApplyChanges", []interface{}{arg1, arg2, arg3})
+	fake.recordInvocation("ApplyChanges", []interface{}{arg1, arg2, arg3, arg4})
 	fake.applyChangesMutex.Unlock()
 	if stub != nil {
-		return stub(arg1, arg2, arg3...)
+		return stub(arg1, arg2, arg3, arg4...)
 	}
 	if specificReturn {
 		return ret.result1
@@ -65,17 +69,17 @@ func (fake *FakeOpsManager) ApplyChangesCallCount() int {
 	return len(fake.applyChangesArgsForCall)
 }
 
-func (fake *FakeOpsManager) ApplyChangesCalls(stub func(io.Writer, bool, ...string) error) {
+func (fake *FakeOpsManager) ApplyChangesCalls(stub func(context.Context, io.Writer, bool, ...string) error) {
 	fake.applyChangesMutex.Lock()
 	defer fake.applyChangesMutex.Unlock()
 	fake.ApplyChangesStub = stub
 }
 
-func (fake *FakeOpsManager) ApplyChangesArgsForCall(i int) (io.Writer, bool, []string) {
+func (fake *FakeOpsManager) ApplyChangesArgsForCall(i int) (context.Context, io.Writer, bool, []string) {
 	fake.applyChangesMutex.RLock()
 	defer fake.applyChangesMutex.RUnlock()
 	argsForCall := fake.applyChangesArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
 }
 
 func (fake *FakeOpsManager) ApplyChangesReturns(result1 error) {
@@ -101,17 +105,18 @@ func (fake *FakeOpsManager) ApplyChangesReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *FakeOpsManager) CheckPendingChanges() (bool, error) {
+func (fake *FakeOpsManager) CheckPendingChanges(arg1 context.Context) (bool, error) {
 	fake.checkPendingChangesMutex.Lock()
 	ret, specificReturn := fake.checkPendingChangesReturnsOnCall[len(fake.checkPendingChangesArgsForCall)]
 	fake.checkPendingChangesArgsForCall = append(fake.checkPendingChangesArgsForCall, struct {
-	}{})
+		arg1 context.Context
+	}{arg1})
 	stub := fake.CheckPendingChangesStub
 	fakeReturns := fake.checkPendingChangesReturns
-	fake.recordInvocation("CheckPendingChanges", []interface{}{})
+	fake.recordInvocation("CheckPendingChanges", []interface{}{arg1})
 	fake.checkPendingChangesMutex.Unlock()
 	if stub != nil {
-		return stub()
+		return stub(arg1)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -125,12 +130,19 @@ func (fake *FakeOpsManager) CheckPendingChangesCallCount() int {
 	return len(fake.checkPendingChangesArgsForCall)
 }
 
-func (fake *FakeOpsManager) CheckPendingChangesCalls(stub func() (bool, error)) {
+func (fake *FakeOpsManager) CheckPendingChangesCalls(stub func(context.Context) (bool, error)) {
 	fake.checkPendingChangesMutex.Lock()
 	defer fake.checkPendingChangesMutex.Unlock()
 	fake.CheckPendingChangesStub = stub
 }
 
+func (fake *FakeOpsManager) CheckPendingChangesArgsForCall(i int) context.Context {
+	fake.checkPendingChangesMutex.RLock()
+	defer fake.checkPendingChangesMutex.RUnlock()
+	argsForCall := fake.checkPendingChangesArgsForCall[i]
+	return argsForCall.arg1
+}
+
 func (fake *FakeOpsManager) CheckPendingChangesReturns(result1 bool, result2 error) {
 	fake.checkPendingChangesMutex.Lock()
 	defer fake.checkPendingChangesMutex.Unlock()
@@ -2,6 +2,7 @@
 package rotatefakes
 
 import (
+	"context"
 	"sync"
 
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
@@ -10,11 +11,12 @@ import (
 )
 
 type FakeRouterValidator struct {
-	ValidateCertsStub        func(*manifest.Manifest, func(bosh.VM) bool) error
+	ValidateCertsStub        func(context.Context, *manifest.Manifest, func(bosh.VM) bool) error
 	validateCertsMutex       sync.RWMutex
 	validateCertsArgsForCall []struct {
-		arg1 *manifest.Manifest
-		arg2 func(bosh.VM) bool
+		arg1 context.Context
+		arg2 *manifest.Manifest
+		arg3 func(bosh.VM) bool
 	}
 	validateCertsReturns struct {
 		result1 error
@@ -26,19 +28,20 @@ type FakeRouterValidator struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeRouterValidator) ValidateCerts(arg1 *manifest.Manifest, arg2 func(bosh.VM) bool) error {
+func (fake *FakeRouterValidator) ValidateCerts(arg1 context.Context, arg2 *manifest.Manifest, arg3 func(bosh.VM) bool) error {
 	fake.validateCertsMutex.Lock()
 	ret, specificReturn := fake.validateCertsReturnsOnCall[len(fake.validateCertsArgsForCall)]
 	fake.validateCertsArgsForCall = append(fake.validateCertsArgsForCall, struct {
-		arg1 *manifest.Manifest
-		arg2 func(bosh.VM) bool
-	}{arg1, arg2})
+		arg1 context.Context
+		arg2 *manifest.Manifest
+		arg3 func(bosh.VM) bool
+	}{arg1, arg2, arg3})
 	stub := fake.ValidateCertsStub
 	fakeReturns := fake.validateCertsReturns
-	fake.recordInvocation("ValidateCerts", []interface{}{arg1, arg2})
+	fake.recordInvocation("ValidateCerts", []interface{}{arg1, arg2, arg3})
 	fake.validateCertsMutex.Unlock()
 	if stub != nil {
-		return stub(arg1, arg2)
+		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1
@@ -52,17 +55,17 @@ func (fake *FakeRouterValidator) ValidateCertsCallCount() int {
 	return len(fake.validateCertsArgsForCall)
 }
 
-func (fake *FakeRouterValidator) ValidateCertsCalls(stub func(*manifest.Manifest, func(bosh.VM) bool) error) {
+func (fake *FakeRouterValidator) ValidateCertsCalls(stub func(context.Context, *manifest.Manifest, func(bosh.VM) bool) error) {
 	fake.validateCertsMutex.Lock()
 	defer fake.validateCertsMutex.Unlock()
 	fake.ValidateCertsStub = stub
 }
 
-func (fake *FakeRouterValidator) ValidateCertsArgsForCall(i int) (*manifest.Manifest, func(bosh.VM) bool) {
+func (fake *FakeRouterValidator) ValidateCertsArgsForCall(i int) (context.Context, *manifest.Manifest, func(bosh.VM) bool) {
 	fake.validateCertsMutex.RLock()
 	defer fake.validateCertsMutex.RUnlock()
 	argsForCall := fake.validateCertsArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
 func (fake *FakeRouterValidator) ValidateCertsReturns(result1 error) {
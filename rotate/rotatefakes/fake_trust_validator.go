@@ -0,0 +1,120 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package rotatefakes
+
+import (
+	"context"
+	"sync"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/rotate"
+)
+
+type FakeTrustValidator struct {
+	ValidateTrustStub        func(context.Context, *manifest.Manifest, string, func() func(bosh.VM) bool) error
+	validateTrustMutex       sync.RWMutex
+	validateTrustArgsForCall []struct {
+		arg1 context.Context
+		arg2 *manifest.Manifest
+		arg3 string
+		arg4 func() func(bosh.VM) bool
+	}
+	validateTrustReturns struct {
+		result1 error
+	}
+	validateTrustReturnsOnCall map[int]struct {
+		result1 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeTrustValidator) ValidateTrust(arg1 context.Context, arg2 *manifest.Manifest, arg3 string, arg4 func() func(bosh.VM) bool) error {
+	fake.validateTrustMutex.Lock()
+	ret, specificReturn := fake.validateTrustReturnsOnCall[len(fake.validateTrustArgsForCall)]
+	fake.validateTrustArgsForCall = append(fake.validateTrustArgsForCall, struct {
+		arg1 context.Context
+		arg2 *manifest.Manifest
+		arg3 string
+		arg4 func() func(bosh.VM) bool
+	}{arg1, arg2, arg3, arg4})
+	stub := fake.ValidateTrustStub
+	fakeReturns := fake.validateTrustReturns
+	fake.recordInvocation("ValidateTrust", []interface{}{arg1, arg2, arg3, arg4})
+	fake.validateTrustMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeTrustValidator) ValidateTrustCallCount() int {
+	fake.validateTrustMutex.RLock()
+	defer fake.validateTrustMutex.RUnlock()
+	return len(fake.validateTrustArgsForCall)
+}
+
+func (fake *FakeTrustValidator) ValidateTrustCalls(stub func(context.Context, *manifest.Manifest, string, func() func(bosh.VM) bool) error) {
+	fake.validateTrustMutex.Lock()
+	defer fake.validateTrustMutex.Unlock()
+	fake.ValidateTrustStub = stub
+}
+
+func (fake *FakeTrustValidator) ValidateTrustArgsForCall(i int) (context.Context, *manifest.Manifest, string, func() func(bosh.VM) bool) {
+	fake.validateTrustMutex.RLock()
+	defer fake.validateTrustMutex.RUnlock()
+	argsForCall := fake.validateTrustArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+}
+
+func (fake *FakeTrustValidator) ValidateTrustReturns(result1 error) {
+	fake.validateTrustMutex.Lock()
+	defer fake.validateTrustMutex.Unlock()
+	fake.ValidateTrustStub = nil
+	fake.validateTrustReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeTrustValidator) ValidateTrustReturnsOnCall(i int, result1 error) {
+	fake.validateTrustMutex.Lock()
+	defer fake.validateTrustMutex.Unlock()
+	fake.ValidateTrustStub = nil
+	if fake.validateTrustReturnsOnCall == nil {
+		fake.validateTrustReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.validateTrustReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeTrustValidator) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.validateTrustMutex.RLock()
+	defer fake.validateTrustMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeTrustValidator) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ rotate.TrustValidator = new(FakeTrustValidator)
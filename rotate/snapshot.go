@@ -0,0 +1,105 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package rotate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	snapshotDirName      = "snapshot"
+	snapshotFileName     = "snapshot.json"
+	snapshotManifestsDir = "manifests"
+)
+
+// Snapshot records which deployments a rotation changed so it can be rolled
+// back, along with their deployed manifests from before the rotation for
+// reference. The original CAs are copied within credhub.
+type Snapshot struct {
+	dir string
+
+	Created     time.Time `json:"created"`
+	Deployments []string  `json:"deployments"`
+	// IntermediateOnly is whether the rotation kept the existing root, only
+	// the intermediates of the deployments were copied
+	IntermediateOnly bool `json:"intermediate_only,omitempty"`
+}
+
+// OpenSnapshot opens the pre-rotation snapshot in the specified workspace
+// directory, loading it if one was previously taken.
+func OpenSnapshot(workspace string) (*Snapshot, error) {
+	s := &Snapshot{
+		dir: filepath.Join(workspace, snapshotDirName),
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, snapshotManifestsDir), 0700); err != nil {
+		return nil, fmt.Errorf("could not create snapshot directory %s: %w", s.dir, err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, snapshotFileName))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot %s: %w", s.dir, err)
+	}
+	if err = json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("could not parse snapshot %s: %w", s.dir, err)
+	}
+	return s, nil
+}
+
+// Exists returns true if a snapshot was taken
+func (s *Snapshot) Exists() bool {
+	return !s.Created.IsZero()
+}
+
+// Dir returns the location of the snapshot on disk
+func (s *Snapshot) Dir() string {
+	return s.dir
+}
+
+// ManifestPath returns the location of the deployment's saved manifest
+func (s *Snapshot) ManifestPath(deploymentName string) string {
+	return filepath.Join(s.dir, snapshotManifestsDir, deploymentName+".yml")
+}
+
+// HasDeployment returns true if the deployment's manifest was saved
+func (s *Snapshot) HasDeployment(deploymentName string) bool {
+	for _, d := range s.Deployments {
+		if d == deploymentName {
+			return true
+		}
+	}
+	return false
+}
+
+// saveManifest stores the deployment's manifest, it isn't part of the
+// snapshot until commit is called.
+func (s *Snapshot) saveManifest(deploymentName string, content []byte) error {
+	if err := ioutil.WriteFile(s.ManifestPath(deploymentName), content, 0600); err != nil {
+		return fmt.Errorf("could not save %s manifest to snapshot: %w", deploymentName, err)
+	}
+	return nil
+}
+
+// commit records the snapshot as complete for the specified deployments
+func (s *Snapshot) commit(deployments []string, intermediateOnly bool) error {
+	s.Created = time.Now().UTC()
+	s.Deployments = append([]string(nil), deployments...)
+	s.IntermediateOnly = intermediateOnly
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(s.dir, snapshotFileName), b, 0600); err != nil {
+		return fmt.Errorf("could not write snapshot %s: %w", s.dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package rotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/validate"
+)
+
+// Check is the result of inspecting part of the foundation, a check may be
+// unknown when the foundation couldn't be inspected.
+type Check int
+
+const (
+	Unknown Check = iota
+	No
+	Yes
+)
+
+func (c Check) String() string {
+	switch c {
+	case Yes:
+		return "yes"
+	case No:
+		return "no"
+	default:
+		return "unknown"
+	}
+}
+
+func checkOf(b bool) Check {
+	if b {
+		return Yes
+	}
+	return No
+}
+
+// DeploymentStatus is the observed rotation state of a single deployment
+type DeploymentStatus struct {
+	DeploymentName string
+
+	// RegenCertExists is whether the regen intermediate exists in credhub
+	RegenCertExists Check
+	// ManifestReferencesRegen is whether the deployed manifest still
+	// references the regen certificate variables
+	ManifestReferencesRegen Check
+	// CertSwapped is whether the original intermediate in credhub is
+	// identical to the regen intermediate
+	CertSwapped Check
+	// VMsHaveRegenCert is whether the first diego cell carries the regen
+	// intermediate
+	VMsHaveRegenCert Check
+}
+
+// Status is the rotation progress inferred from the live foundation
+type Status struct {
+	// RegenRootExists is whether the regen root exists in credhub
+	RegenRootExists Check
+	// RootSwapped is whether the original root in credhub is identical to
+	// the regen root
+	RootSwapped Check
+	Deployments []DeploymentStatus
+
+	// IntermediateOnly is whether the rotation in progress keeps the
+	// existing root
+	IntermediateOnly bool
+	// Phase describes where the foundation is in the rotation
+	Phase string
+	// StartPhase is the recommended safe start phase, empty if there's no
+	// rotation in progress
+	StartPhase string
+}
+
+// Status inspects credhub, the deployed manifests and the diego cells to
+// infer how far a rotation has progressed. It makes no changes.
+func (r *CertRotator) Status(ctx context.Context) (*Status, error) {
+	manifests, err := r.getDiegoCellManifestsSorted(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Status{}
+	s.RegenRootExists, s.RootSwapped, err = r.certStatus(ctx, manifest.RootCertName, manifest.RootCertRegenName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range r.selected(manifests) {
+		m := m
+		events.Infof(r.events, "Inspecting %s", m.DeploymentName)
+
+		d := DeploymentStatus{
+			DeploymentName: m.DeploymentName,
+		}
+		d.RegenCertExists, d.CertSwapped, err = r.certStatus(ctx, m.IntermediateCertPath(), m.IntermediateCertRegenPath())
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := r.bosh.GetDeploymentManifest(ctx, m.DeploymentName)
+		if err != nil {
+			return nil, fmt.Errorf("could not inspect %s manifest: %w", m.DeploymentName, err)
+		}
+		d.ManifestReferencesRegen = checkOf(manifest.ReferencesRegenCerts(content))
+
+		if d.RegenCertExists == Yes {
+			err = r.diegoValidator.ValidateCertsMatch(ctx, &m, m.IntermediateCertRegenPath(), validate.FirstInstanceFilter())
+			switch {
+			case err == nil:
+				d.VMsHaveRegenCert = Yes
+			case errors.Is(err, validate.CertMismatchError):
+				d.VMsHaveRegenCert = No
+			default:
+				events.Warnf(r.events, "could not check the %s diego cell certs: %v", m.DeploymentName, err)
+			}
+		}
+
+		s.Deployments = append(s.Deployments, d)
+	}
+
+	s.infer()
+	return s, nil
+}
+
+// certStatus checks whether the regen cert exists and if the original has
+// already been overwritten by it.
+func (r *CertRotator) certStatus(ctx context.Context, certPath, regenPath string) (exists Check, swapped Check, err error) {
+	regen, err := r.getCertificate(ctx, regenPath)
+	if errors.Is(err, credhub.ErrCredentialNotFound) {
+		return No, Unknown, nil
+	}
+	if err != nil {
+		return Unknown, Unknown, err
+	}
+
+	original, err := r.getCertificate(ctx, certPath)
+	if err != nil {
+		return Yes, Unknown, err
+	}
+
+	return Yes, checkOf(original.Value.Certificate == regen.Value.Certificate), nil
+}
+
+// infer determines the rotation phase from the observed state. Rerunning a
+// phase is safe so when in doubt the earlier phase is recommended.
+func (s *Status) infer() {
+	// the regen root is generated with the first regen intermediate and
+	// removed after the last one, so without it only intermediates are
+	// being rotated
+	if s.RegenRootExists == No {
+		for _, d := range s.Deployments {
+			s.IntermediateOnly = s.IntermediateOnly || d.RegenCertExists == Yes
+		}
+	}
+
+	allReferenceRegen, anyReferenceRegen := true, false
+	allRegenExist, anyRegenExist := s.RegenRootExists == Yes || s.IntermediateOnly, s.RegenRootExists == Yes
+	allExistingSwapped := s.RegenRootExists != Yes || s.RootSwapped == Yes
+	for _, d := range s.Deployments {
+		// deployments that weren't selected for an intermediate only
+		// rotation are left alone
+		if s.IntermediateOnly && d.RegenCertExists != Yes && d.ManifestReferencesRegen != Yes {
+			continue
+		}
+		allReferenceRegen = allReferenceRegen && d.ManifestReferencesRegen == Yes
+		anyReferenceRegen = anyReferenceRegen || d.ManifestReferencesRegen == Yes
+		allRegenExist = allRegenExist && d.RegenCertExists == Yes
+		anyRegenExist = anyRegenExist || d.RegenCertExists == Yes
+		if d.RegenCertExists == Yes {
+			allExistingSwapped = allExistingSwapped && d.CertSwapped == Yes
+		}
+	}
+
+	switch {
+	case !anyRegenExist && !anyReferenceRegen:
+		s.Phase = "no rotation in progress"
+		s.StartPhase = ""
+	case !anyReferenceRegen && allExistingSwapped:
+		s.Phase = "changes are applied, regen certs remain in credhub"
+		s.StartPhase = PhaseCleanup
+	case allRegenExist && allExistingSwapped:
+		s.Phase = "credhub has the regen certs, deployments still reference the regen certs"
+		s.StartPhase = PhaseApply
+	case allRegenExist && allReferenceRegen:
+		s.Phase = "regen certs are deployed, credhub has the original certs"
+		s.StartPhase = PhaseCredhub
+	default:
+		s.Phase = "regen certs are partially deployed"
+		s.StartPhase = PhaseBosh
+	}
+}
@@ -4,6 +4,7 @@
 package rotate
 
 import (
+	"context"
 	"io"
 
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
@@ -14,42 +15,51 @@ import (
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . BoshRunner
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . DiegoValidator
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . RouterValidator
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . TrustValidator
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . CredhubRunner
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . OpsManager
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . ManifestLoader
 
 // BoshRunner interfaces with bosh
 type BoshRunner interface {
-	GetDeploymentVMs(deploymentName string) (vms []bosh.VM, err error)
-	Deploy(deploymentName string, manifestFilename string) error
-	DeployWithFlags(deploymentName string, manifestFilename string, flags ...string) error
+	GetDeploymentVMs(ctx context.Context, deploymentName string) (vms []bosh.VM, err error)
+	GetDeploymentVMStates(ctx context.Context, deploymentName string) (vms []bosh.VM, err error)
+	Deploy(ctx context.Context, deploymentName string, manifestFilename string) error
+	DeployWithFlags(ctx context.Context, deploymentName string, manifestFilename string, flags ...string) error
+	GetDeploymentManifest(ctx context.Context, deploymentName string) ([]byte, error)
 }
 
 // DiegoValidator validates the identity certs on diego cells
 type DiegoValidator interface {
-	ValidateCerts(manifest *manifest.Manifest, diegoCellFilter func(bosh.VM) bool) error
+	ValidateCerts(ctx context.Context, manifest *manifest.Manifest, diegoCellFilter func(bosh.VM) bool) error
+	ValidateCertsMatch(ctx context.Context, manifest *manifest.Manifest, certPath string, diegoCellFilter func(bosh.VM) bool) error
 }
 
 // RouterValidator validates the CA certs on the routers
 type RouterValidator interface {
-	ValidateCerts(manifest *manifest.Manifest, routerFilter func(bosh.VM) bool) error
+	ValidateCerts(ctx context.Context, manifest *manifest.Manifest, routerFilter func(bosh.VM) bool) error
+}
+
+// TrustValidator validates the jobs that must trust the root trust it
+type TrustValidator interface {
+	ValidateTrust(ctx context.Context, manifest *manifest.Manifest, rootPath string, newFilter func() func(bosh.VM) bool) error
 }
 
 // CredhubRunner interfaces with credhub
 type CredhubRunner interface {
-	GetCertificate(certPath string) (*credhub.Certificate, error)
-	Delete(path string) error
-	Import(credentialJsonPath string) error
-	ImportCertificates(certs []credhub.Certificate) error
+	GetCertificate(ctx context.Context, certPath string) (*credhub.Certificate, error)
+	Delete(ctx context.Context, path string) error
+	Import(ctx context.Context, credentialJsonPath string) error
+	ImportCertificates(ctx context.Context, certs []credhub.Certificate) error
 }
 
 // OpsManager interfaces with opsman
 type OpsManager interface {
-	CheckPendingChanges() (bool, error)
-	ApplyChanges(stdout io.Writer, ignoreWarnings bool, product ...string) error
+	CheckPendingChanges(ctx context.Context) (bool, error)
+	ApplyChanges(ctx context.Context, stdout io.Writer, ignoreWarnings bool, product ...string) error
 }
 
 // ManifestLoader loads bosh manifests from existing deployments
 type ManifestLoader interface {
-	GetAllManifestsWithDiegoCells() (manifests []manifest.Manifest, err error)
+	GetAllManifestsWithDiegoCells(ctx context.Context) (manifests []manifest.Manifest, err error)
 }
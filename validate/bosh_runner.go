@@ -4,11 +4,13 @@
 package validate
 
 import (
+	"context"
+
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
 )
 
 // BoshRunner interfaces with bosh
 type BoshRunner interface {
-	GetDeploymentVMs(deploymentName string) (vms []bosh.VM, err error)
-	ScpFile(deploymentName, source, target string) error
+	GetDeploymentVMs(ctx context.Context, deploymentName string) (vms []bosh.VM, err error)
+	ScpFile(ctx context.Context, deploymentName, source, target string) error
 }
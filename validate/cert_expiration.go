@@ -4,13 +4,13 @@
 package validate
 
 import (
+	"context"
 	"crypto/x509"
-	"encoding/pem"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
-	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
 )
 
@@ -20,56 +20,121 @@ var CertMismatchError = errors.New("certificate on instance doesn't match credhu
 
 // CertExpiration validates credhub cert expiration
 type CertExpiration struct {
-	credhub *credhub.Runner
+	credhub CredhubRunner
 }
 
 // NewCertExpiration creates a cert expiration validator
-func NewCertExpiration(credhub *credhub.Runner) *CertExpiration {
+func NewCertExpiration(credhub CredhubRunner) *CertExpiration {
 	return &CertExpiration{
 		credhub: credhub,
 	}
 }
 
+// CertInfo describes a certificate stored in credhub
+type CertInfo struct {
+	Subject           string    `json:"subject" yaml:"subject"`
+	Issuer            string    `json:"issuer" yaml:"issuer"`
+	Serial            string    `json:"serial" yaml:"serial"`
+	SHA256Fingerprint string    `json:"sha256_fingerprint" yaml:"sha256_fingerprint"`
+	NotBefore         time.Time `json:"not_before" yaml:"not_before"`
+	NotAfter          time.Time `json:"not_after" yaml:"not_after"`
+}
+
 // CheckCertExpiration gets the root CA and intermediate expiration time
-func (v *CertExpiration) CheckRootCertExpiration() (expiration time.Time, err error) {
-	rootCred, err := v.credhub.GetCertificate(manifest.RootCertName)
+func (v *CertExpiration) CheckRootCertExpiration(ctx context.Context) (expiration time.Time, err error) {
+	info, err := v.RootCertInfo(ctx)
 	if err != nil {
-		return expiration, fmt.Errorf("failed to retreive Root CA certificate from credhub: %w", err)
+		return expiration, err
 	}
+	return info.NotAfter, nil
+}
 
-	expiration, err = getExpiration(rootCred.Value.Certificate)
+// CheckCertExpiration gets the root CA and intermediate expiration time
+func (v *CertExpiration) CheckIntermediateCertExpiration(ctx context.Context, cfManifest *manifest.Manifest) (expiration time.Time, err error) {
+	info, err := v.IntermediateCertInfo(ctx, cfManifest)
 	if err != nil {
-		return expiration, fmt.Errorf("failed to compute root expiration: %w", err)
+		return expiration, err
 	}
+	return info.NotAfter, nil
+}
 
-	return expiration, nil
+// RootCertInfo describes the root CA
+func (v *CertExpiration) RootCertInfo(ctx context.Context) (*CertInfo, error) {
+	rootCred, err := v.credhub.GetCertificate(ctx, manifest.RootCertName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retreive Root CA certificate from credhub: %w", err)
+	}
+
+	info, err := getCertInfo(rootCred.Value.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute root expiration: %w", err)
+	}
+
+	return info, nil
 }
 
-// CheckCertExpiration gets the root CA and intermediate expiration time
-func (v *CertExpiration) CheckIntermediateCertExpiration(cfManifest *manifest.Manifest) (expiration time.Time, err error) {
+// IntermediateCertInfo describes the deployment's intermediate CA
+func (v *CertExpiration) IntermediateCertInfo(ctx context.Context, cfManifest *manifest.Manifest) (*CertInfo, error) {
 	intermediateCertPath := cfManifest.IntermediateCertPath()
-	intermediateCred, err := v.credhub.GetCertificate(intermediateCertPath)
+	intermediateCred, err := v.credhub.GetCertificate(ctx, intermediateCertPath)
 	if err != nil {
-		return expiration, fmt.Errorf("failed to retreive intermediate certificate from credhub: %w", err)
+		return nil, fmt.Errorf("failed to retreive intermediate certificate from credhub: %w", err)
 	}
 
-	expiration, err = getExpiration(intermediateCred.Value.Certificate)
+	info, err := getCertInfo(intermediateCred.Value.Certificate)
 	if err != nil {
-		return expiration, fmt.Errorf("failed to compute intermediate expiration: %w", err)
+		return nil, fmt.Errorf("failed to compute intermediate expiration: %w", err)
 	}
 
-	return expiration, nil
+	return info, nil
 }
 
 func getExpiration(cred string) (time.Time, error) {
-	block, _ := pem.Decode([]byte(cred))
-	if block == nil {
-		return time.Time{}, errors.New("failed to parse certificate PEM")
+	info, err := getCertInfo(cred)
+	if err != nil {
+		return time.Time{}, err
 	}
-	cert, err := x509.ParseCertificate(block.Bytes)
+	return info.NotAfter, nil
+}
+
+func getCertInfo(cred string) (*CertInfo, error) {
+	certs, err := parseCerts(cred)
+	if err != nil {
+		return nil, err
+	}
+	info := certInfo(certs[0])
+	return &info, nil
+}
+
+// getCertInfos parses every certificate in the PEM bundle
+func getCertInfos(bundle string) ([]CertInfo, error) {
+	certs, err := parseCerts(bundle)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("failed to parse certificate: %w", err)
+		return nil, err
 	}
+	infos := make([]CertInfo, len(certs))
+	for i, c := range certs {
+		infos[i] = certInfo(c)
+	}
+	return infos, nil
+}
+
+func certInfo(cert *x509.Certificate) CertInfo {
+	return CertInfo{
+		Subject:           cert.Subject.String(),
+		Issuer:            cert.Issuer.String(),
+		Serial:            hexColons(cert.SerialNumber.Bytes()),
+		SHA256Fingerprint: fingerprint(cert),
+		NotBefore:         cert.NotBefore,
+		NotAfter:          cert.NotAfter,
+	}
+}
 
-	return cert.NotAfter, nil
+// hexColons formats the bytes like openssl, as colon separated upper case hex
+func hexColons(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02X", c)
+	}
+	return strings.Join(parts, ":")
 }
@@ -43,3 +43,27 @@ func TestGetExpiration(t *testing.T) {
 		t.Errorf("Expected cert to expire on 9/16/22, but it expires on %s", expiration)
 	}
 }
+
+func TestGetCertInfo(t *testing.T) {
+	info, err := getCertInfo(certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Subject != "CN=Diego Instance Identity Intermediate CA" {
+		t.Errorf("unexpected subject %s", info.Subject)
+	}
+	if info.Issuer != "CN=Diego Instance Identity Root CA" {
+		t.Errorf("unexpected issuer %s", info.Issuer)
+	}
+	if info.Serial != "65:15:E5:48:E2:BE:B7:B0:52:A7:5D:0A:9E:38:9C:FC:AD:49:FB:E6" {
+		t.Errorf("unexpected serial %s", info.Serial)
+	}
+	fingerprint := "23:F3:8C:E7:B9:AD:D9:FD:91:47:B2:86:72:7D:47:26:CB:D2:76:BA:17:7B:EF:13:0A:93:D5:6B:5C:58:1E:AB"
+	if info.SHA256Fingerprint != fingerprint {
+		t.Errorf("unexpected fingerprint %s", info.SHA256Fingerprint)
+	}
+	if !info.NotBefore.Equal(time.Date(2020, time.September, 16, 21, 22, 39, 0, time.UTC)) {
+		t.Errorf("unexpected not before %s", info.NotBefore)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package validate_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+)
+
+// the tests shadow the manifest package with a manifest variable
+const (
+	rootCertName      = manifest.RootCertName
+	rootCertRegenName = manifest.RootCertRegenName
+)
+
+// testCA is a CA certificate for the validator tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+// newCA creates a CA valid from notBefore to notAfter, it's self signed when
+// the parent is nil.
+func newCA(t *testing.T, name string, parent *testCA, notBefore, notAfter time.Time) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+// validCA creates a CA valid for a year
+func validCA(t *testing.T, name string, parent *testCA) *testCA {
+	t.Helper()
+	return newCA(t, name, parent, time.Now().Add(-time.Hour), time.Now().AddDate(1, 0, 0))
+}
+
+// credhubCert creates the credhub credential of the CA
+func credhubCert(name string, ca, parent *testCA) *credhub.Certificate {
+	c := &credhub.Certificate{Name: name, Type: "certificate"}
+	c.Value.Certificate = ca.pem
+	c.Value.CA = ca.pem
+	if parent != nil {
+		c.Value.CA = parent.pem
+	}
+	return c
+}
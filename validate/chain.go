@@ -0,0 +1,145 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package validate
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+)
+
+// CertChainError is returned by validators when the certificate on the BOSH
+// instance doesn't chain to a trusted root. It's a CertMismatchError.
+var CertChainError = fmt.Errorf("%w: certificate doesn't chain to a trusted root", CertMismatchError)
+
+// CertValidityError is returned by validators when a certificate is expired
+// or not yet valid. It's a CertMismatchError.
+var CertValidityError = fmt.Errorf("%w: certificate is expired or not yet valid", CertMismatchError)
+
+// CredhubRootError is returned by validators when the credhub intermediate
+// wasn't issued by the current root.
+var CredhubRootError = errors.New("credhub intermediate CA isn't the current root")
+
+// parseCerts parses every certificate in the PEM bundle
+func parseCerts(bundle string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(bundle)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("failed to parse certificate PEM")
+	}
+	return certs, nil
+}
+
+// fingerprint is the SHA-256 fingerprint of the certificate
+func fingerprint(cert *x509.Certificate) string {
+	f := sha256.Sum256(cert.Raw)
+	return hexColons(f[:])
+}
+
+// describe identifies the certificate by subject and fingerprint
+func describe(cert *x509.Certificate) string {
+	return fmt.Sprintf("%q (SHA-256 %s)", cert.Subject.String(), fingerprint(cert))
+}
+
+// checkValidity returns a CertValidityError if any of the certs is expired
+// or not yet valid
+func checkValidity(certs []*x509.Certificate, now time.Time) error {
+	for _, c := range certs {
+		if now.Before(c.NotBefore) {
+			return fmt.Errorf("%w: %s isn't valid until %s", CertValidityError, describe(c), c.NotBefore.UTC().Format(time.RFC3339))
+		}
+		if now.After(c.NotAfter) {
+			return fmt.Errorf("%w: %s expired on %s", CertValidityError, describe(c), c.NotAfter.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// verifyChain checks the chain's first cert chains to one of the roots
+func verifyChain(chain []*x509.Certificate, roots []*x509.Certificate, now time.Time) error {
+	rootPool := x509.NewCertPool()
+	for _, r := range roots {
+		rootPool.AddCert(r)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", CertChainError, describe(chain[0]), err)
+	}
+	return nil
+}
+
+// credhubChain returns the intermediate at certPath and the root that issued
+// it. The root must be the current root, or the regen root during a rotation,
+// and the intermediate must chain to it.
+func credhubChain(ctx context.Context, credhub CredhubRunner, certPath string, now time.Time) (intermediate, root *x509.Certificate, err error) {
+	cred, err := credhub.GetCertificate(ctx, certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	intermediates, err := parseCerts(cred.Value.Certificate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse the %s certificate: %w", certPath, err)
+	}
+	cas, err := parseCerts(cred.Value.CA)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse the %s CA: %w", certPath, err)
+	}
+	intermediate, root = intermediates[0], cas[0]
+
+	var current []string
+	for _, name := range []string{manifest.RootCertName, manifest.RootCertRegenName} {
+		rootCred, err := credhub.GetCertificate(ctx, name)
+		if err != nil {
+			if name == manifest.RootCertName {
+				return nil, nil, err
+			}
+			// the regen root only exists during a rotation
+			continue
+		}
+		certs, err := parseCerts(rootCred.Value.Certificate)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not parse the %s certificate: %w", name, err)
+		}
+		if certs[0].Equal(root) {
+			if err = verifyChain([]*x509.Certificate{intermediate}, []*x509.Certificate{root}, now); err != nil {
+				return nil, nil, fmt.Errorf("credhub %s: %w", certPath, err)
+			}
+			return intermediate, root, nil
+		}
+		current = append(current, describe(certs[0]))
+	}
+	return nil, nil, fmt.Errorf("%w: %s was issued by %s, but the current root is %v",
+		CredhubRootError, certPath, describe(root), current)
+}
@@ -4,10 +4,12 @@
 package validate
 
 import (
+	"context"
+
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
 )
 
 // CredhubRunner interfaces with credhub
 type CredhubRunner interface {
-	GetCertificate(certPath string) (*credhub.Certificate, error)
+	GetCertificate(ctx context.Context, certPath string) (*credhub.Certificate, error)
 }
@@ -4,93 +4,95 @@
 package validate
 
 import (
+	"context"
+	"crypto/x509"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
 )
 
-var diegoCellPrefixes = []string{"diego_cell", "windows_diego_cell", "isolated_diego_cell"}
+// diegoCellPrefixes are the instance groups running rep, compute is the
+// diego cell of the Small Footprint Runtime
+var diegoCellPrefixes = []string{"diego_cell", "windows_diego_cell", "isolated_diego_cell", "compute"}
 
 // Diego can be used to validate instance identity certs on diego cells
 type Diego struct {
+	validator
 	bosh    BoshRunner
 	credhub CredhubRunner
 }
 
 // NewDiego creates a diego cell cert validator
-func NewDiego(bosh BoshRunner, credhub CredhubRunner) *Diego {
+func NewDiego(bosh BoshRunner, credhub CredhubRunner, opts ...Option) *Diego {
 	return &Diego{
-		credhub: credhub,
-		bosh:    bosh,
+		validator: newValidator(opts),
+		credhub:   credhub,
+		bosh:      bosh,
 	}
 }
 
 // ValidateCerts checks that each diego cell instance identity cert
 // matches the current active intermediate issuing CA.
-func (v *Diego) ValidateCerts(manifest *manifest.Manifest, diegoCellFilter func(bosh.VM) bool) error {
-	intermediate, err := v.credhub.GetCertificate(manifest.IntermediateCertPath())
+func (v *Diego) ValidateCerts(ctx context.Context, manifest *manifest.Manifest, diegoCellFilter func(bosh.VM) bool) error {
+	return v.ValidateCertsMatch(ctx, manifest, manifest.IntermediateCertPath(), diegoCellFilter)
+}
+
+// ValidateCertsMatch checks that each diego cell instance identity cert
+// matches the intermediate CA stored in credhub at the specified path, and
+// chains to the root that issued it.
+func (v *Diego) ValidateCertsMatch(ctx context.Context, manifest *manifest.Manifest, certPath string, diegoCellFilter func(bosh.VM) bool) error {
+	intermediate, root, err := credhubChain(ctx, v.credhub, certPath, time.Now())
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Validating %s diego cell certificates\n", manifest.DeploymentName)
-	err = v.checkDiegoCerts(manifest.DeploymentName, intermediate.Value.Certificate, diegoCellFilter)
+	events.Infof(v.events, "Validating %s diego cell certificates", manifest.DeploymentName)
+	err = v.checkDiegoCerts(ctx, manifest.DeploymentName, intermediate, root, diegoCellFilter)
 	if err != nil {
 		return fmt.Errorf("validation certs on diego cells: %w", err)
 	}
 	return nil
 }
 
-func (v *Diego) checkDiegoCerts(deploymentName string, credhubIntermediateCert string, diegoCellFilter func(bosh.VM) bool) error {
-	vms, err := v.bosh.GetDeploymentVMs(deploymentName)
+func (v *Diego) checkDiegoCerts(ctx context.Context, deploymentName string, intermediate, root *x509.Certificate, diegoCellFilter func(bosh.VM) bool) error {
+	vms, err := v.bosh.GetDeploymentVMs(ctx, deploymentName)
 	if err != nil {
 		return err
 	}
 
+	var diegoCells []bosh.VM
 	for _, vm := range vms {
 		if isDiegoCell(vm) && diegoCellFilter(vm) {
-			err = v.checkDiegoCert(vm, credhubIntermediateCert)
-			if err != nil {
-				return err
-			}
+			diegoCells = append(diegoCells, vm)
 		}
 	}
 
-	return nil
+	return v.checkInstances(ctx, v.bosh, "diego_instance_identity", "instance identity cert", vms, diegoCells, identityCertPath,
+		func(diegoCell bosh.VM, instanceIdentity []byte) error {
+			return v.checkDiegoCert(diegoCell, instanceIdentity, intermediate, root)
+		})
 }
 
-func (v *Diego) checkDiegoCert(diegoCell bosh.VM, credhubIntermediateCert string) error {
-	log.Println("Validating cert on", diegoCell.Name)
-
-	instanceIdentityCert, err := ioutil.TempFile("", "instance-identity-*.crt")
+func (v *Diego) checkDiegoCert(diegoCell bosh.VM, instanceIdentity []byte, intermediate, root *x509.Certificate) error {
+	chain, err := parseCerts(string(instanceIdentity))
 	if err != nil {
-		return fmt.Errorf("failed to create temp instance identity cert file: %w", err)
+		return fmt.Errorf("could not parse %s instance identity certificate: %w", diegoCell, err)
 	}
-	defer os.Remove(instanceIdentityCert.Name())
-
-	source := fmt.Sprintf("%s:%s", diegoCell.Name, identityCertPath(diegoCell))
-	err = v.bosh.ScpFile(diegoCell.DeploymentName, source, instanceIdentityCert.Name())
-	if err != nil {
-		return err
+	if !chain[0].Equal(intermediate) {
+		return fmt.Errorf("%w: for instance %s, expected %s but got %s",
+			CertMismatchError, diegoCell, describe(intermediate), describe(chain[0]))
 	}
 
-	instanceIdentity, err := ioutil.ReadFile(instanceIdentityCert.Name())
-	if err != nil {
-		return fmt.Errorf("could not read %s instance identity certificate from %s: %w",
-			diegoCell, instanceIdentityCert.Name(), err)
+	now := time.Now()
+	if err = checkValidity(chain, now); err != nil {
+		return fmt.Errorf("for instance %s: %w", diegoCell, err)
 	}
-
-	cic := strings.TrimSpace(credhubIntermediateCert)
-	dii := strings.TrimSpace(string(instanceIdentity))
-
-	if cic != dii {
-		return fmt.Errorf("%w: for instance %s, expected:\n%s\nbut got:\n%s",
-			CertMismatchError, diegoCell, cic, dii)
+	if err = verifyChain(chain, []*x509.Certificate{root}, now); err != nil {
+		return fmt.Errorf("for instance %s: %w", diegoCell, err)
 	}
 	return nil
 }
@@ -4,8 +4,12 @@
 package validate_test
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"strings"
+	"time"
 
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
@@ -17,13 +21,18 @@ import (
 
 type boshRunner struct {
 	identityCert string
+	// vms overrides the deployment's VMs
+	vms []bosh.VM
 }
 
 type credhubRunner struct {
-	cert *credhub.Certificate
+	certs map[string]*credhub.Certificate
 }
 
-func (b boshRunner) GetDeploymentVMs(deploymentName string) (vms []bosh.VM, err error) {
+func (b boshRunner) GetDeploymentVMs(ctx context.Context, deploymentName string) (vms []bosh.VM, err error) {
+	if b.vms != nil {
+		return b.vms, nil
+	}
 	return []bosh.VM{
 		{
 			Name:           "diego_cell/guid1",
@@ -40,48 +49,116 @@ func (b boshRunner) GetDeploymentVMs(deploymentName string) (vms []bosh.VM, err
 	}, nil
 }
 
-func (b boshRunner) ScpFile(deploymentName, source, target string) error {
+func (b boshRunner) ScpFile(ctx context.Context, deploymentName, source, target string) error {
 	// pretend to grab the cert from the diego cell
 	return ioutil.WriteFile(target, []byte(b.identityCert), 0644)
 }
 
-func (c credhubRunner) GetCertificate(certPath string) (*credhub.Certificate, error) {
-	return c.cert, nil
+func (c credhubRunner) GetCertificate(ctx context.Context, certPath string) (*credhub.Certificate, error) {
+	if cert, ok := c.certs[certPath]; ok {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("%s not found", certPath)
 }
 
 func TestValidateDiegoIdentityCerts(t *testing.T) {
-	newCert := func() *credhub.Certificate {
-		c := &credhub.Certificate{Name: "/cf/ca"}
-		c.Value.Certificate = "cert"
-		c.Value.CA = "cert"
-		c.Value.PrivateKey = "cert"
-		return c
-	}
-	credhubRunner := credhubRunner{
-		cert: newCert(),
-	}
-	boshRunner := boshRunner{
-		identityCert: credhubRunner.cert.Value.Certificate,
-	}
-
 	manifest := &manifest.Manifest{
 		DeploymentName: "cf-guid",
 		Path:           "/tmp/cf.yml",
 	}
 
+	newCredhub := func(certs ...*credhub.Certificate) credhubRunner {
+		r := credhubRunner{certs: map[string]*credhub.Certificate{}}
+		for _, c := range certs {
+			r.certs[c.Name] = c
+		}
+		return r
+	}
+
+	root := validCA(t, "Diego Instance Identity Root CA", nil)
+	intermediate := validCA(t, "Diego Instance Identity Intermediate CA", root)
+	credhubRunner := newCredhub(
+		credhubCert(rootCertName, root, nil),
+		credhubCert(manifest.IntermediateCertPath(), intermediate, root),
+	)
+	boshRunner := boshRunner{
+		identityCert: intermediate.pem,
+	}
+
 	t.Run("matching certs", func(t *testing.T) {
 		v := validate.NewDiego(boshRunner, credhubRunner)
-		err := v.ValidateCerts(manifest, validate.AllInstancesFilter)
+		err := v.ValidateCerts(context.Background(), manifest, validate.AllInstancesFilter)
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
 	t.Run("mismatched certs", func(t *testing.T) {
-		boshRunner.identityCert = "some_other_cert"
+		boshRunner := boshRunner
+		boshRunner.identityCert = validCA(t, "Diego Instance Identity Intermediate CA", root).pem
 		v := validate.NewDiego(boshRunner, credhubRunner)
-		err := v.ValidateCerts(manifest, validate.AllInstancesFilter)
+		err := v.ValidateCerts(context.Background(), manifest, validate.AllInstancesFilter)
 		if !errors.Is(err, validate.CertMismatchError) {
 			t.Fatal("Expected an error to be returned about certs not matching, error was", err)
 		}
+		if strings.Contains(err.Error(), "BEGIN CERTIFICATE") || !strings.Contains(err.Error(), "SHA-256") {
+			t.Errorf("expected the mismatch to be reported by fingerprint, but got %v", err)
+		}
+	})
+	t.Run("expired certs", func(t *testing.T) {
+		expired := newCA(t, "Expired CA", root, time.Now().AddDate(-2, 0, 0), time.Now().AddDate(-1, 0, 0))
+		boshRunner := boshRunner
+		boshRunner.identityCert = intermediate.pem + expired.pem
+		v := validate.NewDiego(boshRunner, credhubRunner)
+		err := v.ValidateCerts(context.Background(), manifest, validate.AllInstancesFilter)
+		if !errors.Is(err, validate.CertValidityError) {
+			t.Fatal("Expected an error about the expired cert, error was", err)
+		}
+	})
+	t.Run("credhub intermediate issued by another root", func(t *testing.T) {
+		other := validCA(t, "Diego Instance Identity Root CA", nil)
+		credhubRunner := newCredhub(
+			credhubCert(rootCertName, other, nil),
+			credhubCert(manifest.IntermediateCertPath(), intermediate, root),
+		)
+		v := validate.NewDiego(boshRunner, credhubRunner)
+		err := v.ValidateCerts(context.Background(), manifest, validate.AllInstancesFilter)
+		if !errors.Is(err, validate.CredhubRootError) {
+			t.Fatal("Expected an error about the credhub root, error was", err)
+		}
+	})
+	t.Run("regen intermediate", func(t *testing.T) {
+		regenRoot := validCA(t, "Diego Instance Identity Root CA", nil)
+		regen := validCA(t, "Diego Instance Identity Intermediate CA", regenRoot)
+		credhubRunner := newCredhub(
+			credhubCert(rootCertName, root, nil),
+			credhubCert(rootCertRegenName, regenRoot, nil),
+			credhubCert(manifest.IntermediateCertRegenPath(), regen, regenRoot),
+		)
+		boshRunner := boshRunner
+		boshRunner.identityCert = regen.pem
+		v := validate.NewDiego(boshRunner, credhubRunner)
+		err := v.ValidateCertsMatch(context.Background(), manifest, manifest.IntermediateCertRegenPath(), validate.AllInstancesFilter)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("small footprint", func(t *testing.T) {
+		boshRunner.vms = []bosh.VM{
+			{Name: "compute/guid1", DeploymentName: "cf-guid"},
+			{Name: "control/guid1", DeploymentName: "cf-guid"},
+			{Name: "database/guid1", DeploymentName: "cf-guid"},
+		}
+		var validated []string
+		v := validate.NewDiego(boshRunner, credhubRunner)
+		err := v.ValidateCerts(context.Background(), manifest, func(vm bosh.VM) bool {
+			validated = append(validated, vm.Name)
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(validated) != 1 || validated[0] != "compute/guid1" {
+			t.Errorf("expected only compute/guid1 to be validated, but got %v", validated)
+		}
 	})
 }
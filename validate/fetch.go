@@ -0,0 +1,232 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package validate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+)
+
+// batchScpTarget is the local file name when copying a file from every
+// instance of an instance group with one bosh scp, the bosh CLI replaces the
+// variables for each instance it copies from
+const batchScpTarget = "((instance_group))-((instance_id))"
+
+// instanceCheck checks the file copied from the instance
+type instanceCheck func(vm bosh.VM, file []byte) error
+
+// fetchJob copies a file from one VM, or from every VM of an instance group
+// at once when batch is true
+type fetchJob struct {
+	group string
+	vms   []bosh.VM
+	batch bool
+}
+
+// checkInstances copies the file at path from each of the selected VMs and
+// runs the check on it, working on at most parallelism jobs at once. When
+// every VM of an instance group is selected the file is copied from all of
+// them with a single bosh scp. The failure of the first failed instance in
+// selected order is returned as an InstanceError. Once an instance fails no
+// more jobs are started, unless the validator has a report, then every
+// instance is checked and its result recorded.
+func (v *validator) checkInstances(ctx context.Context, b BoshRunner, operation, checkName string, vms, selected []bosh.VM,
+	path func(bosh.VM) string, check instanceCheck) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   = map[string]error{}
+		failed bool
+	)
+
+	limit := make(chan struct{}, v.parallelism)
+	for _, j := range fetchJobs(vms, selected) {
+		j := j
+		limit <- struct{}{}
+		mu.Lock()
+		halt := failed && v.report == nil
+		mu.Unlock()
+		if halt {
+			<-limit
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-limit }()
+
+			if vm, err := v.runJob(ctx, b, operation, checkName, j, path, check); err != nil {
+				mu.Lock()
+				errs[vm.Name] = err
+				failed = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, vm := range selected {
+		if err, ok := errs[vm.Name]; ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// runJob copies the file from the job's VMs and checks them in turn, it
+// returns the first VM that fails
+func (v *validator) runJob(ctx context.Context, b BoshRunner, operation, checkName string, j fetchJob, path func(bosh.VM) string,
+	check instanceCheck) (failedVM bosh.VM, failure error) {
+	started := time.Now()
+	var files map[string][]byte
+	if j.batch {
+		files = v.batchFetch(ctx, b, j, path(j.vms[0]))
+	}
+
+	for _, vm := range j.vms {
+		if !j.batch {
+			started = time.Now()
+		}
+		events.Infof(v.events, "Validating cert on %s", vm.Name)
+		file, ok := files[vm.Name]
+		var err error
+		result := ResultUnreachable
+		if !ok {
+			file, err = fetchFile(ctx, b, vm, path(vm))
+		}
+		if err == nil {
+			err = check(vm, file)
+			result = checkResult(err)
+		}
+		v.publishResult(operation, vm, started, err)
+		if v.report != nil {
+			v.report.add(vm, checkName, result, err)
+		}
+		if err == nil {
+			continue
+		}
+		if failure == nil {
+			failedVM, failure = vm, &InstanceError{VM: vm, Result: result, Err: err}
+		}
+		if v.report == nil {
+			break
+		}
+	}
+	return failedVM, failure
+}
+
+// checkResult is the result of an instance whose file was copied, the checks
+// only fail with a mismatch or because the file couldn't be parsed
+func checkResult(err error) Result {
+	switch {
+	case err == nil:
+		return ResultOK
+	case errors.Is(err, CertMismatchError):
+		return ResultMismatch
+	default:
+		return ResultParseError
+	}
+}
+
+// batchFetch copies the file from every VM of the job's instance group with
+// one bosh scp. It returns the files that were copied, keyed by VM name, the
+// rest are copied from each VM in turn. Older bosh CLIs don't replace the
+// variables in the target, so none of the files are found.
+func (v *validator) batchFetch(ctx context.Context, b BoshRunner, j fetchJob, path string) map[string][]byte {
+	files := map[string][]byte{}
+	dir, err := ioutil.TempDir("", "instance-files-*")
+	if err != nil {
+		return files
+	}
+	defer os.RemoveAll(dir)
+
+	events.Infof(v.events, "Copying %s from the %d %s instances", path, len(j.vms), j.group)
+	source := fmt.Sprintf("%s:%s", j.group, path)
+	if err = b.ScpFile(ctx, j.vms[0].DeploymentName, source, filepath.Join(dir, batchScpTarget)); err != nil {
+		events.Warnf(v.events, "Failed to copy %s from every %s instance, copying from each instance in turn: %v",
+			path, j.group, err)
+	}
+
+	for _, vm := range j.vms {
+		content, err := ioutil.ReadFile(filepath.Join(dir, j.group+"-"+instanceID(vm)))
+		if err == nil {
+			files[vm.Name] = content
+		}
+	}
+	return files
+}
+
+// fetchJobs splits the selected VMs into jobs, one for each instance group
+// whose VMs are all selected, and one for each of the other VMs
+func fetchJobs(vms, selected []bosh.VM) []fetchJob {
+	groupSize := map[string]int{}
+	for _, vm := range vms {
+		groupSize[instanceGroup(vm)]++
+	}
+
+	var groups []string
+	byGroup := map[string][]bosh.VM{}
+	for _, vm := range selected {
+		g := instanceGroup(vm)
+		if _, ok := byGroup[g]; !ok {
+			groups = append(groups, g)
+		}
+		byGroup[g] = append(byGroup[g], vm)
+	}
+
+	var jobs []fetchJob
+	for _, g := range groups {
+		if len(byGroup[g]) > 1 && len(byGroup[g]) == groupSize[g] {
+			jobs = append(jobs, fetchJob{group: g, vms: byGroup[g], batch: true})
+			continue
+		}
+		for _, vm := range byGroup[g] {
+			jobs = append(jobs, fetchJob{group: g, vms: []bosh.VM{vm}})
+		}
+	}
+	return jobs
+}
+
+// fetchFile copies the file from the VM
+func fetchFile(ctx context.Context, b BoshRunner, vm bosh.VM, path string) ([]byte, error) {
+	f, err := ioutil.TempFile("", "instance-file-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	source := fmt.Sprintf("%s:%s", vm.Name, path)
+	if err = b.ScpFile(ctx, vm.DeploymentName, source, f.Name()); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s from %s: %w", path, vm, err)
+	}
+	return content, nil
+}
+
+// instanceGroup is the instance group of the VM, the part of its name before
+// the slash
+func instanceGroup(vm bosh.VM) string {
+	return strings.SplitN(vm.Name, "/", 2)[0]
+}
+
+// instanceID is the ID of the VM's instance, the part of its name after the
+// slash
+func instanceID(vm bosh.VM) string {
+	parts := strings.SplitN(vm.Name, "/", 2)
+	return parts[len(parts)-1]
+}
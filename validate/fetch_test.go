@@ -0,0 +1,172 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package validate_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/validate"
+)
+
+// batchBoshRunner records the scp sources and how many were copying at once
+type batchBoshRunner struct {
+	vms  []bosh.VM
+	file string
+	// replaceVars pretends to be a bosh CLI that replaces the instance
+	// variables in the scp target
+	replaceVars bool
+
+	mu          sync.Mutex
+	vmCalls     int
+	sources     []string
+	copying     int
+	maxCopying  int
+	failSources map[string]bool
+}
+
+func (b *batchBoshRunner) GetDeploymentVMs(ctx context.Context, deploymentName string) ([]bosh.VM, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.vmCalls++
+	return b.vms, nil
+}
+
+func (b *batchBoshRunner) ScpFile(ctx context.Context, deploymentName, source, target string) error {
+	b.mu.Lock()
+	b.sources = append(b.sources, source)
+	b.copying++
+	if b.copying > b.maxCopying {
+		b.maxCopying = b.copying
+	}
+	fail := b.failSources[source]
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.copying--
+		b.mu.Unlock()
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if fail {
+		return fmt.Errorf("failed to copy %s", source)
+	}
+
+	instance := strings.SplitN(source, ":", 2)[0]
+	if strings.Contains(instance, "/") || !b.replaceVars {
+		return ioutil.WriteFile(target, []byte(b.file), 0644)
+	}
+	for _, vm := range b.vms {
+		parts := strings.SplitN(vm.Name, "/", 2)
+		if parts[0] != instance || b.failSources[vm.Name+":"+strings.SplitN(source, ":", 2)[1]] {
+			continue
+		}
+		t := strings.ReplaceAll(target, "((instance_group))", parts[0])
+		t = strings.ReplaceAll(t, "((instance_id))", parts[1])
+		if err := ioutil.WriteFile(t, []byte(b.file), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestValidateConcurrently(t *testing.T) {
+	m := &manifest.Manifest{DeploymentName: "cf-guid"}
+	root := validCA(t, "Diego Instance Identity Root CA", nil)
+	intermediate := validCA(t, "Diego Instance Identity Intermediate CA", root)
+	credhubRunner := credhubRunner{certs: map[string]*credhub.Certificate{
+		rootCertName:             credhubCert(rootCertName, root, nil),
+		m.IntermediateCertPath(): credhubCert(m.IntermediateCertPath(), intermediate, root),
+	}}
+
+	var vms []bosh.VM
+	for i := 0; i < 6; i++ {
+		vms = append(vms, bosh.VM{Name: fmt.Sprintf("diego_cell/guid%d", i), DeploymentName: "cf-guid"})
+	}
+	vms = append(vms, bosh.VM{Name: "isolated_diego_cell/guid1", DeploymentName: "cf-guid"})
+	identityCertPath := "/var/vcap/jobs/rep/config/certs/rep/instance_identity.crt"
+
+	t.Run("batches the instance groups", func(t *testing.T) {
+		b := &batchBoshRunner{vms: vms, file: intermediate.pem, replaceVars: true}
+		v := validate.NewDiego(b, credhubRunner, validate.WithParallelism(4))
+		if err := v.ValidateCerts(context.Background(), m, validate.AllInstancesFilter); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(b.sources)
+		expected := []string{"diego_cell:" + identityCertPath, "isolated_diego_cell/guid1:" + identityCertPath}
+		if fmt.Sprint(b.sources) != fmt.Sprint(expected) {
+			t.Errorf("expected %v to be copied, but got %v", expected, b.sources)
+		}
+	})
+	t.Run("copies from each instance when the bosh CLI doesn't batch", func(t *testing.T) {
+		b := &batchBoshRunner{vms: vms, file: intermediate.pem}
+		v := validate.NewDiego(b, credhubRunner, validate.WithParallelism(4))
+		if err := v.ValidateCerts(context.Background(), m, validate.AllInstancesFilter); err != nil {
+			t.Fatal(err)
+		}
+		if len(b.sources) != 1+len(vms) {
+			t.Errorf("expected the batch and each instance to be copied, but got %v", b.sources)
+		}
+	})
+	t.Run("copies from the instances the batch missed", func(t *testing.T) {
+		missed := "diego_cell/guid3:" + identityCertPath
+		b := &batchBoshRunner{vms: vms, file: intermediate.pem, replaceVars: true,
+			failSources: map[string]bool{missed: true}}
+		v := validate.NewDiego(b, credhubRunner, validate.WithParallelism(4))
+		err := v.ValidateCerts(context.Background(), m, validate.AllInstancesFilter)
+		if err == nil || !strings.Contains(err.Error(), missed) {
+			t.Errorf("expected the missed instance to be copied on its own and fail, but got %v", err)
+		}
+	})
+	t.Run("copies at most parallelism at once", func(t *testing.T) {
+		b := &batchBoshRunner{vms: vms, file: intermediate.pem}
+		v := validate.NewDiego(b, credhubRunner, validate.WithParallelism(2))
+		if err := v.ValidateCerts(context.Background(), m, validate.SampleFilter(5)); err != nil {
+			t.Fatal(err)
+		}
+		if len(b.sources) != 5 {
+			t.Errorf("expected the 5 sampled instances to be copied, but got %v", b.sources)
+		}
+		if b.maxCopying != 2 {
+			t.Errorf("expected 2 copies at once, but got %d", b.maxCopying)
+		}
+	})
+	t.Run("returns the first failed instance", func(t *testing.T) {
+		b := &batchBoshRunner{vms: vms, file: intermediate.pem, failSources: map[string]bool{
+			"diego_cell/guid1:" + identityCertPath: true,
+			"diego_cell/guid2:" + identityCertPath: true,
+		}}
+		v := validate.NewDiego(b, credhubRunner, validate.WithParallelism(3))
+		err := v.ValidateCerts(context.Background(), m, validate.SampleFilter(5))
+		if err == nil || !strings.Contains(err.Error(), "diego_cell/guid1") {
+			t.Errorf("expected diego_cell/guid1 to fail, but got %v", err)
+		}
+	})
+}
+
+func TestInventory(t *testing.T) {
+	b := &batchBoshRunner{vms: []bosh.VM{{Name: "router/guid1", DeploymentName: "cf-guid"}}}
+	inventory := validate.NewInventory(b)
+	for i := 0; i < 2; i++ {
+		vms, err := inventory.GetDeploymentVMs(context.Background(), "cf-guid")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vms) != 1 {
+			t.Errorf("expected the router, but got %v", vms)
+		}
+	}
+	if b.vmCalls != 1 {
+		t.Errorf("expected the VMs to be got from bosh once, but got them %d times", b.vmCalls)
+	}
+}
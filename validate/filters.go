@@ -7,6 +7,12 @@ import "github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
 
 var AllInstancesFilter = func(vm bosh.VM) bool { return true }
 
+// AllInstances creates a filter matching every instance, it can be used
+// where a new filter is needed for each job
+func AllInstances() func(vm bosh.VM) bool {
+	return AllInstancesFilter
+}
+
 func FirstInstanceFilter() func(vm bosh.VM) bool {
 	count := 0
 	return func(vm bosh.VM) bool {
@@ -14,3 +20,12 @@ func FirstInstanceFilter() func(vm bosh.VM) bool {
 		return count == 1
 	}
 }
+
+// SampleFilter matches the first n instances
+func SampleFilter(n int) func(vm bosh.VM) bool {
+	count := 0
+	return func(vm bosh.VM) bool {
+		count++
+		return count <= n
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package validate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+	"gopkg.in/yaml.v2"
+)
+
+// The kinds of certs deployed on the instances
+const (
+	// InstanceIdentityCerts is the intermediate chain rep signs the app
+	// instance identity certs with
+	InstanceIdentityCerts = "instance_identity"
+	// RouterCACerts is the CA bundle gorouter verifies the app instance
+	// identity certs with
+	RouterCACerts = "router_ca_certs"
+)
+
+// DeployedCerts are the certs deployed on an instance
+type DeployedCerts struct {
+	VM    bosh.VM
+	Kind  string
+	Certs []CertInfo
+}
+
+// InstanceCerts gets the certs deployed on the diego cells and routers,
+// which can differ from credhub until a rotation finishes
+type InstanceCerts struct {
+	validator
+	bosh BoshRunner
+}
+
+// NewInstanceCerts creates a deployed cert getter
+func NewInstanceCerts(bosh BoshRunner, opts ...Option) *InstanceCerts {
+	return &InstanceCerts{
+		validator: newValidator(opts),
+		bosh:      bosh,
+	}
+}
+
+// GetDeployedCerts returns the instance identity chain of each diego cell and
+// the CA bundle of each router in the deployment
+func (v *InstanceCerts) GetDeployedCerts(ctx context.Context, manifest *manifest.Manifest) ([]DeployedCerts, error) {
+	vms, err := v.bosh.GetDeploymentVMs(ctx, manifest.DeploymentName)
+	if err != nil {
+		return nil, err
+	}
+
+	events.Infof(v.events, "Getting the certs deployed on the %s instances", manifest.DeploymentName)
+	var deployed []DeployedCerts
+	for _, vm := range vms {
+		var d *DeployedCerts
+		started := time.Now()
+		switch {
+		case isDiegoCell(vm):
+			d, err = v.getIdentityCerts(ctx, vm)
+		case isRouter(vm):
+			d, err = v.getRouterCACerts(ctx, vm)
+		default:
+			continue
+		}
+		v.publishResult("deployed_certs", vm, started, err)
+		if err != nil {
+			return nil, err
+		}
+		deployed = append(deployed, *d)
+	}
+	return deployed, nil
+}
+
+func (v *InstanceCerts) getIdentityCerts(ctx context.Context, diegoCell bosh.VM) (*DeployedCerts, error) {
+	b, err := v.fetch(ctx, diegoCell, identityCertPath(diegoCell))
+	if err != nil {
+		return nil, err
+	}
+	certs, err := getCertInfos(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s instance identity certificate: %w", diegoCell, err)
+	}
+	return &DeployedCerts{VM: diegoCell, Kind: InstanceIdentityCerts, Certs: certs}, nil
+}
+
+func (v *InstanceCerts) getRouterCACerts(ctx context.Context, routerVM bosh.VM) (*DeployedCerts, error) {
+	b, err := v.fetch(ctx, routerVM, routerVMConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var c struct {
+		CACerts string `yaml:"ca_certs"`
+	}
+	if err = yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s router config: %w", routerVM, err)
+	}
+	certs, err := getCertInfos(c.CACerts)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s router CA certificates: %w", routerVM, err)
+	}
+	return &DeployedCerts{VM: routerVM, Kind: RouterCACerts, Certs: certs}, nil
+}
+
+// fetch copies the file from the VM
+func (v *InstanceCerts) fetch(ctx context.Context, vm bosh.VM, path string) ([]byte, error) {
+	return fetchFile(ctx, v.bosh, vm, path)
+}
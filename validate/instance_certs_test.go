@@ -0,0 +1,62 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package validate
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+)
+
+type deployedBoshRunner struct {
+	files map[string]string
+}
+
+func (b deployedBoshRunner) GetDeploymentVMs(ctx context.Context, deploymentName string) (vms []bosh.VM, err error) {
+	return []bosh.VM{
+		{Name: "diego_cell/guid1", DeploymentName: deploymentName},
+		{Name: "router/guid1", DeploymentName: deploymentName},
+		{Name: "diego_brain/guid1", DeploymentName: deploymentName},
+	}, nil
+}
+
+func (b deployedBoshRunner) ScpFile(ctx context.Context, deploymentName, source, target string) error {
+	return ioutil.WriteFile(target, []byte(b.files[source]), 0644)
+}
+
+func TestGetDeployedCerts(t *testing.T) {
+	indented := "  " + strings.ReplaceAll(strings.TrimSpace(certificate), "\n", "\n  ")
+	b := deployedBoshRunner{files: map[string]string{
+		"diego_cell/guid1:/var/vcap/jobs/rep/config/certs/rep/instance_identity.crt": certificate + certificate,
+		"router/guid1:" + routerVMConfigPath:                                         "ca_certs: |\n" + indented + "\n",
+	}}
+
+	v := NewInstanceCerts(b)
+	deployed, err := v.GetDeployedCerts(context.Background(), &manifest.Manifest{DeploymentName: "cf-guid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deployed) != 2 {
+		t.Fatalf("expected only the diego cell and router certs, but got %v", deployed)
+	}
+	if deployed[0].Kind != InstanceIdentityCerts || len(deployed[0].Certs) != 2 {
+		t.Errorf("expected the diego cell's chain of 2 certs, but got %s with %d", deployed[0].Kind, len(deployed[0].Certs))
+	}
+	if deployed[1].Kind != RouterCACerts || len(deployed[1].Certs) != 1 {
+		t.Errorf("expected the router's bundle of 1 cert, but got %s with %d", deployed[1].Kind, len(deployed[1].Certs))
+	}
+	if deployed[1].Certs[0].Subject != "CN=Diego Instance Identity Intermediate CA" {
+		t.Errorf("unexpected router cert %s", deployed[1].Certs[0].Subject)
+	}
+
+	b.files["router/guid1:"+routerVMConfigPath] = "ca_certs: not a cert\n"
+	if _, err = v.GetDeployedCerts(context.Background(), &manifest.Manifest{DeploymentName: "cf-guid"}); err == nil {
+		t.Error("expected an error for a router without certs")
+	}
+}
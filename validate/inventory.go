@@ -0,0 +1,50 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package validate
+
+import (
+	"context"
+	"sync"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+)
+
+// Inventory is a BoshRunner that gets each deployment's VMs once, so the
+// validators sharing it don't each ask BOSH for the same VMs
+type Inventory struct {
+	runner BoshRunner
+
+	mu  sync.Mutex
+	vms map[string][]bosh.VM
+}
+
+// NewInventory creates an inventory of the deployment VMs
+func NewInventory(runner BoshRunner) *Inventory {
+	return &Inventory{
+		runner: runner,
+		vms:    map[string][]bosh.VM{},
+	}
+}
+
+// GetDeploymentVMs returns the deployment's VMs, only the first call for
+// each deployment asks BOSH
+func (i *Inventory) GetDeploymentVMs(ctx context.Context, deploymentName string) ([]bosh.VM, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if vms, ok := i.vms[deploymentName]; ok {
+		return vms, nil
+	}
+	vms, err := i.runner.GetDeploymentVMs(ctx, deploymentName)
+	if err != nil {
+		return nil, err
+	}
+	i.vms[deploymentName] = vms
+	return vms, nil
+}
+
+// ScpFile copies a file using bosh scp
+func (i *Inventory) ScpFile(ctx context.Context, deploymentName, source, target string) error {
+	return i.runner.ScpFile(ctx, deploymentName, source, target)
+}
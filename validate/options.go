@@ -0,0 +1,69 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package validate
+
+import (
+	"time"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+)
+
+// Option configures optional validator behavior
+type Option func(v *validator)
+
+// WithEvents publishes the validation progress and results to the specified
+// sink instead of the standard logger.
+func WithEvents(s events.Sink) Option {
+	return func(v *validator) {
+		v.events = s
+	}
+}
+
+// WithParallelism sets the maximum number of instances whose certs are
+// fetched and checked at once. The default is one at a time.
+func WithParallelism(n int) Option {
+	return func(v *validator) {
+		if n > 0 {
+			v.parallelism = n
+		}
+	}
+}
+
+// WithReport records the result of every instance in the report. Every
+// selected instance is checked instead of stopping at the first failure, the
+// first failure is still returned.
+func WithReport(r *Report) Option {
+	return func(v *validator) {
+		v.report = r
+	}
+}
+
+// validator is the behavior shared by the validators
+type validator struct {
+	events      events.Sink
+	parallelism int
+	report      *Report
+}
+
+func newValidator(opts []Option) validator {
+	v := validator{
+		events:      events.NewLogSink(),
+		parallelism: 1,
+	}
+	for _, opt := range opts {
+		opt(&v)
+	}
+	return v
+}
+
+// publishResult publishes the result of validating a single instance
+func (v *validator) publishResult(operation string, vm bosh.VM, started time.Time, err error) {
+	v.events.Publish(events.Event{
+		Type:       events.Validation,
+		Operation:  operation,
+		Deployment: vm.DeploymentName,
+		Instance:   vm.Name,
+	}.Finished(started, err))
+}
@@ -0,0 +1,359 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+)
+
+// The report formats
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+// Result is the outcome of validating an instance
+type Result string
+
+// The results, from best to worst
+const (
+	ResultOK          Result = "ok"
+	ResultUnreachable Result = "unreachable"
+	ResultParseError  Result = "parse_error"
+	ResultMismatch    Result = "mismatch"
+)
+
+var severity = map[Result]int{
+	ResultOK:          0,
+	ResultUnreachable: 1,
+	ResultParseError:  2,
+	ResultMismatch:    3,
+}
+
+// ExitCode is the process exit code for the result, 1 is left for failures
+// to run the validation.
+func (r Result) ExitCode() int {
+	switch r {
+	case ResultUnreachable:
+		return 2
+	case ResultParseError:
+		return 3
+	case ResultMismatch:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// InstanceError is returned by the validators when an instance fails, its
+// result is recorded in the validator's report.
+type InstanceError struct {
+	VM     bosh.VM
+	Result Result
+	Err    error
+}
+
+func (e *InstanceError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the instance's failure
+func (e *InstanceError) Unwrap() error {
+	return e.Err
+}
+
+// InstanceResult is the result of a check of an instance
+type InstanceResult struct {
+	Instance string `json:"instance"`
+	Check    string `json:"check"`
+	Result   Result `json:"result"`
+	Error    string `json:"error,omitempty"`
+}
+
+// InstanceGroupResults are the results of an instance group's instances
+type InstanceGroupResults struct {
+	Name      string           `json:"name"`
+	Instances []InstanceResult `json:"instances"`
+}
+
+// DeploymentResults are the results of a deployment's instance groups
+type DeploymentResults struct {
+	Name           string                 `json:"name"`
+	InstanceGroups []InstanceGroupResults `json:"instance_groups"`
+}
+
+// Report collects the result of each check of each instance, it's safe to
+// share between validators. Only the latest result of a check of an instance
+// is kept, so a report shared by the validation rounds of a rotation shows
+// the current state of each instance.
+type Report struct {
+	mu      sync.Mutex
+	results map[reportKey]reportEntry
+	// rounds are the open rounds staging each deployment's results
+	rounds map[string]*Round
+}
+
+// Round stages the results of the checks of some deployments so they can be
+// judged on their own. The results are only added to the report when the
+// round is committed. A nil round has no results.
+type Round struct {
+	report      *Report
+	deployments []string
+	results     map[reportKey]reportEntry
+}
+
+type reportKey struct {
+	deployment string
+	instance   string
+	check      string
+}
+
+type reportEntry struct {
+	result Result
+	err    error
+}
+
+// NewReport creates an empty validation report
+func NewReport() *Report {
+	return &Report{
+		results: map[reportKey]reportEntry{},
+		rounds:  map[string]*Round{},
+	}
+}
+
+// NewRound opens a round that stages the results of the checks of the
+// deployments until it's committed or discarded
+func (r *Report) NewRound(deployments ...string) *Round {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	round := &Round{report: r, deployments: deployments, results: map[reportKey]reportEntry{}}
+	for _, d := range deployments {
+		r.rounds[d] = round
+	}
+	return round
+}
+
+// add records the result of the check of the instance
+func (r *Report) add(vm bosh.VM, check string, result Result, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := reportKey{deployment: vm.DeploymentName, instance: vm.Name, check: check}
+	if round, ok := r.rounds[vm.DeploymentName]; ok {
+		round.results[key] = reportEntry{result: result, err: err}
+		return
+	}
+	r.results[key] = reportEntry{result: result, err: err}
+}
+
+// Err returns nil when every instance checked in the round passed, otherwise
+// an error like the report's Err
+func (rd *Round) Err() error {
+	if rd == nil {
+		return nil
+	}
+	rd.report.mu.Lock()
+	defer rd.report.mu.Unlock()
+	return resultsErr(rd.results)
+}
+
+// Commit adds the round's results to the report and closes the round
+func (rd *Round) Commit() {
+	if rd == nil {
+		return
+	}
+	rd.report.mu.Lock()
+	defer rd.report.mu.Unlock()
+
+	for k, e := range rd.results {
+		rd.report.results[k] = e
+	}
+	rd.close()
+}
+
+// Discard closes the round without adding its results to the report
+func (rd *Round) Discard() {
+	if rd == nil {
+		return
+	}
+	rd.report.mu.Lock()
+	defer rd.report.mu.Unlock()
+	rd.close()
+}
+
+// close stops the round staging its deployments' results, the report must
+// be locked
+func (rd *Round) close() {
+	for _, d := range rd.deployments {
+		if rd.report.rounds[d] == rd {
+			delete(rd.report.rounds, d)
+		}
+	}
+	rd.results = map[reportKey]reportEntry{}
+}
+
+// sortedKeys returns the keys of the results ordered by deployment, instance
+// and check
+func sortedKeys(results map[reportKey]reportEntry) []reportKey {
+	keys := make([]reportKey, 0, len(results))
+	for k := range results {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].deployment != keys[j].deployment {
+			return keys[i].deployment < keys[j].deployment
+		}
+		if keys[i].instance != keys[j].instance {
+			return keys[i].instance < keys[j].instance
+		}
+		return keys[i].check < keys[j].check
+	})
+	return keys
+}
+
+// Status is the worst result of every instance, ok when the report is empty
+func (r *Report) Status() Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return worst(r.results)
+}
+
+// worst returns the worst of the results, ok when there are none
+func worst(results map[reportKey]reportEntry) Result {
+	status := ResultOK
+	for _, e := range results {
+		if severity[e.result] > severity[status] {
+			status = e.result
+		}
+	}
+	return status
+}
+
+// Deployments returns the results grouped by deployment and instance group
+func (r *Report) Deployments() []DeploymentResults {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deployments := []DeploymentResults{}
+	for _, k := range sortedKeys(r.results) {
+		e := r.results[k]
+		if len(deployments) == 0 || deployments[len(deployments)-1].Name != k.deployment {
+			deployments = append(deployments, DeploymentResults{Name: k.deployment})
+		}
+		d := &deployments[len(deployments)-1]
+		group := instanceGroup(bosh.VM{Name: k.instance})
+		if len(d.InstanceGroups) == 0 || d.InstanceGroups[len(d.InstanceGroups)-1].Name != group {
+			d.InstanceGroups = append(d.InstanceGroups, InstanceGroupResults{Name: group})
+		}
+		g := &d.InstanceGroups[len(d.InstanceGroups)-1]
+
+		result := InstanceResult{Instance: k.instance, Check: k.check, Result: e.result}
+		if e.err != nil {
+			result.Error = e.err.Error()
+		}
+		g.Instances = append(g.Instances, result)
+	}
+	return deployments
+}
+
+// Err returns nil when every instance passed, otherwise an error listing the
+// failed instances. It's a CertMismatchError when any instance's certs don't
+// match.
+func (r *Report) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return resultsErr(r.results)
+}
+
+// resultsErr returns an error listing the failed instances of the results
+func resultsErr(results map[reportKey]reportEntry) error {
+	var failures []string
+	for _, k := range sortedKeys(results) {
+		e := results[k]
+		if e.result == ResultOK {
+			continue
+		}
+		msg := ""
+		if e.err != nil {
+			msg = e.err.Error()
+		}
+		failures = append(failures, fmt.Sprintf("%s %s %s: %s", k.deployment, k.instance, k.check, msg))
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("%d instance checks failed: %s", len(failures), strings.Join(failures, "; "))
+	if worst(results) == ResultMismatch {
+		return fmt.Errorf("%w: %s", CertMismatchError, msg)
+	}
+	return errors.New(msg)
+}
+
+// Write writes the report in the format
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatTable:
+		return r.writeTable(w)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Status      Result              `json:"status"`
+			Deployments []DeploymentResults `json:"deployments"`
+		}{r.Status(), r.Deployments()})
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// writeTable writes a summary of each instance group, followed by each
+// failed instance
+func (r *Report) writeTable(w io.Writer) error {
+	deployments := r.Deployments()
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEPLOYMENT\tINSTANCE GROUP\tOK\tUNREACHABLE\tPARSE ERROR\tMISMATCH")
+	for _, d := range deployments {
+		for _, g := range d.InstanceGroups {
+			counts := map[Result]int{}
+			for _, i := range g.Instances {
+				counts[i.Result]++
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\n", d.Name, g.Name,
+				counts[ResultOK], counts[ResultUnreachable], counts[ResultParseError], counts[ResultMismatch])
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	first := true
+	for _, d := range deployments {
+		for _, g := range d.InstanceGroups {
+			for _, i := range g.Instances {
+				if i.Result == ResultOK {
+					continue
+				}
+				if first {
+					fmt.Fprintln(w, "\nFailed instances:")
+					first = false
+				}
+				fmt.Fprintf(w, "  %s %s %s (%s): %s\n", d.Name, i.Instance, i.Check, i.Result, i.Error)
+			}
+		}
+	}
+	fmt.Fprintf(w, "\nStatus: %s\n", r.Status())
+	return nil
+}
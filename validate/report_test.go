@@ -0,0 +1,247 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package validate_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/validate"
+)
+
+// reportBoshRunner copies a different file from each instance, the instances
+// without a file are unreachable
+type reportBoshRunner struct {
+	vms   []bosh.VM
+	files map[string]string
+}
+
+func (b reportBoshRunner) GetDeploymentVMs(ctx context.Context, deploymentName string) ([]bosh.VM, error) {
+	return b.vms, nil
+}
+
+func (b reportBoshRunner) ScpFile(ctx context.Context, deploymentName, source, target string) error {
+	instance := strings.SplitN(source, ":", 2)[0]
+	file, ok := b.files[instance]
+	if !ok {
+		return fmt.Errorf("failed to SCP file from %s", source)
+	}
+	return ioutil.WriteFile(target, []byte(file), 0644)
+}
+
+// recordingSink records the events, validators publish from many goroutines
+type recordingSink struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (r *recordingSink) Publish(e events.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// validations counts the instance validation results
+func (r *recordingSink) validations() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, e := range r.events {
+		if e.Type == events.Validation {
+			n++
+		}
+	}
+	return n
+}
+
+func TestReport(t *testing.T) {
+	m := &manifest.Manifest{DeploymentName: "cf-guid"}
+	root := validCA(t, "Diego Instance Identity Root CA", nil)
+	intermediate := validCA(t, "Diego Instance Identity Intermediate CA", root)
+	credhubRunner := credhubRunner{certs: map[string]*credhub.Certificate{
+		rootCertName:             credhubCert(rootCertName, root, nil),
+		m.IntermediateCertPath(): credhubCert(m.IntermediateCertPath(), intermediate, root),
+	}}
+
+	b := reportBoshRunner{
+		vms: []bosh.VM{
+			{Name: "diego_cell/guid1", DeploymentName: "cf-guid"},
+			{Name: "diego_cell/guid2", DeploymentName: "cf-guid"},
+			{Name: "diego_cell/guid3", DeploymentName: "cf-guid"},
+			{Name: "isolated_diego_cell/guid1", DeploymentName: "cf-guid"},
+		},
+		files: map[string]string{
+			"diego_cell/guid1":          validCA(t, "Diego Instance Identity Intermediate CA", root).pem,
+			"diego_cell/guid2":          "not a cert",
+			"isolated_diego_cell/guid1": intermediate.pem,
+		},
+	}
+
+	t.Run("checks every instance", func(t *testing.T) {
+		report := validate.NewReport()
+		v := validate.NewDiego(b, credhubRunner, validate.WithReport(report))
+		err := v.ValidateCerts(context.Background(), m, validate.AllInstancesFilter)
+
+		var instanceErr *validate.InstanceError
+		if !errors.As(err, &instanceErr) || instanceErr.VM.Name != "diego_cell/guid1" || instanceErr.Result != validate.ResultMismatch {
+			t.Fatalf("expected the first failed instance to be returned, but got %v", err)
+		}
+		if status := report.Status(); status != validate.ResultMismatch || status.ExitCode() != 4 {
+			t.Errorf("expected the worst result to be a mismatch, but got %s", status)
+		}
+		if !errors.Is(report.Err(), validate.CertMismatchError) {
+			t.Errorf("expected the report error to be a mismatch, but got %v", report.Err())
+		}
+
+		deployments := report.Deployments()
+		if len(deployments) != 1 || len(deployments[0].InstanceGroups) != 2 {
+			t.Fatalf("expected the results grouped into 2 instance groups, but got %v", deployments)
+		}
+		var results []string
+		for _, g := range deployments[0].InstanceGroups {
+			for _, i := range g.Instances {
+				results = append(results, fmt.Sprintf("%s %s %s", g.Name, i.Instance, i.Result))
+			}
+		}
+		expected := []string{
+			"diego_cell diego_cell/guid1 mismatch",
+			"diego_cell diego_cell/guid2 parse_error",
+			"diego_cell diego_cell/guid3 unreachable",
+			"isolated_diego_cell isolated_diego_cell/guid1 ok",
+		}
+		if strings.Join(results, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("expected the results\n%s\nbut got\n%s", strings.Join(expected, "\n"), strings.Join(results, "\n"))
+		}
+	})
+
+	t.Run("stops at the first failure without a report", func(t *testing.T) {
+		sink := &recordingSink{}
+		v := validate.NewDiego(b, credhubRunner, validate.WithEvents(sink))
+		if err := v.ValidateCerts(context.Background(), m, validate.AllInstancesFilter); !errors.Is(err, validate.CertMismatchError) {
+			t.Fatal("Expected an error to be returned about certs not matching, error was", err)
+		}
+		if n := sink.validations(); n != 1 {
+			t.Errorf("expected only the first instance to be validated, but got %d", n)
+		}
+	})
+
+	t.Run("latest result of each check", func(t *testing.T) {
+		report := validate.NewReport()
+		validate.NewDiego(b, credhubRunner, validate.WithReport(report)).
+			ValidateCerts(context.Background(), m, validate.AllInstancesFilter)
+
+		fixed := b
+		fixed.files = map[string]string{}
+		for _, vm := range b.vms {
+			fixed.files[vm.Name] = intermediate.pem
+		}
+		err := validate.NewDiego(fixed, credhubRunner, validate.WithReport(report)).
+			ValidateCerts(context.Background(), m, validate.AllInstancesFilter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Status() != validate.ResultOK || report.Err() != nil {
+			t.Errorf("expected the fixed instances to be ok, but got %s: %v", report.Status(), report.Err())
+		}
+	})
+
+	t.Run("rounds", func(t *testing.T) {
+		fixed := b
+		fixed.files = map[string]string{}
+		for _, vm := range b.vms {
+			fixed.files[vm.Name] = intermediate.pem
+		}
+
+		report := validate.NewReport()
+		round := report.NewRound(m.DeploymentName)
+		validate.NewDiego(b, credhubRunner, validate.WithReport(report)).
+			ValidateCerts(context.Background(), m, validate.AllInstancesFilter)
+		if !errors.Is(round.Err(), validate.CertMismatchError) {
+			t.Errorf("expected the round to have the mismatch, but got %v", round.Err())
+		}
+		round.Discard()
+		if report.Err() != nil || len(report.Deployments()) != 0 {
+			t.Errorf("expected the discarded round to not be in the report, but got %v", report.Err())
+		}
+
+		round = report.NewRound(m.DeploymentName)
+		validate.NewDiego(b, credhubRunner, validate.WithReport(report)).
+			ValidateCerts(context.Background(), m, validate.AllInstancesFilter)
+		round.Commit()
+		if !errors.Is(report.Err(), validate.CertMismatchError) {
+			t.Errorf("expected the committed round to be in the report, but got %v", report.Err())
+		}
+
+		// only the isolated cell is checked again, the earlier failures
+		// don't fail the new round
+		round = report.NewRound(m.DeploymentName)
+		err := validate.NewDiego(fixed, credhubRunner, validate.WithReport(report)).
+			ValidateCerts(context.Background(), m, func(vm bosh.VM) bool { return vm.Name == "isolated_diego_cell/guid1" })
+		if err != nil || round.Err() != nil {
+			t.Errorf("expected the round to only judge the isolated cell, but got %v, %v", err, round.Err())
+		}
+		round.Commit()
+		if report.Status() != validate.ResultMismatch {
+			t.Errorf("expected the report to keep the earlier mismatch, but got %s", report.Status())
+		}
+
+		var nilRound *validate.Round
+		if nilRound.Err() != nil {
+			t.Error("expected a nil round to have no results")
+		}
+		nilRound.Commit()
+	})
+
+	t.Run("formats", func(t *testing.T) {
+		report := validate.NewReport()
+		validate.NewDiego(b, credhubRunner, validate.WithReport(report)).
+			ValidateCerts(context.Background(), m, validate.AllInstancesFilter)
+
+		var table bytes.Buffer
+		if err := report.Write(&table, validate.FormatTable); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{
+			"DEPLOYMENT  INSTANCE GROUP       OK  UNREACHABLE  PARSE ERROR  MISMATCH",
+			"cf-guid     diego_cell           0   1            1            1",
+			"cf-guid     isolated_diego_cell  1   0            0            0",
+			"cf-guid diego_cell/guid3 instance identity cert (unreachable): failed to SCP file",
+			"Status: mismatch",
+		} {
+			if !strings.Contains(table.String(), s) {
+				t.Errorf("expected the table to contain %q, but got\n%s", s, table.String())
+			}
+		}
+
+		var out bytes.Buffer
+		if err := report.Write(&out, validate.FormatJSON); err != nil {
+			t.Fatal(err)
+		}
+		var decoded struct {
+			Status      validate.Result              `json:"status"`
+			Deployments []validate.DeploymentResults `json:"deployments"`
+		}
+		if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Status != validate.ResultMismatch || len(decoded.Deployments[0].InstanceGroups[0].Instances) != 3 {
+			t.Errorf("unexpected JSON report %s", out.String())
+		}
+
+		if err := report.Write(&out, "xml"); err == nil {
+			t.Error("expected an error for an unknown format")
+		}
+	})
+}
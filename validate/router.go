@@ -4,107 +4,109 @@
 package validate
 
 import (
+	"context"
+	"crypto/x509"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
 	"gopkg.in/yaml.v2"
 )
 
 const routerVMConfigPath = "/var/vcap/jobs/gorouter/config/gorouter.yml"
 
-// DiegoValidator can be used to validate instance identity certs on diego cells
+// routerInstanceGroups are the instance groups running gorouter, control
+// hosts gorouter on the Small Footprint Runtime
+var routerInstanceGroups = []string{"router", "control"}
+
+// isolatedRouterPrefix starts the name of the isolation segments' router
+// instance groups, which are suffixed when the tile is replicated
+const isolatedRouterPrefix = "isolated_router"
+
+// Router can be used to validate the CA certs on the routers verify the
+// instance identity intermediate
 type Router struct {
+	validator
 	bosh    BoshRunner
 	credhub CredhubRunner
 }
 
-// NewDiegoValidator creates a diego cell cert validator
-func NewRouter(bosh BoshRunner, credhub CredhubRunner) *Router {
+// NewRouter creates a router CA certs validator
+func NewRouter(bosh BoshRunner, credhub CredhubRunner, opts ...Option) *Router {
 	return &Router{
-		credhub: credhub,
-		bosh:    bosh,
+		validator: newValidator(opts),
+		credhub:   credhub,
+		bosh:      bosh,
 	}
 }
 
-// ValidateCerts checks that each diego cell instance identity cert
-// matches the current active intermediate issuing CA.
-func (v *Router) ValidateCerts(manifest *manifest.Manifest, routerVMFilter func(bosh.VM) bool) error {
-	intermediate, err := v.credhub.GetCertificate(manifest.IntermediateCertPath())
+// ValidateCerts checks that the current intermediate issuing CA chains to a
+// root in each router's CA certs.
+func (v *Router) ValidateCerts(ctx context.Context, manifest *manifest.Manifest, routerVMFilter func(bosh.VM) bool) error {
+	intermediate, _, err := credhubChain(ctx, v.credhub, manifest.IntermediateCertPath(), time.Now())
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Validating %s router certificates\n", manifest.DeploymentName)
-	err = v.checkRouterCerts(manifest.DeploymentName, strings.TrimSpace(intermediate.Value.CA), routerVMFilter)
+	events.Infof(v.events, "Validating %s router certificates", manifest.DeploymentName)
+	err = v.checkRouterCerts(ctx, manifest.DeploymentName, intermediate, routerVMFilter)
 	if err != nil {
 		return fmt.Errorf("validating certs on routers: %w", err)
 	}
 	return nil
 }
 
-func (v *Router) checkRouterCerts(deploymentName string, caCert string, routerVMFilter func(bosh.VM) bool) error {
-	vms, err := v.bosh.GetDeploymentVMs(deploymentName)
+func (v *Router) checkRouterCerts(ctx context.Context, deploymentName string, intermediate *x509.Certificate, routerVMFilter func(bosh.VM) bool) error {
+	vms, err := v.bosh.GetDeploymentVMs(ctx, deploymentName)
 	if err != nil {
 		return err
 	}
 
+	var routers []bosh.VM
 	for _, vm := range vms {
 		if isRouter(vm) && routerVMFilter(vm) {
-			err = v.checkRouterCert(vm, caCert)
-			if err != nil {
-				return err
-			}
+			routers = append(routers, vm)
 		}
 	}
 
-	return nil
+	return v.checkInstances(ctx, v.bosh, "router_ca_certs", "router CA certs", vms, routers,
+		func(bosh.VM) string { return routerVMConfigPath },
+		func(routerVM bosh.VM, routerConfig []byte) error {
+			return v.checkRouterCert(routerVM, routerConfig, intermediate)
+		})
 }
 
-func (v *Router) checkRouterCert(routerVM bosh.VM, caCertFromCredhub string) error {
-	log.Println("Validating cert on", routerVM.Name)
-
-	routerConfig, err := ioutil.TempFile("", "router-config-*.yml")
-	if err != nil {
-		return fmt.Errorf("failed to create temp instance router config file: %w", err)
-	}
-	defer os.Remove(routerConfig.Name())
-
-	source := fmt.Sprintf("%s:%s", routerVM.Name, routerVMConfigPath)
-	err = v.bosh.ScpFile(routerVM.DeploymentName, source, routerConfig.Name())
-	if err != nil {
-		return fmt.Errorf("failed to scp router config from %s to %s: %w",
-			routerVM, routerConfig.Name(), err)
-	}
-
-	routerConfigContent, err := ioutil.ReadAll(routerConfig)
-	if err != nil {
-		return fmt.Errorf("failed to read router config from %s: %w",
-			routerConfig.Name(), err)
-	}
-
+func (v *Router) checkRouterCert(routerVM bosh.VM, routerConfigContent []byte, intermediate *x509.Certificate) error {
 	type cacerts struct {
 		CACerts string `yaml:"ca_certs"`
 	}
 
 	var c cacerts
-	err = yaml.Unmarshal(routerConfigContent, &c)
+	err := yaml.Unmarshal(routerConfigContent, &c)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal router yaml from %s: %w",
-			routerConfig.Name(), err)
+		return fmt.Errorf("failed to unmarshal %s router yaml: %w", routerVM, err)
 	}
 
-	if !strings.Contains(c.CACerts, caCertFromCredhub) {
-		return fmt.Errorf("%w: for instance %s, expected it to contain CA:\n%s\nbut got:\n%s",
-			CertMismatchError, routerVM, caCertFromCredhub, c.CACerts)
+	roots, err := parseCerts(c.CACerts)
+	if err != nil {
+		return fmt.Errorf("%w: for instance %s, could not parse the CA certs: %v", CertMismatchError, routerVM, err)
+	}
+	if err = verifyChain([]*x509.Certificate{intermediate}, roots, time.Now()); err != nil {
+		return fmt.Errorf("for instance %s, no root in the CA certs: %w", routerVM, err)
 	}
 	return nil
 }
 
+// isRouter returns true if the VM is an instance of a router instance group
 func isRouter(vm bosh.VM) bool {
-	return strings.HasPrefix(vm.Name, "router")
+	group := instanceGroup(vm)
+	for _, g := range routerInstanceGroups {
+		if group == g {
+			return true
+		}
+	}
+	return strings.HasPrefix(group, isolatedRouterPrefix)
 }
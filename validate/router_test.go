@@ -4,8 +4,12 @@
 package validate_test
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"strings"
+	"time"
 
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
 	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
@@ -17,13 +21,18 @@ import (
 
 type routerBoshRunner struct {
 	routerConfig string
+	// vms overrides the deployment's VMs
+	vms []bosh.VM
 }
 
 type routerCredhubRunner struct {
-	cert *credhub.Certificate
+	certs map[string]*credhub.Certificate
 }
 
-func (b routerBoshRunner) GetDeploymentVMs(deploymentName string) (vms []bosh.VM, err error) {
+func (b routerBoshRunner) GetDeploymentVMs(ctx context.Context, deploymentName string) (vms []bosh.VM, err error) {
+	if b.vms != nil {
+		return b.vms, nil
+	}
 	return []bosh.VM{
 		{
 			Name:           "diego_cell/guid1",
@@ -36,48 +45,123 @@ func (b routerBoshRunner) GetDeploymentVMs(deploymentName string) (vms []bosh.VM
 	}, nil
 }
 
-func (b routerBoshRunner) ScpFile(deploymentName, source, target string) error {
+func (b routerBoshRunner) ScpFile(ctx context.Context, deploymentName, source, target string) error {
 	// pretend to grab the router config
 	return ioutil.WriteFile(target, []byte(b.routerConfig), 0644)
 }
 
-func (c routerCredhubRunner) GetCertificate(certPath string) (*credhub.Certificate, error) {
-	return c.cert, nil
+func (c routerCredhubRunner) GetCertificate(ctx context.Context, certPath string) (*credhub.Certificate, error) {
+	if cert, ok := c.certs[certPath]; ok {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("%s not found", certPath)
 }
 
 func TestValidateRouterCACerts(t *testing.T) {
-	newCert := func() *credhub.Certificate {
-		c := &credhub.Certificate{Name: "/cf/ca"}
-		c.Value.Certificate = "cert"
-		c.Value.CA = "cacert"
-		c.Value.PrivateKey = "key"
-		return c
-	}
-	credhubRunner := routerCredhubRunner{
-		cert: newCert(),
-	}
-	boshRunner := routerBoshRunner{
-		routerConfig: "ca_certs: cacert",
-	}
-
 	manifest := &manifest.Manifest{
 		DeploymentName: "cf-guid",
 		Path:           "/tmp/cf.yml",
 	}
 
+	newCredhub := func(certs ...*credhub.Certificate) routerCredhubRunner {
+		r := routerCredhubRunner{certs: map[string]*credhub.Certificate{}}
+		for _, c := range certs {
+			r.certs[c.Name] = c
+		}
+		return r
+	}
+
+	root := validCA(t, "Diego Instance Identity Root CA", nil)
+	intermediate := validCA(t, "Diego Instance Identity Intermediate CA", root)
+	servicesCA := validCA(t, "Services CA", nil)
+	credhubRunner := newCredhub(
+		credhubCert(rootCertName, root, nil),
+		credhubCert(manifest.IntermediateCertPath(), intermediate, root),
+	)
+	caCerts := func(cas ...*testCA) string {
+		var bundle []string
+		for _, ca := range cas {
+			bundle = append(bundle, strings.TrimSpace(ca.pem))
+		}
+		return "ca_certs: |\n  " + strings.ReplaceAll(strings.Join(bundle, "\n"), "\n", "\n  ") + "\n"
+	}
+	boshRunner := routerBoshRunner{
+		routerConfig: caCerts(servicesCA, root),
+	}
+
 	t.Run("matching certs", func(t *testing.T) {
 		v := validate.NewRouter(boshRunner, credhubRunner)
-		err := v.ValidateCerts(manifest, validate.AllInstancesFilter)
+		err := v.ValidateCerts(context.Background(), manifest, validate.AllInstancesFilter)
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
 	t.Run("mismatched certs", func(t *testing.T) {
-		boshRunner.routerConfig = "ca_certs: some_other_cert"
+		boshRunner := boshRunner
+		boshRunner.routerConfig = caCerts(servicesCA, validCA(t, "Diego Instance Identity Root CA", nil))
 		v := validate.NewRouter(boshRunner, credhubRunner)
-		err := v.ValidateCerts(manifest, validate.AllInstancesFilter)
-		if !errors.Is(err, validate.CertMismatchError) {
+		err := v.ValidateCerts(context.Background(), manifest, validate.AllInstancesFilter)
+		if !errors.Is(err, validate.CertChainError) {
 			t.Fatal("Expected an error to be returned about certs not matching, error was", err)
 		}
+		if !errors.Is(err, validate.CertMismatchError) {
+			t.Error("expected a chain error to be a mismatch")
+		}
+		if strings.Contains(err.Error(), "BEGIN CERTIFICATE") || !strings.Contains(err.Error(), "SHA-256") {
+			t.Errorf("expected the mismatch to be reported by fingerprint, but got %v", err)
+		}
+	})
+	t.Run("expired root", func(t *testing.T) {
+		expiredRoot := newCA(t, "Diego Instance Identity Root CA", nil, time.Now().AddDate(-2, 0, 0), time.Now().AddDate(-1, 0, 0))
+		expired := newCA(t, "Diego Instance Identity Intermediate CA", expiredRoot, time.Now().AddDate(-2, 0, 0), time.Now().AddDate(-1, 0, 0))
+		credhubRunner := newCredhub(
+			credhubCert(rootCertName, expiredRoot, nil),
+			credhubCert(manifest.IntermediateCertPath(), expired, expiredRoot),
+		)
+		boshRunner := boshRunner
+		boshRunner.routerConfig = caCerts(expiredRoot)
+		v := validate.NewRouter(boshRunner, credhubRunner)
+		if err := v.ValidateCerts(context.Background(), manifest, validate.AllInstancesFilter); !errors.Is(err, validate.CertMismatchError) {
+			t.Fatal("Expected an error about the expired certs, error was", err)
+		}
+	})
+	t.Run("small footprint", func(t *testing.T) {
+		boshRunner.vms = []bosh.VM{
+			{Name: "compute/guid1", DeploymentName: "cf-guid"},
+			{Name: "control/guid1", DeploymentName: "cf-guid"},
+			{Name: "database/guid1", DeploymentName: "cf-guid"},
+		}
+		var validated []string
+		v := validate.NewRouter(boshRunner, credhubRunner)
+		err := v.ValidateCerts(context.Background(), manifest, func(vm bosh.VM) bool {
+			validated = append(validated, vm.Name)
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(validated) != 1 || validated[0] != "control/guid1" {
+			t.Errorf("expected only control/guid1 to be validated, but got %v", validated)
+		}
+	})
+	t.Run("isolation segment", func(t *testing.T) {
+		boshRunner.vms = []bosh.VM{
+			{Name: "isolated_diego_cell_is1/guid1", DeploymentName: "cf-guid"},
+			{Name: "isolated_router_is1/guid1", DeploymentName: "cf-guid"},
+			{Name: "isolated_router/guid1", DeploymentName: "cf-guid"},
+			{Name: "router_metrics/guid1", DeploymentName: "cf-guid"},
+		}
+		var validated []string
+		v := validate.NewRouter(boshRunner, credhubRunner)
+		err := v.ValidateCerts(context.Background(), manifest, func(vm bosh.VM) bool {
+			validated = append(validated, vm.Name)
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(validated, ",") != "isolated_router_is1/guid1,isolated_router/guid1" {
+			t.Errorf("expected only the isolated routers to be validated, but got %v", validated)
+		}
 	})
 }
@@ -0,0 +1,144 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package validate
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/pointerstructure"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/events"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+	"gopkg.in/yaml.v2"
+)
+
+// RootNotTrustedError is returned by the trust validator when a job on a BOSH
+// instance doesn't trust the root. It's a CertMismatchError.
+var RootNotTrustedError = fmt.Errorf("%w: root isn't trusted", CertMismatchError)
+
+// Trust can be used to validate the root is trusted at each of the trust
+// spec's root locations
+type Trust struct {
+	validator
+	bosh    BoshRunner
+	credhub CredhubRunner
+}
+
+// NewTrust creates a root trust validator
+func NewTrust(bosh BoshRunner, credhub CredhubRunner, opts ...Option) *Trust {
+	return &Trust{
+		validator: newValidator(opts),
+		credhub:   credhub,
+		bosh:      bosh,
+	}
+}
+
+// ValidateTrust checks that each job in the manifest that must trust the root
+// has the root stored in credhub at rootPath in its rendered config. The
+// instances of each job are filtered by a new filter, like
+// FirstInstanceFilter, so every job is checked.
+func (v *Trust) ValidateTrust(ctx context.Context, manifest *manifest.Manifest, rootPath string, newFilter func() func(bosh.VM) bool) error {
+	trust, err := manifest.RootTrust()
+	if err != nil {
+		return err
+	}
+	cred, err := v.credhub.GetCertificate(ctx, rootPath)
+	if err != nil {
+		return err
+	}
+	roots, err := parseCerts(cred.Value.Certificate)
+	if err != nil {
+		return fmt.Errorf("could not parse the %s certificate: %w", rootPath, err)
+	}
+	vms, err := v.bosh.GetDeploymentVMs(ctx, manifest.DeploymentName)
+	if err != nil {
+		return err
+	}
+
+	events.Infof(v.events, "Validating %s trusts %s", manifest.DeploymentName, rootPath)
+	var failure error
+	for _, t := range trust {
+		if t.Rendered == nil {
+			continue
+		}
+		t := t
+		filter := newFilter()
+		var instances []bosh.VM
+		for _, vm := range vms {
+			if instanceGroup(vm) == t.InstanceGroup && filter(vm) {
+				instances = append(instances, vm)
+			}
+		}
+
+		err := v.checkInstances(ctx, v.bosh, "root_trust", t.Job+" "+t.Path, vms, instances,
+			func(bosh.VM) string { return t.Rendered.File },
+			func(vm bosh.VM, config []byte) error {
+				return checkTrusted(vm, t, config, roots[0])
+			})
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("validating %s trusts the root: %w", t, err)
+		// with a report the remaining jobs are still checked
+		var instanceErr *InstanceError
+		if v.report == nil || !errors.As(err, &instanceErr) {
+			return err
+		}
+		if failure == nil {
+			failure = err
+		}
+	}
+	return failure
+}
+
+// checkTrusted returns a RootNotTrustedError unless the root is one of the
+// CA certs in the job's rendered config
+func checkTrusted(vm bosh.VM, t manifest.JobTrust, config []byte, root *x509.Certificate) error {
+	bundle := string(config)
+	if t.Rendered.Property != "" {
+		var c map[interface{}]interface{}
+		if err := yaml.Unmarshal(config, &c); err != nil {
+			return fmt.Errorf("failed to unmarshal %s %s: %w", vm, t.Rendered.File, err)
+		}
+		value, err := pointerstructure.Get(c, t.Rendered.Property)
+		if err != nil {
+			return fmt.Errorf("%w: for instance %s, %s has no %s: %v",
+				RootNotTrustedError, vm, t.Rendered.File, t.Rendered.Property, err)
+		}
+		bundle = pemBundle(value)
+	}
+
+	cas, err := parseCerts(bundle)
+	if err != nil {
+		return fmt.Errorf("%w: for instance %s, could not parse the %s CA certs: %v", RootNotTrustedError, vm, t, err)
+	}
+	for _, ca := range cas {
+		if ca.Equal(root) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: for instance %s, %s doesn't trust %s", RootNotTrustedError, vm, t, describe(root))
+}
+
+// pemBundle joins the CA certs property, which is either a bundle or a list
+// of certs
+func pemBundle(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var certs []string
+		for _, c := range v {
+			if s, ok := c.(string); ok {
+				certs = append(certs, s)
+			}
+		}
+		return strings.Join(certs, "\n")
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package validate_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/bosh"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/credhub"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/manifest"
+	"github.com/vmware-tanzu/rotate-instance-identity-certificates/validate"
+)
+
+// trustBoshRunner copies the rendered files by their path on the instance
+type trustBoshRunner struct {
+	vms   []bosh.VM
+	files map[string]string
+
+	mu      sync.Mutex
+	sources []string
+}
+
+func (b *trustBoshRunner) GetDeploymentVMs(ctx context.Context, deploymentName string) ([]bosh.VM, error) {
+	return b.vms, nil
+}
+
+func (b *trustBoshRunner) ScpFile(ctx context.Context, deploymentName, source, target string) error {
+	b.mu.Lock()
+	b.sources = append(b.sources, source)
+	b.mu.Unlock()
+	return ioutil.WriteFile(target, []byte(b.files[strings.SplitN(source, ":", 2)[1]]), 0644)
+}
+
+func TestValidateTrust(t *testing.T) {
+	m, err := manifest.NewManifest("p-bosh", "../manifest/testdata/tas-2.13/p-isolation-segment-manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := validCA(t, "Diego Instance Identity Root CA", nil)
+	other := validCA(t, "Other CA", nil)
+	credhubRunner := credhubRunner{certs: map[string]*credhub.Certificate{
+		rootCertName: credhubCert(rootCertName, root, nil),
+	}}
+	indent := func(pem string) string {
+		return "  " + strings.ReplaceAll(strings.TrimSpace(pem), "\n", "\n  ")
+	}
+	newBosh := func() *trustBoshRunner {
+		return &trustBoshRunner{
+			vms: []bosh.VM{
+				{Name: "isolated_router_is1/guid1", DeploymentName: m.DeploymentName},
+				{Name: "isolated_diego_cell_is1/guid1", DeploymentName: m.DeploymentName},
+				{Name: "isolated_diego_cell_is1/guid2", DeploymentName: m.DeploymentName},
+			},
+			files: map[string]string{
+				"/var/vcap/jobs/gorouter/config/gorouter.yml":                        "ca_certs: |\n" + indent(root.pem) + "\n",
+				"/var/vcap/jobs/rep/config/certs/trusted_certs.crt":                  other.pem + root.pem,
+				"/var/vcap/jobs/cflinuxfs3-rootfs-setup/config/certs/trusted_ca.crt": root.pem,
+			},
+		}
+	}
+
+	t.Run("trusted", func(t *testing.T) {
+		b := newBosh()
+		v := validate.NewTrust(b, credhubRunner)
+		if err := v.ValidateTrust(context.Background(), m, rootCertName, validate.AllInstances); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("trusted in a list of CA certs", func(t *testing.T) {
+		b := newBosh()
+		b.files["/var/vcap/jobs/gorouter/config/gorouter.yml"] = "ca_certs:\n- |\n" + indent(other.pem) + "\n- |\n" + indent(root.pem) + "\n"
+		v := validate.NewTrust(b, credhubRunner)
+		if err := v.ValidateTrust(context.Background(), m, rootCertName, validate.AllInstances); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("not trusted", func(t *testing.T) {
+		b := newBosh()
+		b.files["/var/vcap/jobs/cflinuxfs3-rootfs-setup/config/certs/trusted_ca.crt"] = other.pem
+		v := validate.NewTrust(b, credhubRunner)
+		err := v.ValidateTrust(context.Background(), m, rootCertName, validate.AllInstances)
+		if !errors.Is(err, validate.RootNotTrustedError) || !errors.Is(err, validate.CertMismatchError) {
+			t.Fatal("Expected an error about the untrusted root, error was", err)
+		}
+		if !strings.Contains(err.Error(), "cflinuxfs3-rootfs-setup") {
+			t.Errorf("expected the untrusting job to be reported, but got %v", err)
+		}
+	})
+	t.Run("checks each job with a new filter", func(t *testing.T) {
+		b := newBosh()
+		v := validate.NewTrust(b, credhubRunner)
+		if err := v.ValidateTrust(context.Background(), m, rootCertName, validate.FirstInstanceFilter); err != nil {
+			t.Fatal(err)
+		}
+		if len(b.sources) != 3 {
+			t.Errorf("expected the first instance of each of the 3 jobs to be checked, but got %v", b.sources)
+		}
+	})
+}